@@ -40,19 +40,77 @@ type NotifyRef struct {
 
 // ConfigSetSpec defines the desired state of ConfigSet
 type ConfigSetSpec struct {
-	Files      []File    `json:"files,omitempty"`
-	Packages   []Package `json:"packages,omitempty"`
-	Services   []Service `json:"services,omitempty"`
-	Executions []Exec    `json:"executions,omitempty"`
+	// NodeSelector selects the nodes this ConfigSet applies to.  When unset,
+	// the ConfigSet applies to nodes carrying all of its own labels.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	Files        []File                `json:"files,omitempty"`
+	// Repositories are configured before any package is handled, and the
+	// package metadata is refreshed when one of them changed.
+	// +optional
+	Repositories []Repository `json:"repositories,omitempty"`
+	Packages     []Package    `json:"packages,omitempty"`
+	Services     []Service    `json:"services,omitempty"`
+	// Units are systemd unit files and drop-ins.  They are written after the
+	// files and before the services are handled.
+	// +optional
+	Units      []Unit `json:"units,omitempty"`
+	Executions []Exec `json:"executions,omitempty"`
+	// Groups are applied before Users, so that users may reference them.
+	Groups []Group `json:"groups,omitempty"`
+	Users  []User  `json:"users,omitempty"`
 	// Notifies lists resources to reconcile after this ConfigSet is applied.
 	// +optional
 	Notifies []NotifyRef `json:"notifies,omitempty"`
+	// DependsOn lists ConfigSets in the same namespace which must be
+	// successfully applied on a node before this ConfigSet is applied there.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Enforce set to false puts the ConfigSet in audit mode: drift from the
+	// desired state is recorded in the ManagedNode status but not corrected.
+	// Defaults to true.
+	// +optional
+	Enforce *bool `json:"enforce,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!has(self.hold) || !self.hold || !has(self.ensure) || self.ensure != 'absent'",message="an absent package cannot be held"
+// +kubebuilder:validation:XValidation:rule="!has(self.ensure) || self.ensure != 'latest' || ((!has(self.hold) || !self.hold) && !has(self.version))",message="a latest package cannot be held or have a version"
 type Package struct {
+	// Ensure is "installed", "absent" or "latest".  A latest package is
+	// upgraded whenever the repositories offer a different version than the
+	// one installed.
 	Ensure  string `json:"ensure,omitempty"`
 	Name    string `json:"name,omitempty"`
 	Version string `json:"version,omitempty"`
+	// Hold keeps the package at its installed version, so that upgrades
+	// leave it alone, using the native mechanism of the package manager:
+	// pkg lock, IgnorePkg in pacman.conf, a pinned world entry for apk,
+	// apt-mark hold or dnf versionlock.  The hold is released once it is
+	// removed from the ConfigSet.
+	// +optional
+	Hold bool `json:"hold,omitempty"`
+}
+
+// Repository is a package repository.  Its files are named after Name, so
+// that repositories are managed independently of those already on the node.
+type Repository struct {
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	Name string `json:"name"`
+	// URL is the location of the repository.  For apt it is followed by the
+	// suite and components, e.g. "https://deb.example.com/debian stable main".
+	URL string `json:"url"`
+	// Key signs the repository: the PGP fingerprint for pacman, the public
+	// key for the other package managers.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Priority orders the repository against the others for pkg, dnf and
+	// apt.  apk and pacman have no priority.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// Enabled defaults to true.  A disabled repository is kept in the
+	// configuration but not used, or removed from pacman.conf.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
 }
 
 type Service struct {
@@ -63,39 +121,236 @@ type Service struct {
 	Arguments       string   `json:"arguments,omitempty"`
 	User            string   `json:"user,omitempty"`
 	LockGroup       string   `json:"lock_group,omitempty"`
+	// HealthCheck is evaluated after the service is started or restarted.
+	// A service which fails it fails the apply, and keeps the lease of its
+	// lock_group until it passes.
+	// +optional
+	HealthCheck *ServiceHealthCheck `json:"healthCheck,omitempty"`
+}
+
+// ServiceHealthCheck verifies that a service works after it was started or
+// restarted, rather than trusting the exit code of the service manager.
+// Exactly one of exec, tcp, http or activeFor is set.
+// +kubebuilder:validation:XValidation:rule="[has(self.exec), has(self.tcp), has(self.http), has(self.activeFor)].filter(x, x).size() == 1",message="exactly one of exec, tcp, http or activeFor must be set"
+type ServiceHealthCheck struct {
+	// Exec runs a command, which passes when it exits 0.
+	// +optional
+	Exec *HealthCheckExec `json:"exec,omitempty"`
+	// TCP connects to an address, e.g. 127.0.0.1:5432.
+	// +optional
+	TCP string `json:"tcp,omitempty"`
+	// HTTP sends a GET request.
+	// +optional
+	HTTP *HealthCheckHTTP `json:"http,omitempty"`
+	// ActiveFor is a duration, e.g. 30s, for which the service must stay
+	// running, to catch a service which fails shortly after it started.
+	// +optional
+	ActiveFor string `json:"activeFor,omitempty"`
+	// Retries is the number of times a failed check is repeated before the
+	// service counts as unhealthy.  Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+	// Interval is the duration between attempts.  Defaults to 5s.
+	// +optional
+	Interval string `json:"interval,omitempty"`
+	// Timeout limits each exec, tcp or http attempt.  Defaults to 5s.
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type HealthCheckExec struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type HealthCheckHTTP struct {
+	URL string `json:"url"`
+	// Status is the expected status code.  Defaults to 200.
+	// +optional
+	Status int32 `json:"status,omitempty"`
+}
+
+// Unit is a systemd unit file, or a drop-in which extends one.  A unit
+// without content is left as it is on disk, e.g. to enable or mask a unit
+// installed by a package.
+// +kubebuilder:validation:XValidation:rule="!has(self.dropIn) || (!has(self.enable) && !has(self.state) && !has(self.mask))",message="enable, state and mask belong to the unit, not to a drop-in"
+// +kubebuilder:validation:XValidation:rule="!has(self.mask) || !self.mask || !has(self.content)",message="a masked unit cannot have content"
+type Unit struct {
+	// Name is the unit name, e.g. "backup.service" or "backup.timer".
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9:_.@-]+\.(service|socket|timer|path|target|mount|automount|swap|slice|scope)$`
+	Name string `json:"name"`
+	// DropIn writes the content to <name>.d/<dropIn>.conf instead of the unit
+	// file.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	// +optional
+	DropIn  string `json:"dropIn,omitempty"`
+	Content string `json:"content,omitempty"`
+	// Ensure is "present" (the default) or "absent".  An absent unit is
+	// stopped and disabled before its file is removed.
+	// +kubebuilder:validation:Enum=present;absent
+	Ensure string `json:"ensure,omitempty"`
+	// User manages the unit in the systemd instance of the user, from
+	// ~/.config/systemd/user.
+	// +optional
+	User string `json:"user,omitempty"`
+	// Enable enables the unit when true and disables it when false.  Left
+	// alone when unset.
+	// +optional
+	Enable *bool `json:"enable,omitempty"`
+	// State is "running" or "stopped".  Left alone when unset.  A running
+	// unit whose file or drop-ins changed is restarted.
+	// +kubebuilder:validation:Enum=running;stopped
+	// +optional
+	State string `json:"state,omitempty"`
+	// Mask masks the unit when true and unmasks it when false.  Left alone
+	// when unset.
+	// +optional
+	Mask *bool `json:"mask,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!has(self.source) || has(self.sha256)",message="sha256 is required when source is set"
+// +kubebuilder:validation:XValidation:rule="!has(self.ensure) || self.ensure != 'lineinfile' || !has(self.state) || self.state != 'absent' || has(self.line) || has(self.regexp)",message="an absent lineinfile requires line or regexp"
 type File struct {
-	Content       string   `json:"content,omitempty"`
-	Ensure        string   `json:"ensure,omitempty"`
-	Target        string   `json:"target,omitempty"`
-	Group         string   `json:"group,omitempty"`
-	Mode          string   `json:"mode,omitempty"`
-	Owner         string   `json:"owner,omitempty"`
-	Path          string   `json:"path,omitempty"`
-	Template      string   `json:"template,omitempty"`
-	SecretRefs    []string `json:"secretRefs,omitempty"`
-	ConfigMapRefs []string `json:"configMapRefs,omitempty"`
+	Content  string `json:"content,omitempty"`
+	Ensure   string `json:"ensure,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Template string `json:"template,omitempty"`
+	// TemplateEngine selects how Template is rendered.  "gomplate" (the
+	// default) shells out to the gomplate binary; "go" renders in-process with
+	// text/template and the nodemanager function library.
+	// +kubebuilder:validation:Enum=gomplate;go
+	TemplateEngine string   `json:"templateEngine,omitempty"`
+	SecretRefs     []string `json:"secretRefs,omitempty"`
+	ConfigMapRefs  []string `json:"configMapRefs,omitempty"`
+	// Source downloads the content from an http(s) URL, or from an OCI
+	// artifact reference such as oci://ghcr.io/org/artifact:tag.  SHA256 is
+	// required.  Downloads are cached on the node by digest.
+	Source string `json:"source,omitempty"`
+	// SHA256 is the hex digest of the content of Source.  For an OCI artifact
+	// it is the digest of the layer holding the file.
+	// +kubebuilder:validation:Pattern=`^(sha256:)?[a-fA-F0-9]{64}$`
+	SHA256 string `json:"sha256,omitempty"`
 	// CreateOnly skips writing the file if it already exists on disk.
 	// Useful for seed/skeleton files (e.g. ~/.zshrc) that nodemanager should
 	// create on first boot but never overwrite afterward.
 	CreateOnly bool `json:"createOnly,omitempty"`
+	// Validate is a command run against the new content before it replaces
+	// the file, e.g. "sshd -t -f %s".  %s is replaced with the path of a
+	// staged copy.  The file is not written when the command fails.
+	Validate string `json:"validate,omitempty"`
+	// Rollback restores the previous content from the filebucket when a
+	// service subscribed to this file fails to restart after it changed.
+	// Requires the filebucket to be enabled.
+	Rollback bool `json:"rollback,omitempty"`
 	// Purge removes files beneath this path that are not declared in any
 	// ConfigSet matching this node. Only meaningful when ensure is "directory".
 	// Subdirectories are never removed, only plain files.
 	Purge bool `json:"purge,omitempty"`
+	// Line is the line managed when ensure is "lineinfile".
+	Line string `json:"line,omitempty"`
+	// Regexp selects the existing lines managed when ensure is "lineinfile".
+	// The last matching line is replaced by Line, or every matching line is
+	// removed when state is "absent".
+	Regexp string `json:"regexp,omitempty"`
+	// State is "present" (the default) or "absent" when ensure is
+	// "lineinfile".
+	// +kubebuilder:validation:Enum=present;absent
+	State string `json:"state,omitempty"`
+	// Settings are the keys managed when ensure is "ini" or "keyvalue".  Keys
+	// not listed are left untouched, so several ConfigSets may manage
+	// different keys of the same file.
+	Settings []FileSetting `json:"settings,omitempty"`
+}
+
+// FileSetting is a single key within an ini or keyvalue file.
+type FileSetting struct {
+	// Section is the ini section holding Key.  Empty refers to the keys
+	// before the first section header.  Ignored for keyvalue files.
+	Section string `json:"section,omitempty"`
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	// State is "present" (the default) or "absent".
+	// +kubebuilder:validation:Enum=present;absent
+	State string `json:"state,omitempty"`
 }
 
+type User struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Enum=present;absent
+	Ensure string `json:"ensure,omitempty"`
+	UID    *int64 `json:"uid,omitempty"`
+	// Group is the primary group.  It must already exist.
+	Group string `json:"group,omitempty"`
+	// Groups are the supplementary groups.  When set, membership of any other
+	// group is removed.
+	Groups  []string `json:"groups,omitempty"`
+	Home    string   `json:"home,omitempty"`
+	Shell   string   `json:"shell,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	// System creates a system account, without a home directory where the
+	// platform supports it.
+	System bool `json:"system,omitempty"`
+	// AuthorizedKeys are written to ~/.ssh/authorized_keys, replacing its
+	// content.
+	AuthorizedKeys []string `json:"authorizedKeys,omitempty"`
+}
+
+type Group struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Enum=present;absent
+	Ensure string `json:"ensure,omitempty"`
+	GID    *int64 `json:"gid,omitempty"`
+	System bool   `json:"system,omitempty"`
+}
+
+// Exec runs a command when one of its subscribe_files changes, when its
+// schedule is due, or on every reconcile when always is set.  Without any of
+// them it never runs.  The guards creates, unless and onlyIf are checked
+// before each run.
 type Exec struct {
+	// Name identifies the exec in the ManagedNode status.  Defaults to the
+	// command and its arguments.
+	Name            string   `json:"name,omitempty"`
 	Command         string   `json:"command,omitempty"`
 	Args            []string `json:"args,omitempty"`
 	SusbscribeFiles []string `json:"subscribe_files,omitempty"`
+	// Creates skips the command when this path exists.
+	Creates string `json:"creates,omitempty"`
+	// Unless skips the command when this shell command exits 0.
+	Unless string `json:"unless,omitempty"`
+	// OnlyIf skips the command unless this shell command exits 0.
+	OnlyIf string `json:"onlyIf,omitempty"`
+	// Schedule is a cron expression.  The command runs on each scheduled
+	// time, in addition to when a subscribed file changes.
+	Schedule string `json:"schedule,omitempty"`
+	// Always considers the command on every reconcile, subject to its
+	// guards, rather than only when a subscribed file changes.  Ignored with
+	// a schedule.
+	Always bool `json:"always,omitempty"`
+	// Timeout is a duration, e.g. 2m, after which the command is killed.
+	// It may not exceed 5m, the time limit of a reconcile, which the
+	// command shares with the rest of the ConfigSet.
+	Timeout string `json:"timeout,omitempty"`
+	// Env is added to the environment of the command and its guards.
+	Env map[string]string `json:"env,omitempty"`
+	// Cwd is the working directory of the command and its guards.
+	Cwd string `json:"cwd,omitempty"`
+	// User runs the command and its guards as this user.
+	User string `json:"user,omitempty"`
+	// ExitCodes are the exit codes which count as success.  Defaults to [0].
+	ExitCodes []int32 `json:"exitCodes,omitempty"`
 }
 
 // ConfigSetStatus defines the observed state of ConfigSet
 type ConfigSetStatus struct {
 	// Conditions includes a Conflicted condition when a resource overlap is detected
-	// on the node this controller manages.
+	// on the node this controller manages, and a Blocked condition when
+	// dependsOn is not yet satisfied or forms a cycle.
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
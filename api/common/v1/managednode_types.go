@@ -43,6 +43,10 @@ type ManagedNodeSpec struct {
 	// a Raspberry Pi).  Empty or zero means event-driven only.
 	// +optional
 	ReconcilePeriod string `json:"reconcilePeriod,omitempty"`
+	// Audit puts every ConfigSet on this node in audit mode, whatever its
+	// enforce setting: drift is recorded but not corrected.
+	// +optional
+	Audit bool `json:"audit,omitempty"`
 }
 
 type Upgrade struct {
@@ -61,14 +65,109 @@ type NetworkInterface struct {
 
 // ConfigSetApplyStatus records the last reconciliation outcome for a ConfigSet on this node.
 type ConfigSetApplyStatus struct {
-	Name            string      `json:"name"`
-	ResourceVersion string      `json:"resourceVersion,omitempty"`
-	LastApplied     metav1.Time `json:"lastApplied,omitempty"`
-	Error           string      `json:"error,omitempty"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Generation is the metadata.generation of the ConfigSet which was
+	// evaluated.  Unlike the resourceVersion, it does not change when only
+	// the status of the ConfigSet is written.
+	// +optional
+	Generation  int64       `json:"generation,omitempty"`
+	LastApplied metav1.Time `json:"lastApplied,omitempty"`
+	Error       string      `json:"error,omitempty"`
 	// Conflicts lists resources claimed by both this ConfigSet and another matching
 	// ConfigSet, e.g. ["file:/etc/nginx/nginx.conf (also in configset \"web-base\")"].
 	// When non-empty, this ConfigSet was not applied on this reconcile.
 	Conflicts []string `json:"conflicts,omitempty"`
+	// Blocked lists the dependencies which are not yet applied on this node,
+	// e.g. ["base-repos (not yet applied)"].  When non-empty, this ConfigSet
+	// was not applied on this reconcile.
+	Blocked []string `json:"blocked,omitempty"`
+	// RolledBack lists the files restored from the filebucket because a
+	// subscribed service failed to restart.  The ConfigSet is not applied
+	// again on this node until its generation changes.
+	RolledBack []string `json:"rolledBack,omitempty"`
+	// Plan is set when the ConfigSet was evaluated in plan mode.  It lists the
+	// changes that would have been made; nothing was changed on the node.
+	// +optional
+	Plan *ConfigSetPlan `json:"plan,omitempty"`
+	// Executions records the last evaluation of each exec in the ConfigSet.
+	// +optional
+	Executions []ExecStatus `json:"executions,omitempty"`
+	// Audited is set when the ConfigSet was evaluated in audit mode.  Drift
+	// then lists the resources which differ from the desired state; nothing
+	// was changed on the node.
+	// +optional
+	Audited bool `json:"audited,omitempty"`
+	// +optional
+	Drift []DriftedResource `json:"drift,omitempty"`
+	// HeldPackages lists the packages this ConfigSet holds on the node, so
+	// that they are released once the ConfigSet no longer holds them.
+	// +optional
+	HeldPackages []string `json:"heldPackages,omitempty"`
+	// Unhealthy lists the services which failed their health check after
+	// they were started or restarted.  They are checked again on each
+	// reconcile, and the lease of their lock group is held until they pass.
+	// +optional
+	Unhealthy []string `json:"unhealthy,omitempty"`
+	// ServiceArguments lists the services whose arguments this ConfigSet
+	// sets on the node, so that they are cleared once it no longer sets them.
+	// +optional
+	ServiceArguments []string `json:"serviceArguments,omitempty"`
+}
+
+// DriftedResource is a resource which differs from the desired state of a
+// ConfigSet in audit mode.
+type DriftedResource struct {
+	// Kind is one of package, file, service, user or group.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Action is the change enforcement would make, e.g. "install" or "write".
+	Action string `json:"action"`
+}
+
+// ExecStatus records when an exec last ran and why it did or did not run on
+// the most recent reconcile.
+type ExecStatus struct {
+	Name string `json:"name"`
+	// LastRun is when the command last ran.
+	LastRun *metav1.Time `json:"lastRun,omitempty"`
+	// ExitCode of the last run.
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// Output is the tail of the combined stdout and stderr of the last run.
+	Output string `json:"output,omitempty"`
+	// NextRun is the next scheduled time, for execs with a schedule.
+	NextRun *metav1.Time `json:"nextRun,omitempty"`
+	// Reason describes the outcome of the most recent evaluation, e.g.
+	// "ran", "failed: exit code 1" or "skipped: creates /var/lib/app/.done exists".
+	Reason string `json:"reason,omitempty"`
+}
+
+// ConfigSetPlan describes the changes a ConfigSet would make to a node,
+// grouped by resource kind.  An empty plan means the node already matches
+// the desired state.
+type ConfigSetPlan struct {
+	Repositories []PlannedChange `json:"repositories,omitempty"`
+	Packages     []PlannedChange `json:"packages,omitempty"`
+	Files        []PlannedChange `json:"files,omitempty"`
+	Services     []PlannedChange `json:"services,omitempty"`
+	Units        []PlannedChange `json:"units,omitempty"`
+	Executions   []PlannedChange `json:"executions,omitempty"`
+	Groups       []PlannedChange `json:"groups,omitempty"`
+	Users        []PlannedChange `json:"users,omitempty"`
+}
+
+// PlannedChange is a single change that would be made by applying a ConfigSet.
+type PlannedChange struct {
+	// Name identifies the resource: a package name, file path, service name or
+	// command.
+	Name string `json:"name"`
+	// Action is the operation that would be performed, e.g. install, remove,
+	// write, chmod, chown, mkdir, symlink, start, stop, restart or run.
+	Action string `json:"action"`
+	// Detail carries additional context, such as the requested package version
+	// or a unified diff of file content against what is on disk.
+	// +optional
+	Detail string `json:"detail,omitempty"`
 }
 
 // WireGuardInterface holds the identity information for a WireGuard interface
@@ -119,6 +218,19 @@ type ManagedNodeStatus struct {
 	KubernetesNodeCordoned *metav1.Time `json:"kubernetesNodeCordoned,omitempty"`
 	// Jailed indicates whether this node is running inside a FreeBSD jail.
 	Jailed bool `json:"jailed,omitempty"`
+	// Facts describe the node, e.g. "cpu.count", "memory.total_bytes" or
+	// "dmi.product", together with the custom facts from facts.d.
+	// +optional
+	Facts map[string]string `json:"facts,omitempty"`
+	// HeldPackages lists every package held on the node, whether by a
+	// ConfigSet or by hand, which upgrades leave at its installed version.
+	// +optional
+	HeldPackages []string `json:"heldPackages,omitempty"`
+	// Unhealthy lists the services which failed their health check after
+	// they were started or restarted.  They are checked again on each
+	// reconcile, and the lease of their lock group is held until they pass.
+	// +optional
+	Unhealthy []string `json:"unhealthy,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeLabelRuleOperator compares a fact with the values of a condition.
+// +kubebuilder:validation:Enum=In;NotIn;Exists;DoesNotExist;Gt;Gte;Lt;Lte
+type NodeLabelRuleOperator string
+
+const (
+	NodeLabelRuleIn           NodeLabelRuleOperator = "In"
+	NodeLabelRuleNotIn        NodeLabelRuleOperator = "NotIn"
+	NodeLabelRuleExists       NodeLabelRuleOperator = "Exists"
+	NodeLabelRuleDoesNotExist NodeLabelRuleOperator = "DoesNotExist"
+	NodeLabelRuleGt           NodeLabelRuleOperator = "Gt"
+	NodeLabelRuleGte          NodeLabelRuleOperator = "Gte"
+	NodeLabelRuleLt           NodeLabelRuleOperator = "Lt"
+	NodeLabelRuleLte          NodeLabelRuleOperator = "Lte"
+)
+
+// NodeLabelRuleCondition matches a single fact of a node, or the existence of
+// a file on it.
+// +kubebuilder:validation:XValidation:rule="has(self.fact) != has(self.fileExists)",message="exactly one of fact and fileExists must be set"
+type NodeLabelRuleCondition struct {
+	// Fact is a key of the ManagedNode facts, e.g. "virtualization" or
+	// "memory.total_bytes", or one of "os.id", "os.name", "os.release",
+	// "machine" and "hostname".
+	// +optional
+	Fact string `json:"fact,omitempty"`
+
+	// Operator compares the fact with Values.  Defaults to In.  Gt, Gte, Lt
+	// and Lte compare quantities, so "8Gi" may be used for a number of bytes.
+	// +optional
+	Operator NodeLabelRuleOperator `json:"operator,omitempty"`
+
+	// Values are the values the fact is compared with.  The quantity
+	// operators take exactly one value.
+	// +optional
+	Values []string `json:"values,omitempty"`
+
+	// FileExists matches when the path exists on the node, e.g. "/dev/nvidia0".
+	// +optional
+	FileExists string `json:"fileExists,omitempty"`
+}
+
+// NodeLabelRuleSpec defines the desired state of NodeLabelRule
+type NodeLabelRuleSpec struct {
+	// Conditions must all match for the labels to be applied.  A rule without
+	// conditions matches every node.
+	// +optional
+	Conditions []NodeLabelRuleCondition `json:"conditions,omitempty"`
+
+	// Labels are set on every ManagedNode the rule matches, and removed again
+	// once it no longer does.
+	// +kubebuilder:validation:MinProperties=1
+	Labels map[string]string `json:"labels"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// NodeLabelRule is the Schema for the nodelabelrules API.  Each node applies
+// the labels of the rules which match its facts to its own ManagedNode.
+type NodeLabelRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NodeLabelRuleSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NodeLabelRuleList contains a list of NodeLabelRule
+type NodeLabelRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeLabelRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeLabelRule{}, &NodeLabelRuleList{})
+}
@@ -58,6 +58,12 @@ var nodeClusterRoleRules = []rbacv1.PolicyRule{
 		Resources: []string{"managednodes/status"},
 		Verbs:     []string{"update", "patch"},
 	},
+	// NodeLabelRules: read-only (for labels derived from facts)
+	{
+		APIGroups: []string{"common.nodemanager"},
+		Resources: []string{"nodelabelrules"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
 	// JailTemplates: read-only (for template resolution)
 	{
 		APIGroups: []string{"freebsd.nodemanager"},
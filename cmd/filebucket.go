@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+const defaultFileBucketPath = "/var/lib/nodemanager/filebucket"
+
+const fileBucketUsage = `usage: nodemanager filebucket <command> [flags] [args]
+
+commands:
+  list [path]      list backups, newest first for each path
+  show <hash>      print the content of a backup
+  diff <hash>      diff the current file against a backup
+  restore <hash>   restore a backup and pause enforcement of its path
+  release <path>   resume enforcement of a restored path
+`
+
+// runFileBucket implements `nodemanager filebucket`.
+//
+// The filebucket is read directly from disk, so these commands run on the
+// node itself and need no cluster access.  A hash may be abbreviated to any
+// unique prefix of at least four characters.
+func runFileBucket(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, fileBucketUsage)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("filebucket "+args[0], flag.ExitOnError)
+	bucket := fs.String("bucket", defaultFileBucketPath, "Root directory of the filebucket")
+	noHold := fs.Bool("no-hold", false, "restore: do not pause enforcement of the restored path")
+	_ = fs.Parse(args[1:])
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = fileBucketList(*bucket, fs.Arg(0))
+	case "show":
+		err = fileBucketShow(*bucket, requireArg(fs, "hash"))
+	case "diff":
+		err = fileBucketDiff(*bucket, requireArg(fs, "hash"))
+	case "restore":
+		err = fileBucketRestore(*bucket, requireArg(fs, "hash"), !*noHold)
+	case "release":
+		err = fileBucketRelease(*bucket, requireArg(fs, "path"))
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown filebucket command %q\n", args[0])
+		fmt.Fprint(os.Stderr, fileBucketUsage)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func fileBucketList(bucket, path string) error {
+	entries, err := files.ListFileBucket(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	holds, err := files.FileBucketHolds(bucket)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tHASH\tBACKED UP\tMODE\tOWNER\tHELD")
+	for _, e := range entries {
+		held := ""
+		if hold, ok := holds[e.Meta.Path]; ok && hold.Hash == e.Hash {
+			held = hold.Since
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Meta.Path, e.Hash[:12], e.Meta.BackedUpAt, e.Meta.Mode, owner(e.Meta), held)
+	}
+	return w.Flush()
+}
+
+func fileBucketShow(bucket, prefix string) error {
+	hash, err := files.ResolveFileBucketHash(bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	data, meta, err := files.ReadFileBucket(bucket, hash)
+	if err != nil {
+		return err
+	}
+
+	// The metadata goes to stderr so that stdout can be redirected to a file.
+	fmt.Fprintf(os.Stderr, "# path: %s\n# backed up: %s\n# mode: %s\n# owner: %s\n", meta.Path, meta.BackedUpAt, meta.Mode, owner(meta))
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func fileBucketDiff(bucket, prefix string) error {
+	hash, err := files.ResolveFileBucketHash(bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	data, meta, err := files.ReadFileBucket(bucket, hash)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(meta.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(current)),
+		B:        difflib.SplitLines(string(data)),
+		FromFile: meta.Path,
+		ToFile:   fmt.Sprintf("%s (%s)", meta.Path, hash[:12]),
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(diff)
+	return nil
+}
+
+func fileBucketRestore(bucket, prefix string, hold bool) error {
+	hash, err := files.ResolveFileBucketHash(bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	// Hold first, so that a reconcile between the two steps cannot overwrite
+	// the restored file.
+	if hold {
+		_, meta, err := files.ReadFileBucket(bucket, hash)
+		if err != nil {
+			return err
+		}
+		if err := files.HoldFile(bucket, meta.Path, hash); err != nil {
+			return err
+		}
+	}
+
+	meta, err := files.RestoreFromFileBucket(bucket, hash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s from %s (mode %s, owner %s)\n", meta.Path, hash[:12], meta.Mode, owner(meta))
+	if hold {
+		fmt.Printf("enforcement of %s is paused; resume it with:\n", meta.Path)
+		fmt.Printf("  nodemanager filebucket release %s\n", meta.Path)
+	}
+	return nil
+}
+
+func fileBucketRelease(bucket, path string) error {
+	released, err := files.ReleaseFile(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	if !released {
+		fmt.Printf("%s is not held\n", path)
+		return nil
+	}
+
+	fmt.Printf("enforcement of %s resumes on the next reconcile\n", path)
+	return nil
+}
+
+// requireArg returns the first positional argument of fs, exiting when it is
+// missing.
+func requireArg(fs *flag.FlagSet, name string) string {
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "error: %s is required\n", name)
+		fmt.Fprint(os.Stderr, fileBucketUsage)
+		os.Exit(1)
+	}
+	return fs.Arg(0)
+}
+
+// owner formats the ownership recorded in meta, preferring names.
+func owner(meta files.FileBucketMeta) string {
+	user := meta.Owner
+	if user == "" {
+		user = fmt.Sprint(meta.UID)
+	}
+	group := meta.Group
+	if group == "" {
+		group = fmt.Sprint(meta.GID)
+	}
+	return user + ":" + group
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/zachfi/nodemanager/pkg/locker"
+)
+
+const locksUsage = `usage: nodemanager locks <command> [flags] [args]
+
+commands:
+  list             list the lock leases, their holders and why they hold them
+  release <lease>  force-release a lease held by a node, after confirmation
+`
+
+// runLocks implements `nodemanager locks`.
+//
+// Lists the Leases which coordinate upgrade groups, service lock groups and
+// jail update groups, and releases a lease whose holder is gone so that the
+// rest of its group can proceed.  It runs from an admin machine against the
+// cluster.
+func runLocks(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, locksUsage)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("locks "+args[0], flag.ExitOnError)
+	kubeconfig := fs.String("kubeconfig", "", "Admin kubeconfig (defaults to KUBECONFIG env / ~/.kube/config)")
+	namespace := fs.String("namespace", "nodemanager", "Kubernetes namespace for nodemanager objects")
+	yes := fs.Bool("yes", false, "release: do not ask for confirmation")
+	_ = fs.Parse(args[1:])
+
+	ctx := context.Background()
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = locksList(ctx, mustClientset(*kubeconfig), *namespace, os.Stdout)
+	case "release":
+		if fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "error: lease is required")
+			fmt.Fprint(os.Stderr, locksUsage)
+			os.Exit(1)
+		}
+		err = locksRelease(ctx, mustClientset(*kubeconfig), types.NamespacedName{Namespace: *namespace, Name: fs.Arg(0)}, *yes, os.Stdin, os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown locks command %q\n", args[0])
+		fmt.Fprint(os.Stderr, locksUsage)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func locksList(ctx context.Context, cs kubernetes.Interface, namespace string, out io.Writer) error {
+	locks, err := locker.ListLocks(ctx, cs, namespace)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LEASE\tHOLDER\tPURPOSE\tACQUIRED\tEXPIRES\tSLOTS")
+	for _, l := range locks {
+		holder, purpose, acquired, expires := "-", "-", "-", "-"
+		if l.Holder != "" {
+			holder, purpose = l.Holder, l.Purpose
+			acquired = age(l.AcquiredAt) + " ago"
+			expires = "expired"
+		}
+		if l.Held() {
+			expires = "in " + until(l.ExpiresAt)
+		}
+		slots := l.Slots
+		if slots == "" {
+			slots = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", l.Name, holder, purpose, acquired, expires, slots)
+	}
+	return w.Flush()
+}
+
+func locksRelease(ctx context.Context, cs kubernetes.Interface, req types.NamespacedName, yes bool, in io.Reader, out io.Writer) error {
+	locks, err := locker.ListLocks(ctx, cs, req.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var lock *locker.LockStatus
+	for i := range locks {
+		if locks[i].Name == req.Name {
+			lock = &locks[i]
+		}
+	}
+	if lock == nil || !lock.Held() {
+		return fmt.Errorf("lease %s is not held", req.String())
+	}
+
+	if !yes {
+		fmt.Fprintf(out, "lease %s is held by %s for %s, acquired %s ago.\n", req.String(), lock.Holder, lock.Purpose, age(lock.AcquiredAt))
+		fmt.Fprint(out, "Release it? The holder may still be acting on the lock. [y/N] ")
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("not released")
+		}
+	}
+
+	if err := locker.ForceRelease(ctx, cs, req, lock.Holder); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "released lease %s held by %s\n", req.String(), lock.Holder)
+	return nil
+}
+
+// age formats the time since t, rounded to the second.
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// until formats the time until t, rounded to the second.
+func until(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return time.Until(t).Round(time.Second).String()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/zachfi/nodemanager/pkg/locker"
+)
+
+func TestLocksRelease(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	req := types.NamespacedName{Namespace: "nodemanager", Name: "workers"}
+
+	cfg := locker.Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	cs := fake.NewSimpleClientset()
+	lkr := locker.NewLeaseLocker(ctx, logger, cfg, cs, req.Namespace, "node-a")
+	require.NoError(t, lkr.Lock(locker.WithPurpose(ctx, locker.PurposeUpgrade), req))
+
+	var out bytes.Buffer
+	require.NoError(t, locksList(ctx, cs, req.Namespace, &out))
+	require.Contains(t, out.String(), "workers  node-a  upgrade")
+
+	// Anything but yes leaves the lease alone.
+	out.Reset()
+	require.Error(t, locksRelease(ctx, cs, req, false, strings.NewReader("n\n"), &out))
+	require.Contains(t, out.String(), "held by node-a for upgrade")
+	require.True(t, lkr.Locked(ctx, req))
+
+	require.NoError(t, locksRelease(ctx, cs, req, false, strings.NewReader("y\n"), &out))
+	require.False(t, lkr.Locked(ctx, req))
+
+	require.ErrorContains(t, locksRelease(ctx, cs, req, true, nil, &out), "not held")
+}
@@ -118,6 +118,12 @@ func main() {
 		case "rbac":
 			runRBAC(os.Args[2:])
 			return
+		case "filebucket":
+			runFileBucket(os.Args[2:])
+			return
+		case "locks":
+			runLocks(os.Args[2:])
+			return
 		case "version", "-version", "--version":
 			fmt.Println(versionString())
 			return
@@ -240,7 +246,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	locker := locker.NewLeaseLocker(ctx, logger, cfg.ControllerConfig.Locker, clientset, cfg.ControllerConfig.Namespace, hostname)
+	locker := locker.NewSemaphoreLocker(ctx, logger, cfg.ControllerConfig.Locker, clientset, cfg.ControllerConfig.Namespace, hostname)
 
 	controller.SetBuildInfo(version, gitCommit, buildDate, goarch, goos)
 
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+// driftKinds are the resource kinds whose drift is reported in audit mode.
+// Executions are not state, so they cannot drift.
+var driftKinds = []string{"repository", "package", "file", "unit", "service", "user", "group"}
+
+// auditMode reports whether cs is evaluated without enforcement on node,
+// either because the ConfigSet opts out or because the node is in audit mode.
+func auditMode(cs *commonv1.ConfigSet, node commonv1.ManagedNode) bool {
+	if node.Spec.Audit {
+		return true
+	}
+	return cs.Spec.Enforce != nil && !*cs.Spec.Enforce
+}
+
+// driftFromPlan returns the resources which differ from the desired state
+// according to plan.  Service and unit restarts are left out: they follow
+// from a file which differs and say nothing about the service itself.
+func driftFromPlan(plan commonv1.ConfigSetPlan) []commonv1.DriftedResource {
+	var drift []commonv1.DriftedResource
+
+	add := func(kind string, changes []commonv1.PlannedChange) {
+		for _, c := range changes {
+			if (kind == "service" || kind == "unit") && c.Action == "restart" {
+				continue
+			}
+			drift = append(drift, commonv1.DriftedResource{Kind: kind, Name: c.Name, Action: c.Action})
+		}
+	}
+
+	add("repository", plan.Repositories)
+	add("package", plan.Packages)
+	add("file", plan.Files)
+	add("unit", plan.Units)
+	add("service", plan.Services)
+	add("user", plan.Users)
+	add("group", plan.Groups)
+
+	return drift
+}
+
+// recordDriftMetrics sets the drifted resource gauge of every kind for
+// configSetName, including the kinds which have no drift.
+func recordDriftMetrics(nodeName, configSetName string, drift []commonv1.DriftedResource) {
+	counts := make(map[string]int, len(driftKinds))
+	for _, d := range drift {
+		counts[d.Kind]++
+	}
+
+	for _, kind := range driftKinds {
+		driftedResources.WithLabelValues(nodeName, configSetName, kind).Set(float64(counts[kind]))
+	}
+}
+
+// clearDriftMetrics removes the drifted resource gauge for configSetName once
+// it is enforced again or no longer applies to the node.
+func clearDriftMetrics(nodeName, configSetName string) {
+	driftedResources.DeletePartialMatch(prometheus.Labels{"node": nodeName, "configset": configSetName})
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func TestAuditMode(t *testing.T) {
+	cs := &commonv1.ConfigSet{}
+	node := commonv1.ManagedNode{}
+	require.False(t, auditMode(cs, node))
+
+	cs.Spec.Enforce = ptr.To(true)
+	require.False(t, auditMode(cs, node))
+
+	cs.Spec.Enforce = ptr.To(false)
+	require.True(t, auditMode(cs, node))
+
+	cs.Spec.Enforce = ptr.To(true)
+	node.Spec.Audit = true
+	require.True(t, auditMode(cs, node), "the node override wins")
+}
+
+func TestAuditDrift(t *testing.T) {
+	dir := t.TempDir()
+	drifted := filepath.Join(dir, "drifted.conf")
+	matching := filepath.Join(dir, "matching.conf")
+	require.NoError(t, os.WriteFile(drifted, []byte("edited by hand\n"), 0o644))
+	require.NoError(t, os.WriteFile(matching, []byte("desired\n"), 0o644))
+
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+
+	fileSet := []commonv1.File{
+		{Path: drifted, Content: "desired\n"},
+		{Path: matching, Content: "desired\n"},
+	}
+	svcs := []commonv1.Service{
+		{Name: "app", Ensure: "running", SusbscribeFiles: []string{drifted}},
+		{Name: "sshd", Enable: true},
+		{Name: "telnetd", Enable: false},
+	}
+	sys.Service().(*mockServiceHandler).enabled = map[string]bool{"telnetd": true}
+
+	p := &planner{}
+	ctx := context.Background()
+	changed, _, err := r.handleFileSet(ctx, "test-node", "cs", "default", fileSet, commonv1.ManagedNode{}, p)
+	require.NoError(t, err)
+	_, _, _, err = r.handleServiceSet(ctx, "test-node", "default", svcs, fileSet, changed, nil, nil, nil, p)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(drifted)
+	require.NoError(t, err)
+	require.Equal(t, "edited by hand\n", string(data), "drift is not corrected")
+
+	p.plan.Packages = append(p.plan.Packages, commonv1.PlannedChange{Name: "curl", Action: "install"})
+	p.plan.Executions = append(p.plan.Executions, commonv1.PlannedChange{Name: "/bin/true", Action: "run"})
+
+	drift := driftFromPlan(p.plan)
+	require.Equal(t, []commonv1.DriftedResource{
+		{Kind: "package", Name: "curl", Action: "install"},
+		{Kind: "file", Name: drifted, Action: "write"},
+		{Kind: "service", Name: "app", Action: "start"},
+		{Kind: "service", Name: "sshd", Action: "enable"},
+		{Kind: "service", Name: "telnetd", Action: "disable"},
+	}, drift)
+
+	recordDriftMetrics("test-node", "audit-cs", drift)
+	require.Equal(t, 1.0, driftGauge(t, "test-node", "audit-cs", "file"))
+	require.Equal(t, 0.0, driftGauge(t, "test-node", "audit-cs", "user"))
+
+	clearDriftMetrics("test-node", "audit-cs")
+	require.False(t, driftedResources.DeleteLabelValues("test-node", "audit-cs", "file"), "the gauge was removed")
+}
+
+func driftGauge(t *testing.T, node, configSet, kind string) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, driftedResources.WithLabelValues(node, configSet, kind).Write(&m))
+	return m.GetGauge().GetValue()
+}
@@ -10,6 +10,8 @@ import (
 	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
 
 	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -21,7 +23,9 @@ type NodeData struct {
 	Labels     map[string]string
 	ConfigMaps map[string]string
 	Secrets    map[string][]byte
-	Status     commonv1.ManagedNodeStatus
+	// Facts is a shorthand for Status.Facts.
+	Facts  map[string]string
+	Status commonv1.ManagedNodeStatus
 }
 
 // NodeInfo carries the identity and observed state of a single ManagedNode,
@@ -76,6 +80,27 @@ func nodeLabelMatch(node commonv1.ManagedNode, matchers map[string]string) error
 	return errors.Wrap(ErrLabelsNotMatched, fmt.Sprintf(": %s to matchers: %s", node.Labels, matchers))
 }
 
+// configSetNodeMatch returns an error if cs does not apply to node.  When the
+// ConfigSet has a nodeSelector it is evaluated against the node labels;
+// otherwise the legacy behaviour of requiring every ConfigSet label to be
+// present on the node applies.
+func configSetNodeMatch(node commonv1.ManagedNode, cs *commonv1.ConfigSet) error {
+	if cs.Spec.NodeSelector == nil {
+		return nodeLabelMatch(node, cs.Labels)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(cs.Spec.NodeSelector)
+	if err != nil {
+		return errors.Wrap(err, "invalid nodeSelector")
+	}
+
+	if selector.Matches(labels.Set(node.Labels)) {
+		return nil
+	}
+
+	return errors.Wrap(ErrLabelsNotMatched, fmt.Sprintf(": %s to selector: %s", node.Labels, selector))
+}
+
 // createInitialNode will create a ManagedNode object.
 func createInitialNode(ctx context.Context, w client.Writer, obj client.Object) error {
 	if err := w.Create(ctx, obj); err != nil {
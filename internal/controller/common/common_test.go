@@ -1,7 +1,13 @@
 package common
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
 )
 
 func TestMatchAllLabels(t *testing.T) {
@@ -65,6 +71,100 @@ func TestMatchAllLabels(t *testing.T) {
 	}
 }
 
+func TestConfigSetNodeMatch(t *testing.T) {
+	node := commonv1.ManagedNode{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			"kubernetes.io/os": "arch",
+			"role":             "router",
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		spec    commonv1.ConfigSetSpec
+		matches bool
+		invalid bool
+	}{
+		{
+			name:    "legacy labels match",
+			labels:  map[string]string{"kubernetes.io/os": "arch"},
+			matches: true,
+		},
+		{
+			name:   "legacy labels without selector do not match",
+			labels: nil,
+		},
+		{
+			name:   "selector takes precedence over labels",
+			labels: map[string]string{"kubernetes.io/os": "arch"},
+			spec: commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"kubernetes.io/os": "alpine"},
+			}},
+		},
+		{
+			name: "os in set",
+			spec: commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "kubernetes.io/os", Operator: metav1.LabelSelectorOpIn, Values: []string{"arch", "alpine"}},
+				},
+			}},
+			matches: true,
+		},
+		{
+			name: "except routers",
+			spec: commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "kubernetes.io/os", Operator: metav1.LabelSelectorOpExists},
+					{Key: "role", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"router"}},
+				},
+			}},
+		},
+		{
+			name: "does not exist",
+			spec: commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "nodemanager/disabled", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			}},
+			matches: true,
+		},
+		{
+			name:    "empty selector matches every node",
+			spec:    commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{}},
+			matches: true,
+		},
+		{
+			name: "invalid operator",
+			spec: commonv1.ConfigSetSpec{NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "role", Operator: "Near"},
+				},
+			}},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &commonv1.ConfigSet{
+				ObjectMeta: metav1.ObjectMeta{Labels: tt.labels},
+				Spec:       tt.spec,
+			}
+			err := configSetNodeMatch(node, cs)
+			switch {
+			case tt.matches:
+				require.NoError(t, err)
+			case tt.invalid:
+				require.Error(t, err)
+				require.False(t, errors.Is(err, ErrLabelsNotMatched))
+			default:
+				require.ErrorIs(t, err, ErrLabelsNotMatched)
+			}
+		})
+	}
+}
+
 func TestSlicesEqual(t *testing.T) {
 	tests := []struct {
 		name string
@@ -6,6 +6,7 @@ import (
 
 	"github.com/zachfi/nodemanager/internal/controller/freebsd"
 	"github.com/zachfi/nodemanager/internal/notification"
+	"github.com/zachfi/nodemanager/pkg/facts"
 	"github.com/zachfi/nodemanager/pkg/locker"
 )
 
@@ -30,6 +31,19 @@ func (c *FileBucketConfig) RegisterFlagsAndApplyDefaults(prefix string, f *flag.
 	f.DurationVar(&c.MaxAge, prefix+".max-age", 7*24*time.Hour, "Remove blobs from the filebucket older than this duration (0 = keep forever)")
 }
 
+type FileWatchConfig struct {
+	// Enabled watches the files managed by each ConfigSet and reconciles the
+	// ConfigSet as soon as one is changed outside nodemanager.
+	Enabled bool `json:"enabled,omitempty"`
+	// Debounce coalesces a burst of changes into a single reconcile.
+	Debounce time.Duration `json:"debounce,omitempty"`
+}
+
+func (c *FileWatchConfig) RegisterFlagsAndApplyDefaults(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&c.Enabled, prefix+".enabled", false, "Watch managed files and correct drift as soon as they change (inotify on Linux, kqueue on FreeBSD)")
+	f.DurationVar(&c.Debounce, prefix+".debounce", 5*time.Second, "Wait this long after the last change to a managed file before reconciling its ConfigSet")
+}
+
 type ControllerConfig struct {
 	MetricsAddr          string
 	EnableLeaderElection bool
@@ -71,11 +85,18 @@ func (c *ControllerConfig) RegisterFlagsAndApplyDefaults(prefix string, f *flag.
 type ManagedNodeConfig struct {
 	ForgivenessPeriod time.Duration
 	DrainTimeout      time.Duration
+	// FactsDir holds executables whose output is published as custom facts.
+	FactsDir string
+	// FactsTTL is how long the output of the custom fact executables is
+	// reused before they are run again.
+	FactsTTL time.Duration
 }
 
 func (c *ManagedNodeConfig) RegisterFlagsAndApplyDefaults(prefix string, f *flag.FlagSet) {
 	f.DurationVar(&c.ForgivenessPeriod, prefix+".forgiveness-period", 1*time.Minute, "The duration to wait after a scheduled upgrade time before considering the upgrade missed and allowing a new upgrade to be scheduled.")
 	f.DurationVar(&c.DrainTimeout, prefix+".drain-timeout", 5*time.Minute, "The maximum duration to wait for pods to drain from a kubernetes node before proceeding with the upgrade.")
+	f.StringVar(&c.FactsDir, prefix+".facts-dir", facts.DefaultExternalDir, "Directory of executables whose JSON or key=value output is published as custom node facts (empty disables custom facts).")
+	f.DurationVar(&c.FactsTTL, prefix+".facts-ttl", 10*time.Minute, "How long the output of the custom fact executables is reused before they are run again (0 runs them on every reconcile).")
 }
 
 type ConfigSetConfig struct {
@@ -84,6 +105,7 @@ type ConfigSetConfig struct {
 	// It is not exposed as a CLI flag (the top-level namespace flag is used instead).
 	Namespace  string           `json:"-"`
 	FileBucket FileBucketConfig `json:"fileBucket,omitempty"`
+	FileWatch  FileWatchConfig  `json:"fileWatch,omitempty"`
 	// ReconcilePeriod controls how often the ConfigSet controller re-applies
 	// desired state even without a Kubernetes event.  Set to match the node's
 	// reconcilePeriod for consistent convergence behaviour.  Zero means
@@ -91,9 +113,18 @@ type ConfigSetConfig struct {
 	ReconcilePeriod time.Duration `json:"reconcilePeriod,omitempty"`
 	// GomplatePath is propagated from ControllerConfig at startup; not a CLI flag.
 	GomplatePath string `json:"-"`
+	// SourceCachePath is where file content downloaded from a source is
+	// cached, in the filebucket layout.
+	SourceCachePath string `json:"sourceCachePath,omitempty"`
+	// PlanOnly evaluates every ConfigSet in plan mode on this node: changes are
+	// computed and published to the ManagedNode status but never applied.
+	PlanOnly bool `json:"planOnly,omitempty"`
 }
 
 func (c *ConfigSetConfig) RegisterFlagsAndApplyDefaults(prefix string, f *flag.FlagSet) {
 	c.FileBucket.RegisterFlagsAndApplyDefaults(prefix+".file-bucket", f)
+	c.FileWatch.RegisterFlagsAndApplyDefaults(prefix+".file-watch", f)
 	f.DurationVar(&c.ReconcilePeriod, prefix+".reconcile-period", 0, "How often to re-apply ConfigSets regardless of events (0 = event-driven only).")
+	f.StringVar(&c.SourceCachePath, prefix+".source-cache-path", "/var/lib/nodemanager/cache", "Directory caching file content downloaded from a source, keyed by digest (empty disables the cache).")
+	f.BoolVar(&c.PlanOnly, prefix+".plan-only", false, "Compute and publish the changes each ConfigSet would make without applying them.")
 }
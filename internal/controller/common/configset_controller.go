@@ -36,6 +36,7 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -50,6 +51,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
 	"github.com/zachfi/nodemanager/pkg/files"
@@ -58,8 +60,13 @@ import (
 	"github.com/zachfi/nodemanager/pkg/packages"
 	"github.com/zachfi/nodemanager/pkg/services"
 	"github.com/zachfi/nodemanager/pkg/services/systemd"
+	"github.com/zachfi/nodemanager/pkg/templates"
 )
 
+// reconcileTimeout bounds a single ConfigSet reconcile, including the
+// executions it runs.
+const reconcileTimeout = 5 * time.Minute
+
 // ConfigSetReconciler reconciles a ConfigSet object
 type ConfigSetReconciler struct {
 	client.Client
@@ -70,11 +77,24 @@ type ConfigSetReconciler struct {
 	locker locker.Locker
 	cfg    ConfigSetConfig
 
+	// sources downloads file content from File.Source.
+	sources *files.SourceFetcher
+
+	// watcher enqueues a ConfigSet when one of its files is changed outside
+	// nodemanager.  Nil unless the file watch is enabled.
+	watcher *fileWatcher
+
 	// lastResourceVersion tracks the resource_version label most recently recorded
 	// for each (node, configset) pair so stale label sets can be deleted from the
 	// configSetAppliedResourceVersion gauge.
 	lastResourceVersionMu sync.Mutex
 	lastResourceVersion   map[string]string // key: "node/configset"
+
+	// pendingRestarts holds the restarts which were deferred because the
+	// lease of their lock group could not be acquired, so that they are
+	// retried on the next reconcile although their files no longer change.
+	pendingRestartsMu sync.Mutex
+	pendingRestarts   map[string]pendingRestart // key: "namespace/user/service"
 }
 
 func NewConfigSetReconciler(client client.Client, scheme *runtime.Scheme, logger *slog.Logger, cfg ConfigSetConfig, system handler.System, locker locker.Locker) *ConfigSetReconciler {
@@ -86,7 +106,9 @@ func NewConfigSetReconciler(client client.Client, scheme *runtime.Scheme, logger
 		locker:              locker,
 		system:              system,
 		cfg:                 cfg,
+		sources:             files.NewSourceFetcher(logger, nil, cfg.SourceCachePath),
 		lastResourceVersion: make(map[string]string),
+		pendingRestarts:     make(map[string]pendingRestart),
 	}
 }
 
@@ -101,7 +123,7 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	r.logger.Debug("reconciling configset", "configset", req.Name)
 
 	// Prevent a single stuck reconcile from blocking the worker indefinitely.
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
 	defer cancel()
 
 	var err error
@@ -144,7 +166,13 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	err = nodeLabelMatch(node, configSet.Labels)
+	err = configSetNodeMatch(node, &configSet)
+	if err != nil && !errors.Is(err, ErrLabelsNotMatched) {
+		r.logger.Error("configset has an invalid node selector, skipping", "configset", configSet.Name, "err", err)
+		r.removeConfigSetStatus(ctx, configSet.Name)
+		err = nil
+		return ctrl.Result{}, nil
+	}
 	if err != nil {
 		span.AddEvent("labels do not match, cleaning up status",
 			trace.WithAttributes(attribute.String("node", node.Name)))
@@ -170,7 +198,12 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				attribute.StringSlice("conflicts", conflicts)))
 		configSetConflictsTotal.WithLabelValues(nodeName, configSet.Name).Add(float64(len(conflicts)))
 		r.logger.Warn("configset has resource conflicts, skipping apply", "configset", configSet.Name, "conflicts", conflicts)
-		if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, configSet.Name, configSet.ResourceVersion, nil, conflicts); statusErr != nil {
+		if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, commonv1.ConfigSetApplyStatus{
+			Name:            configSet.Name,
+			ResourceVersion: configSet.ResourceVersion,
+			Generation:      configSet.Generation,
+			Conflicts:       conflicts,
+		}); statusErr != nil {
 			r.logger.Error("failed to update conflict status on node", "err", statusErr)
 		}
 		if statusErr := r.updateConfigSetCondition(ctx, req, conflicts); statusErr != nil {
@@ -185,41 +218,134 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		r.logger.Error("failed to clear conflict condition on configset", "err", statusErr)
 	}
 
+	// In plan mode every handler records what it would change instead of
+	// changing it.  Audit mode is evaluated the same way, and reports what
+	// would change as drift.  Dependencies are checked in the same mode.
+	var p *planner
+	planRequested := planAnnotated(&configSet) || r.cfg.PlanOnly
+	audit := auditMode(&configSet, node)
+	if planRequested || audit {
+		p = &planner{}
+		span.SetAttributes(attribute.Bool("plan", planRequested), attribute.Bool("audit", audit))
+	}
+
+	blocked, cycle, err := r.checkDependencies(ctx, &configSet, node, p != nil)
+	if err != nil {
+		r.logger.Error("failed to check dependencies", "configset", configSet.Name, "err", err)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if len(cycle) > 0 || len(blocked) > 0 {
+		reason, message := reasonDependenciesNotReady, fmt.Sprintf("waiting for dependencies: %s", strings.Join(blocked, ", "))
+		if len(cycle) > 0 {
+			reason, message = reasonDependencyCycle, fmt.Sprintf("dependency cycle: %s", strings.Join(cycle, " -> "))
+			blocked = []string{message}
+		}
+		span.AddEvent("dependencies not satisfied",
+			trace.WithAttributes(attribute.StringSlice("blocked", blocked)))
+		r.logger.Info("configset is blocked, skipping apply", "configset", configSet.Name, "blocked", blocked)
+		if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, commonv1.ConfigSetApplyStatus{
+			Name:            configSet.Name,
+			ResourceVersion: configSet.ResourceVersion,
+			Generation:      configSet.Generation,
+			Blocked:         blocked,
+		}); statusErr != nil {
+			r.logger.Error("failed to update blocked status on node", "err", statusErr)
+		}
+		if statusErr := r.updateBlockedCondition(ctx, req, reason, message); statusErr != nil {
+			r.logger.Error("failed to update blocked condition on configset", "err", statusErr)
+		}
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if len(configSet.Spec.DependsOn) > 0 {
+		if statusErr := r.updateBlockedCondition(ctx, req, "", ""); statusErr != nil {
+			r.logger.Error("failed to clear blocked condition on configset", "err", statusErr)
+		}
+	}
+
+	// A ConfigSet whose files were rolled back would only be rolled back
+	// again; wait for it to be fixed.
+	if p == nil && rolledBackOnNode(node, &configSet) {
+		r.logger.Warn("configset was rolled back on this node, skipping apply until it changes", "configset", configSet.Name)
+		return ctrl.Result{RequeueAfter: r.cfg.ReconcilePeriod}, nil
+	}
+
+	if r.watcher != nil {
+		r.watcher.begin(configSet.Name, configSet.Namespace)
+		defer r.watcher.track(configSet.Name, configSet.Namespace, watchedPaths(configSet.Spec.Files))
+	}
+
 	r.logger.Debug("applying configset", "configset", configSet.Name,
+		"plan", planRequested,
+		"audit", audit,
+		"repositories", len(configSet.Spec.Repositories),
 		"packages", len(configSet.Spec.Packages),
 		"files", len(configSet.Spec.Files),
+		"units", len(configSet.Spec.Units),
 		"services", len(configSet.Spec.Services),
-		"executions", len(configSet.Spec.Executions))
+		"executions", len(configSet.Spec.Executions),
+		"users", len(configSet.Spec.Users),
+		"groups", len(configSet.Spec.Groups))
 
 	applyStart := time.Now()
 
 	var (
 		changedFiles      []string
 		pkgErr            error
+		userErr           error
 		fileErr           error
+		unitErr           error
 		svcErr            error
 		execErr           error
 		fileBackupUpdates map[string]string
+		execStatuses      []commonv1.ExecStatus
+		heldPackages      []string
+		rolledBack        []string
+		unhealthy         []string
+		argServices       []string
 		phaseStart        time.Time
 	)
 
+	// Repositories are handled first, so that the packages may come from
+	// them.
 	phaseStart = time.Now()
-	pkgErr = r.handlePackageSet(ctx, nodeName, configSet.Spec.Packages)
+	repoErr := r.handleRepositorySet(ctx, nodeName, configSet.Spec.Repositories, p)
+	heldPackages, pkgErr = r.handlePackageSet(ctx, nodeName, configSet.Spec.Packages, heldPackagesFor(node, configSet.Name), p)
+	pkgErr = errors.Join(repoErr, pkgErr)
 	r.logger.Debug("packages handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "err", pkgErr)
 
+	// Users are handled after packages, which may provide their shells, and
+	// before files, which may be owned by them.
+	phaseStart = time.Now()
+	userErr = errors.Join(
+		r.handleGroupSet(ctx, nodeName, configSet.Spec.Groups, p),
+		r.handleUserSet(ctx, nodeName, configSet.Spec.Users, p),
+	)
+	r.logger.Debug("users handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "err", userErr)
+
 	phaseStart = time.Now()
-	changedFiles, fileBackupUpdates, fileErr = r.handleFileSet(ctx, nodeName, configSet.Name, req.Namespace, configSet.Spec.Files, node)
+	changedFiles, fileBackupUpdates, fileErr = r.handleFileSet(ctx, nodeName, configSet.Name, req.Namespace, configSet.Spec.Files, node, p)
 	r.logger.Debug("files handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "changed", len(changedFiles), "err", fileErr)
 
+	// Units are handled after files and before services, which may refer to
+	// them.
 	phaseStart = time.Now()
-	svcErr = r.handleServiceSet(ctx, nodeName, req.Namespace, configSet.Spec.Services, configSet.Spec.Files, changedFiles)
+	unitErr = r.handleUnitSet(ctx, nodeName, configSet.Spec.Units, p)
+	r.logger.Debug("units handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "err", unitErr)
+
+	phaseStart = time.Now()
+	rolledBack, unhealthy, argServices, svcErr = r.handleServiceSet(ctx, nodeName, req.Namespace, configSet.Spec.Services, configSet.Spec.Files, changedFiles, fileBackupUpdates, unhealthyServicesFor(node, configSet.Name), serviceArgumentsFor(node, configSet.Name), p)
 	r.logger.Debug("services handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "err", svcErr)
 
 	phaseStart = time.Now()
-	execErr = r.handleExecutions(ctx, configSet.Spec.Executions, changedFiles)
+	execStatuses, execErr = r.handleExecutions(ctx, configSet.Spec.Executions, changedFiles, execStatusesFor(node, configSet.Name), p)
 	r.logger.Debug("executions handled", "configset", configSet.Name, "duration", time.Since(phaseStart), "err", execErr)
 
-	err = errors.Join(pkgErr, fileErr, svcErr, execErr)
+	err = errors.Join(pkgErr, userErr, fileErr, unitErr, svcErr, execErr)
+
+	if p != nil {
+		return r.finishPlan(ctx, node, &configSet, p, planRequested, audit, err)
+	}
+	clearDriftMetrics(nodeName, configSet.Name)
 
 	if len(fileBackupUpdates) > 0 {
 		if backupErr := r.updateFileBackups(ctx, node.Name, node.Namespace, fileBackupUpdates); backupErr != nil {
@@ -242,10 +368,30 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		r.recordResourceVersion(nodeName, configSet.Name, configSet.ResourceVersion, now)
 	}
 
-	if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, configSet.Name, configSet.ResourceVersion, err, nil); statusErr != nil {
+	if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, commonv1.ConfigSetApplyStatus{
+		Name:             configSet.Name,
+		ResourceVersion:  configSet.ResourceVersion,
+		Generation:       configSet.Generation,
+		Error:            errorString(err),
+		Executions:       execStatuses,
+		RolledBack:       rolledBack,
+		HeldPackages:     heldPackages,
+		Unhealthy:        unhealthy,
+		ServiceArguments: argServices,
+	}); statusErr != nil {
 		r.logger.Error("failed to update configset status on node", "err", statusErr)
 	}
 
+	if len(rolledBack) > 0 {
+		if statusErr := r.updateDegradedCondition(ctx, req, nodeName, rolledBack); statusErr != nil {
+			r.logger.Error("failed to update degraded condition on configset", "err", statusErr)
+		}
+	} else if err == nil && meta.IsStatusConditionTrue(configSet.Status.Conditions, conditionDegraded) {
+		if statusErr := r.updateDegradedCondition(ctx, req, nodeName, nil); statusErr != nil {
+			r.logger.Error("failed to clear degraded condition on configset", "err", statusErr)
+		}
+	}
+
 	if err != nil {
 		// Use a fixed requeue instead of returning the error (which triggers
 		// exponential backoff and can delay retries up to 15 minutes).
@@ -255,6 +401,57 @@ func (r *ConfigSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	r.notifyResources(ctx, &configSet)
 
+	// Wake up for the next scheduled exec if that is sooner than the next
+	// periodic reconcile.
+	requeue := r.cfg.ReconcilePeriod
+	if next := nextExecRun(execStatuses); !next.IsZero() {
+		if untilNext := max(time.Until(next), time.Second); requeue == 0 || untilNext < requeue {
+			requeue = untilNext
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// finishPlan publishes the plan computed for cs to the ManagedNode status, and
+// in audit mode the drift it implies.  No apply metrics are recorded and no
+// notifications are sent, since nothing was changed on the node.
+func (r *ConfigSetReconciler) finishPlan(ctx context.Context, node commonv1.ManagedNode, cs *commonv1.ConfigSet, p *planner, showPlan, audit bool, planErr error) (ctrl.Result, error) {
+	r.logger.Info("computed configset plan", "configset", cs.Name,
+		"audit", audit,
+		"repositories", len(p.plan.Repositories),
+		"packages", len(p.plan.Packages),
+		"files", len(p.plan.Files),
+		"units", len(p.plan.Units),
+		"services", len(p.plan.Services),
+		"executions", len(p.plan.Executions),
+		"users", len(p.plan.Users),
+		"groups", len(p.plan.Groups),
+		"err", planErr)
+
+	entry := commonv1.ConfigSetApplyStatus{
+		Name:            cs.Name,
+		ResourceVersion: cs.ResourceVersion,
+		Generation:      cs.Generation,
+		Error:           errorString(planErr),
+	}
+	if showPlan {
+		entry.Plan = &p.plan
+	}
+	if audit {
+		entry.Audited = true
+		entry.Drift = driftFromPlan(p.plan)
+		recordDriftMetrics(node.Name, cs.Name, entry.Drift)
+	}
+
+	if statusErr := r.updateConfigSetStatus(ctx, node.Name, node.Namespace, entry); statusErr != nil {
+		r.logger.Error("failed to update configset plan on node", "err", statusErr)
+	}
+
+	if planErr != nil {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
 	if r.cfg.ReconcilePeriod > 0 {
 		return ctrl.Result{RequeueAfter: r.cfg.ReconcilePeriod}, nil
 	}
@@ -332,20 +529,39 @@ func (r *ConfigSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return fmt.Errorf("failed to get hostname for ManagedNode watch: %w", err)
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	if r.cfg.FileWatch.Enabled {
+		r.watcher, err = newFileWatcher(r.logger, hostname, r.cfg.FileWatch.Debounce)
+		if err != nil {
+			return fmt.Errorf("failed to create file watcher: %w", err)
+		}
+		if err := mgr.Add(r.watcher); err != nil {
+			return fmt.Errorf("failed to register file watcher runnable: %w", err)
+		}
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&commonv1.ConfigSet{}).
 		Watches(&corev1.Secret{}, ctrlhandler.EnqueueRequestsFromMapFunc(r.configSetsReferencingSecret)).
 		Watches(&corev1.ConfigMap{}, ctrlhandler.EnqueueRequestsFromMapFunc(r.configSetsReferencingConfigMap)).
 		// Watch the local ManagedNode so label changes (e.g. role labels set after
 		// startup) immediately re-trigger ConfigSet reconciliation without polling.
-		// Use LabelChangedPredicate so status-only updates (SSH keys, interfaces,
-		// WireGuard, configset apply results) don't cause a flood of reconciles.
+		// Spec changes (e.g. switching the node to audit mode) do the same.
+		// Status-only updates (SSH keys, interfaces, WireGuard, configset apply
+		// results) are filtered out so they don't cause a flood of reconciles.
 		Watches(&commonv1.ManagedNode{}, ctrlhandler.EnqueueRequestsFromMapFunc(r.configSetsOnNodeChange(hostname)),
-			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+			builder.WithPredicates(predicate.Or(predicate.LabelChangedPredicate{}, predicate.GenerationChangedPredicate{}))).
+		// Unblock ConfigSets with dependsOn as soon as a dependency is applied.
+		Watches(&commonv1.ManagedNode{}, ctrlhandler.EnqueueRequestsFromMapFunc(r.configSetsWithDependencies(hostname)),
+			builder.WithPredicates(appliedConfigSetsChanged)).
 		// Serialize ConfigSet reconciles so concurrent package installs from
 		// multiple ConfigSets matching the same node are not possible.
-		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1})
+
+	if r.watcher != nil {
+		b = b.WatchesRawSource(source.Channel(r.watcher.events, &ctrlhandler.EnqueueRequestForObject{}))
+	}
+
+	return b.Complete(r)
 }
 
 // runFileBucketGC runs the filebucket garbage collector on a durable schedule.
@@ -383,24 +599,36 @@ func (r *ConfigSetReconciler) runFileBucketGC(ctx context.Context) {
 	}
 }
 
-// configSetsOnNodeChange returns a mapper that enqueues all ConfigSets in the
-// controller namespace when the local ManagedNode changes. This ensures that
-// label changes on the node (e.g. role labels applied after startup) are
-// reflected in ConfigSet label-match evaluation without polling.
+// configSetsOnNodeChange returns a mapper that enqueues the ConfigSets in the
+// controller namespace affected by a change to the local ManagedNode: those
+// which now select the node, and those recorded in its status which may no
+// longer select it. This ensures that label changes on the node (e.g. role
+// labels applied after startup) are reflected in ConfigSet matching without
+// polling.
 func (r *ConfigSetReconciler) configSetsOnNodeChange(hostname string) ctrlhandler.MapFunc {
 	return func(ctx context.Context, obj client.Object) []reconcile.Request {
 		if obj.GetName() != hostname {
 			return nil
 		}
+		node, ok := obj.(*commonv1.ManagedNode)
+		if !ok {
+			return nil
+		}
 		var list commonv1.ConfigSetList
 		if err := r.List(ctx, &list, client.InNamespace(r.cfg.Namespace)); err != nil {
 			return nil
 		}
-		reqs := make([]reconcile.Request, len(list.Items))
-		for i, cs := range list.Items {
-			reqs[i] = reconcile.Request{
-				NamespacedName: types.NamespacedName{Name: cs.Name, Namespace: cs.Namespace},
+		var reqs []reconcile.Request
+		for _, cs := range list.Items {
+			recorded := slices.ContainsFunc(node.Status.ConfigSets, func(s commonv1.ConfigSetApplyStatus) bool {
+				return s.Name == cs.Name
+			})
+			if !recorded && configSetNodeMatch(*node, &cs) != nil {
+				continue
 			}
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: cs.Name, Namespace: cs.Namespace},
+			})
 		}
 		return reqs
 	}
@@ -448,18 +676,12 @@ func (r *ConfigSetReconciler) configSetsReferencingConfigMap(ctx context.Context
 	return reqs
 }
 
-// updateConfigSetStatus records the result of a ConfigSet reconciliation in the ManagedNode status.
-// conflicts is non-nil when a conflict was detected; applyErr is non-nil when apply itself failed.
-func (r *ConfigSetReconciler) updateConfigSetStatus(ctx context.Context, nodeName, nodeNamespace, configSetName, resourceVersion string, applyErr error, conflicts []string) error {
-	entry := commonv1.ConfigSetApplyStatus{
-		Name:            configSetName,
-		ResourceVersion: resourceVersion,
-		LastApplied:     metav1.Now(),
-		Conflicts:       conflicts,
-	}
-	if applyErr != nil {
-		entry.Error = applyErr.Error()
-	}
+// updateConfigSetStatus records the result of a ConfigSet reconciliation in the ManagedNode status,
+// replacing the existing entry for the ConfigSet.  Exec statuses and held packages are carried over
+// from the existing entry when entry has none, since they are only evaluated on apply.
+func (r *ConfigSetReconciler) updateConfigSetStatus(ctx context.Context, nodeName, nodeNamespace string, entry commonv1.ConfigSetApplyStatus) error {
+	entry.LastApplied = metav1.Now()
+	configSetName := entry.Name
 
 	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		var node commonv1.ManagedNode
@@ -470,12 +692,41 @@ func (r *ConfigSetReconciler) updateConfigSetStatus(ctx context.Context, nodeNam
 		found := false
 		for i, cs := range node.Status.ConfigSets {
 			if cs.Name == configSetName {
+				// Keep the exec history when the execs were not evaluated,
+				// e.g. on a conflict, block or plan.
+				if entry.Executions == nil {
+					entry.Executions = cs.Executions
+				}
+				// Likewise the packages held by the ConfigSet, which are
+				// only known after the packages were applied.
+				if entry.HeldPackages == nil {
+					entry.HeldPackages = cs.HeldPackages
+				}
+				// And the services which failed their health check, which
+				// are only checked on apply.
+				if entry.Unhealthy == nil {
+					entry.Unhealthy = cs.Unhealthy
+				}
+				// And the services whose arguments the ConfigSet sets.
+				if entry.ServiceArguments == nil {
+					entry.ServiceArguments = cs.ServiceArguments
+				}
 				// Skip the write if nothing meaningful changed — avoids triggering
 				// a ManagedNode watch event (and a downstream ManagedNode reconcile)
 				// on every ConfigSet reconcile.
 				if cs.ResourceVersion == entry.ResourceVersion &&
+					cs.Generation == entry.Generation &&
 					cs.Error == entry.Error &&
-					slicesEqual(cs.Conflicts, entry.Conflicts) {
+					slicesEqual(cs.Conflicts, entry.Conflicts) &&
+					slicesEqual(cs.Blocked, entry.Blocked) &&
+					slicesEqual(cs.RolledBack, entry.RolledBack) &&
+					equality.Semantic.DeepEqual(cs.Plan, entry.Plan) &&
+					cs.Audited == entry.Audited &&
+					equality.Semantic.DeepEqual(cs.Drift, entry.Drift) &&
+					equality.Semantic.DeepEqual(cs.Executions, entry.Executions) &&
+					slicesEqual(cs.HeldPackages, entry.HeldPackages) &&
+					slicesEqual(cs.Unhealthy, entry.Unhealthy) &&
+					slicesEqual(cs.ServiceArguments, entry.ServiceArguments) {
 					return nil
 				}
 				node.Status.ConfigSets[i] = entry
@@ -543,6 +794,10 @@ func (r *ConfigSetReconciler) removeConfigSetStatus(ctx context.Context, configS
 	configSetApplyTotal.DeleteLabelValues(nodeName, configSetName, "error")
 	fileChangesTotal.DeleteLabelValues(nodeName, configSetName, "success")
 	fileChangesTotal.DeleteLabelValues(nodeName, configSetName, "error")
+	clearDriftMetrics(nodeName, configSetName)
+	if r.watcher != nil {
+		r.watcher.forget(configSetName)
+	}
 
 	r.lastResourceVersionMu.Lock()
 	key := nodeName + "/" + configSetName
@@ -557,32 +812,37 @@ func (r *ConfigSetReconciler) removeConfigSetStatus(ctx context.Context, configS
 // updateConfigSetCondition sets or clears the Conflicted condition on the ConfigSet itself.
 // Pass a non-nil conflicts slice to set the condition; pass nil to clear it.
 func (r *ConfigSetReconciler) updateConfigSetCondition(ctx context.Context, req ctrl.Request, conflicts []string) error {
+	var condition metav1.Condition
+	if len(conflicts) > 0 {
+		condition = metav1.Condition{
+			Type:    "Conflicted",
+			Status:  metav1.ConditionTrue,
+			Reason:  "ResourceConflict",
+			Message: fmt.Sprintf("resource overlap with another ConfigSet: %s", conflicts),
+		}
+	} else {
+		condition = metav1.Condition{
+			Type:    "Conflicted",
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoConflict",
+			Message: "",
+		}
+	}
+
+	return r.setConfigSetCondition(ctx, req, condition)
+}
+
+// setConfigSetCondition records condition on the ConfigSet, skipping the
+// write when an identical condition is already present.
+func (r *ConfigSetReconciler) setConfigSetCondition(ctx context.Context, req ctrl.Request, condition metav1.Condition) error {
 	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		var cs commonv1.ConfigSet
 		if err := r.Get(ctx, req.NamespacedName, &cs); err != nil {
 			return err
 		}
 
-		var condition metav1.Condition
-		if len(conflicts) > 0 {
-			condition = metav1.Condition{
-				Type:               "Conflicted",
-				Status:             metav1.ConditionTrue,
-				Reason:             "ResourceConflict",
-				Message:            fmt.Sprintf("resource overlap with another ConfigSet: %s", conflicts),
-				LastTransitionTime: metav1.Now(),
-				ObservedGeneration: cs.Generation,
-			}
-		} else {
-			condition = metav1.Condition{
-				Type:               "Conflicted",
-				Status:             metav1.ConditionFalse,
-				Reason:             "NoConflict",
-				Message:            "",
-				LastTransitionTime: metav1.Now(),
-				ObservedGeneration: cs.Generation,
-			}
-		}
+		condition.LastTransitionTime = metav1.Now()
+		condition.ObservedGeneration = cs.Generation
 
 		existing := meta.FindStatusCondition(cs.Status.Conditions, condition.Type)
 		if existing != nil &&
@@ -616,6 +876,20 @@ func slicesEqual(a, b []string) bool {
 	return true
 }
 
+// errorString returns the message of err, or "" when err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// ownedFile is a File of the named ConfigSet.
+type ownedFile struct {
+	commonv1.File
+	owner string
+}
+
 func (r *ConfigSetReconciler) detectConflicts(ctx context.Context, cs *commonv1.ConfigSet, node commonv1.ManagedNode) ([]string, error) {
 	var all commonv1.ConfigSetList
 	if err := r.List(ctx, &all, client.InNamespace(cs.Namespace)); err != nil {
@@ -623,19 +897,37 @@ func (r *ConfigSetReconciler) detectConflicts(ctx context.Context, cs *commonv1.
 	}
 
 	// Build resource inventory from all OTHER ConfigSets that match this node.
-	claimedFiles := make(map[string]string)    // path → owning configset name
-	claimedServices := make(map[string]string) // name → owning configset name
-	claimedPackages := make(map[string]string) // name → owning configset name
+	claimedFiles := make(map[string]string)      // path → owning configset name
+	claimedParts := make(map[string]string)      // path → configset managing part of it
+	claimedKeys := make(map[string]string)       // path + claim → owning configset name
+	claimedLines := make(map[string][]ownedFile) // path → lineinfile edits of it
+	claimedServices := make(map[string]string)   // name → owning configset name
+	claimedPackages := make(map[string]string)   // name → owning configset name
+	claimedRepos := make(map[string]string)      // name → owning configset name
+	claimedUnits := make(map[string]string)      // unit id → owning configset name
+	claimedUsers := make(map[string]string)      // name → owning configset name
+	claimedGroups := make(map[string]string)     // name → owning configset name
 
 	for _, other := range all.Items {
 		if other.Name == cs.Name {
 			continue
 		}
-		if nodeLabelMatch(node, other.Labels) != nil {
+		if configSetNodeMatch(node, &other) != nil {
 			continue // doesn't apply to this node
 		}
 		for _, f := range other.Spec.Files {
-			claimedFiles[f.Path] = other.Name
+			if !files.FileEnsureFromString(f.Ensure).Partial() {
+				claimedFiles[f.Path] = other.Name
+				continue
+			}
+			claimedParts[f.Path] = other.Name
+			if files.FileEnsureFromString(f.Ensure) == files.LineInFile {
+				claimedLines[f.Path] = append(claimedLines[f.Path], ownedFile{f, other.Name})
+				continue
+			}
+			for _, c := range fileClaims(f) {
+				claimedKeys[f.Path+"\x00"+c] = other.Name
+			}
 		}
 		for _, s := range other.Spec.Services {
 			claimedServices[s.Name] = other.Name
@@ -643,12 +935,46 @@ func (r *ConfigSetReconciler) detectConflicts(ctx context.Context, cs *commonv1.
 		for _, p := range other.Spec.Packages {
 			claimedPackages[p.Name] = other.Name
 		}
+		for _, repo := range other.Spec.Repositories {
+			claimedRepos[repo.Name] = other.Name
+		}
+		for _, u := range other.Spec.Units {
+			claimedUnits[unitID(u)] = other.Name
+		}
+		for _, u := range other.Spec.Users {
+			claimedUsers[u.Name] = other.Name
+		}
+		for _, g := range other.Spec.Groups {
+			claimedGroups[g.Name] = other.Name
+		}
 	}
 
 	var conflicts []string
 	for _, f := range cs.Spec.Files {
+		// A file managed as a whole conflicts with any other claim on the
+		// path; partial edits only conflict on the same line or key.
 		if owner, ok := claimedFiles[f.Path]; ok {
 			conflicts = append(conflicts, fmt.Sprintf("file:%s (also in configset %q)", f.Path, owner))
+			continue
+		}
+		if !files.FileEnsureFromString(f.Ensure).Partial() {
+			if owner, ok := claimedParts[f.Path]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("file:%s (also in configset %q)", f.Path, owner))
+			}
+			continue
+		}
+		if files.FileEnsureFromString(f.Ensure) == files.LineInFile {
+			for _, claimed := range claimedLines[f.Path] {
+				if lineEditsConflict(f, claimed.File) {
+					conflicts = append(conflicts, fmt.Sprintf("file:%s %s (also in configset %q)", f.Path, fileClaims(f)[0], claimed.owner))
+				}
+			}
+			continue
+		}
+		for _, c := range fileClaims(f) {
+			if owner, ok := claimedKeys[f.Path+"\x00"+c]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("file:%s %s (also in configset %q)", f.Path, c, owner))
+			}
 		}
 	}
 	for _, s := range cs.Spec.Services {
@@ -661,53 +987,362 @@ func (r *ConfigSetReconciler) detectConflicts(ctx context.Context, cs *commonv1.
 			conflicts = append(conflicts, fmt.Sprintf("package:%s (also in configset %q)", p.Name, owner))
 		}
 	}
+	for _, repo := range cs.Spec.Repositories {
+		if owner, ok := claimedRepos[repo.Name]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("repository:%s (also in configset %q)", repo.Name, owner))
+		}
+	}
+	for _, u := range cs.Spec.Units {
+		if owner, ok := claimedUnits[unitID(u)]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("unit:%s (also in configset %q)", unitID(u), owner))
+		}
+	}
+	for _, u := range cs.Spec.Users {
+		if owner, ok := claimedUsers[u.Name]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("user:%s (also in configset %q)", u.Name, owner))
+		}
+	}
+	for _, g := range cs.Spec.Groups {
+		if owner, ok := claimedGroups[g.Name]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("group:%s (also in configset %q)", g.Name, owner))
+		}
+	}
 
 	return conflicts, nil
 }
 
-func (r *ConfigSetReconciler) handlePackageSet(ctx context.Context, nodeName string, packageSet []commonv1.Package) error {
+// handleRepositorySet configures each repository, and refreshes the package
+// metadata once when any of them changed.
+func (r *ConfigSetReconciler) handleRepositorySet(ctx context.Context, nodeName string, repos []commonv1.Repository, p *planner) error {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	ctx, span := r.tracer.Start(ctx, "handleRepositorySet")
+	defer span.End()
+
+	handler := r.system.Package()
+
+	var (
+		errs    []error
+		changed bool
+	)
+	for _, repo := range repos {
+		want := repositoryFromSpec(repo)
+
+		if p != nil {
+			configured, err := handler.RepositoryConfigured(ctx, want)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("repository %q: %w", repo.Name, err))
+				continue
+			}
+			if !configured {
+				p.addRepository(repo.Name, "configure", repo.URL)
+			}
+			continue
+		}
+
+		repoChanged, err := handler.EnsureRepository(ctx, want)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("repository %q: %w", repo.Name, err))
+		}
+		if repoChanged || err != nil {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+			packageOperationsTotal.WithLabelValues(nodeName, "repository", result).Inc()
+		}
+		changed = changed || repoChanged
+	}
+
+	if changed {
+		r.logger.Info("refreshing package metadata")
+		err := handler.Refresh(ctx)
+		result := "success"
+		if err != nil {
+			result = "error"
+			errs = append(errs, fmt.Errorf("failed to refresh package metadata: %w", err))
+		}
+		packageOperationsTotal.WithLabelValues(nodeName, "refresh", result).Inc()
+	}
+
+	return errors.Join(errs...)
+}
+
+func repositoryFromSpec(repo commonv1.Repository) packages.Repository {
+	return packages.Repository{
+		Name:     repo.Name,
+		URL:      repo.URL,
+		Key:      repo.Key,
+		Priority: int(repo.Priority),
+		Enabled:  repo.Enabled == nil || *repo.Enabled,
+	}
+}
+
+// handlePackageSet ensures the state of each package, and holds or releases
+// it.  The packages to install and those to remove are each handled in a
+// single transaction.  owned lists the packages the ConfigSet held after its previous apply;
+// those it no longer holds are released.  The packages the ConfigSet holds
+// are returned.
+func (r *ConfigSetReconciler) handlePackageSet(ctx context.Context, nodeName string, packageSet []commonv1.Package, owned []string, p *planner) ([]string, error) {
 	ctx, span := r.tracer.Start(ctx, "handlePackageSet")
 	defer span.End()
 
 	handler := r.system.Package()
 
-	pkgs, err := handler.List(ctx)
+	installedPkgs, err := handler.List(ctx)
 	if err != nil {
-		return err
+		return owned, err
+	}
+
+	// Packages which follow the latest version are compared against the
+	// versions the repositories offer.
+	var latest []string
+	for _, pkg := range packageSet {
+		if packages.PackageEnsureFromString(pkg.Ensure) == packages.Latest {
+			latest = append(latest, pkg.Name)
+		}
+	}
+	available := make(map[string]string)
+	if len(latest) > 0 {
+		available, err = handler.Available(ctx, latest)
+		if err != nil {
+			return owned, fmt.Errorf("failed to list available packages: %w", err)
+		}
+	}
+
+	held := make(map[string]bool)
+	if needsHeld(packageSet, owned) {
+		names, err := handler.Held(ctx)
+		if err != nil {
+			return owned, fmt.Errorf("failed to list held packages: %w", err)
+		}
+		for _, name := range names {
+			held[name] = true
+		}
 	}
 
 	var errs []error
+	// holds is never nil, so that an apply which releases every hold is
+	// recorded.
+	holds := []string{}
+
+	setHold := func(name string, hold bool) error {
+		if held[name] == hold {
+			return nil
+		}
+		action := "unhold"
+		if hold {
+			action = "hold"
+		}
+		if p != nil {
+			p.addPackage(name, action, "")
+			return nil
+		}
+
+		var holdErr error
+		if hold {
+			holdErr = handler.Hold(ctx, name)
+		} else {
+			holdErr = handler.Unhold(ctx, name)
+		}
+		result := "success"
+		if holdErr != nil {
+			result = "error"
+		} else {
+			held[name] = hold
+		}
+		packageOperationsTotal.WithLabelValues(nodeName, action, result).Inc()
+		return holdErr
+	}
+
+	var (
+		installs []packages.Package
+		removes  []string
+		// wantHolds is the hold state of each installed package once the
+		// transactions are done.
+		wantHolds []packageHold
+	)
+
+	declared := make(map[string]bool, len(packageSet))
 	for _, pkg := range packageSet {
-		switch packages.PackageEnsureFromString(pkg.Ensure) {
-		case packages.Installed:
-			installedVersion, installed := pkgs[pkg.Name]
-			needsInstall := !installed || (pkg.Version != "" && installedVersion != pkg.Version)
-			if needsInstall {
-				installErr := handler.Install(ctx, pkg.Name, pkg.Version)
-				result := "success"
-				if installErr != nil {
-					result = "error"
-					errs = append(errs, installErr)
+		declared[pkg.Name] = true
+
+		switch ensure := packages.PackageEnsureFromString(pkg.Ensure); ensure {
+		case packages.Installed, packages.Latest:
+			// Holds placed by hand are kept; those placed by this ConfigSet
+			// are released once it no longer asks for them.
+			wantHold := pkg.Hold || (held[pkg.Name] && !slices.Contains(owned, pkg.Name))
+			if pkg.Hold {
+				holds = append(holds, pkg.Name)
+			}
+
+			installedVersion, installed := installedPkgs[pkg.Name]
+			needsInstall := !installed || (pkg.Version != "" && !versionMatches(handler, installedVersion, pkg.Version))
+			action, detail := "install", pkg.Version
+			if availableVersion, ok := available[pkg.Name]; ok && ensure == packages.Latest && installed && installedVersion != availableVersion {
+				needsInstall = true
+				action, detail = "upgrade", availableVersion
+			}
+
+			if needsInstall && p != nil {
+				p.addPackage(pkg.Name, action, detail)
+			} else if needsInstall {
+				// A held package cannot change version; release the hold
+				// for the install and place it again afterwards.
+				if err := setHold(pkg.Name, false); err != nil {
+					errs = append(errs, err)
+					continue
 				}
-				packageOperationsTotal.WithLabelValues(nodeName, "install", result).Inc()
+				installs = append(installs, packages.Package{Name: pkg.Name, Version: pkg.Version})
 			}
+
+			wantHolds = append(wantHolds, packageHold{name: pkg.Name, hold: wantHold})
 		case packages.Absent:
-			if _, installed := pkgs[pkg.Name]; installed {
-				r.logger.Info("removing package", "name", pkg.Name)
-				removeErr := handler.Remove(ctx, pkg.Name)
-				result := "success"
-				if removeErr != nil {
-					result = "error"
-					errs = append(errs, removeErr)
+			if _, installed := installedPkgs[pkg.Name]; installed && p != nil {
+				p.addPackage(pkg.Name, "remove", "")
+			} else if installed {
+				// Package managers refuse to remove a held package.
+				if err := setHold(pkg.Name, false); err != nil {
+					errs = append(errs, err)
+					continue
 				}
-				packageOperationsTotal.WithLabelValues(nodeName, "remove", result).Inc()
+				removes = append(removes, pkg.Name)
 			}
 		default:
 			errs = append(errs, fmt.Errorf("unhandled Ensure value %q for package %q", pkg.Ensure, pkg.Name))
 		}
 	}
 
-	return errors.Join(errs...)
+	// Each transaction resolves its packages together and syncs the package
+	// metadata at most once.
+	missing := make(map[string]bool)
+	if len(installs) > 0 {
+		installErr := handler.InstallPackages(ctx, installs)
+		result := "success"
+		if installErr != nil {
+			result = "error"
+			errs = append(errs, fmt.Errorf("failed to install packages: %w", installErr))
+			// A package which was installed keeps its previous version, and
+			// its hold is placed again below; one which never was installed
+			// cannot be held.
+			for _, pkg := range installs {
+				if _, installed := installedPkgs[pkg.Name]; !installed {
+					missing[pkg.Name] = true
+				}
+			}
+		}
+		packageOperationsTotal.WithLabelValues(nodeName, "install", result).Add(float64(len(installs)))
+	}
+
+	if len(removes) > 0 {
+		r.logger.Info("removing packages", "names", removes)
+		removeErr := handler.RemovePackages(ctx, removes)
+		result := "success"
+		if removeErr != nil {
+			result = "error"
+			errs = append(errs, fmt.Errorf("failed to remove packages: %w", removeErr))
+		}
+		packageOperationsTotal.WithLabelValues(nodeName, "remove", result).Add(float64(len(removes)))
+	}
+
+	for _, h := range wantHolds {
+		if missing[h.name] {
+			holds = slices.DeleteFunc(holds, func(name string) bool { return name == h.name })
+			continue
+		}
+		if err := setHold(h.name, h.hold); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Release the holds of packages which were dropped from the ConfigSet.
+	for _, name := range owned {
+		if declared[name] {
+			continue
+		}
+		if err := setHold(name, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Holds which could not be released are kept, so that the release is
+	// retried on the next apply.
+	for _, name := range owned {
+		if held[name] && !slices.Contains(holds, name) {
+			holds = append(holds, name)
+		}
+	}
+
+	if p != nil {
+		// Nothing was changed, so the ConfigSet still holds what it held.
+		return owned, errors.Join(errs...)
+	}
+
+	slices.Sort(holds)
+	return holds, errors.Join(errs...)
+}
+
+// versionMatches reports whether the installed version of a package is the
+// requested one, as compared by the package handler.
+func versionMatches(h handler.PackageHandler, installed, requested string) bool {
+	if m, ok := h.(handler.VersionMatcher); ok {
+		return m.VersionMatches(installed, requested)
+	}
+	return installed == requested
+}
+
+// heldPackagesFor returns the packages held by the named ConfigSet on the node.
+func heldPackagesFor(node commonv1.ManagedNode, configSetName string) []string {
+	for _, cs := range node.Status.ConfigSets {
+		if cs.Name == configSetName {
+			return cs.HeldPackages
+		}
+	}
+	return nil
+}
+
+// unhealthyServicesFor returns the services of the named ConfigSet which
+// failed their health check on the node.
+func unhealthyServicesFor(node commonv1.ManagedNode, configSetName string) []string {
+	for _, cs := range node.Status.ConfigSets {
+		if cs.Name == configSetName {
+			return cs.Unhealthy
+		}
+	}
+	return nil
+}
+
+// serviceArgumentsFor returns the services whose arguments the named
+// ConfigSet sets on the node.
+func serviceArgumentsFor(node commonv1.ManagedNode, configSetName string) []string {
+	for _, cs := range node.Status.ConfigSets {
+		if cs.Name == configSetName {
+			return cs.ServiceArguments
+		}
+	}
+	return nil
+}
+
+// packageHold is the hold state of a package.
+type packageHold struct {
+	name string
+	hold bool
+}
+
+// needsHeld reports whether the held packages must be known to apply the
+// package set.
+func needsHeld(packageSet []commonv1.Package, owned []string) bool {
+	if len(owned) > 0 {
+		return true
+	}
+	for _, pkg := range packageSet {
+		if pkg.Hold {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *ConfigSetReconciler) WithTracer(tracer trace.Tracer) {
@@ -722,7 +1357,18 @@ func (r *ConfigSetReconciler) WithSystem(system handler.System) {
 	r.system = system
 }
 
-func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName string, namespace string, serviceSet []commonv1.Service, fileSet []commonv1.File, changedFiles []string) error {
+// handleServiceSet ensures the state of each service and restarts those
+// subscribed to a changed file.  When a restart fails, the changed files
+// which opted in to rollback are restored from backups, the filebucket
+// hashes of their previous content, and the restart is retried.  The
+// restored paths are returned, along with the services which failed their
+// health check.  Services in wasUnhealthy, which failed it on a previous
+// reconcile, are checked again.  A restart whose lock group is held by
+// another node is deferred to the next reconcile rather than rolled back.
+// ownedArgs lists the services whose arguments the ConfigSet set on its
+// previous apply; those it no longer sets are cleared.  The services whose
+// arguments it sets are returned.
+func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName string, namespace string, serviceSet []commonv1.Service, fileSet []commonv1.File, changedFiles []string, backups map[string]string, wasUnhealthy []string, ownedArgs []string, p *planner) ([]string, []string, []string, error) {
 	ctx, span := r.tracer.Start(ctx, "handleServiceSet")
 	defer span.End()
 
@@ -737,8 +1383,18 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 	var (
 		errs            []error
 		restartServices = make(map[string]restartService)
+		rolledBack      []string
+		unhealthy       []string
+		argServices     = []string{}
+		checked         = make(map[string]bool)
 	)
 
+	// The unhealthy services are only known once they are checked, so a plan
+	// leaves the list from the last apply in place.
+	if p == nil {
+		unhealthy = []string{}
+	}
+
 	for _, cf := range changedFiles {
 		for _, svc := range serviceSet {
 			svcCtx := serviceContext(ctx, svc.User)
@@ -754,6 +1410,34 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 		}
 	}
 
+	// A restart deferred on a previous reconcile is retried, with the files
+	// which changed then, so that they may still be rolled back.
+	for _, svc := range serviceSet {
+		if svc.Ensure != services.Running.String() {
+			continue
+		}
+		pending, ok := r.pendingRestart(namespace, svc)
+		if !ok {
+			continue
+		}
+		r.logger.Debug("retrying deferred restart", "service", svc.Name, "files", pending.changedFiles)
+		restartServices[svc.Name] = restartService{serviceContext(ctx, svc.User), svc}
+		for _, cf := range pending.changedFiles {
+			if !slices.Contains(changedFiles, cf) {
+				changedFiles = append(changedFiles, cf)
+			}
+		}
+		backups = maps.Clone(backups)
+		if backups == nil {
+			backups = make(map[string]string)
+		}
+		for path, hash := range pending.backups {
+			if _, ok := backups[path]; !ok {
+				backups[path] = hash
+			}
+		}
+	}
+
 	for _, svc := range serviceSet {
 		svcCtx := serviceContext(ctx, svc.User)
 		svcHandler := withUserContext(handler, svcCtx)
@@ -765,6 +1449,16 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 
 		if rcFileManaged {
 			r.logger.Debug("skipping sysrc calls for service with managed rc.conf.d file", "service", svc.Name, "path", rcConfPath)
+		} else if p != nil {
+			enabled, enabledErr := svcHandler.Enabled(svcCtx, svc.Name)
+			switch {
+			case enabledErr != nil:
+				errs = append(errs, fmt.Errorf("failed to read the enablement of service %q: %w", svc.Name, enabledErr))
+			case svc.Enable && !enabled:
+				p.addService(svc.Name, "enable", "disabled")
+			case !svc.Enable && enabled:
+				p.addService(svc.Name, "disable", "enabled")
+			}
 		} else {
 			if svc.Enable {
 				enableErr := svcHandler.Enable(svcCtx, svc.Name)
@@ -783,20 +1477,40 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 				}
 				serviceOperationsTotal.WithLabelValues(nodeName, "disable", result).Inc()
 			}
+		}
 
-			if svc.Arguments != "" {
-				if argsErr := svcHandler.SetArguments(svcCtx, svc.Name, svc.Arguments); argsErr != nil {
-					errs = append(errs, fmt.Errorf("failed to set service arguments for %q: %w", svc.Name, argsErr))
-				}
+		// Arguments the ConfigSet set before are cleared once they are
+		// removed from the Service.  Those which could not be cleared are
+		// kept, so that clearing them is retried on the next apply.
+		var argsChanged bool
+		ownsArgs := slices.Contains(ownedArgs, svc.Name)
+		if !rcFileManaged && (svc.Arguments != "" || ownsArgs) {
+			changed, argsErr := r.setServiceArguments(svcCtx, svcHandler, svc.Name, svc.Arguments, p)
+			if argsErr != nil {
+				errs = append(errs, fmt.Errorf("failed to set service arguments for %q: %w", svc.Name, argsErr))
 			}
+			argsChanged = changed
+			ownsArgs = svc.Arguments != "" || argsErr != nil
+		}
+		if ownsArgs {
+			argServices = append(argServices, svc.Name)
 		}
 
 		status, _ := svcHandler.Status(svcCtx, svc.Name)
 		span.SetAttributes(attribute.String("status", status.String()))
 
+		// A running service is restarted for changed arguments to apply; a
+		// stopped one picks them up when it is started below.
+		if argsChanged && status == services.Running && svc.Ensure == services.Running.String() {
+			r.logger.Debug("changed arguments will restart service", "service", svc.Name)
+			restartServices[svc.Name] = restartService{svcCtx, svc}
+		}
+
 		switch services.ServiceStatusFromString(svc.Ensure) {
 		case services.Running:
-			if status != services.Running {
+			if status != services.Running && p != nil {
+				p.addService(svc.Name, "start", status.String())
+			} else if status != services.Running {
 				startErr := svcHandler.Start(svcCtx, svc.Name)
 				result := "success"
 				if startErr != nil {
@@ -804,9 +1518,19 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 					errs = append(errs, fmt.Errorf("failed to start service %q: %w", svc.Name, startErr))
 				}
 				serviceOperationsTotal.WithLabelValues(nodeName, "start", result).Inc()
+
+				if startErr == nil && svc.HealthCheck != nil {
+					checked[svc.Name] = true
+					if healthErr := r.checkServiceHealth(svcCtx, nodeName, svcHandler, svc); healthErr != nil {
+						errs = append(errs, healthErr)
+						unhealthy = append(unhealthy, svc.Name)
+					}
+				}
 			}
 		case services.Stopped:
-			if status != services.Stopped {
+			if status != services.Stopped && p != nil {
+				p.addService(svc.Name, "stop", status.String())
+			} else if status != services.Stopped {
 				stopErr := svcHandler.Stop(svcCtx, svc.Name)
 				result := "success"
 				if stopErr != nil {
@@ -818,26 +1542,58 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 		}
 	}
 
-	var (
-		req types.NamespacedName
-		err error
-	)
+	// Clear the arguments of services which were dropped from the ConfigSet.
+	for _, name := range ownedArgs {
+		if slices.ContainsFunc(serviceSet, func(svc commonv1.Service) bool { return svc.Name == name }) {
+			continue
+		}
+		if _, argsErr := r.setServiceArguments(ctx, handler, name, "", p); argsErr != nil {
+			errs = append(errs, fmt.Errorf("failed to clear service arguments for %q: %w", name, argsErr))
+			argServices = append(argServices, name)
+		}
+	}
+
+	// Services which failed their health check on a previous reconcile are
+	// checked again, unless they are restarted below.  The lease of their
+	// lock group is held until they pass.
+	for _, svc := range serviceSet {
+		_, restarting := restartServices[svc.Name]
+		if p != nil || svc.HealthCheck == nil || checked[svc.Name] || restarting || !slices.Contains(wasUnhealthy, svc.Name) {
+			continue
+		}
+
+		svcCtx := serviceContext(ctx, svc.User)
+		healthErr := r.checkServiceHealth(svcCtx, nodeName, withUserContext(handler, svcCtx), svc)
+		if healthErr != nil {
+			errs = append(errs, healthErr)
+			unhealthy = append(unhealthy, svc.Name)
+		}
+		if lockErr := r.holdLockGroup(ctx, namespace, svc, healthErr == nil); lockErr != nil {
+			errs = append(errs, lockErr)
+		}
+	}
 
-	restartF := func(restart string, restartSvc restartService) error {
+	restartF := func(restart string, restartSvc restartService) (err error) {
 		if restartSvc.LockGroup != "" {
-			req = types.NamespacedName{
+			req := types.NamespacedName{
 				Namespace: namespace,
 				Name:      restartSvc.LockGroup,
 			}
 
-			if err = r.locker.Lock(ctx, req); err != nil {
-				return fmt.Errorf("failed to acquire lock: %w", err)
+			if err = r.locker.Lock(locker.WithPurpose(ctx, locker.PurposeServiceRestart), req); err != nil {
+				return fmt.Errorf("%w %q for service %q: %w", errLockHeld, req.Name, restart, err)
 			}
 
 			defer func() {
+				// An unhealthy service keeps the lease, so that the rest of
+				// the group does not restart until it passes its check.
+				if errors.Is(err, errUnhealthy) {
+					r.logger.Warn("holding lock until service is healthy", "lease", req, "service", restart)
+					return
+				}
 				unlockErr := r.locker.Unlock(ctx, req)
 				if unlockErr != nil {
-					r.logger.Error("failed to unlock", "err", err)
+					r.logger.Error("failed to unlock", "err", unlockErr)
 				}
 			}()
 		}
@@ -854,17 +1610,78 @@ func (r *ConfigSetReconciler) handleServiceSet(ctx context.Context, nodeName str
 			return fmt.Errorf("failed to restart service %q: %w", restart, err)
 		}
 
-		return nil
+		return r.checkServiceHealth(restartSvc.Context, nodeName, restartHandler, restartSvc.Service)
 	}
 
 	for restart, restartSvc := range restartServices {
-		err = restartF(restart, restartSvc)
+		if p != nil {
+			p.addService(restart, "restart", "subscribed file changed")
+			continue
+		}
+
+		err := restartF(restart, restartSvc)
+		if errors.Is(err, errLockHeld) {
+			// Another node is restarting the group, or holds it while its
+			// service is unhealthy.  The restart is retried on the next
+			// reconcile; nothing has failed, so nothing is rolled back.
+			r.logger.Info("deferring restart until the lock is free", "service", restart, "err", err)
+			r.deferRestart(namespace, restartSvc.Service, changedFiles, backups)
+			errs = append(errs, err)
+			continue
+		}
+		r.clearPendingRestart(namespace, restartSvc.Service)
+
 		if err != nil {
 			errs = append(errs, err)
+
+			restored, rollbackErr := r.rollbackFiles(ctx, restartSvc.Service, fileSet, changedFiles, backups)
+			if rollbackErr != nil {
+				errs = append(errs, rollbackErr)
+			}
+			if len(restored) > 0 {
+				rolledBack = append(rolledBack, restored...)
+
+				if err = restartF(restart, restartSvc); err != nil {
+					errs = append(errs, fmt.Errorf("after rollback: %w", err))
+				}
+			}
+		}
+
+		if errors.Is(err, errUnhealthy) {
+			unhealthy = append(unhealthy, restart)
 		}
 	}
+	slices.Sort(unhealthy)
 
-	return errors.Join(errs...)
+	if p != nil {
+		// Nothing was changed, so the ConfigSet still sets what it set.
+		return rolledBack, unhealthy, ownedArgs, errors.Join(errs...)
+	}
+
+	slices.Sort(argServices)
+	return rolledBack, unhealthy, argServices, errors.Join(errs...)
+}
+
+// setServiceArguments sets the arguments of the named service, or clears
+// them when args is empty, and reports whether they changed.  In a plan, the
+// change is only recorded.
+func (r *ConfigSetReconciler) setServiceArguments(ctx context.Context, svcHandler handler.ServiceHandler, name, args string, p *planner) (bool, error) {
+	if p == nil {
+		return svcHandler.SetArguments(ctx, name, args)
+	}
+
+	current, err := svcHandler.Arguments(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if current != args {
+		action := "set arguments"
+		if args == "" {
+			action = "clear arguments"
+		}
+		p.addService(name, action, args)
+	}
+	return false, nil
 }
 
 func serviceContext(ctx context.Context, user string) context.Context {
@@ -898,7 +1715,7 @@ func (r *ConfigSetReconciler) managedPathsUnder(ctx context.Context, namespace s
 		prefix += "/"
 	}
 	for _, cs := range list.Items {
-		if nodeLabelMatch(node, cs.Labels) != nil {
+		if configSetNodeMatch(node, &cs) != nil {
 			continue // this configset does not apply to this node
 		}
 		for _, f := range cs.Spec.Files {
@@ -910,8 +1727,19 @@ func (r *ConfigSetReconciler) managedPathsUnder(ctx context.Context, namespace s
 	return managed
 }
 
+// fileHeld reports whether path was restored by hand with `nodemanager
+// filebucket restore`, which pauses its enforcement by every writer until it
+// is released.
+func (r *ConfigSetReconciler) fileHeld(held map[string]files.FileHold, path string) bool {
+	hold, ok := held[path]
+	if ok {
+		r.logger.Info("file enforcement paused by filebucket restore", "path", path, "hash", hold.Hash, "since", hold.Since)
+	}
+	return ok
+}
+
 // handleFileSet
-func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string, configSetName string, namespace string, fileSet []commonv1.File, node commonv1.ManagedNode) ([]string, map[string]string, error) {
+func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string, configSetName string, namespace string, fileSet []commonv1.File, node commonv1.ManagedNode, p *planner) ([]string, map[string]string, error) {
 	ctx, span := r.tracer.Start(ctx, "handleFileSet")
 	defer span.End()
 
@@ -921,9 +1749,35 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 	fileBackupUpdates := make(map[string]string)
 	var errs []error
 
+	// Paths restored by hand with `nodemanager filebucket restore` are not
+	// enforced until they are released.
+	held, holdErr := files.FileBucketHolds(r.cfg.FileBucket.Path)
+	if holdErr != nil {
+		errs = append(errs, holdErr)
+	}
+
 	for _, file := range fileSet {
+		if r.fileHeld(held, file.Path) {
+			continue
+		}
+
 		switch files.FileEnsureFromString(file.Ensure) {
 		case files.File:
+			// Content from a source is written even when it is empty.
+			resolved := file.Content != ""
+			if file.Source != "" {
+				planned, srcErr := r.resolveSource(ctx, &file, p)
+				if srcErr != nil {
+					errs = append(errs, srcErr)
+					continue
+				}
+				if planned {
+					changedFiles = append(changedFiles, file.Path)
+					continue
+				}
+				resolved = true
+			}
+
 			// If we have a template, let's set the content based on the rendered template.
 			if file.Template != "" {
 				data, dataErr := r.collectData(ctx, namespace, file, node)
@@ -932,7 +1786,7 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 					continue
 				}
 
-				content, tmplErr := r.buildTemplate(ctx, file.Template, data)
+				content, tmplErr := r.buildTemplate(ctx, file.TemplateEngine, file.Template, data)
 				if tmplErr != nil {
 					errs = append(errs, fmt.Errorf("failed to build template for file %q: %s: %w", file.Path, file.Template, tmplErr))
 					continue
@@ -940,17 +1794,18 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 
 				if len(content) > 0 {
 					file.Content = string(content)
+					resolved = true
 				}
 			}
 
-			if file.Content != "" && file.Ensure != files.Absent.String() {
+			if resolved && file.Ensure != files.Absent.String() {
 				if file.CreateOnly {
 					if _, statErr := os.Stat(file.Path); statErr == nil {
 						continue // file exists; leave it untouched
 					}
 				}
 
-				changed, backupHash, writeErr := r.writeFileContent(ctx, file, handler)
+				changed, backupHash, writeErr := r.applyFileContent(ctx, file, handler, p)
 				if writeErr != nil {
 					errs = append(errs, writeErr)
 					continue
@@ -963,9 +1818,39 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 				}
 			}
 
+		case files.LineInFile, files.INI, files.KeyValue:
+			content, edited, exists, editErr := editedContent(file)
+			if editErr != nil {
+				errs = append(errs, editErr)
+				continue
+			}
+			if !exists && !edited {
+				continue // nothing to add and nothing to remove from
+			}
+			if !edited && file.Owner == "" && file.Group == "" && file.Mode == "" {
+				continue
+			}
+
+			file.Content = string(content)
+			changed, backupHash, writeErr := r.applyFileContent(ctx, file, handler, p)
+			if writeErr != nil {
+				errs = append(errs, writeErr)
+				continue
+			}
+			if changed {
+				changedFiles = append(changedFiles, file.Path)
+			}
+			if backupHash != "" {
+				fileBackupUpdates[file.Path] = backupHash
+			}
+
 		case files.Directory:
 			// Create the directory if it does not exist, with the correct mode.
-			if _, statErr := os.Stat(file.Path); os.IsNotExist(statErr) {
+			if _, statErr := os.Stat(file.Path); os.IsNotExist(statErr) && p != nil {
+				p.addFile(file.Path, "mkdir", file.Mode)
+				changedFiles = append(changedFiles, file.Path)
+				continue
+			} else if os.IsNotExist(statErr) {
 				var fileMode os.FileMode
 
 				if file.Mode == "" {
@@ -986,7 +1871,17 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 				changedFiles = append(changedFiles, file.Path)
 			} else {
 				// Set the mode
-				if file.Mode != "" {
+				if file.Mode != "" && p != nil {
+					differs, modeErr := files.ModeDiffers(ctx, file.Path, file.Mode)
+					if modeErr != nil {
+						errs = append(errs, fmt.Errorf("failed to compare file mode: %w", modeErr))
+						continue
+					}
+					if differs {
+						p.addFile(file.Path, "chmod", file.Mode)
+						changedFiles = append(changedFiles, file.Path)
+					}
+				} else if file.Mode != "" {
 					changed, modeErr := handler.SetMode(ctx, file.Path, file.Mode)
 					if modeErr != nil {
 						errs = append(errs, fmt.Errorf("failed to set file mode: %w", modeErr))
@@ -1010,7 +1905,10 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 						continue // never remove subdirectories
 					}
 					entryPath := file.Path + "/" + entry.Name()
-					if _, ok := managed[entryPath]; !ok {
+					if _, ok := managed[entryPath]; !ok && p != nil {
+						p.addFile(entryPath, "remove", "purge "+file.Path)
+						changedFiles = append(changedFiles, entryPath)
+					} else if !ok {
 						r.logger.Info("purging unmanaged file", "path", entryPath, "directory", file.Path)
 						if removeErr := os.Remove(entryPath); removeErr != nil {
 							errs = append(errs, fmt.Errorf("purge: failed to remove %q: %w", entryPath, removeErr))
@@ -1028,7 +1926,10 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 				continue
 			}
 
-			if target != file.Target {
+			if target != file.Target && p != nil {
+				p.addFile(file.Path, "symlink", file.Target)
+				changedFiles = append(changedFiles, file.Path)
+			} else if target != file.Target {
 				changed, removeErr := handler.Remove(ctx, file.Path)
 				if removeErr != nil {
 					r.logger.Error("failed removing existing link", "path", file.Path, "err", removeErr)
@@ -1047,6 +1948,14 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 				}
 			}
 		case files.Absent:
+			if p != nil {
+				if _, statErr := os.Lstat(file.Path); statErr == nil {
+					p.addFile(file.Path, "remove", "")
+					changedFiles = append(changedFiles, file.Path)
+				}
+				continue
+			}
+
 			changed, removeErr := handler.Remove(ctx, file.Path)
 			if removeErr != nil {
 				r.logger.Error("failed removing file", "path", file.Path, "err", removeErr)
@@ -1060,51 +1969,24 @@ func (r *ConfigSetReconciler) handleFileSet(ctx context.Context, nodeName string
 		}
 	}
 
-	fileChangesTotal.WithLabelValues(nodeName, configSetName, "success").Add(float64(len(changedFiles)))
-
-	return changedFiles, fileBackupUpdates, errors.Join(errs...)
-}
-
-func (r *ConfigSetReconciler) handleExecutions(ctx context.Context, serviceSet []commonv1.Exec, changedFiles []string) error {
-	ctx, span := r.tracer.Start(ctx, "handleExecutions")
-	defer span.End()
-
-	handler := r.system.Exec()
-
-	var totalErrs error
-	var runExec []commonv1.Exec
-
-	for _, cf := range changedFiles {
-		for _, exe := range serviceSet {
-			for _, sub := range exe.SusbscribeFiles {
-				if sub == cf {
-					runExec = append(runExec, exe)
-				}
-			}
-		}
-	}
-
-	for _, exe := range runExec {
-		_, _, err := handler.RunCommand(ctx, exe.Command, exe.Args...)
-		r.logger.Info("running exec", "command", exe.Command)
-		if err != nil {
-			totalErrs = fmt.Errorf("%w: %s", totalErrs, err.Error())
-		}
+	if p == nil {
+		fileChangesTotal.WithLabelValues(nodeName, configSetName, "success").Add(float64(len(changedFiles)))
 	}
 
-	return totalErrs
+	return changedFiles, fileBackupUpdates, errors.Join(errs...)
 }
 
 func (r *ConfigSetReconciler) collectData(ctx context.Context, namespace string, file commonv1.File, node commonv1.ManagedNode) (data Data, err error) {
 	var nodeData NodeData
 	nodeData.Labels = node.Labels
+	nodeData.Facts = node.Status.Facts
 	nodeData.Status = node.Status
 
 	secrets := map[string][]byte{}
 	for _, s := range file.SecretRefs {
 
 		// Render the secretRef in case it is a template string
-		st, err := r.buildTemplate(ctx, s, Data{Node: nodeData})
+		st, err := r.buildTemplate(ctx, file.TemplateEngine, s, Data{Node: nodeData})
 		if err != nil {
 			return Data{}, fmt.Errorf("failed to build template string rendering secretRef: %w", err)
 		}
@@ -1158,7 +2040,18 @@ func (r *ConfigSetReconciler) collectData(ctx context.Context, namespace string,
 	return data, nil
 }
 
-func (r *ConfigSetReconciler) buildTemplate(ctx context.Context, template string, data Data) (content []byte, err error) {
+func (r *ConfigSetReconciler) buildTemplate(ctx context.Context, engine, template string, data Data) (content []byte, err error) {
+	switch templates.EngineFromString(engine) {
+	case templates.Gomplate:
+		return r.buildGomplateTemplate(ctx, template, data)
+	case templates.Go:
+		return buildGoTemplate(template, data)
+	default:
+		return nil, fmt.Errorf("unhandled template engine %q", engine)
+	}
+}
+
+func (r *ConfigSetReconciler) buildGomplateTemplate(ctx context.Context, template string, data Data) (content []byte, err error) {
 	// echo '{"foo": {"foo": "bar"}}' | gomplate -i '{{(ds "data").foo.foo}}' -d data=stdin:///foo.json
 
 	b, err := json.Marshal(data)
@@ -1244,8 +2137,19 @@ func (r *ConfigSetReconciler) updateLastGCTimestamp(ctx context.Context, node co
 // It returns whether anything changed, the SHA256 hash of any pre-write backup (empty
 // string if no backup was taken), and any error.
 func (r *ConfigSetReconciler) writeFileContent(ctx context.Context, file commonv1.File, handler handler.FileHandler) (changed bool, backupHash string, err error) {
-	// Filebucket: back up the existing file before overwriting it.
-	if r.cfg.FileBucket.Enabled {
+	current, readErr := os.ReadFile(file.Path)
+	contentDiffers := readErr != nil || string(current) != file.Content
+
+	if file.Validate != "" && contentDiffers {
+		if err = r.validateContent(ctx, file); err != nil {
+			return false, "", err
+		}
+	}
+
+	// Filebucket: back up the existing file before overwriting it.  Only a
+	// content change is backed up, so that the recorded hash is always the
+	// previous content of the file.
+	if r.cfg.FileBucket.Enabled && readErr == nil && contentDiffers {
 		info, statErr := os.Stat(file.Path)
 		if statErr == nil && !info.IsDir() {
 			if r.cfg.FileBucket.MaxFileSizeBytes > 0 && info.Size() > r.cfg.FileBucket.MaxFileSizeBytes {
@@ -1255,19 +2159,16 @@ func (r *ConfigSetReconciler) writeFileContent(ctx context.Context, file commonv
 					"limit", r.cfg.FileBucket.MaxFileSizeBytes,
 				)
 			} else {
-				current, readErr := os.ReadFile(file.Path)
-				if readErr == nil {
-					h, bucketErr := files.SaveToFileBucket(r.cfg.FileBucket.Path, file.Path, current, info)
-					if bucketErr != nil {
-						r.logger.Warn("filebucket backup failed", "path", file.Path, "err", bucketErr)
-					} else {
-						backupHash = h
-						r.logger.Info("backed up file to filebucket",
-							"path", file.Path,
-							"hash", h,
-							"bucket", r.cfg.FileBucket.Path,
-						)
-					}
+				h, bucketErr := files.SaveToFileBucket(r.cfg.FileBucket.Path, file.Path, current, info)
+				if bucketErr != nil {
+					r.logger.Warn("filebucket backup failed", "path", file.Path, "err", bucketErr)
+				} else {
+					backupHash = h
+					r.logger.Info("backed up file to filebucket",
+						"path", file.Path,
+						"hash", h,
+						"bucket", r.cfg.FileBucket.Path,
+					)
 				}
 			}
 		}
@@ -1275,14 +2176,24 @@ func (r *ConfigSetReconciler) writeFileContent(ctx context.Context, file commonv
 
 	var contentChanged, ownerChanged, modeChanged bool
 
-	contentChanged, err = handler.WriteContentFile(ctx, file.Path, []byte(file.Content))
+	// Content from a source, such as a binary which may be running, replaces
+	// the file rather than being written into it.
+	write := handler.WriteContentFile
+	if file.Source != "" {
+		write = handler.ReplaceContentFile
+	}
+	contentChanged, err = write(ctx, file.Path, []byte(file.Content))
 	if err != nil {
 		return false, backupHash, fmt.Errorf("failed to write content to file: %w", err)
 	}
 
-	ownerChanged, err = handler.Chown(ctx, file.Path, file.Owner, file.Group)
-	if err != nil {
-		return true, backupHash, fmt.Errorf("failed to chown file: %w", err)
+	// Partially managed files keep their existing ownership unless one is
+	// requested, since the rest of the file belongs to someone else.
+	if !files.FileEnsureFromString(file.Ensure).Partial() || file.Owner != "" || file.Group != "" {
+		ownerChanged, err = handler.Chown(ctx, file.Path, file.Owner, file.Group)
+		if err != nil {
+			return true, backupHash, fmt.Errorf("failed to chown file: %w", err)
+		}
 	}
 
 	if file.Mode != "" {
@@ -1295,6 +2206,17 @@ func (r *ConfigSetReconciler) writeFileContent(ctx context.Context, file commonv
 	return contentChanged || ownerChanged || modeChanged, backupHash, nil
 }
 
+// applyFileContent writes file.Content, or records the write in p when
+// planning.
+func (r *ConfigSetReconciler) applyFileContent(ctx context.Context, file commonv1.File, handler handler.FileHandler, p *planner) (changed bool, backupHash string, err error) {
+	if p != nil {
+		changed, err = r.planFileContent(ctx, file, p)
+		return changed, "", err
+	}
+
+	return r.writeFileContent(ctx, file, handler)
+}
+
 // recordResourceVersion sets configSetAppliedResourceVersion for the given
 // (node, configset, resource_version) and removes the gauge entry for any
 // previous resource_version so cardinality stays bounded.
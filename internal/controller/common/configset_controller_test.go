@@ -210,6 +210,78 @@ var _ = Describe("ConfigSet Controller", func() {
 		})
 	})
 
+	Context("When ConfigSets manage different keys of the same file", func() {
+		const csKeyA = "keyvalue-a"
+		const csKeyB = "keyvalue-b"
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			ensureLocalNodeLabel(ctx, "nodemanager.test/enabled", "true")
+
+			By("creating two ConfigSets editing different keys of the same path")
+			for name, key := range map[string]string{csKeyA: "sshd_enable", csKeyB: "ntpd_enable"} {
+				cs := &commonv1.ConfigSet{}
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cs)
+				if err != nil && errors.IsNotFound(err) {
+					Expect(k8sClient.Create(ctx, &commonv1.ConfigSet{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      name,
+							Namespace: "default",
+							Labels:    map[string]string{"nodemanager.test/enabled": "true"},
+						},
+						Spec: commonv1.ConfigSetSpec{
+							Files: []commonv1.File{
+								{
+									Path:     "/nonexistent/keyvalue-test.conf",
+									Ensure:   "keyvalue",
+									Settings: []commonv1.FileSetting{{Key: key, State: "absent"}},
+								},
+							},
+						},
+					})).To(Succeed())
+				}
+			}
+		})
+
+		AfterEach(func() {
+			for _, name := range []string{csKeyA, csKeyB} {
+				cs := &commonv1.ConfigSet{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cs); err == nil {
+					Expect(k8sClient.Delete(ctx, cs)).To(Succeed())
+				}
+			}
+		})
+
+		It("should not conflict because conflicts are detected per key", func() {
+			sys := &mockSystemHandler{}
+			lkr := locker.NewLeaseLocker(ctx, logger, locker.Config{}, clientset, "default", hostname)
+			reconciler := &ConfigSetReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+				tracer: noop.NewTracerProvider().Tracer("test"),
+				logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})),
+				system: sys,
+				locker: lkr,
+			}
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: csKeyA, Namespace: "default"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			osHostname, _ := os.Hostname()
+			mn := &commonv1.ManagedNode{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: osHostname, Namespace: "default"}, mn)).To(Succeed())
+			var entry *commonv1.ConfigSetApplyStatus
+			for i := range mn.Status.ConfigSets {
+				if mn.Status.ConfigSets[i].Name == csKeyA {
+					entry = &mn.Status.ConfigSets[i]
+				}
+			}
+			Expect(entry).NotTo(BeNil(), "the ConfigSet should be recorded in the ManagedNode status")
+			Expect(entry.Conflicts).To(BeEmpty())
+		})
+	})
+
 	Context("When updateConfigSetCondition is called repeatedly with the same state", func() {
 		const csIdempotent = "condition-idempotent"
 		ctx := context.Background()
@@ -269,22 +341,40 @@ var _ = Describe("ConfigSet Controller", func() {
 	Context("When the local ManagedNode changes", func() {
 		const csWatch1 = "watch-cs-one"
 		const csWatch2 = "watch-cs-two"
+		const csWatch3 = "watch-cs-three"
 		ctx := context.Background()
 
 		BeforeEach(func() {
-			for _, name := range []string{csWatch1, csWatch2} {
+			specs := map[string]commonv1.ConfigSetSpec{
+				// Selects every node.
+				csWatch1: {NodeSelector: &metav1.LabelSelector{}},
+				// Selects no node, but is recorded in the node status.
+				csWatch2: {NodeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "nodemanager.test/never", Operator: metav1.LabelSelectorOpExists},
+					},
+				}},
+				// Selects no node and is not recorded.
+				csWatch3: {NodeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "nodemanager.test/never", Operator: metav1.LabelSelectorOpExists},
+					},
+				}},
+			}
+			for name, spec := range specs {
 				cs := &commonv1.ConfigSet{}
 				err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cs)
 				if err != nil && errors.IsNotFound(err) {
 					Expect(k8sClient.Create(ctx, &commonv1.ConfigSet{
 						ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+						Spec:       spec,
 					})).To(Succeed())
 				}
 			}
 		})
 
 		AfterEach(func() {
-			for _, name := range []string{csWatch1, csWatch2} {
+			for _, name := range []string{csWatch1, csWatch2, csWatch3} {
 				cs := &commonv1.ConfigSet{}
 				if err := k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, cs); err == nil {
 					Expect(k8sClient.Delete(ctx, cs)).To(Succeed())
@@ -306,8 +396,13 @@ var _ = Describe("ConfigSet Controller", func() {
 			other := &commonv1.ManagedNode{ObjectMeta: metav1.ObjectMeta{Name: "other-node", Namespace: "default"}}
 			Expect(mapper(ctx, other)).To(BeNil())
 
-			By("returning one request per ConfigSet for the matching hostname")
-			local := &commonv1.ManagedNode{ObjectMeta: metav1.ObjectMeta{Name: hostname, Namespace: "default"}}
+			By("returning requests for selected and previously applied ConfigSets for the matching hostname")
+			local := &commonv1.ManagedNode{
+				ObjectMeta: metav1.ObjectMeta{Name: hostname, Namespace: "default"},
+				Status: commonv1.ManagedNodeStatus{
+					ConfigSets: []commonv1.ConfigSetApplyStatus{{Name: csWatch2}},
+				},
+			}
 			reqs := mapper(ctx, local)
 			Expect(reqs).NotTo(BeEmpty())
 			names := make([]string, len(reqs))
@@ -315,6 +410,7 @@ var _ = Describe("ConfigSet Controller", func() {
 				names[i] = r.Name
 			}
 			Expect(names).To(ContainElements(csWatch1, csWatch2))
+			Expect(names).NotTo(ContainElement(csWatch3))
 		})
 	})
 
@@ -422,7 +518,7 @@ var _ = Describe("ConfigSet Controller", func() {
 				{Path: "/etc/rc.conf.d/unbound_exporter", Ensure: "file", Content: "unbound_exporter_host=localhost"},
 			}
 
-			err := r.handleServiceSet(ctx, "test-node", "default", services, files, nil)
+			_, _, _, err := r.handleServiceSet(ctx, "test-node", "default", services, files, nil, nil, nil, nil, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			svcMock := sys.Service().(*mockServiceHandler)
@@ -443,7 +539,7 @@ var _ = Describe("ConfigSet Controller", func() {
 				{Name: "unbound_exporter", Enable: true, Ensure: "running", Arguments: "some-args"},
 			}
 
-			err := r.handleServiceSet(ctx, "test-node", "default", services, nil, nil)
+			_, _, _, err := r.handleServiceSet(ctx, "test-node", "default", services, nil, nil, nil, nil, nil, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			svcMock := sys.Service().(*mockServiceHandler)
@@ -466,7 +562,7 @@ var _ = Describe("ConfigSet Controller", func() {
 				{Path: "/etc/rc.conf.d/myservice", Ensure: "file", Content: "myservice_enable=NO"},
 			}
 
-			err := r.handleServiceSet(ctx, "test-node", "default", services, files, nil)
+			_, _, _, err := r.handleServiceSet(ctx, "test-node", "default", services, files, nil, nil, nil, nil, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			svcMock := sys.Service().(*mockServiceHandler)
@@ -0,0 +1,228 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+const (
+	conditionBlocked = "Blocked"
+
+	reasonDependencyCycle       = "DependencyCycle"
+	reasonDependenciesNotReady  = "DependenciesNotApplied"
+	reasonDependenciesSatisfied = "DependenciesApplied"
+)
+
+// checkDependencies returns the dependencies of cs which are not yet
+// successfully applied on node, and the cycle cs is part of, if any.  A
+// dependency counts as applied when the node status records the dependency's
+// current generation without an error, conflict or block of its own, and
+// without changes left to make if it was only planned or audited.  When
+// evaluating is set, cs is itself only planned or audited, and a dependency
+// which was planned or audited without error is enough: nothing is applied
+// either way.
+func (r *ConfigSetReconciler) checkDependencies(ctx context.Context, cs *commonv1.ConfigSet, node commonv1.ManagedNode, evaluating bool) (blocked, cycle []string, err error) {
+	if len(cs.Spec.DependsOn) == 0 {
+		return nil, nil, nil
+	}
+
+	var all commonv1.ConfigSetList
+	if err := r.List(ctx, &all, client.InNamespace(cs.Namespace)); err != nil {
+		return nil, nil, err
+	}
+
+	byName := make(map[string]*commonv1.ConfigSet, len(all.Items))
+	for i := range all.Items {
+		byName[all.Items[i].Name] = &all.Items[i]
+	}
+	byName[cs.Name] = cs
+
+	if cycle := dependencyCycle(cs.Name, byName); cycle != nil {
+		return nil, cycle, nil
+	}
+
+	return unmetDependencies(cs, byName, node, evaluating), nil, nil
+}
+
+// unmetDependencies returns the dependencies of cs which do not allow it to
+// be applied, or evaluated when evaluating is set, with the reason for each.
+func unmetDependencies(cs *commonv1.ConfigSet, byName map[string]*commonv1.ConfigSet, node commonv1.ManagedNode, evaluating bool) (blocked []string) {
+	for _, name := range cs.Spec.DependsOn {
+		dep, ok := byName[name]
+		if !ok {
+			blocked = append(blocked, fmt.Sprintf("%s (not found)", name))
+			continue
+		}
+		if configSetNodeMatch(node, dep) != nil {
+			blocked = append(blocked, fmt.Sprintf("%s (does not apply to this node)", name))
+			continue
+		}
+
+		state := dependencyState(node.Status.ConfigSets, dep)
+		switch {
+		case state == depApplied, state == depEvaluated && evaluating:
+		case state == depEvaluated:
+			blocked = append(blocked, fmt.Sprintf("%s (planned or audited, not applied)", name))
+		default:
+			blocked = append(blocked, fmt.Sprintf("%s (not yet applied)", name))
+		}
+	}
+
+	return blocked
+}
+
+const (
+	// depPending is a dependency which is not evaluated at its current
+	// generation, or failed to apply.
+	depPending = "pending"
+	// depEvaluated is a dependency which was planned or audited without
+	// error, and would still change the node.
+	depEvaluated = "evaluated"
+	// depApplied is a dependency whose desired state is in place, because it
+	// was applied or because its plan or audit found nothing to change.
+	depApplied = "applied"
+)
+
+// dependencyState returns the state of the current generation of cs
+// according to statuses.
+func dependencyState(statuses []commonv1.ConfigSetApplyStatus, cs *commonv1.ConfigSet) string {
+	for _, s := range statuses {
+		if s.Name == cs.Name {
+			if s.Generation != cs.Generation {
+				return depPending
+			}
+			return applyState(s)
+		}
+	}
+	return depPending
+}
+
+// applyState returns the state a status entry records, regardless of the
+// generation it was recorded for.
+func applyState(s commonv1.ConfigSetApplyStatus) string {
+	switch {
+	case s.Error != "" || len(s.Conflicts) > 0 || len(s.Blocked) > 0:
+		return depPending
+	case s.Plan != nil && len(driftFromPlan(*s.Plan)) > 0:
+		return depEvaluated
+	case s.Audited && len(s.Drift) > 0:
+		return depEvaluated
+	}
+	return depApplied
+}
+
+// dependencyCycle returns the path of a dependency cycle which includes
+// start, e.g. [a b a], or nil when there is none.  Missing ConfigSets are
+// ignored here and reported as unmet dependencies instead.
+func dependencyCycle(start string, byName map[string]*commonv1.ConfigSet) []string {
+	visited := make(map[string]bool)
+
+	var walk func(name string, path []string) []string
+	walk = func(name string, path []string) []string {
+		cs, ok := byName[name]
+		if !ok {
+			return nil
+		}
+		for _, dep := range cs.Spec.DependsOn {
+			if dep == start {
+				return append(slices.Clone(path), dep)
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if cycle := walk(dep, append(path, dep)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+
+	return walk(start, []string{start})
+}
+
+// updateBlockedCondition sets the Blocked condition on the ConfigSet.  An
+// empty reason clears it.
+func (r *ConfigSetReconciler) updateBlockedCondition(ctx context.Context, req reconcile.Request, reason, message string) error {
+	condition := metav1.Condition{
+		Type:    conditionBlocked,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+	if reason == "" {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonDependenciesSatisfied
+		condition.Message = ""
+	}
+
+	return r.setConfigSetCondition(ctx, req, condition)
+}
+
+// appliedConfigSetsChanged passes ManagedNode updates which change the set of
+// ConfigSets successfully applied, planned or audited on the node, so that
+// dependents are reconciled as soon as their dependencies are applied rather
+// than on the next requeue.
+var appliedConfigSetsChanged = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNode, ok := e.ObjectOld.(*commonv1.ManagedNode)
+		if !ok {
+			return false
+		}
+		newNode, ok := e.ObjectNew.(*commonv1.ManagedNode)
+		if !ok {
+			return false
+		}
+		return appliedSummary(oldNode.Status.ConfigSets) != appliedSummary(newNode.Status.ConfigSets)
+	},
+}
+
+func appliedSummary(statuses []commonv1.ConfigSetApplyStatus) string {
+	applied := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		if state := applyState(s); state != depPending {
+			applied = append(applied, fmt.Sprintf("%s@%d:%s", s.Name, s.Generation, state))
+		}
+	}
+	slices.Sort(applied)
+	return strings.Join(applied, ",")
+}
+
+// configSetsWithDependencies returns a mapper that enqueues every ConfigSet
+// with dependsOn when the local ManagedNode records a newly applied ConfigSet.
+func (r *ConfigSetReconciler) configSetsWithDependencies(hostname string) ctrlhandler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		if obj.GetName() != hostname {
+			return nil
+		}
+		var list commonv1.ConfigSetList
+		if err := r.List(ctx, &list, client.InNamespace(r.cfg.Namespace)); err != nil {
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, cs := range list.Items {
+			if len(cs.Spec.DependsOn) == 0 {
+				continue
+			}
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: cs.Name, Namespace: cs.Namespace},
+			})
+		}
+		return reqs
+	}
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func testConfigSets(deps map[string][]string) map[string]*commonv1.ConfigSet {
+	out := make(map[string]*commonv1.ConfigSet, len(deps))
+	for name, d := range deps {
+		out[name] = &commonv1.ConfigSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       commonv1.ConfigSetSpec{DependsOn: d},
+		}
+	}
+	return out
+}
+
+func TestDependencyCycle(t *testing.T) {
+	cases := []struct {
+		name     string
+		deps     map[string][]string
+		start    string
+		expected []string
+	}{
+		{
+			name:  "no dependencies",
+			deps:  map[string][]string{"a": nil},
+			start: "a",
+		},
+		{
+			name:  "chain",
+			deps:  map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil},
+			start: "a",
+		},
+		{
+			name:  "missing dependency",
+			deps:  map[string][]string{"a": {"missing"}},
+			start: "a",
+		},
+		{
+			name:     "self",
+			deps:     map[string][]string{"a": {"a"}},
+			start:    "a",
+			expected: []string{"a", "a"},
+		},
+		{
+			name:     "indirect",
+			deps:     map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			start:    "a",
+			expected: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:  "cycle not including start",
+			deps:  map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}},
+			start: "a",
+		},
+		{
+			name:  "diamond",
+			deps:  map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": nil},
+			start: "a",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, dependencyCycle(tc.start, testConfigSets(tc.deps)))
+		})
+	}
+}
+
+func TestDependencyState(t *testing.T) {
+	cs := &commonv1.ConfigSet{ObjectMeta: metav1.ObjectMeta{Name: "base", Generation: 2, ResourceVersion: "20"}}
+	pending := &commonv1.ConfigSetPlan{Packages: []commonv1.PlannedChange{{Name: "nginx", Action: "install"}}}
+
+	cases := []struct {
+		name     string
+		status   commonv1.ConfigSetApplyStatus
+		expected string
+	}{
+		{name: "applied", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2}, expected: depApplied},
+		{name: "status written since", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, ResourceVersion: "19"}, expected: depApplied},
+		{name: "old generation", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 1}, expected: depPending},
+		{name: "error", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Error: "boom"}, expected: depPending},
+		{name: "conflicted", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Conflicts: []string{"x"}}, expected: depPending},
+		{name: "blocked", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Blocked: []string{"x"}}, expected: depPending},
+		{name: "planned", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Plan: pending}, expected: depEvaluated},
+		{name: "planned without changes", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Plan: &commonv1.ConfigSetPlan{}}, expected: depApplied},
+		{name: "planned with error", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Plan: pending, Error: "boom"}, expected: depPending},
+		{name: "audited", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Audited: true, Drift: []commonv1.DriftedResource{{Kind: "package", Name: "nginx", Action: "install"}}}, expected: depEvaluated},
+		{name: "audited without drift", status: commonv1.ConfigSetApplyStatus{Name: "base", Generation: 2, Audited: true}, expected: depApplied},
+		{name: "other", status: commonv1.ConfigSetApplyStatus{Name: "other", Generation: 2}, expected: depPending},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, dependencyState([]commonv1.ConfigSetApplyStatus{tc.status}, cs))
+		})
+	}
+}
+
+func TestUnmetDependencies(t *testing.T) {
+	byName := testConfigSets(map[string][]string{"web": {"repos", "users"}, "repos": nil, "users": nil})
+	for _, cs := range byName {
+		cs.Generation = 1
+		cs.Spec.NodeSelector = &metav1.LabelSelector{}
+	}
+
+	node := commonv1.ManagedNode{}
+	node.Status.ConfigSets = []commonv1.ConfigSetApplyStatus{
+		{Name: "repos", Generation: 1},
+		{Name: "users", Generation: 1, Plan: &commonv1.ConfigSetPlan{Users: []commonv1.PlannedChange{{Name: "deploy", Action: "create"}}}},
+	}
+
+	// An applying ConfigSet needs its dependencies applied, not only planned.
+	require.Equal(t, []string{"users (planned or audited, not applied)"}, unmetDependencies(byName["web"], byName, node, false))
+
+	// A planned ConfigSet is evaluated against the plan of its dependencies.
+	require.Empty(t, unmetDependencies(byName["web"], byName, node, true))
+
+	// Likewise an audited one against their audit.
+	node.Status.ConfigSets[1] = commonv1.ConfigSetApplyStatus{Name: "users", Generation: 1, Audited: true, Drift: []commonv1.DriftedResource{{Kind: "user", Name: "deploy", Action: "create"}}}
+	require.Equal(t, []string{"users (planned or audited, not applied)"}, unmetDependencies(byName["web"], byName, node, false))
+	require.Empty(t, unmetDependencies(byName["web"], byName, node, true))
+
+	// A dependency which failed blocks evaluation too.
+	node.Status.ConfigSets[1].Error = "boom"
+	require.Equal(t, []string{"users (not yet applied)"}, unmetDependencies(byName["web"], byName, node, true))
+
+	// An audit which found no drift satisfies an applying ConfigSet.
+	node.Status.ConfigSets[1] = commonv1.ConfigSetApplyStatus{Name: "users", Generation: 1, Audited: true}
+	require.Empty(t, unmetDependencies(byName["web"], byName, node, false))
+}
+
+func TestAppliedSummary(t *testing.T) {
+	a := []commonv1.ConfigSetApplyStatus{
+		{Name: "b", ResourceVersion: "1"},
+		{Name: "a", ResourceVersion: "3", LastApplied: metav1.Now()},
+		{Name: "c", ResourceVersion: "1", Error: "boom"},
+	}
+	b := []commonv1.ConfigSetApplyStatus{
+		{Name: "a", ResourceVersion: "3"},
+		{Name: "b", ResourceVersion: "1"},
+	}
+	require.Equal(t, appliedSummary(a), appliedSummary(b), "only successful applies matter")
+
+	b[1].Error = "boom"
+	require.NotEqual(t, appliedSummary(a), appliedSummary(b))
+
+	// A dependency which is planned, and later applied, wakes its dependents
+	// both times.
+	b[1].Error = ""
+	b[1].Plan = &commonv1.ConfigSetPlan{Files: []commonv1.PlannedChange{{Name: "/etc/motd", Action: "write"}}}
+	require.NotEqual(t, appliedSummary(a), appliedSummary(b))
+}
@@ -0,0 +1,286 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorhill/cronexpr"
+	"go.opentelemetry.io/otel/attribute"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+const (
+	// maxExecOutputBytes bounds the output recorded for a single exec in the
+	// ManagedNode status.  The tail is kept, since that is where errors are.
+	maxExecOutputBytes = 1024
+
+	// execShell runs the unless and onlyIf guards.
+	execShell = "/bin/sh"
+)
+
+// handleExecutions runs the execs which are triggered on this reconcile and
+// whose guards pass.  previous holds the statuses recorded by the last
+// reconcile, which carry the schedule state; the returned statuses replace
+// them.  In plan mode the runs are recorded on p and previous is returned
+// unchanged.
+func (r *ConfigSetReconciler) handleExecutions(ctx context.Context, execSet []commonv1.Exec, changedFiles []string, previous []commonv1.ExecStatus, p *planner) ([]commonv1.ExecStatus, error) {
+	ctx, span := r.tracer.Start(ctx, "handleExecutions")
+	defer span.End()
+
+	handler := r.system.Exec()
+	now := time.Now()
+
+	var errs []error
+	statuses := make([]commonv1.ExecStatus, 0, len(execSet))
+
+	for _, exe := range execSet {
+		name := execName(exe)
+
+		status := commonv1.ExecStatus{Name: name}
+		if i := slices.IndexFunc(previous, func(s commonv1.ExecStatus) bool { return s.Name == name }); i >= 0 {
+			status = *previous[i].DeepCopy()
+		}
+
+		triggered, reason, err := execTriggered(exe, changedFiles, &status, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exec %q: %w", name, err))
+			status.Reason = "error: " + err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+		if !triggered {
+			status.Reason = reason
+			statuses = append(statuses, status)
+			continue
+		}
+
+		if p != nil {
+			if exe.Creates != "" {
+				if _, statErr := os.Stat(exe.Creates); statErr == nil {
+					continue
+				}
+			}
+			detail := strings.Join(exe.Args, " ")
+			if exe.Unless != "" || exe.OnlyIf != "" {
+				detail = strings.TrimSpace(detail + " (subject to unless/onlyIf guards)")
+			}
+			p.addExec(name, "run", detail)
+			continue
+		}
+
+		if err := r.runExec(ctx, handler, exe, &status); err != nil {
+			errs = append(errs, fmt.Errorf("exec %q: %w", name, err))
+		}
+		statuses = append(statuses, status)
+	}
+
+	if p != nil {
+		return previous, errors.Join(errs...)
+	}
+
+	return statuses, errors.Join(errs...)
+}
+
+// execTriggered reports whether exe should be considered on this reconcile:
+// when a subscribed file changed, when its schedule is due, or on every
+// reconcile for an exec without a schedule which sets always.  An exec with
+// none of these is never triggered.  status.NextRun is initialised and
+// advanced for execs with a schedule.  When exe is not triggered, reason says
+// why.
+func execTriggered(exe commonv1.Exec, changedFiles []string, status *commonv1.ExecStatus, now time.Time) (bool, string, error) {
+	subscribed := slices.ContainsFunc(exe.SusbscribeFiles, func(f string) bool {
+		return slices.Contains(changedFiles, f)
+	})
+
+	if exe.Schedule == "" {
+		status.NextRun = nil
+		switch {
+		case subscribed || exe.Always:
+			return true, "", nil
+		case len(exe.SusbscribeFiles) > 0:
+			return false, "waiting for a subscribed file to change", nil
+		}
+		return false, "not triggered: no subscribe_files, schedule or always", nil
+	}
+
+	expr, err := cronexpr.Parse(exe.Schedule)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to parse schedule %q: %w", exe.Schedule, err)
+	}
+
+	// The first run is the first scheduled time after the exec is seen, not
+	// immediately.
+	if status.NextRun == nil {
+		status.NextRun = &metav1.Time{Time: expr.Next(now)}
+	}
+
+	due := !now.Before(status.NextRun.Time)
+	if due {
+		status.NextRun = &metav1.Time{Time: expr.Next(now)}
+	}
+
+	if due || subscribed {
+		return true, "", nil
+	}
+	return false, "not due until " + status.NextRun.Format(time.RFC3339), nil
+}
+
+// runExec checks the guards of exe and runs it, recording the result on
+// status.  An error is returned when a guard cannot be run, or the command
+// fails, times out or exits with an unexpected code.
+func (r *ConfigSetReconciler) runExec(ctx context.Context, h handler.ExecHandler, exe commonv1.Exec, status *commonv1.ExecStatus) error {
+	ctx, span := r.tracer.Start(ctx, "runExec")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("name", status.Name))
+
+	if exe.Timeout != "" {
+		timeout, err := time.ParseDuration(exe.Timeout)
+		if err != nil {
+			status.Reason = "error: invalid timeout"
+			return fmt.Errorf("failed to parse timeout %q: %w", exe.Timeout, err)
+		}
+		// The exec runs within the reconcile, which would cut a longer
+		// timeout short.
+		if timeout > reconcileTimeout {
+			status.Reason = "error: timeout exceeds " + reconcileTimeout.String()
+			return fmt.Errorf("timeout %q exceeds the reconcile timeout of %s", exe.Timeout, reconcileTimeout)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	opts := execOptions(exe)
+
+	skip, err := execSkipReason(ctx, h, exe, opts)
+	if err != nil {
+		status.Reason = "error: " + err.Error()
+		return err
+	}
+	if skip != "" {
+		r.logger.Debug("skipping exec", "name", status.Name, "reason", skip)
+		status.Reason = "skipped: " + skip
+		return nil
+	}
+
+	r.logger.Info("running exec", "name", status.Name, "command", exe.Command)
+	output, code, runErr := h.RunCommandWithOptions(ctx, opts, exe.Command, exe.Args...)
+
+	now := metav1.Now()
+	exitCode := int32(code)
+	status.LastRun = &now
+	status.ExitCode = &exitCode
+	status.Output = tailOutput(output)
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status.Reason = "failed: timed out after " + exe.Timeout
+		return fmt.Errorf("timed out after %s", exe.Timeout)
+	case code < 0:
+		status.Reason = "failed: " + runErr.Error()
+		return runErr
+	case !slices.Contains(execExitCodes(exe), exitCode):
+		status.Reason = fmt.Sprintf("failed: exit code %d", code)
+		return fmt.Errorf("unexpected exit code %d", code)
+	}
+
+	status.Reason = "ran"
+	return nil
+}
+
+// execSkipReason evaluates the creates, onlyIf and unless guards of exe, in
+// that order, and returns why exe should be skipped, or "" to run it.
+func execSkipReason(ctx context.Context, h handler.ExecHandler, exe commonv1.Exec, opts handler.ExecOptions) (string, error) {
+	if exe.Creates != "" {
+		if _, err := os.Stat(exe.Creates); err == nil {
+			return fmt.Sprintf("creates %s exists", exe.Creates), nil
+		}
+	}
+
+	if exe.OnlyIf != "" {
+		_, code, err := h.RunCommandWithOptions(ctx, opts, execShell, "-c", exe.OnlyIf)
+		if code < 0 {
+			return "", fmt.Errorf("failed to run onlyIf: %w", err)
+		}
+		if code != 0 {
+			return fmt.Sprintf("onlyIf exited %d", code), nil
+		}
+	}
+
+	if exe.Unless != "" {
+		_, code, err := h.RunCommandWithOptions(ctx, opts, execShell, "-c", exe.Unless)
+		if code < 0 {
+			return "", fmt.Errorf("failed to run unless: %w", err)
+		}
+		if code == 0 {
+			return "unless exited 0", nil
+		}
+	}
+
+	return "", nil
+}
+
+func execOptions(exe commonv1.Exec) handler.ExecOptions {
+	opts := handler.ExecOptions{
+		Dir:  exe.Cwd,
+		User: exe.User,
+	}
+	for _, k := range slices.Sorted(maps.Keys(exe.Env)) {
+		opts.Env = append(opts.Env, k+"="+exe.Env[k])
+	}
+	return opts
+}
+
+func execExitCodes(exe commonv1.Exec) []int32 {
+	if len(exe.ExitCodes) == 0 {
+		return []int32{0}
+	}
+	return exe.ExitCodes
+}
+
+// execName returns the name of exe in the ManagedNode status.
+func execName(exe commonv1.Exec) string {
+	if exe.Name != "" {
+		return exe.Name
+	}
+	return strings.TrimSpace(exe.Command + " " + strings.Join(exe.Args, " "))
+}
+
+func tailOutput(output string) string {
+	if len(output) <= maxExecOutputBytes {
+		return output
+	}
+	return "... (output truncated)\n" + output[len(output)-maxExecOutputBytes:]
+}
+
+// execStatusesFor returns the exec statuses recorded on node for the named
+// ConfigSet.
+func execStatusesFor(node commonv1.ManagedNode, configSetName string) []commonv1.ExecStatus {
+	for _, cs := range node.Status.ConfigSets {
+		if cs.Name == configSetName {
+			return cs.Executions
+		}
+	}
+	return nil
+}
+
+// nextExecRun returns the earliest scheduled exec run, or the zero time.
+func nextExecRun(statuses []commonv1.ExecStatus) time.Time {
+	var next time.Time
+	for _, s := range statuses {
+		if s.NextRun != nil && (next.IsZero() || s.NextRun.Time.Before(next)) {
+			next = s.NextRun.Time
+		}
+	}
+	return next
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+func TestHandleExecutions(t *testing.T) {
+	dir := t.TempDir()
+	done := filepath.Join(dir, ".migrated")
+	require.NoError(t, os.WriteFile(done, nil, 0o644))
+
+	exec := &handler.MockExecHandler{
+		// onlyIf exits 1, unless exits 0, then the two commands which run
+		// exit 0 and 3.
+		Status: []int{1, 0, 0, 3},
+		Output: []string{"", "", "ok\n", "boom\n"},
+	}
+	sys := &mockSystemHandler{execHandler: exec}
+	r := newPlanTestReconciler(sys)
+
+	execs := []commonv1.Exec{
+		{Name: "migrate", Command: "/usr/local/bin/migrate", Creates: done, Always: true},
+		{Name: "onlyif", Command: "/bin/true", OnlyIf: "test -f /nonexistent", Always: true},
+		{Name: "unless", Command: "/bin/true", Unless: "grep -q x /etc/x", Always: true},
+		{Name: "subscribed", Command: "/bin/true", SusbscribeFiles: []string{"/etc/other.conf"}},
+		{Name: "always", Command: "/usr/bin/newaliases", Cwd: "/etc", Env: map[string]string{"B": "2", "A": "1"}, Always: true},
+		{Name: "partial", Command: "/usr/bin/check", ExitCodes: []int32{0, 2}, Always: true},
+		{Name: "untriggered", Command: "/usr/bin/untriggered"},
+		{Name: "slow", Command: "/usr/bin/slow", Timeout: "10m", Always: true},
+	}
+
+	statuses, err := r.handleExecutions(context.Background(), execs, nil, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `exec "partial": unexpected exit code 3`)
+	require.Contains(t, err.Error(), `exec "slow": timeout "10m" exceeds the reconcile timeout of 5m0s`)
+
+	reasons := make(map[string]string)
+	for _, s := range statuses {
+		reasons[s.Name] = s.Reason
+	}
+	require.Equal(t, map[string]string{
+		"migrate":     "skipped: creates " + done + " exists",
+		"onlyif":      "skipped: onlyIf exited 1",
+		"unless":      "skipped: unless exited 0",
+		"subscribed":  "waiting for a subscribed file to change",
+		"always":      "ran",
+		"partial":     "failed: exit code 3",
+		"untriggered": "not triggered: no subscribe_files, schedule or always",
+		"slow":        "error: timeout exceeds 5m0s",
+	}, reasons)
+	require.NotContains(t, exec.Recorder, "/usr/bin/untriggered")
+	require.NotContains(t, exec.Recorder, "/usr/bin/slow")
+
+	require.Equal(t, [][]string{{"-c", "test -f /nonexistent"}, {"-c", "grep -q x /etc/x"}}, exec.Recorder[execShell])
+	require.Equal(t, []handler.ExecOptions{{Dir: "/etc", Env: []string{"A=1", "B=2"}}}, exec.OptionsRecorder["/usr/bin/newaliases"])
+
+	always := statuses[4]
+	require.NotNil(t, always.LastRun)
+	require.Equal(t, int32(0), *always.ExitCode)
+	require.Equal(t, "ok\n", always.Output)
+	require.Equal(t, "boom\n", statuses[5].Output)
+}
+
+func TestExecTriggeredSchedule(t *testing.T) {
+	exe := commonv1.Exec{Command: "/usr/bin/backup", Schedule: "0 3 * * *"}
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	// The first evaluation only schedules the next run.
+	var status commonv1.ExecStatus
+	triggered, reason, err := execTriggered(exe, nil, &status, now)
+	require.NoError(t, err)
+	require.False(t, triggered)
+	require.Equal(t, "not due until 2026-01-03T03:00:00Z", reason)
+	require.Equal(t, time.Date(2026, 1, 3, 3, 0, 0, 0, time.UTC), status.NextRun.Time)
+
+	triggered, _, err = execTriggered(exe, nil, &status, now.Add(15*time.Hour+time.Second))
+	require.NoError(t, err)
+	require.True(t, triggered)
+	require.Equal(t, time.Date(2026, 1, 4, 3, 0, 0, 0, time.UTC), status.NextRun.Time)
+
+	// A subscribed file change runs it early without moving the schedule.
+	exe.SusbscribeFiles = []string{"/etc/backup.conf"}
+	triggered, _, err = execTriggered(exe, []string{"/etc/backup.conf"}, &status, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.True(t, triggered)
+	require.Equal(t, time.Date(2026, 1, 4, 3, 0, 0, 0, time.UTC), status.NextRun.Time)
+
+	_, _, err = execTriggered(commonv1.Exec{Schedule: "not a schedule"}, nil, &status, now)
+	require.Error(t, err)
+}
+
+func TestNextExecRun(t *testing.T) {
+	require.True(t, nextExecRun(nil).IsZero())
+
+	early := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+	require.Equal(t, early, nextExecRun([]commonv1.ExecStatus{
+		{Name: "a", NextRun: &metav1.Time{Time: late}},
+		{Name: "b"},
+		{Name: "c", NextRun: &metav1.Time{Time: early}},
+	}))
+}
+
+func TestTailOutput(t *testing.T) {
+	require.Equal(t, "short", tailOutput("short"))
+
+	long := strings.Repeat("a", maxExecOutputBytes) + "end"
+	out := tailOutput(long)
+	require.True(t, strings.HasPrefix(out, "... (output truncated)\n"))
+	require.True(t, strings.HasSuffix(out, "end"))
+}
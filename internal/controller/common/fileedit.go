@@ -0,0 +1,118 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+const stateAbsent = "absent"
+
+// editedContent returns the content of file.Path after applying the partial
+// edit described by file, and whether that differs from the file on disk.  A
+// missing file is treated as empty.  exists reports whether the file was
+// already on disk, so that an edit which only removes content does not create
+// an empty file.
+func editedContent(file commonv1.File) (content []byte, changed, exists bool, err error) {
+	current, err := os.ReadFile(file.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, false, fmt.Errorf("failed to read file %q: %w", file.Path, err)
+	}
+	exists = err == nil
+
+	switch files.FileEnsureFromString(file.Ensure) {
+	case files.LineInFile:
+		if file.Line == "" && file.State != stateAbsent {
+			return nil, false, exists, fmt.Errorf("lineinfile %q requires line", file.Path)
+		}
+		// An empty line would match, and remove, every blank line.
+		if file.Line == "" && file.Regexp == "" {
+			return nil, false, exists, fmt.Errorf("absent lineinfile %q requires line or regexp", file.Path)
+		}
+		content, err = files.EnsureLine(current, file.Line, file.Regexp, file.State == stateAbsent)
+	case files.INI:
+		content, err = files.EnsureINI(current, fileSettings(file.Settings))
+	case files.KeyValue:
+		content, err = files.EnsureKeyValues(current, fileSettings(file.Settings))
+	default:
+		return nil, false, exists, fmt.Errorf("unhandled partial file ensure %q", file.Ensure)
+	}
+	if err != nil {
+		return nil, false, exists, fmt.Errorf("failed to edit file %q: %w", file.Path, err)
+	}
+
+	return content, !bytes.Equal(content, current), exists, nil
+}
+
+func fileSettings(settings []commonv1.FileSetting) []files.Setting {
+	out := make([]files.Setting, 0, len(settings))
+	for _, s := range settings {
+		out = append(out, files.Setting{
+			Section: s.Section,
+			Key:     s.Key,
+			Value:   s.Value,
+			Absent:  s.State == stateAbsent,
+		})
+	}
+	return out
+}
+
+// fileClaims returns the identifiers of the parts of a file managed by f,
+// used by detectConflicts.  Files managed as a whole return nil; partial
+// modes return one identifier per line or key.  Two lineinfile edits may
+// claim the same line with different identifiers, so they are compared with
+// lineEditsConflict.
+func fileClaims(f commonv1.File) []string {
+	switch files.FileEnsureFromString(f.Ensure) {
+	case files.LineInFile:
+		if f.Regexp != "" {
+			return []string{"line /" + f.Regexp + "/"}
+		}
+		return []string{"line " + f.Line}
+	case files.INI:
+		claims := make([]string, 0, len(f.Settings))
+		for _, s := range f.Settings {
+			claims = append(claims, "key ["+s.Section+"]"+s.Key)
+		}
+		return claims
+	case files.KeyValue:
+		claims := make([]string, 0, len(f.Settings))
+		for _, s := range f.Settings {
+			claims = append(claims, "key "+s.Key)
+		}
+		return claims
+	}
+	return nil
+}
+
+// lineEditsConflict reports whether two lineinfile edits of the same path
+// manage the same line: when they claim it alike, or when the line either
+// one writes is matched by the other, which would replace or remove it.
+func lineEditsConflict(a, b commonv1.File) bool {
+	if slices.Equal(fileClaims(a), fileClaims(b)) {
+		return true
+	}
+	return lineEditMatches(a, b) || lineEditMatches(b, a)
+}
+
+// lineEditMatches reports whether the line written by other is matched by
+// the regexp, or failing that the line, of f.
+func lineEditMatches(f, other commonv1.File) bool {
+	if other.State == stateAbsent || other.Line == "" {
+		return false
+	}
+	if f.Regexp == "" {
+		return f.Line == other.Line
+	}
+	re, err := regexp.Compile(f.Regexp)
+	if err != nil {
+		// The edit fails when it is applied.
+		return false
+	}
+	return re.MatchString(other.Line)
+}
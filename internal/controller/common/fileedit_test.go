@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func TestHandleFileSetPartial(t *testing.T) {
+	dir := t.TempDir()
+	sshd := filepath.Join(dir, "sshd_config")
+	rcConf := filepath.Join(dir, "rc.conf")
+	dnfConf := filepath.Join(dir, "dnf.conf")
+	missing := filepath.Join(dir, "missing.conf")
+
+	require.NoError(t, os.WriteFile(sshd, []byte("Port 22\n#PermitRootLogin yes\n"), 0o644))
+	require.NoError(t, os.WriteFile(rcConf, []byte("sshd_enable=\"YES\"\n"), 0o644))
+	require.NoError(t, os.WriteFile(dnfConf, []byte("[main]\ngpgcheck=1\n"), 0o644))
+
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+
+	changed, _, err := r.handleFileSet(context.Background(), "test-node", "cs", "default", []commonv1.File{
+		{Path: sshd, Ensure: "lineinfile", Line: "PermitRootLogin no", Regexp: "^#?PermitRootLogin"},
+		{Path: rcConf, Ensure: "keyvalue", Settings: []commonv1.FileSetting{{Key: "sshd_enable", Value: "YES"}}},
+		{Path: dnfConf, Ensure: "ini", Settings: []commonv1.FileSetting{{Section: "main", Key: "gpgcheck", Value: "1"}}},
+		{Path: missing, Ensure: "lineinfile", Line: "x", State: "absent"},
+	}, commonv1.ManagedNode{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{sshd}, changed)
+
+	writes := sys.File().(*mockFileHandler).fileWriteCalls
+	require.Len(t, writes, 1)
+	require.Contains(t, writes, sshd)
+
+	// An absent line needs something to match, or every blank line would
+	// be removed.
+	require.NoError(t, os.WriteFile(sshd, []byte("Port 22\n\nPermitRootLogin no\n"), 0o644))
+	_, _, err = r.handleFileSet(context.Background(), "test-node", "cs", "default", []commonv1.File{
+		{Path: sshd, Ensure: "lineinfile", State: "absent"},
+	}, commonv1.ManagedNode{}, nil)
+	require.ErrorContains(t, err, "requires line or regexp")
+	require.Len(t, sys.File().(*mockFileHandler).fileWriteCalls, 1)
+}
+
+func TestFileClaims(t *testing.T) {
+	require.Nil(t, fileClaims(commonv1.File{Path: "/etc/a", Ensure: "file"}))
+	require.Equal(t, []string{"line /^Port/"}, fileClaims(commonv1.File{Ensure: "lineinfile", Line: "Port 2222", Regexp: "^Port"}))
+	require.Equal(t, []string{"line Port 2222"}, fileClaims(commonv1.File{Ensure: "lineinfile", Line: "Port 2222"}))
+	require.Equal(t, []string{"key [main]gpgcheck"}, fileClaims(commonv1.File{
+		Ensure:   "ini",
+		Settings: []commonv1.FileSetting{{Section: "main", Key: "gpgcheck"}},
+	}))
+	require.Equal(t, []string{"key sshd_enable"}, fileClaims(commonv1.File{
+		Ensure:   "keyvalue",
+		Settings: []commonv1.FileSetting{{Key: "sshd_enable"}},
+	}))
+}
+
+func TestLineEditsConflict(t *testing.T) {
+	line := func(l, re string) commonv1.File {
+		return commonv1.File{Path: "/etc/ssh/sshd_config", Ensure: "lineinfile", Line: l, Regexp: re}
+	}
+
+	cases := []struct {
+		name     string
+		a, b     commonv1.File
+		conflict bool
+	}{
+		{"same line", line("PermitRootLogin no", ""), line("PermitRootLogin no", ""), true},
+		{"same regexp", line("Port 22", "^Port"), line("Port 2222", "^Port"), true},
+		{"line matched by regexp", line("PermitRootLogin no", ""), line("PermitRootLogin yes", "^PermitRootLogin"), true},
+		{"regexp matches line", line("PermitRootLogin yes", "^#?PermitRootLogin"), line("PermitRootLogin no", "^PermitRootLogin"), true},
+		{"removal matches line", commonv1.File{Ensure: "lineinfile", Regexp: "^Port", State: "absent"}, line("Port 22", ""), true},
+		{"different lines", line("Port 22", ""), line("PermitRootLogin no", ""), false},
+		{"regexp misses line", line("Port 22", "^Port"), line("PermitRootLogin no", "^PermitRootLogin"), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.conflict, lineEditsConflict(tc.a, tc.b))
+			require.Equal(t, tc.conflict, lineEditsConflict(tc.b, tc.a))
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/types"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/locker"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+const (
+	defaultHealthCheckRetries  = 3
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// errUnhealthy is wrapped by the error of a service which failed its health
+// check, so that its lock group lease is held rather than released.
+var errUnhealthy = errors.New("health check failed")
+
+// checkServiceHealth evaluates the health check of svc, repeating it until it
+// passes or its retries are used up.  A service without a health check is
+// healthy.
+func (r *ConfigSetReconciler) checkServiceHealth(ctx context.Context, nodeName string, svcHandler handler.ServiceHandler, svc commonv1.Service) error {
+	check := svc.HealthCheck
+	if check == nil {
+		return nil
+	}
+
+	ctx, span := r.tracer.Start(ctx, "checkServiceHealth")
+	defer span.End()
+	span.SetAttributes(attribute.String("service", svc.Name))
+
+	retries := defaultHealthCheckRetries
+	if check.Retries != nil {
+		retries = int(*check.Retries)
+	}
+	interval, err := parseDurationDefault(check.Interval, defaultHealthCheckInterval)
+	if err != nil {
+		return fmt.Errorf("invalid health check interval for service %q: %w", svc.Name, err)
+	}
+	timeout, err := parseDurationDefault(check.Timeout, defaultHealthCheckTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid health check timeout for service %q: %w", svc.Name, err)
+	}
+	activeFor, err := parseDurationDefault(check.ActiveFor, 0)
+	if err != nil {
+		return fmt.Errorf("invalid health check activeFor for service %q: %w", svc.Name, err)
+	}
+
+	// A check which keeps failing runs every attempt, and must give up
+	// before the reconcile times out.  The sum is taken in seconds so that a
+	// large number of retries cannot overflow it.
+	attemptTime := timeout
+	if activeFor > 0 {
+		attemptTime = activeFor
+	}
+	total := float64(retries+1)*attemptTime.Seconds() + float64(retries)*interval.Seconds()
+	if total > reconcileTimeout.Seconds() {
+		return fmt.Errorf("health check for service %q may take %s, which exceeds the reconcile timeout of %s",
+			svc.Name, time.Duration(total*float64(time.Second)).Round(time.Second), reconcileTimeout)
+	}
+
+	var checkErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		if activeFor > 0 {
+			checkErr = serviceActiveFor(ctx, svcHandler, svc.Name, activeFor)
+		} else {
+			checkErr = r.runHealthCheck(ctx, check, timeout)
+		}
+		if checkErr == nil {
+			break
+		}
+		r.logger.Debug("service health check failed", "service", svc.Name, "attempt", attempt+1, "err", checkErr)
+	}
+
+	result := "success"
+	if checkErr != nil {
+		result = "error"
+	}
+	serviceOperationsTotal.WithLabelValues(nodeName, "health_check", result).Inc()
+	if checkErr != nil {
+		return fmt.Errorf("%w for service %q: %w", errUnhealthy, svc.Name, checkErr)
+	}
+
+	return nil
+}
+
+// runHealthCheck runs a single exec, tcp or http attempt.
+func (r *ConfigSetReconciler) runHealthCheck(ctx context.Context, check *commonv1.ServiceHealthCheck, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case check.Exec != nil:
+		output, code, err := r.system.Exec().RunCommand(ctx, check.Exec.Command, check.Exec.Args...)
+		if err != nil || code != 0 {
+			return fmt.Errorf("%s exited with code %d: %s", check.Exec.Command, code, strings.TrimSpace(output))
+		}
+	case check.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", check.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case check.HTTP != nil:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.HTTP.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		expected := http.StatusOK
+		if check.HTTP.Status != 0 {
+			expected = int(check.HTTP.Status)
+		}
+		if resp.StatusCode != expected {
+			return fmt.Errorf("GET %s returned %d, expected %d", check.HTTP.URL, resp.StatusCode, expected)
+		}
+	default:
+		return fmt.Errorf("no check is set")
+	}
+
+	return nil
+}
+
+// serviceActiveFor polls the status of the service until it has been running
+// for the whole window.
+func serviceActiveFor(ctx context.Context, svcHandler handler.ServiceHandler, name string, window time.Duration) error {
+	deadline := time.Now().Add(window)
+	for {
+		status, err := svcHandler.Status(ctx, name)
+		if err != nil {
+			return err
+		}
+		if status != services.Running {
+			return fmt.Errorf("service is %s", status)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(remaining, time.Second)):
+		}
+	}
+}
+
+// holdLockGroup keeps the lease of the lock group of svc while it is
+// unhealthy, and releases it once it is healthy.  Only a lease this node
+// already holds is renewed or released.
+func (r *ConfigSetReconciler) holdLockGroup(ctx context.Context, namespace string, svc commonv1.Service, healthy bool) error {
+	if svc.LockGroup == "" {
+		return nil
+	}
+
+	req := types.NamespacedName{Namespace: namespace, Name: svc.LockGroup}
+	if !r.locker.Locked(ctx, req) {
+		return nil
+	}
+
+	if healthy {
+		r.logger.Info("service is healthy, releasing lock", "service", svc.Name, "lease", req)
+		return r.locker.Unlock(ctx, req)
+	}
+
+	if err := r.locker.Lock(locker.WithPurpose(ctx, locker.PurposeServiceRestart), req); err != nil {
+		return fmt.Errorf("failed to renew lock for unhealthy service %q: %w", svc.Name, err)
+	}
+	return nil
+}
+
+func parseDurationDefault(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,265 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/locker"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+func TestCheckServiceHealth(t *testing.T) {
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// The service comes up on the third request.
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	retries := func(n int32) *int32 { return &n }
+
+	cases := []struct {
+		name   string
+		check  commonv1.ServiceHealthCheck
+		status services.ServiceStatus
+		exit   []int
+		err    bool
+	}{
+		{
+			name:  "tcp",
+			check: commonv1.ServiceHealthCheck{TCP: listener.Addr().String()},
+		},
+		{
+			name:  "tcp refused",
+			check: commonv1.ServiceHealthCheck{TCP: closedAddr, Retries: retries(0)},
+			err:   true,
+		},
+		{
+			name:  "http after retries",
+			check: commonv1.ServiceHealthCheck{HTTP: &commonv1.HealthCheckHTTP{URL: server.URL}, Interval: "1ms"},
+		},
+		{
+			name:  "http unexpected status",
+			check: commonv1.ServiceHealthCheck{HTTP: &commonv1.HealthCheckHTTP{URL: server.URL, Status: 204}, Retries: retries(1), Interval: "1ms"},
+			err:   true,
+		},
+		{
+			name:  "exec",
+			check: commonv1.ServiceHealthCheck{Exec: &commonv1.HealthCheckExec{Command: "pg_isready"}, Interval: "1ms"},
+			exit:  []int{2, 0},
+		},
+		{
+			name:  "exec failing",
+			check: commonv1.ServiceHealthCheck{Exec: &commonv1.HealthCheckExec{Command: "pg_isready"}, Retries: retries(1), Interval: "1ms"},
+			exit:  []int{2, 2},
+			err:   true,
+		},
+		{
+			name:   "active",
+			check:  commonv1.ServiceHealthCheck{ActiveFor: "5ms"},
+			status: services.Running,
+		},
+		{
+			name:   "not active",
+			check:  commonv1.ServiceHealthCheck{ActiveFor: "5ms", Retries: retries(0)},
+			status: services.Stopped,
+			err:    true,
+		},
+		{
+			name:  "exceeds reconcile timeout",
+			check: commonv1.ServiceHealthCheck{TCP: listener.Addr().String(), Retries: retries(10), Interval: "30s"},
+			err:   true,
+		},
+		{
+			name:   "activeFor exceeds reconcile timeout",
+			check:  commonv1.ServiceHealthCheck{ActiveFor: "2m", Retries: retries(2)},
+			status: services.Running,
+			err:    true,
+		},
+		{
+			name:  "retries overflow",
+			check: commonv1.ServiceHealthCheck{TCP: listener.Addr().String(), Retries: retries(2147483647), Interval: "1h"},
+			err:   true,
+		},
+		{
+			name:  "invalid interval",
+			check: commonv1.ServiceHealthCheck{TCP: listener.Addr().String(), Interval: "soon"},
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			svcHandler := &mockServiceHandler{serviceStatus: map[string]services.ServiceStatus{"web": tc.status}}
+			r := newPlanTestReconciler(&mockSystemHandler{
+				serviceHandler: svcHandler,
+				execHandler:    &handler.MockExecHandler{Status: tc.exit},
+			})
+
+			err := r.checkServiceHealth(ctx, "test-node", svcHandler, commonv1.Service{Name: "web", HealthCheck: &tc.check})
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHandleServiceSetHealthCheck(t *testing.T) {
+	ctx := context.Background()
+
+	svcHandler := &mockServiceHandler{serviceStatus: map[string]services.ServiceStatus{"nginx": services.Running}}
+	exec := &handler.MockExecHandler{Status: []int{1, 1}}
+	r := newPlanTestReconciler(&mockSystemHandler{serviceHandler: svcHandler, execHandler: exec})
+	r.locker = locker.NewLeaseLocker(ctx, r.logger, locker.Config{}, fake.NewSimpleClientset(), "default", "test-node")
+	lease := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	retries := int32(1)
+	svcs := []commonv1.Service{{
+		Name:            "nginx",
+		Ensure:          "running",
+		SusbscribeFiles: []string{"/etc/nginx/nginx.conf"},
+		LockGroup:       "web",
+		HealthCheck: &commonv1.ServiceHealthCheck{
+			Exec:     &commonv1.HealthCheckExec{Command: "curl", Args: []string{"-f", "http://localhost"}},
+			Retries:  &retries,
+			Interval: "1ms",
+		},
+	}}
+
+	// A restart which leaves the service unhealthy fails, and keeps the lease
+	// so that the rest of the group does not restart.
+	_, unhealthy, _, err := r.handleServiceSet(ctx, "test-node", "default", svcs, nil, []string{"/etc/nginx/nginx.conf"}, nil, nil, nil, nil)
+	require.ErrorIs(t, err, errUnhealthy)
+	require.Equal(t, []string{"nginx"}, unhealthy)
+	require.Equal(t, 1, svcHandler.restartCalls["nginx"])
+	require.True(t, r.locker.Locked(ctx, lease))
+
+	// The next reconcile checks the service again without restarting it, and
+	// releases the lease once it passes.
+	_, unhealthy, _, err = r.handleServiceSet(ctx, "test-node", "default", svcs, nil, nil, nil, unhealthy, nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, unhealthy)
+	require.Empty(t, unhealthy)
+	require.Equal(t, 1, svcHandler.restartCalls["nginx"])
+	require.False(t, r.locker.Locked(ctx, lease))
+	require.Len(t, exec.Recorder["curl"], 3)
+}
+
+func TestHandleServiceSetUnhealthyHoldsGroup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	clientset := fake.NewSimpleClientset()
+	cfg := locker.Config{LeaseDuration: time.Minute}
+	lease := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	// Two nodes of the same lock group, each with a filebucket backup of the
+	// config which changed on this reconcile.
+	type node struct {
+		r          *ConfigSetReconciler
+		svcHandler *mockServiceHandler
+		fileSet    []commonv1.File
+		backups    map[string]string
+	}
+	newNode := func(name string, exit []int) node {
+		bucket := filepath.Join(dir, name, "bucket")
+		path := filepath.Join(dir, name, "nginx.conf")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		previous := []byte("good config\n")
+		require.NoError(t, os.WriteFile(path, previous, 0o644))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		hash, err := files.SaveToFileBucket(bucket, path, previous, info)
+		require.NoError(t, err)
+
+		svcHandler := &mockServiceHandler{serviceStatus: map[string]services.ServiceStatus{"nginx": services.Running}}
+		r := newPlanTestReconciler(&mockSystemHandler{
+			serviceHandler: svcHandler,
+			execHandler:    &handler.MockExecHandler{Status: exit},
+		})
+		r.cfg.FileBucket = FileBucketConfig{Enabled: true, Path: bucket}
+		r.locker = locker.NewLeaseLocker(ctx, r.logger, cfg, clientset, "default", name)
+
+		return node{
+			r:          r,
+			svcHandler: svcHandler,
+			fileSet:    []commonv1.File{{Path: path, Content: "new config\n", Rollback: true}},
+			backups:    map[string]string{path: hash},
+		}
+	}
+
+	retries := int32(1)
+	svcsFor := func(n node) []commonv1.Service {
+		return []commonv1.Service{{
+			Name:            "nginx",
+			Ensure:          "running",
+			SusbscribeFiles: []string{n.fileSet[0].Path},
+			LockGroup:       "web",
+			HealthCheck: &commonv1.ServiceHealthCheck{
+				Exec:     &commonv1.HealthCheckExec{Command: "check"},
+				Retries:  &retries,
+				Interval: "1ms",
+			},
+		}}
+	}
+
+	// The first node is unhealthy after its restart, and again after its
+	// rollback, and keeps the lease.
+	bad := newNode("bad-node", []int{1, 1, 1, 1})
+	good := newNode("good-node", []int{0})
+
+	_, badUnhealthy, _, err := bad.r.handleServiceSet(ctx, "bad-node", "default", svcsFor(bad), bad.fileSet, []string{bad.fileSet[0].Path}, bad.backups, nil, nil, nil)
+	require.ErrorIs(t, err, errUnhealthy)
+	require.True(t, bad.r.locker.Locked(ctx, lease))
+
+	// The second node waits for the lease, and keeps its new config.
+	rolledBack, unhealthy, _, err := good.r.handleServiceSet(ctx, "good-node", "default", svcsFor(good), good.fileSet, []string{good.fileSet[0].Path}, good.backups, nil, nil, nil)
+	require.ErrorIs(t, err, errLockHeld)
+	require.NotErrorIs(t, err, errUnhealthy)
+	require.Empty(t, rolledBack)
+	require.Empty(t, unhealthy)
+	require.Zero(t, good.svcHandler.restartCalls["nginx"])
+	require.NotContains(t, good.r.system.File().(*mockFileHandler).fileWriteCalls, good.fileSet[0].Path)
+
+	// The first node passes its check on a later reconcile and releases the
+	// lease, after which the second node restarts.
+	bad.r.system.(*mockSystemHandler).execHandler = &handler.MockExecHandler{Status: []int{0}}
+	_, badUnhealthy, _, err = bad.r.handleServiceSet(ctx, "bad-node", "default", svcsFor(bad), bad.fileSet, nil, nil, badUnhealthy, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, badUnhealthy)
+	require.False(t, bad.r.locker.Locked(ctx, lease))
+
+	rolledBack, _, _, err = good.r.handleServiceSet(ctx, "good-node", "default", svcsFor(good), good.fileSet, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, rolledBack)
+	require.Equal(t, 1, good.svcHandler.restartCalls["nginx"])
+	require.False(t, good.r.locker.Locked(ctx, lease))
+}
@@ -45,12 +45,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
 	"github.com/zachfi/nodemanager/internal/notification"
 	"github.com/zachfi/nodemanager/pkg/common"
 	"github.com/zachfi/nodemanager/pkg/common/labels"
+	"github.com/zachfi/nodemanager/pkg/facts"
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"github.com/zachfi/nodemanager/pkg/locker"
 	notificationv1 "github.com/zachfi/nodemanager/pkg/notification/v1"
@@ -69,6 +71,7 @@ type ManagedNodeReconciler struct {
 	clientset    kubernetes.Interface
 	agentVersion string
 	notifier     notification.Notifier
+	facts        *facts.Collector
 }
 
 func NewManagedNodeReconciler(client client.Client, scheme *runtime.Scheme, logger *slog.Logger, cfg ManagedNodeConfig, system handler.System, locker locker.Locker, clientset kubernetes.Interface, agentVersion string, notifier notification.Notifier) *ManagedNodeReconciler {
@@ -83,6 +86,7 @@ func NewManagedNodeReconciler(client client.Client, scheme *runtime.Scheme, logg
 		clientset:    clientset,
 		agentVersion: agentVersion,
 		notifier:     notifier,
+		facts:        facts.New(logger, system.Exec(), cfg.FactsDir, cfg.FactsTTL),
 	}
 }
 
@@ -90,6 +94,7 @@ func NewManagedNodeReconciler(client client.Client, scheme *runtime.Scheme, logg
 //+kubebuilder:rbac:groups=common.nodemanager.nodemanager,resources=managednodes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=common.nodemanager.nodemanager,resources=managednodes/finalizers,verbs=update
 //+kubebuilder:rbac:groups=common.nodemanager.nodemanager,resources=configsets,verbs=list
+//+kubebuilder:rbac:groups=common.nodemanager.nodemanager,resources=nodelabelrules,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;patch
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
 //+kubebuilder:rbac:groups="policy",resources=pods/eviction,verbs=create
@@ -130,12 +135,14 @@ func (r *ManagedNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	err = r.updateNodeLabels(ctx, node)
+	nodeFacts := r.collectFacts(ctx)
+
+	err = r.updateNodeLabels(ctx, node, nodeFacts)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	err = r.updateNodeStatus(ctx, node)
+	err = r.updateNodeStatus(ctx, node, nodeFacts)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -179,6 +186,7 @@ func (r *ManagedNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&commonv1.ManagedNode{}, builder.WithPredicates(newNameFilterPredicate(hostname))).
+		Watches(&commonv1.NodeLabelRule{}, ctrlhandler.EnqueueRequestsFromMapFunc(r.localNodeRequests(hostname))).
 		Complete(r)
 }
 
@@ -199,59 +207,71 @@ func newNameFilterPredicate(targetName string) predicate.Predicate {
 	}
 }
 
-func (r *ManagedNodeReconciler) updateNodeLabels(ctx context.Context, node *commonv1.ManagedNode) error {
-	labels := labels.DefaultLabels(ctx, r.system.Node())
+// updateNodeLabels sets the default labels and the labels of the matching
+// NodeLabelRules on the ManagedNode.
+func (r *ManagedNodeReconciler) updateNodeLabels(ctx context.Context, node *commonv1.ManagedNode, nodeFacts map[string]string) error {
+	defaults := labels.DefaultLabels(ctx, r.system.Node())
 
-	nodeLabels := node.GetLabels()
-	if nodeLabels == nil {
-		nodeLabels = make(map[string]string)
+	fromRules, err := r.nodeLabelRuleLabels(ctx, ruleFacts(r.system.Node().Info(ctx), nodeFacts))
+	if err != nil {
+		return err
 	}
 
-	var updateLabels bool
-	for k, v := range labels {
-		if vv, ok := node.Labels[k]; ok {
-			if vv != v {
-				updateLabels = true
-				nodeLabels[k] = v
-			}
-		} else {
-			updateLabels = true
-			nodeLabels[k] = v
-		}
+	desired := node.DeepCopy()
+	if !mergeNodeLabels(desired, defaults, fromRules) {
+		return nil
 	}
 
-	if updateLabels {
-		r.logger.Info("updating labels", "labels", nodeLabels)
+	r.logger.Info("updating labels", "labels", desired.GetLabels())
 
-		if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-			var fresh commonv1.ManagedNode
-			if err := r.Get(ctx, types.NamespacedName{Name: node.Name, Namespace: node.Namespace}, &fresh); err != nil {
-				return err
-			}
-			freshLabels := fresh.GetLabels()
-			if freshLabels == nil {
-				freshLabels = make(map[string]string)
-			}
-			for k, v := range nodeLabels {
-				freshLabels[k] = v
-			}
-			fresh.SetLabels(freshLabels)
-			return r.Update(ctx, &fresh)
-		}); err != nil {
-			return fmt.Errorf("failed to update ManagedNode: %w", err)
+	if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var fresh commonv1.ManagedNode
+		if err := r.Get(ctx, types.NamespacedName{Name: node.Name, Namespace: node.Namespace}, &fresh); err != nil {
+			return err
+		}
+		if !mergeNodeLabels(&fresh, defaults, fromRules) {
+			return nil
 		}
+		return r.Update(ctx, &fresh)
+	}); err != nil {
+		return fmt.Errorf("failed to update ManagedNode: %w", err)
 	}
 
 	return nil
 }
 
-func (r *ManagedNodeReconciler) updateNodeStatus(ctx context.Context, node *commonv1.ManagedNode) error {
+// collectHeldPackages returns the sorted names of the packages held on the
+// node, or nil when the package manager cannot list them.
+func (r *ManagedNodeReconciler) collectHeldPackages(ctx context.Context) []string {
+	held, err := r.system.Package().Held(ctx)
+	if err != nil {
+		r.logger.Debug("failed to list held packages", "err", err)
+		return nil
+	}
+	if len(held) == 0 {
+		return nil
+	}
+	slices.Sort(held)
+	return held
+}
+
+// collectFacts returns the facts of the node, or nil when no collector is
+// configured.
+func (r *ManagedNodeReconciler) collectFacts(ctx context.Context) map[string]string {
+	if r.facts == nil {
+		return nil
+	}
+	return r.facts.Collect(ctx)
+}
+
+func (r *ManagedNodeReconciler) updateNodeStatus(ctx context.Context, node *commonv1.ManagedNode, newFacts map[string]string) error {
 	// Compute new status values before entering the retry loop.
 	newAgentVersion := r.agentVersion
 	info := r.system.Node().Info(ctx)
 	newRelease := info.OS.Release
 	newInterfaces := collectNetworkInterfaces()
 	newSSHHostKeys := collectSSHHostKeys(ctx, r.system.Exec(), node.Name)
+	newHeldPackages := r.collectHeldPackages(ctx)
 	liveWG := collectWireGuardInterfaces(ctx, r.system.Exec())
 
 	if node.Spec.WireGuard.Enabled {
@@ -278,7 +298,9 @@ func (r *ManagedNodeReconciler) updateNodeStatus(ctx context.Context, node *comm
 		node.Status.Jailed == newJailed &&
 		reflect.DeepEqual(node.Status.Interfaces, newInterfaces) &&
 		reflect.DeepEqual(node.Status.SSHHostKeys, newSSHHostKeys) &&
-		reflect.DeepEqual(node.Status.WireGuard, liveWG) {
+		reflect.DeepEqual(node.Status.WireGuard, liveWG) &&
+		reflect.DeepEqual(node.Status.Facts, newFacts) &&
+		slices.Equal(node.Status.HeldPackages, newHeldPackages) {
 		return nil
 	}
 
@@ -295,6 +317,8 @@ func (r *ManagedNodeReconciler) updateNodeStatus(ctx context.Context, node *comm
 		fresh.Status.SSHHostKeys = newSSHHostKeys
 		fresh.Status.WireGuard = liveWG
 		fresh.Status.Jailed = newJailed
+		fresh.Status.HeldPackages = newHeldPackages
+		fresh.Status.Facts = newFacts
 		return r.Status().Update(ctx, &fresh)
 	}); err != nil {
 		return fmt.Errorf("failed to update ManagedNode status: %w", err)
@@ -554,7 +578,7 @@ func (r *ManagedNodeReconciler) handleUpgrade(ctx context.Context, node *commonv
 		// member upgrades per slot.  Other members see the lock as held and
 		// skip gracefully rather than retrying with backoff.
 		lockTTL := time.Until(schedExpr.Next(time.Now()))
-		err = r.locker.LockFor(ctx, req, lockTTL)
+		err = r.locker.LockFor(locker.WithPurpose(ctx, locker.PurposeUpgrade), req, lockTTL)
 		if err != nil {
 			if k8serrors.IsConflict(err) {
 				r.logger.Info("upgrade group lock held by another node, skipping this slot",
@@ -970,7 +994,7 @@ func (r *ManagedNodeReconciler) pruneStaleConfigSetStatus(ctx context.Context, n
 	// Build the set of ConfigSet names that currently match this node.
 	matching := make(map[string]struct{}, len(allConfigSets.Items))
 	for _, cs := range allConfigSets.Items {
-		if nodeLabelMatch(*node, cs.Labels) == nil {
+		if configSetNodeMatch(*node, &cs) == nil {
 			matching[cs.Name] = struct{}{}
 		}
 	}
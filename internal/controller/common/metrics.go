@@ -26,18 +26,25 @@ var (
 		Buckets: prometheus.DefBuckets,
 	}, []string{"node", "configset"})
 
-	// packageOperationsTotal counts package manager operations (install/remove/upgrade).
+	// packageOperationsTotal counts package manager operations (install/remove/upgrade/repository/refresh).
 	packageOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "nodemanager_package_operations_total",
 		Help: "Total number of package manager operations.",
 	}, []string{"node", "operation", "result"})
 
-	// serviceOperationsTotal counts service manager operations (start/stop/restart/enable/disable).
+	// serviceOperationsTotal counts service manager operations (start/stop/restart/enable/disable/mask/unmask/daemon_reload).
 	serviceOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "nodemanager_service_operations_total",
 		Help: "Total number of service manager operations.",
 	}, []string{"node", "operation", "result"})
 
+	// userOperationsTotal counts local user and group operations
+	// (user_add/user_modify/user_remove and the group_ equivalents).
+	userOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nodemanager_user_operations_total",
+		Help: "Total number of local user and group operations.",
+	}, []string{"node", "operation", "result"})
+
 	// fileChangesTotal counts files that were changed during a ConfigSet apply.
 	fileChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "nodemanager_file_changes_total",
@@ -82,6 +89,20 @@ var (
 		Name: "nodemanager_configset_applied_resource_version",
 		Help: "Unix timestamp of the last successful ConfigSet apply, labelled by resource version. Use to track rollout convergence across nodes.",
 	}, []string{"node", "configset", "resource_version"})
+
+	// driftedResources records how many resources of each kind differ from
+	// the desired state of a ConfigSet in audit mode.
+	driftedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nodemanager_drifted_resources",
+		Help: "Number of resources which differ from the desired state of a ConfigSet in audit mode.",
+	}, []string{"node", "configset", "kind"})
+
+	// fileDriftEventsTotal counts changes to managed files made outside
+	// nodemanager, as seen by the file watcher.
+	fileDriftEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nodemanager_file_drift_events_total",
+		Help: "Total number of changes to managed files made outside nodemanager.",
+	}, []string{"node", "configset", "path"})
 )
 
 // SetBuildInfo sets the build info metric to 1 with the given labels.
@@ -97,6 +118,7 @@ func init() {
 		configSetApplyDuration,
 		packageOperationsTotal,
 		serviceOperationsTotal,
+		userOperationsTotal,
 		fileChangesTotal,
 		upgradeTotal,
 		upgradeDuration,
@@ -104,5 +126,7 @@ func init() {
 		lastConfigSetApplyTimestamp,
 		configSetConflictsTotal,
 		configSetAppliedResourceVersion,
+		driftedResources,
+		fileDriftEventsTotal,
 	)
 }
@@ -2,9 +2,13 @@ package common
 
 import (
 	"context"
+	"slices"
+	"strings"
 
 	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
 	"github.com/zachfi/nodemanager/pkg/services"
+	"github.com/zachfi/nodemanager/pkg/users"
 )
 
 var systemHandler = &mockSystemHandler{}
@@ -14,9 +18,11 @@ var hostname, err = systemHandler.Node().Hostname()
 var (
 	_ handler.PackageHandler = (*mockPackageHandler)(nil)
 	_ handler.ServiceHandler = (*mockServiceHandler)(nil)
+	_ handler.UnitHandler    = (*mockServiceHandler)(nil)
 	_ handler.FileHandler    = (*mockFileHandler)(nil)
 	_ handler.ExecHandler    = (*mockExecHandler)(nil)
 	_ handler.NodeHandler    = (*mockNodeHandler)(nil)
+	_ handler.UserHandler    = (*mockUserHandler)(nil)
 	_ handler.System         = (*mockSystemHandler)(nil)
 )
 
@@ -26,6 +32,7 @@ type mockSystemHandler struct {
 	fileHandler    handler.FileHandler
 	nodeHandler    handler.NodeHandler
 	execHandler    handler.ExecHandler
+	userHandler    handler.UserHandler
 }
 
 func (m *mockSystemHandler) Package() handler.PackageHandler {
@@ -63,6 +70,13 @@ func (m *mockSystemHandler) Exec() handler.ExecHandler {
 	return m.execHandler
 }
 
+func (m *mockSystemHandler) User() handler.UserHandler {
+	if m.userHandler == nil {
+		m.userHandler = &mockUserHandler{}
+	}
+	return m.userHandler
+}
+
 type mockServiceHandler struct {
 	startCalls   map[string]int
 	stopCalls    map[string]int
@@ -73,10 +87,23 @@ type mockServiceHandler struct {
 	reloadCalls  map[string]int
 	setArgsCalls map[string]int
 
-	// TODO: implement daemon reload calls if needed
 	daemonReloadCalls int
 
+	// arguments holds the arguments set on each service, and enabled the
+	// services which are enabled.
+	arguments map[string]string
+	enabled   map[string]bool
+
+	// unitFiles holds the unit files and drop-ins by path, and unitStates the
+	// state reported by UnitFileState.
+	unitFiles  map[string][]byte
+	unitStates map[string]string
+	unitCalls  []string
+
 	serviceStatus map[string]services.ServiceStatus // Simulated service status
+
+	// restartErrs are returned by successive Restart calls for a service.
+	restartErrs map[string][]error
 }
 
 func (m *mockServiceHandler) Start(ctx context.Context, service string) error {
@@ -102,6 +129,12 @@ func (m *mockServiceHandler) Restart(ctx context.Context, service string) error
 		m.restartCalls = make(map[string]int)
 	}
 	m.restartCalls[service]++
+
+	if errs := m.restartErrs[service]; len(errs) > 0 {
+		m.restartErrs[service] = errs[1:]
+		return errs[0]
+	}
+
 	// Simulate restarting the service
 	return nil // Return nil to indicate success
 }
@@ -128,8 +161,11 @@ func (m *mockServiceHandler) Enable(ctx context.Context, service string) error {
 		m.enableCalls = make(map[string]int)
 	}
 	m.enableCalls[service]++
-	// Simulate enabling the service
-	return nil // Return nil to indicate success
+	if m.enabled == nil {
+		m.enabled = make(map[string]bool)
+	}
+	m.enabled[service] = true
+	return nil
 }
 
 func (m *mockServiceHandler) Disable(ctx context.Context, service string) error {
@@ -137,17 +173,102 @@ func (m *mockServiceHandler) Disable(ctx context.Context, service string) error
 		m.disableCalls = make(map[string]int)
 	}
 	m.disableCalls[service]++
-	// Simulate disabling the service
-	return nil // Return nil to indicate success
+	delete(m.enabled, service)
+	return nil
+}
+
+func (m *mockServiceHandler) Enabled(ctx context.Context, service string) (bool, error) {
+	return m.enabled[service], nil
 }
 
-func (m *mockServiceHandler) SetArguments(ctx context.Context, service, args string) error {
+func (m *mockServiceHandler) SetArguments(ctx context.Context, service, args string) (bool, error) {
 	if m.setArgsCalls == nil {
 		m.setArgsCalls = make(map[string]int)
 	}
 	m.setArgsCalls[service]++
-	// Simulate setting service arguments
-	return nil // Return nil to indicate success
+
+	if m.arguments == nil {
+		m.arguments = make(map[string]string)
+	}
+	if m.arguments[service] == args {
+		return false, nil
+	}
+	if args == "" {
+		delete(m.arguments, service)
+	} else {
+		m.arguments[service] = args
+	}
+	return true, nil
+}
+
+func (m *mockServiceHandler) Arguments(ctx context.Context, service string) (string, error) {
+	return m.arguments[service], nil
+}
+
+func mockUnitPath(name, dropIn string) string {
+	if dropIn != "" {
+		return name + ".d/" + dropIn + ".conf"
+	}
+	return name
+}
+
+func (m *mockServiceHandler) UnitContent(ctx context.Context, name, dropIn string) ([]byte, error) {
+	return m.unitFiles[mockUnitPath(name, dropIn)], nil
+}
+
+func (m *mockServiceHandler) UnitPath(ctx context.Context, name, dropIn string) (string, error) {
+	return mockUnitPath(name, dropIn), nil
+}
+
+func (m *mockServiceHandler) WriteUnit(ctx context.Context, name, dropIn string, content []byte) (bool, error) {
+	path := mockUnitPath(name, dropIn)
+	if string(m.unitFiles[path]) == string(content) {
+		return false, nil
+	}
+	if m.unitFiles == nil {
+		m.unitFiles = make(map[string][]byte)
+	}
+	m.unitFiles[path] = content
+	m.unitCalls = append(m.unitCalls, "write "+path)
+	return true, nil
+}
+
+func (m *mockServiceHandler) RemoveUnit(ctx context.Context, name, dropIn string) (bool, error) {
+	path := mockUnitPath(name, dropIn)
+	if _, ok := m.unitFiles[path]; !ok {
+		return false, nil
+	}
+	delete(m.unitFiles, path)
+	m.unitCalls = append(m.unitCalls, "remove "+path)
+	return true, nil
+}
+
+func (m *mockServiceHandler) UnitFileState(ctx context.Context, name string) (string, error) {
+	if state, ok := m.unitStates[name]; ok {
+		return state, nil
+	}
+	return "disabled", nil
+}
+
+func (m *mockServiceHandler) Mask(ctx context.Context, name string) error {
+	if m.unitStates == nil {
+		m.unitStates = make(map[string]string)
+	}
+	m.unitStates[name] = "masked"
+	m.unitCalls = append(m.unitCalls, "mask "+name)
+	return nil
+}
+
+func (m *mockServiceHandler) Unmask(ctx context.Context, name string) error {
+	delete(m.unitStates, name)
+	m.unitCalls = append(m.unitCalls, "unmask "+name)
+	return nil
+}
+
+func (m *mockServiceHandler) DaemonReload(ctx context.Context) error {
+	m.daemonReloadCalls++
+	m.unitCalls = append(m.unitCalls, "daemon-reload")
+	return nil
 }
 
 // mockPackageHandler implements the PackageHandler interface for testing.
@@ -156,6 +277,14 @@ type mockPackageHandler struct {
 	removeCalls  map[string]int
 	packageList  map[string]string
 	upgradeCalls int
+	held         []string
+	holdCalls    []string
+	unholdCalls  []string
+	calls        []string
+	repositories map[string]packages.Repository
+	refreshCalls int
+	available    map[string]string
+	installErr   error
 }
 
 func (m *mockPackageHandler) Install(ctx context.Context, pkg, version string) error {
@@ -163,14 +292,44 @@ func (m *mockPackageHandler) Install(ctx context.Context, pkg, version string) e
 		m.installCalls = make(map[string]int)
 	}
 	m.installCalls[pkg]++
+	m.calls = append(m.calls, "install "+pkg)
 
 	return nil // Simulate successful installation
 }
 
 func (m *mockPackageHandler) Remove(ctx context.Context, pkg string) error {
+	m.calls = append(m.calls, "remove "+pkg)
 	return nil // Simulate successful uninstallation
 }
 
+func (m *mockPackageHandler) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	if m.installCalls == nil {
+		m.installCalls = make(map[string]int)
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		m.installCalls[pkg.Name]++
+		names = append(names, pkg.Name)
+	}
+	m.calls = append(m.calls, "install "+strings.Join(names, " "))
+	return m.installErr
+}
+
+func (m *mockPackageHandler) RemovePackages(ctx context.Context, names []string) error {
+	m.calls = append(m.calls, "remove "+strings.Join(names, " "))
+	return nil
+}
+
+func (m *mockPackageHandler) Available(ctx context.Context, names []string) (map[string]string, error) {
+	available := make(map[string]string)
+	for _, name := range names {
+		if v, ok := m.available[name]; ok {
+			available[name] = v
+		}
+	}
+	return available, nil
+}
+
 func (m *mockPackageHandler) List(ctx context.Context) (map[string]string, error) {
 	if m.packageList == nil {
 		m.packageList = map[string]string{"pkg1": "", "pkg2": "", "pkg3": ""}
@@ -183,12 +342,57 @@ func (m *mockPackageHandler) UpgradeAll(ctx context.Context) error {
 	return nil // Simulate successful upgrade of all packages
 }
 
+func (m *mockPackageHandler) Hold(ctx context.Context, pkg string) error {
+	m.holdCalls = append(m.holdCalls, pkg)
+	m.calls = append(m.calls, "hold "+pkg)
+	if !slices.Contains(m.held, pkg) {
+		m.held = append(m.held, pkg)
+	}
+	return nil
+}
+
+func (m *mockPackageHandler) Unhold(ctx context.Context, pkg string) error {
+	m.unholdCalls = append(m.unholdCalls, pkg)
+	m.calls = append(m.calls, "unhold "+pkg)
+	m.held = slices.DeleteFunc(m.held, func(p string) bool { return p == pkg })
+	return nil
+}
+
+func (m *mockPackageHandler) Held(ctx context.Context) ([]string, error) {
+	return slices.Clone(m.held), nil
+}
+
+func (m *mockPackageHandler) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	current, ok := m.repositories[repo.Name]
+	return ok && current == repo, nil
+}
+
+func (m *mockPackageHandler) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	if current, ok := m.repositories[repo.Name]; ok && current == repo {
+		return false, nil
+	}
+	if m.repositories == nil {
+		m.repositories = make(map[string]packages.Repository)
+	}
+	m.repositories[repo.Name] = repo
+	m.calls = append(m.calls, "repository "+repo.Name)
+	return true, nil
+}
+
+func (m *mockPackageHandler) Refresh(ctx context.Context) error {
+	m.refreshCalls++
+	m.calls = append(m.calls, "refresh")
+	return nil
+}
+
 // mockFileHandler implements the FileHandler interface for testing.
 type mockFileHandler struct {
 	fileExistsCalls map[string]int
 	fileWriteCalls  map[string]int
-	fileReadCalls   map[string]int
-	fileRemoveCalls map[string]int
+	// fileReplaceCalls counts the writes which replace the file as a whole.
+	fileReplaceCalls map[string]int
+	fileReadCalls    map[string]int
+	fileRemoveCalls  map[string]int
 }
 
 // type FileHandler interface {
@@ -230,6 +434,14 @@ func (m *mockFileHandler) WriteContentFile(ctx context.Context, path string, con
 	return true, nil // Return nil to indicate success
 }
 
+func (m *mockFileHandler) ReplaceContentFile(ctx context.Context, path string, content []byte) (bool, error) {
+	if m.fileReplaceCalls == nil {
+		m.fileReplaceCalls = make(map[string]int)
+	}
+	m.fileReplaceCalls[path]++
+	return m.WriteContentFile(ctx, path, content)
+}
+
 func (m *mockFileHandler) Remove(ctx context.Context, path string) (bool, error) {
 	if m.fileRemoveCalls == nil {
 		m.fileRemoveCalls = make(map[string]int)
@@ -313,6 +525,79 @@ func (m *mockExecHandler) SimpleRunCommand(ctx context.Context, command string,
 	return err
 }
 
+func (m *mockExecHandler) RunCommandWithOptions(ctx context.Context, opts handler.ExecOptions, command string, arg ...string) (string, int, error) {
+	return m.RunCommand(ctx, command, arg...)
+}
+
 func (m *mockExecHandler) RunCommandWithInput(ctx context.Context, stdin string, command string, arg ...string) (string, int, error) {
 	return m.RunCommand(ctx, command, arg...)
 }
+
+// mockUserHandler implements the UserHandler interface for testing.
+type mockUserHandler struct {
+	users  map[string]*users.User
+	groups map[string]*users.Group
+
+	addUserCalls     map[string]int
+	modifyUserCalls  map[string]int
+	removeUserCalls  map[string]int
+	addGroupCalls    map[string]int
+	modifyGroupCalls map[string]int
+	removeGroupCalls map[string]int
+}
+
+func (m *mockUserHandler) LookupUser(ctx context.Context, name string) (*users.User, error) {
+	return m.users[name], nil
+}
+
+func (m *mockUserHandler) AddUser(ctx context.Context, user users.User) error {
+	if m.addUserCalls == nil {
+		m.addUserCalls = make(map[string]int)
+	}
+	m.addUserCalls[user.Name]++
+	return nil
+}
+
+func (m *mockUserHandler) ModifyUser(ctx context.Context, user users.User) error {
+	if m.modifyUserCalls == nil {
+		m.modifyUserCalls = make(map[string]int)
+	}
+	m.modifyUserCalls[user.Name]++
+	return nil
+}
+
+func (m *mockUserHandler) RemoveUser(ctx context.Context, name string) error {
+	if m.removeUserCalls == nil {
+		m.removeUserCalls = make(map[string]int)
+	}
+	m.removeUserCalls[name]++
+	return nil
+}
+
+func (m *mockUserHandler) LookupGroup(ctx context.Context, name string) (*users.Group, error) {
+	return m.groups[name], nil
+}
+
+func (m *mockUserHandler) AddGroup(ctx context.Context, group users.Group) error {
+	if m.addGroupCalls == nil {
+		m.addGroupCalls = make(map[string]int)
+	}
+	m.addGroupCalls[group.Name]++
+	return nil
+}
+
+func (m *mockUserHandler) ModifyGroup(ctx context.Context, group users.Group) error {
+	if m.modifyGroupCalls == nil {
+		m.modifyGroupCalls = make(map[string]int)
+	}
+	m.modifyGroupCalls[group.Name]++
+	return nil
+}
+
+func (m *mockUserHandler) RemoveGroup(ctx context.Context, name string) error {
+	if m.removeGroupCalls == nil {
+		m.removeGroupCalls = make(map[string]int)
+	}
+	m.removeGroupCalls[name]++
+	return nil
+}
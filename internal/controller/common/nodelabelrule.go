@@ -0,0 +1,239 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/common"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+// ruleFacts returns the facts NodeLabelRule conditions are evaluated against:
+// the collected node facts and the fields of the system info.
+func ruleFacts(info *handler.SysInfo, nodeFacts map[string]string) map[string]string {
+	facts := make(map[string]string, len(nodeFacts)+5)
+	for k, v := range nodeFacts {
+		facts[k] = v
+	}
+
+	for k, v := range map[string]string{
+		"os.id":      info.OS.ID,
+		"os.name":    info.OS.Name,
+		"os.release": info.OS.Release,
+		"machine":    info.Machine,
+		"hostname":   info.Name,
+	} {
+		if v != "" {
+			facts[k] = v
+		}
+	}
+
+	return facts
+}
+
+// matchNodeLabelRule reports whether every condition of the rule matches the
+// facts.  exists reports whether a path exists on the node.
+func matchNodeLabelRule(rule *commonv1.NodeLabelRule, facts map[string]string, exists func(string) bool) (bool, error) {
+	for _, c := range rule.Spec.Conditions {
+		ok, err := matchNodeLabelRuleCondition(c, facts, exists)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchNodeLabelRuleCondition(c commonv1.NodeLabelRuleCondition, facts map[string]string, exists func(string) bool) (bool, error) {
+	if c.FileExists != "" {
+		return exists(c.FileExists), nil
+	}
+
+	value, ok := facts[c.Fact]
+
+	switch c.Operator {
+	case "", commonv1.NodeLabelRuleIn:
+		return ok && slices.Contains(c.Values, value), nil
+	case commonv1.NodeLabelRuleNotIn:
+		return !ok || !slices.Contains(c.Values, value), nil
+	case commonv1.NodeLabelRuleExists:
+		return ok, nil
+	case commonv1.NodeLabelRuleDoesNotExist:
+		return !ok, nil
+	case commonv1.NodeLabelRuleGt, commonv1.NodeLabelRuleGte, commonv1.NodeLabelRuleLt, commonv1.NodeLabelRuleLte:
+		if len(c.Values) != 1 {
+			return false, fmt.Errorf("operator %s on fact %q requires exactly one value", c.Operator, c.Fact)
+		}
+		want, err := resource.ParseQuantity(c.Values[0])
+		if err != nil {
+			return false, fmt.Errorf("invalid value for fact %q: %w", c.Fact, err)
+		}
+		if !ok {
+			return false, nil
+		}
+		have, err := resource.ParseQuantity(value)
+		if err != nil {
+			// A fact which is not a quantity never compares.
+			return false, nil
+		}
+
+		cmp := have.Cmp(want)
+		switch c.Operator {
+		case commonv1.NodeLabelRuleGt:
+			return cmp > 0, nil
+		case commonv1.NodeLabelRuleGte:
+			return cmp >= 0, nil
+		case commonv1.NodeLabelRuleLt:
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+	}
+
+	return false, fmt.Errorf("unknown operator %q", c.Operator)
+}
+
+// nodeLabelRuleLabels returns the labels of the NodeLabelRules which match
+// the facts.  Rules are evaluated by name, and the first rule to set a label
+// wins.
+func (r *ManagedNodeReconciler) nodeLabelRuleLabels(ctx context.Context, facts map[string]string) (map[string]string, error) {
+	var rules commonv1.NodeLabelRuleList
+	if err := r.List(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("failed to list NodeLabelRules: %w", err)
+	}
+
+	sort.Slice(rules.Items, func(i, j int) bool {
+		return rules.Items[i].Name < rules.Items[j].Name
+	})
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	result := make(map[string]string)
+	for i := range rules.Items {
+		rule := &rules.Items[i]
+
+		ok, err := matchNodeLabelRule(rule, facts, exists)
+		if err != nil {
+			r.logger.Warn("skipping invalid NodeLabelRule", "rule", rule.Name, "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		for k, v := range rule.Spec.Labels {
+			if existing, set := result[k]; set {
+				if existing != v {
+					r.logger.Warn("NodeLabelRules disagree on a label, keeping the first", "rule", rule.Name, "label", k)
+				}
+				continue
+			}
+			result[k] = v
+		}
+	}
+
+	return result, nil
+}
+
+// mergeNodeLabels sets the default labels and the labels from NodeLabelRules
+// on the node, and removes the labels previously set by rules which no longer
+// match.  The keys set by rules are recorded in an annotation, so that labels
+// set by hand are never removed.  It reports whether the node changed.
+func mergeNodeLabels(node *commonv1.ManagedNode, defaults, fromRules map[string]string) bool {
+	nodeLabels := node.GetLabels()
+	if nodeLabels == nil {
+		nodeLabels = make(map[string]string)
+	}
+
+	var changed bool
+	set := func(k, v string) {
+		if vv, ok := nodeLabels[k]; !ok || vv != v {
+			nodeLabels[k] = v
+			changed = true
+		}
+	}
+
+	for k, v := range defaults {
+		set(k, v)
+	}
+
+	var previous []string
+	if v := node.GetAnnotations()[common.AnnotationNodeLabelRuleKeys]; v != "" {
+		previous = strings.Split(v, ",")
+	}
+	for _, k := range previous {
+		if _, ok := fromRules[k]; ok {
+			continue
+		}
+		if _, ok := defaults[k]; ok {
+			continue
+		}
+		if _, ok := nodeLabels[k]; ok {
+			delete(nodeLabels, k)
+			changed = true
+		}
+	}
+
+	keys := make([]string, 0, len(fromRules))
+	for k, v := range fromRules {
+		// Rules may not override the default labels.
+		if _, ok := defaults[k]; ok {
+			continue
+		}
+		set(k, v)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	annotations := node.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if joined := strings.Join(keys, ","); annotations[common.AnnotationNodeLabelRuleKeys] != joined {
+		if joined == "" {
+			delete(annotations, common.AnnotationNodeLabelRuleKeys)
+		} else {
+			annotations[common.AnnotationNodeLabelRuleKeys] = joined
+		}
+		node.SetAnnotations(annotations)
+		changed = true
+	}
+
+	node.SetLabels(nodeLabels)
+
+	return changed
+}
+
+// localNodeRequests returns a reconcile request for the ManagedNode of this
+// host, for any change to a NodeLabelRule.
+func (r *ManagedNodeReconciler) localNodeRequests(hostname string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		var nodes commonv1.ManagedNodeList
+		if err := r.List(ctx, &nodes); err != nil {
+			r.logger.Error("failed to list managed nodes for NodeLabelRule watch", "err", err)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, n := range nodes.Items {
+			if n.Name == hostname {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: n.Name, Namespace: n.Namespace},
+				})
+			}
+		}
+		return requests
+	}
+}
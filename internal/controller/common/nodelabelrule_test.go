@@ -0,0 +1,179 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/common"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+func TestMatchNodeLabelRule(t *testing.T) {
+	info := &handler.SysInfo{Name: "node1", Machine: "x86_64"}
+	info.OS.ID = "arch"
+	facts := ruleFacts(info, map[string]string{
+		"virtualization":     "kvm",
+		"memory.total_bytes": "16710103040",
+		"cpu.model":          "AMD EPYC",
+	})
+	exists := func(path string) bool { return path == "/dev/nvidia0" }
+
+	cases := []struct {
+		name       string
+		conditions []commonv1.NodeLabelRuleCondition
+		expected   bool
+		err        bool
+	}{
+		{
+			name:     "no conditions",
+			expected: true,
+		},
+		{
+			name: "sysinfo",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "os.id", Values: []string{"arch", "alpine"}},
+				{Fact: "machine", Operator: commonv1.NodeLabelRuleIn, Values: []string{"x86_64"}},
+			},
+			expected: true,
+		},
+		{
+			name: "all conditions must match",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "os.id", Values: []string{"arch"}},
+				{Fact: "virtualization", Values: []string{"xen"}},
+			},
+		},
+		{
+			name: "not in",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "virtualization", Operator: commonv1.NodeLabelRuleNotIn, Values: []string{"none"}},
+			},
+			expected: true,
+		},
+		{
+			name: "not in missing fact",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "container", Operator: commonv1.NodeLabelRuleNotIn, Values: []string{"docker"}},
+			},
+			expected: true,
+		},
+		{
+			name: "exists",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "cpu.model", Operator: commonv1.NodeLabelRuleExists},
+				{Fact: "dmi.serial", Operator: commonv1.NodeLabelRuleDoesNotExist},
+			},
+			expected: true,
+		},
+		{
+			name: "quantity",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "memory.total_bytes", Operator: commonv1.NodeLabelRuleGte, Values: []string{"8Gi"}},
+				{Fact: "memory.total_bytes", Operator: commonv1.NodeLabelRuleLt, Values: []string{"32Gi"}},
+			},
+			expected: true,
+		},
+		{
+			name: "quantity too small",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "memory.total_bytes", Operator: commonv1.NodeLabelRuleGt, Values: []string{"16Gi"}},
+			},
+		},
+		{
+			name: "quantity of a string fact",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "cpu.model", Operator: commonv1.NodeLabelRuleGt, Values: []string{"1"}},
+			},
+		},
+		{
+			name: "invalid quantity",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{Fact: "memory.total_bytes", Operator: commonv1.NodeLabelRuleGt, Values: []string{"lots"}},
+			},
+			err: true,
+		},
+		{
+			name: "file exists",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{FileExists: "/dev/nvidia0"},
+			},
+			expected: true,
+		},
+		{
+			name: "file does not exist",
+			conditions: []commonv1.NodeLabelRuleCondition{
+				{FileExists: "/dev/nvidia1"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := &commonv1.NodeLabelRule{
+				Spec: commonv1.NodeLabelRuleSpec{
+					Conditions: tc.conditions,
+					Labels:     map[string]string{"a": "b"},
+				},
+			}
+
+			ok, err := matchNodeLabelRule(rule, facts, exists)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, ok)
+		})
+	}
+}
+
+func TestMergeNodeLabels(t *testing.T) {
+	defaults := map[string]string{"kubernetes.io/os": "arch"}
+
+	node := &commonv1.ManagedNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"kubernetes.io/os": "arch",
+				"manual":           "true",
+			},
+		},
+	}
+
+	// Rules may not override the default labels.
+	changed := mergeNodeLabels(node, defaults, map[string]string{
+		"gpu":              "nvidia",
+		"size":             "large",
+		"kubernetes.io/os": "linux",
+	})
+	require.True(t, changed)
+	require.Equal(t, map[string]string{
+		"kubernetes.io/os": "arch",
+		"manual":           "true",
+		"gpu":              "nvidia",
+		"size":             "large",
+	}, node.Labels)
+	require.Equal(t, "gpu,size", node.Annotations[common.AnnotationNodeLabelRuleKeys])
+
+	require.False(t, mergeNodeLabels(node, defaults, map[string]string{"gpu": "nvidia", "size": "large"}))
+
+	// Labels from rules which no longer match are removed, others are kept.
+	changed = mergeNodeLabels(node, defaults, map[string]string{"gpu": "amd"})
+	require.True(t, changed)
+	require.Equal(t, map[string]string{
+		"kubernetes.io/os": "arch",
+		"manual":           "true",
+		"gpu":              "amd",
+	}, node.Labels)
+	require.Equal(t, "gpu", node.Annotations[common.AnnotationNodeLabelRuleKeys])
+
+	changed = mergeNodeLabels(node, defaults, map[string]string{})
+	require.True(t, changed)
+	require.Equal(t, map[string]string{
+		"kubernetes.io/os": "arch",
+		"manual":           "true",
+	}, node.Labels)
+	require.NotContains(t, node.Annotations, common.AnnotationNodeLabelRuleKeys)
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages/dnf"
+)
+
+func TestHandlePackageSetHold(t *testing.T) {
+	ctx := context.Background()
+
+	pkgHandler := &mockPackageHandler{
+		packageList: map[string]string{"nginx": "1.24.0", "linux": "6.9.1", "openssl": "3.1.4", "telnet": "0.17"},
+		held:        []string{"openssl", "telnet"},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{packageHandler: pkgHandler})
+
+	// openssl was held by hand and stays held; telnet was held by this
+	// ConfigSet and is released before its removal.  Holds are placed once
+	// the transactions are done.
+	holds, err := r.handlePackageSet(ctx, "test-node", []commonv1.Package{
+		{Name: "nginx", Ensure: "installed", Hold: true},
+		{Name: "linux", Ensure: "installed", Hold: true},
+		{Name: "openssl", Ensure: "installed"},
+		{Name: "telnet", Ensure: "absent"},
+	}, []string{"telnet"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux", "nginx"}, holds)
+	require.Equal(t, []string{"unhold telnet", "remove telnet", "hold nginx", "hold linux"}, pkgHandler.calls)
+	require.ElementsMatch(t, []string{"openssl", "nginx", "linux"}, pkgHandler.held)
+
+	// Changing the version of a held package releases the hold for the
+	// install.  Dropping linux from the ConfigSet releases its hold.
+	pkgHandler.calls = nil
+	holds, err = r.handlePackageSet(ctx, "test-node", []commonv1.Package{
+		{Name: "nginx", Ensure: "installed", Version: "1.26.0", Hold: true},
+	}, holds, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx"}, holds)
+	require.Equal(t, []string{"unhold nginx", "install nginx", "hold nginx", "unhold linux"}, pkgHandler.calls)
+
+	// Removing the hold releases it.
+	pkgHandler.calls = nil
+	pkgHandler.packageList["nginx"] = "1.26.0"
+	holds, err = r.handlePackageSet(ctx, "test-node", []commonv1.Package{
+		{Name: "nginx", Ensure: "installed", Version: "1.26.0"},
+	}, holds, nil)
+	require.NoError(t, err)
+	require.NotNil(t, holds)
+	require.Empty(t, holds)
+	require.Equal(t, []string{"unhold nginx"}, pkgHandler.calls)
+	require.Equal(t, []string{"openssl"}, pkgHandler.held)
+}
+
+func TestHandlePackageSetVersionWithoutRelease(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+
+	// A version without the release matches the installed RPM, so the held
+	// package is neither unlocked nor installed again.
+	exec := &handler.MockExecHandler{Output: []string{
+		"nginx 1.20.1-14.el9_2.1\n",
+		"nginx-1:1.20.1-14.el9_2.1.*\n",
+	}}
+	r := newPlanTestReconciler(&mockSystemHandler{packageHandler: dnf.New(logger, exec)})
+
+	holds, err := r.handlePackageSet(ctx, "test-node", []commonv1.Package{
+		{Name: "nginx", Ensure: "installed", Version: "1.20.1", Hold: true},
+	}, []string{"nginx"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx"}, holds)
+	require.Len(t, exec.Recorder["/usr/bin/dnf"], 1, "only the versionlocks are listed")
+	require.Equal(t, "versionlock", exec.Recorder["/usr/bin/dnf"][0][0])
+}
+
+func TestPlanPackageSetHold(t *testing.T) {
+	pkgHandler := &mockPackageHandler{
+		packageList: map[string]string{"nginx": "1.24.0", "linux": "6.9.1"},
+		held:        []string{"linux"},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{packageHandler: pkgHandler})
+	p := &planner{}
+
+	holds, err := r.handlePackageSet(context.Background(), "test-node", []commonv1.Package{
+		{Name: "nginx", Ensure: "installed", Hold: true},
+		{Name: "linux", Ensure: "installed"},
+	}, []string{"linux"}, p)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux"}, holds)
+	require.Empty(t, pkgHandler.calls)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "nginx", Action: "hold"},
+		{Name: "linux", Action: "unhold"},
+	}, p.plan.Packages)
+}
+
+func TestHandleRepositorySet(t *testing.T) {
+	ctx := context.Background()
+
+	pkgHandler := &mockPackageHandler{}
+	r := newPlanTestReconciler(&mockSystemHandler{packageHandler: pkgHandler})
+
+	disabled := false
+	repos := []commonv1.Repository{
+		{Name: "example", URL: "https://pkg.example.com"},
+		{Name: "testing", URL: "https://testing.example.com", Enabled: &disabled},
+	}
+
+	// The metadata is refreshed once, after every repository is configured.
+	require.NoError(t, r.handleRepositorySet(ctx, "test-node", repos, nil))
+	require.Equal(t, []string{"repository example", "repository testing", "refresh"}, pkgHandler.calls)
+	require.False(t, pkgHandler.repositories["testing"].Enabled)
+
+	// Nothing changed, so nothing is refreshed.
+	pkgHandler.calls = nil
+	require.NoError(t, r.handleRepositorySet(ctx, "test-node", repos, nil))
+	require.Empty(t, pkgHandler.calls)
+
+	// Plan mode records the repositories which would change.
+	repos[0].URL = "https://mirror.example.com"
+	p := &planner{}
+	require.NoError(t, r.handleRepositorySet(ctx, "test-node", repos, p))
+	require.Empty(t, pkgHandler.calls)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "example", Action: "configure", Detail: "https://mirror.example.com"},
+	}, p.plan.Repositories)
+}
+
+func TestHandlePackageSetBatch(t *testing.T) {
+	ctx := context.Background()
+
+	pkgHandler := &mockPackageHandler{
+		packageList: map[string]string{"vim": "9.1", "curl": "8.8.0", "telnet": "0.17", "nano": "8.0"},
+		available:   map[string]string{"curl": "8.9.1", "vim": "9.1"},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{packageHandler: pkgHandler})
+
+	packageSet := []commonv1.Package{
+		{Name: "git", Ensure: "installed"},
+		{Name: "curl", Ensure: "latest"},
+		{Name: "vim", Ensure: "latest"},
+		{Name: "htop", Ensure: "installed", Hold: true},
+		{Name: "telnet", Ensure: "absent"},
+		{Name: "nano", Ensure: "absent"},
+	}
+
+	// Plan mode reports upgrades of the packages following the latest
+	// version.
+	p := &planner{}
+	_, err := r.handlePackageSet(ctx, "test-node", packageSet, nil, p)
+	require.NoError(t, err)
+	require.Empty(t, pkgHandler.calls)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "git", Action: "install"},
+		{Name: "curl", Action: "upgrade", Detail: "8.9.1"},
+		{Name: "htop", Action: "install"},
+		{Name: "telnet", Action: "remove"},
+		{Name: "nano", Action: "remove"},
+		{Name: "htop", Action: "hold"},
+	}, p.plan.Packages)
+
+	// One transaction installs, and one removes.
+	_, err = r.handlePackageSet(ctx, "test-node", packageSet, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"install git curl htop", "remove telnet nano", "hold htop"}, pkgHandler.calls)
+
+	// A failed transaction places the hold of an installed package again,
+	// which keeps its previous version, and holds nothing which was never
+	// installed.
+	pkgHandler.calls = nil
+	pkgHandler.held = []string{"vim"}
+	pkgHandler.installErr = errors.New("conflicting files")
+	holds, err := r.handlePackageSet(ctx, "test-node", []commonv1.Package{
+		{Name: "vim", Ensure: "installed", Version: "9.2", Hold: true},
+		{Name: "tmux", Ensure: "installed", Hold: true},
+	}, []string{"vim"}, nil)
+	require.ErrorContains(t, err, "conflicting files")
+	require.Equal(t, []string{"vim"}, holds)
+	require.Equal(t, []string{"unhold vim", "install vim tmux", "hold vim"}, pkgHandler.calls)
+	require.Equal(t, []string{"vim"}, pkgHandler.held)
+}
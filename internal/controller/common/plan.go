@@ -0,0 +1,156 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/common"
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+// maxPlanDiffBytes bounds the diff recorded for a single file so that one
+// large change cannot push the ManagedNode status past the API object size
+// limit.
+const maxPlanDiffBytes = 4096
+
+// planAnnotated reports whether cs is annotated to be planned rather than
+// applied: the annotation is set to a value other than an empty one or a
+// false boolean such as "false" or "0".
+func planAnnotated(cs *commonv1.ConfigSet) bool {
+	v := cs.Annotations[common.AnnotationConfigSetPlan]
+	if v == "" {
+		return false
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		return b
+	}
+	return true
+}
+
+// planner records the changes a ConfigSet would make instead of making them.
+// The handle* functions receive a nil *planner when changes should be applied.
+type planner struct {
+	plan commonv1.ConfigSetPlan
+}
+
+func (p *planner) addRepository(name, action, detail string) {
+	p.plan.Repositories = append(p.plan.Repositories, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addPackage(name, action, detail string) {
+	p.plan.Packages = append(p.plan.Packages, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addFile(name, action, detail string) {
+	p.plan.Files = append(p.plan.Files, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addService(name, action, detail string) {
+	p.plan.Services = append(p.plan.Services, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addUnit(name, action, detail string) {
+	p.plan.Units = append(p.plan.Units, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addExec(name, action, detail string) {
+	p.plan.Executions = append(p.plan.Executions, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addGroup(name, action, detail string) {
+	p.plan.Groups = append(p.plan.Groups, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+func (p *planner) addUser(name, action, detail string) {
+	p.plan.Users = append(p.plan.Users, commonv1.PlannedChange{Name: name, Action: action, Detail: detail})
+}
+
+// planFileContent is the plan mode counterpart of writeFileContent.  It
+// records the write, chown and chmod that would be performed for file and
+// reports whether the file would change.  Ownership is only compared when
+// the File names an owner or group, since the OS default is applied by the
+// FileHandler itself.
+func (r *ConfigSetReconciler) planFileContent(ctx context.Context, file commonv1.File, p *planner) (bool, error) {
+	current, err := os.ReadFile(file.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read file %q: %w", file.Path, err)
+	}
+	exists := err == nil
+
+	if !exists || string(current) != file.Content {
+		detail := "content differs (diff suppressed: file references secrets)"
+		if len(file.SecretRefs) == 0 {
+			detail = unifiedDiff(file.Path, string(current), file.Content)
+		}
+		p.addFile(file.Path, "write", detail)
+		return true, nil
+	}
+
+	changed := false
+
+	if file.Owner != "" || file.Group != "" {
+		differs, ownerErr := files.OwnerDiffers(file.Path, file.Owner, file.Group)
+		if ownerErr != nil {
+			return false, fmt.Errorf("failed to compare file owner: %w", ownerErr)
+		}
+		if differs {
+			p.addFile(file.Path, "chown", file.Owner+":"+file.Group)
+			changed = true
+		}
+	}
+
+	if file.Mode != "" {
+		differs, modeErr := files.ModeDiffers(ctx, file.Path, file.Mode)
+		if modeErr != nil {
+			return false, fmt.Errorf("failed to compare file mode: %w", modeErr)
+		}
+		if differs {
+			p.addFile(file.Path, "chmod", file.Mode)
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// unifiedDiff returns a unified diff from current to desired, truncated to
+// maxPlanDiffBytes.
+func unifiedDiff(path, current, desired string) string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(desired),
+		FromFile: path,
+		ToFile:   path + " (desired)",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("failed to compute diff: %s", err)
+	}
+
+	if len(diff) > maxPlanDiffBytes {
+		diff = truncateDiff(diff, maxPlanDiffBytes) + "... (diff truncated)\n"
+	}
+
+	return diff
+}
+
+// truncateDiff cuts diff to at most n bytes after the last whole line.  A
+// first line longer than n is cut at a rune boundary instead, so that the
+// result remains valid UTF-8.
+func truncateDiff(diff string, n int) string {
+	if i := strings.LastIndexByte(diff[:n], '\n'); i >= 0 {
+		return diff[:i+1]
+	}
+
+	for n > 0 && !utf8.RuneStart(diff[n]) {
+		n--
+	}
+	return diff[:n] + "\n"
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/common"
+)
+
+func newPlanTestReconciler(sys *mockSystemHandler) *ConfigSetReconciler {
+	return &ConfigSetReconciler{
+		tracer: noop.NewTracerProvider().Tracer("test"),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})),
+		system: sys,
+	}
+}
+
+func TestPlanPackageSet(t *testing.T) {
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+	p := &planner{}
+
+	_, err := r.handlePackageSet(context.Background(), "test-node", []commonv1.Package{
+		{Name: "pkg1", Ensure: "installed"},
+		{Name: "nginx", Ensure: "installed", Version: "1.24.0"},
+		{Name: "pkg2", Ensure: "absent"},
+		{Name: "missing", Ensure: "absent"},
+	}, nil, p)
+	require.NoError(t, err)
+
+	require.Empty(t, sys.Package().(*mockPackageHandler).installCalls)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "nginx", Action: "install", Detail: "1.24.0"},
+		{Name: "pkg2", Action: "remove"},
+	}, p.plan.Packages)
+}
+
+func TestPlanFileSet(t *testing.T) {
+	dir := t.TempDir()
+	changedPath := filepath.Join(dir, "changed.conf")
+	samePath := filepath.Join(dir, "same.conf")
+	newPath := filepath.Join(dir, "new.conf")
+	secretPath := filepath.Join(dir, "secret.conf")
+	absentPath := filepath.Join(dir, "absent.conf")
+
+	require.NoError(t, os.WriteFile(changedPath, []byte("a\nb\n"), 0o644))
+	require.NoError(t, os.WriteFile(samePath, []byte("same\n"), 0o644))
+	require.NoError(t, os.Chmod(samePath, 0o644))
+	require.NoError(t, os.WriteFile(secretPath, []byte("old"), 0o600))
+	require.NoError(t, os.WriteFile(absentPath, []byte("x"), 0o644))
+
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+	p := &planner{}
+
+	changed, backups, err := r.handleFileSet(context.Background(), "test-node", "cs", "default", []commonv1.File{
+		{Path: changedPath, Ensure: "file", Content: "a\nc\n"},
+		{Path: samePath, Ensure: "file", Content: "same\n", Mode: "0600"},
+		{Path: newPath, Ensure: "file", Content: "new\n"},
+		{Path: secretPath, Ensure: "file", Content: "new", SecretRefs: []string{"creds"}},
+		{Path: absentPath, Ensure: "absent"},
+		{Path: filepath.Join(dir, "never-existed"), Ensure: "absent"},
+	}, commonv1.ManagedNode{}, p)
+	require.NoError(t, err)
+	require.Empty(t, backups)
+	require.Equal(t, []string{changedPath, samePath, newPath, secretPath, absentPath}, changed)
+
+	require.Len(t, p.plan.Files, 5)
+	require.Equal(t, "write", p.plan.Files[0].Action)
+	require.Contains(t, p.plan.Files[0].Detail, "-b\n+c\n")
+	require.Equal(t, commonv1.PlannedChange{Name: samePath, Action: "chmod", Detail: "0600"}, p.plan.Files[1])
+	require.Equal(t, "write", p.plan.Files[2].Action)
+	require.Contains(t, p.plan.Files[2].Detail, "+new\n")
+	require.NotContains(t, p.plan.Files[3].Detail, "new", "diffs for files referencing secrets must be suppressed")
+	require.Equal(t, commonv1.PlannedChange{Name: absentPath, Action: "remove"}, p.plan.Files[4])
+
+	// Nothing on disk may have changed.
+	require.Empty(t, sys.File().(*mockFileHandler).fileWriteCalls)
+	content, err := os.ReadFile(changedPath)
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\n", string(content))
+	info, err := os.Stat(samePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	_, err = os.Stat(newPath)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(absentPath)
+	require.NoError(t, err)
+}
+
+func TestPlanServiceSetAndExecutions(t *testing.T) {
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+	p := &planner{}
+	ctx := context.Background()
+
+	svcs := []commonv1.Service{
+		{Name: "chronyd", Enable: true, Ensure: "running", Arguments: "-d", SusbscribeFiles: []string{"/etc/chrony.conf"}},
+	}
+	_, _, _, err := r.handleServiceSet(ctx, "test-node", "default", svcs, nil, []string{"/etc/chrony.conf"}, nil, nil, nil, p)
+	require.NoError(t, err)
+
+	_, err = r.handleExecutions(ctx, []commonv1.Exec{
+		{Command: "/usr/bin/newaliases", Args: []string{"-v"}, SusbscribeFiles: []string{"/etc/chrony.conf"}},
+		{Name: "reload-chrony", Command: "/usr/bin/chronyc", Args: []string{"reload", "sources"}, SusbscribeFiles: []string{"/etc/chrony.conf"}},
+	}, []string{"/etc/chrony.conf"}, nil, p)
+	require.NoError(t, err)
+
+	svcMock := sys.Service().(*mockServiceHandler)
+	require.Empty(t, svcMock.enableCalls)
+	require.Empty(t, svcMock.setArgsCalls)
+	require.Empty(t, svcMock.startCalls)
+	require.Empty(t, svcMock.restartCalls)
+	require.Empty(t, sys.Exec().(*mockExecHandler).execCalls)
+
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "chronyd", Action: "enable", Detail: "disabled"},
+		{Name: "chronyd", Action: "set arguments", Detail: "-d"},
+		{Name: "chronyd", Action: "start", Detail: "stopped"},
+		{Name: "chronyd", Action: "restart", Detail: "subscribed file changed"},
+	}, p.plan.Services)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "/usr/bin/newaliases -v", Action: "run", Detail: "-v"},
+		{Name: "reload-chrony", Action: "run", Detail: "reload sources"},
+	}, p.plan.Executions)
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := "--- a\n+++ b\n+" + strings.Repeat("é", 10) + "\n"
+
+	require.Equal(t, "--- a\n+++ b\n", truncateDiff(diff, 20))
+
+	// A single long line is cut between runes.
+	line := strings.Repeat("é", 10)
+	for n := 1; n < len(line); n++ {
+		out := truncateDiff(line, n)
+		require.True(t, utf8.ValidString(out), "cut at %d", n)
+		require.LessOrEqual(t, len(out), n+1)
+	}
+
+	out := unifiedDiff("/etc/motd", "", strings.Repeat("ünïcode line\n", 1000))
+	require.True(t, utf8.ValidString(out))
+	require.True(t, strings.HasSuffix(out, "line\n... (diff truncated)\n"))
+}
+
+func TestPlanAnnotated(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":      false,
+		"false": false,
+		"0":     false,
+		"true":  true,
+		"1":     true,
+		"yes":   true,
+	} {
+		cs := &commonv1.ConfigSet{}
+		cs.Annotations = map[string]string{common.AnnotationConfigSetPlan: value}
+		require.Equal(t, want, planAnnotated(cs), "value %q", value)
+	}
+
+	require.False(t, planAnnotated(&commonv1.ConfigSet{}))
+}
@@ -0,0 +1,223 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+const (
+	conditionDegraded = "Degraded"
+
+	reasonRolledBack = "RolledBack"
+	reasonApplied    = "Applied"
+
+	// validatePlaceholder is replaced with the staged file in File.Validate.
+	validatePlaceholder = "%s"
+)
+
+// errLockHeld is wrapped by the error of a restart whose lock group lease
+// could not be acquired, usually because another node holds it.  The restart
+// did not run, so the files it follows are not rolled back.
+var errLockHeld = errors.New("failed to acquire lock")
+
+// pendingRestart is a restart deferred by errLockHeld, with the changed files
+// and their filebucket backups which caused it.
+type pendingRestart struct {
+	changedFiles []string
+	backups      map[string]string
+}
+
+func pendingRestartKey(namespace string, svc commonv1.Service) string {
+	return namespace + "/" + svc.User + "/" + svc.Name
+}
+
+// deferRestart records a restart of svc to retry on the next reconcile.  The
+// changed files it subscribes to are added to any restart already pending.
+func (r *ConfigSetReconciler) deferRestart(namespace string, svc commonv1.Service, changedFiles []string, backups map[string]string) {
+	key := pendingRestartKey(namespace, svc)
+
+	r.pendingRestartsMu.Lock()
+	defer r.pendingRestartsMu.Unlock()
+	if r.pendingRestarts == nil {
+		r.pendingRestarts = make(map[string]pendingRestart)
+	}
+
+	pending := r.pendingRestarts[key]
+	if pending.backups == nil {
+		pending.backups = make(map[string]string)
+	}
+	for _, cf := range changedFiles {
+		if !slices.Contains(svc.SusbscribeFiles, cf) {
+			continue
+		}
+		if !slices.Contains(pending.changedFiles, cf) {
+			pending.changedFiles = append(pending.changedFiles, cf)
+		}
+		if hash, ok := backups[cf]; ok {
+			if _, seen := pending.backups[cf]; !seen {
+				pending.backups[cf] = hash
+			}
+		}
+	}
+	r.pendingRestarts[key] = pending
+}
+
+// pendingRestart returns the restart of svc deferred on a previous reconcile.
+func (r *ConfigSetReconciler) pendingRestart(namespace string, svc commonv1.Service) (pendingRestart, bool) {
+	r.pendingRestartsMu.Lock()
+	defer r.pendingRestartsMu.Unlock()
+	pending, ok := r.pendingRestarts[pendingRestartKey(namespace, svc)]
+	return pending, ok
+}
+
+// clearPendingRestart forgets the deferred restart of svc once it has run.
+func (r *ConfigSetReconciler) clearPendingRestart(namespace string, svc commonv1.Service) {
+	r.pendingRestartsMu.Lock()
+	defer r.pendingRestartsMu.Unlock()
+	delete(r.pendingRestarts, pendingRestartKey(namespace, svc))
+}
+
+// validateContent stages the new content of file and runs the File's
+// validate command against the staged copy.
+func (r *ConfigSetReconciler) validateContent(ctx context.Context, file commonv1.File) error {
+	if !strings.Contains(file.Validate, validatePlaceholder) {
+		return fmt.Errorf("validate command for %q must contain %s", file.Path, validatePlaceholder)
+	}
+
+	// Stage in a directory of its own beside the directory of the file, so
+	// that the copy stays on the same filesystem but is never matched by an
+	// include glob, such as conf.d/*, of the config being validated.  The
+	// base name is kept for validators which care about the extension.
+	stageDir, err := os.MkdirTemp(filepath.Dir(filepath.Dir(file.Path)), ".nodemanager-validate-*")
+	if err != nil {
+		stageDir, err = os.MkdirTemp("", "nodemanager-validate-*")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stage %q for validation: %w", file.Path, err)
+	}
+	defer func() { _ = os.RemoveAll(stageDir) }()
+
+	staged, err := os.OpenFile(filepath.Join(stageDir, filepath.Base(file.Path)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to stage %q for validation: %w", file.Path, err)
+	}
+
+	_, err = staged.WriteString(file.Content)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stage %q for validation: %w", file.Path, err)
+	}
+
+	if file.Mode != "" {
+		mode, modeErr := files.GetFileModeFromString(ctx, file.Mode)
+		if modeErr == nil {
+			_ = os.Chmod(staged.Name(), mode)
+		}
+	}
+
+	command := strings.ReplaceAll(file.Validate, validatePlaceholder, shellQuote(staged.Name()))
+	output, code, err := r.system.Exec().RunCommand(ctx, execShell, "-c", command)
+	if err != nil || code != 0 {
+		return fmt.Errorf("validation of %q failed with exit code %d, file not written: %s", file.Path, code, strings.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// rollbackFiles restores the previous content of the files which svc
+// subscribes to, which changed on this reconcile and which opted in to
+// rollback.  backups maps each changed path to the filebucket hash of its
+// previous content.  The restored paths are returned.
+func (r *ConfigSetReconciler) rollbackFiles(ctx context.Context, svc commonv1.Service, fileSet []commonv1.File, changedFiles []string, backups map[string]string) ([]string, error) {
+	handler := r.system.File()
+
+	var (
+		restored []string
+		errs     []error
+	)
+
+	// A file restored by hand since it changed is left as it is.
+	held, err := files.FileBucketHolds(r.cfg.FileBucket.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fileSet {
+		if !f.Rollback || !slices.Contains(svc.SusbscribeFiles, f.Path) || !slices.Contains(changedFiles, f.Path) {
+			continue
+		}
+		if r.fileHeld(held, f.Path) {
+			continue
+		}
+
+		hash, ok := backups[f.Path]
+		if !ok {
+			errs = append(errs, fmt.Errorf("cannot roll back %q: no previous content in the filebucket", f.Path))
+			continue
+		}
+
+		previous, err := os.ReadFile(files.FileBucketBlobPath(r.cfg.FileBucket.Path, hash))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot roll back %q: %w", f.Path, err))
+			continue
+		}
+
+		r.logger.Warn("rolling back file after failed service restart", "path", f.Path, "service", svc.Name, "hash", hash)
+		if _, err := handler.WriteContentFile(ctx, f.Path, previous); err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back %q: %w", f.Path, err))
+			continue
+		}
+		restored = append(restored, f.Path)
+	}
+
+	return restored, errors.Join(errs...)
+}
+
+// rolledBackOnNode reports whether node records a rollback of the current
+// generation of cs.  The generation is compared rather than the
+// resourceVersion, which the Degraded condition written after the rollback
+// changes.
+func rolledBackOnNode(node commonv1.ManagedNode, cs *commonv1.ConfigSet) bool {
+	for _, s := range node.Status.ConfigSets {
+		if s.Name == cs.Name {
+			return len(s.RolledBack) > 0 && s.Generation == cs.Generation
+		}
+	}
+	return false
+}
+
+// updateDegradedCondition sets the Degraded condition on the ConfigSet after
+// files were rolled back on nodeName.  An empty rolledBack clears it.
+func (r *ConfigSetReconciler) updateDegradedCondition(ctx context.Context, req reconcile.Request, nodeName string, rolledBack []string) error {
+	condition := metav1.Condition{
+		Type:    conditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonRolledBack,
+		Message: fmt.Sprintf("rolled back on node %s after a failed service restart: %s", nodeName, strings.Join(rolledBack, ", ")),
+	}
+	if len(rolledBack) == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonApplied
+		condition.Message = ""
+	}
+
+	return r.setConfigSetCondition(ctx, req, condition)
+}
+
+// shellQuote quotes s for use as a single word in a /bin/sh command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/trace/noop"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/locker"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+var _ = Describe("Rollback reconcile integration", func() {
+	const csName = "rollback-cs"
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: csName, Namespace: "default"}}
+
+	var (
+		bucketDir string
+		fileDir   string
+		path      string
+	)
+
+	BeforeEach(func() {
+		ensureLocalNodeLabel(ctx, "nodemanager.test/enabled", "true")
+
+		var err error
+		bucketDir, err = os.MkdirTemp("", "rollback-bucket-*")
+		Expect(err).NotTo(HaveOccurred())
+		fileDir, err = os.MkdirTemp("", "rollback-files-*")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(fileDir, "nginx.conf")
+		Expect(os.WriteFile(path, []byte("good config\n"), 0o644)).To(Succeed())
+
+		cs := &commonv1.ConfigSet{}
+		err = k8sClient.Get(ctx, req.NamespacedName, cs)
+		if err != nil && k8serrors.IsNotFound(err) {
+			Expect(k8sClient.Create(ctx, &commonv1.ConfigSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      csName,
+					Namespace: "default",
+					Labels:    map[string]string{"nodemanager.test/enabled": "true"},
+				},
+				Spec: commonv1.ConfigSetSpec{
+					Files: []commonv1.File{
+						{Path: path, Ensure: "file", Content: "bad config\n", Rollback: true},
+					},
+					Services: []commonv1.Service{
+						{Name: "nginx", Ensure: "running", SusbscribeFiles: []string{path}},
+					},
+				},
+			})).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(bucketDir)).To(Succeed())
+		Expect(os.RemoveAll(fileDir)).To(Succeed())
+
+		cs := &commonv1.ConfigSet{}
+		if err := k8sClient.Get(ctx, req.NamespacedName, cs); err == nil {
+			Expect(k8sClient.Delete(ctx, cs)).To(Succeed())
+		}
+	})
+
+	It("does not apply a rolled back ConfigSet again after its status is written", func() {
+		svcHandler := &mockServiceHandler{
+			serviceStatus: map[string]services.ServiceStatus{"nginx": services.Running},
+			restartErrs:   map[string][]error{"nginx": {errors.New("exit status 1")}},
+		}
+		sys := &mockSystemHandler{serviceHandler: svcHandler}
+		r := &ConfigSetReconciler{
+			Client: k8sClient,
+			Scheme: k8sClient.Scheme(),
+			tracer: noop.NewTracerProvider().Tracer("test"),
+			logger: logger,
+			system: sys,
+			locker: locker.NewLeaseLocker(ctx, logger, locker.Config{}, clientset, "default", hostname),
+			cfg: ConfigSetConfig{
+				Namespace:  "default",
+				FileBucket: FileBucketConfig{Enabled: true, Path: bucketDir, MaxFileSizeBytes: 102400},
+			},
+		}
+
+		_, err := r.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svcHandler.restartCalls["nginx"]).To(Equal(2))
+		writes := sys.File().(*mockFileHandler).fileWriteCalls[path]
+
+		By("verifying the rollback wrote the Degraded condition, which changed the resourceVersion")
+		cs := &commonv1.ConfigSet{}
+		Expect(k8sClient.Get(ctx, req.NamespacedName, cs)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(cs.Status.Conditions, conditionDegraded)).To(BeTrue())
+
+		osHostname, _ := os.Hostname()
+		mn := &commonv1.ManagedNode{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: osHostname, Namespace: "default"}, mn)).To(Succeed())
+		var entry commonv1.ConfigSetApplyStatus
+		for _, s := range mn.Status.ConfigSets {
+			if s.Name == csName {
+				entry = s
+			}
+		}
+		Expect(entry.RolledBack).To(Equal([]string{path}))
+		Expect(entry.Generation).To(Equal(cs.Generation))
+		Expect(entry.ResourceVersion).NotTo(Equal(cs.ResourceVersion))
+
+		By("verifying the next reconcile does not apply the ConfigSet again")
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svcHandler.restartCalls["nginx"]).To(Equal(2))
+		Expect(sys.File().(*mockFileHandler).fileWriteCalls[path]).To(Equal(writes))
+	})
+})
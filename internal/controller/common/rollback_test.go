@@ -0,0 +1,230 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/locker"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+func TestWriteFileContentValidate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sshd_config")
+	require.NoError(t, os.WriteFile(path, []byte("Port 22\n"), 0o644))
+
+	sys := &mockSystemHandler{execHandler: &execs.ExecHandlerCommon{}}
+	r := newPlanTestReconciler(sys)
+
+	// The staged copy holds the new content, not the file on disk.
+	file := commonv1.File{Path: path, Content: "Port 2222\n", Validate: "grep -q 2222 %s"}
+	changed, _, err := r.writeFileContent(context.Background(), file, sys.File())
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	file.Content = "Port\n"
+	_, _, err = r.writeFileContent(context.Background(), file, sys.File())
+	require.ErrorContains(t, err, "file not written")
+	require.Equal(t, 1, sys.File().(*mockFileHandler).fileWriteCalls[path])
+
+	file.Validate = "grep -q 2222"
+	_, _, err = r.writeFileContent(context.Background(), file, sys.File())
+	require.ErrorContains(t, err, "must contain %s")
+
+	// The staged copy is removed.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestValidateContentStaging(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	path := filepath.Join(confDir, "site.conf")
+	require.NoError(t, os.MkdirAll(confDir, 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("listen 80;\n"), 0o644))
+
+	sys := &mockSystemHandler{execHandler: &execs.ExecHandlerCommon{}}
+	r := newPlanTestReconciler(sys)
+
+	// While the validator runs, an include glob of conf.d matches only the
+	// file itself, and the staged copy keeps the base name of the file.
+	file := commonv1.File{
+		Path:     path,
+		Content:  "listen 8080;\n",
+		Validate: `test "$(ls -A ` + confDir + `)" = site.conf && test "$(basename %s)" = site.conf`,
+	}
+	require.NoError(t, r.validateContent(context.Background(), file))
+
+	// The staging directory is removed.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestHandleServiceSetRollback(t *testing.T) {
+	dir := t.TempDir()
+	bucket := filepath.Join(dir, "bucket")
+	path := filepath.Join(dir, "nginx.conf")
+	other := filepath.Join(dir, "other.conf")
+
+	previous := []byte("good config\n")
+	require.NoError(t, os.WriteFile(path, previous, 0o644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	hash, err := files.SaveToFileBucket(bucket, path, previous, info)
+	require.NoError(t, err)
+
+	svcHandler := &mockServiceHandler{
+		serviceStatus: map[string]services.ServiceStatus{"nginx": services.Running},
+		restartErrs:   map[string][]error{"nginx": {errors.New("exit status 1")}},
+	}
+	sys := &mockSystemHandler{serviceHandler: svcHandler}
+	r := newPlanTestReconciler(sys)
+	r.cfg.FileBucket = FileBucketConfig{Enabled: true, Path: bucket}
+
+	fileSet := []commonv1.File{
+		{Path: path, Content: "bad config\n", Rollback: true},
+		{Path: other, Content: "x", Rollback: true},
+	}
+	svcs := []commonv1.Service{
+		{Name: "nginx", Ensure: "running", Enable: true, SusbscribeFiles: []string{path}},
+	}
+
+	rolledBack, _, _, err := r.handleServiceSet(context.Background(), "test-node", "default", svcs, fileSet, []string{path, other}, map[string]string{path: hash}, nil, nil, nil)
+	require.ErrorContains(t, err, `failed to restart service "nginx"`)
+	require.Equal(t, []string{path}, rolledBack)
+	require.Equal(t, 2, svcHandler.restartCalls["nginx"], "the restart is retried after the rollback")
+	require.Equal(t, 1, sys.File().(*mockFileHandler).fileWriteCalls[path])
+	require.NotContains(t, sys.File().(*mockFileHandler).fileWriteCalls, other)
+
+	// Without a backup there is nothing to roll back to.
+	svcHandler.restartErrs = map[string][]error{"nginx": {errors.New("exit status 1")}}
+	rolledBack, _, _, err = r.handleServiceSet(context.Background(), "test-node", "default", svcs, fileSet, []string{path}, nil, nil, nil, nil)
+	require.ErrorContains(t, err, "no previous content in the filebucket")
+	require.Empty(t, rolledBack)
+}
+
+func TestHandleServiceSetLockHeld(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bucket := filepath.Join(dir, "bucket")
+	path := filepath.Join(dir, "nginx.conf")
+
+	previous := []byte("good config\n")
+	require.NoError(t, os.WriteFile(path, previous, 0o644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	hash, err := files.SaveToFileBucket(bucket, path, previous, info)
+	require.NoError(t, err)
+
+	svcHandler := &mockServiceHandler{serviceStatus: map[string]services.ServiceStatus{"nginx": services.Running}}
+	sys := &mockSystemHandler{serviceHandler: svcHandler}
+	r := newPlanTestReconciler(sys)
+	r.cfg.FileBucket = FileBucketConfig{Enabled: true, Path: bucket}
+
+	clientset := fake.NewSimpleClientset()
+	cfg := locker.Config{LeaseDuration: time.Minute}
+	r.locker = locker.NewLeaseLocker(ctx, r.logger, cfg, clientset, "default", "test-node")
+	other := locker.NewLeaseLocker(ctx, r.logger, cfg, clientset, "default", "other-node")
+	lease := types.NamespacedName{Namespace: "default", Name: "web"}
+	require.NoError(t, other.Lock(ctx, lease))
+
+	fileSet := []commonv1.File{{Path: path, Content: "new config\n", Rollback: true}}
+	svcs := []commonv1.Service{
+		{Name: "nginx", Ensure: "running", SusbscribeFiles: []string{path}, LockGroup: "web"},
+	}
+
+	// The lease is held by another node, so the restart is deferred and the
+	// changed file is left in place.
+	rolledBack, _, _, err := r.handleServiceSet(ctx, "test-node", "default", svcs, fileSet, []string{path}, map[string]string{path: hash}, nil, nil, nil)
+	require.ErrorIs(t, err, errLockHeld)
+	require.Empty(t, rolledBack)
+	require.Zero(t, svcHandler.restartCalls["nginx"])
+	require.NotContains(t, sys.File().(*mockFileHandler).fileWriteCalls, path)
+
+	// Once the lease is released, the next reconcile restarts the service
+	// although the file no longer changes, and may still roll it back.
+	require.NoError(t, other.Unlock(ctx, lease))
+	svcHandler.restartErrs = map[string][]error{"nginx": {errors.New("exit status 1")}}
+	rolledBack, _, _, err = r.handleServiceSet(ctx, "test-node", "default", svcs, fileSet, nil, nil, nil, nil, nil)
+	require.ErrorContains(t, err, `failed to restart service "nginx"`)
+	require.Equal(t, []string{path}, rolledBack)
+	require.Equal(t, 2, svcHandler.restartCalls["nginx"])
+
+	// The restart ran, so it is not retried again.
+	_, _, _, err = r.handleServiceSet(ctx, "test-node", "default", svcs, fileSet, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, svcHandler.restartCalls["nginx"])
+}
+
+func TestRolledBackOnNode(t *testing.T) {
+	cs := &commonv1.ConfigSet{}
+	cs.Name = "web"
+	cs.Generation = 2
+	cs.ResourceVersion = "20"
+
+	node := commonv1.ManagedNode{}
+	require.False(t, rolledBackOnNode(node, cs))
+
+	node.Status.ConfigSets = []commonv1.ConfigSetApplyStatus{{Name: "web", Generation: 1, ResourceVersion: "10", RolledBack: []string{"/etc/nginx.conf"}}}
+	require.False(t, rolledBackOnNode(node, cs), "a new generation is applied again")
+
+	node.Status.ConfigSets[0].Generation = 2
+	node.Status.ConfigSets[0].ResourceVersion = "20"
+	require.True(t, rolledBackOnNode(node, cs))
+
+	// The Degraded condition written after the rollback changes the
+	// resourceVersion but not the generation.
+	cs.ResourceVersion = "21"
+	require.True(t, rolledBackOnNode(node, cs), "a status write does not apply it again")
+}
+
+func TestHandleFileSetHeld(t *testing.T) {
+	dir := t.TempDir()
+	bucket := filepath.Join(dir, "bucket")
+	held := filepath.Join(dir, "held.conf")
+	edited := filepath.Join(dir, "sshd_config")
+	other := filepath.Join(dir, "other.conf")
+
+	require.NoError(t, os.WriteFile(edited, []byte("Port 22\n"), 0o644))
+	require.NoError(t, files.HoldFile(bucket, held, "abcd"))
+	require.NoError(t, files.HoldFile(bucket, edited, "ef01"))
+
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+	r.cfg.FileBucket = FileBucketConfig{Path: bucket}
+
+	fileSet := []commonv1.File{
+		{Path: held, Content: "managed\n"},
+		{Path: edited, Ensure: "lineinfile", Line: "Port 2222", Regexp: "^Port"},
+		{Path: other, Content: "managed\n"},
+	}
+
+	changed, _, err := r.handleFileSet(context.Background(), "test-node", "cs", "default", fileSet, commonv1.ManagedNode{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{other}, changed)
+	require.NotContains(t, sys.File().(*mockFileHandler).fileWriteCalls, held)
+	require.NotContains(t, sys.File().(*mockFileHandler).fileWriteCalls, edited)
+
+	_, err = files.ReleaseFile(bucket, held)
+	require.NoError(t, err)
+	_, err = files.ReleaseFile(bucket, edited)
+	require.NoError(t, err)
+
+	changed, _, err = r.handleFileSet(context.Background(), "test-node", "cs", "default", fileSet, commonv1.ManagedNode{}, nil)
+	require.NoError(t, err)
+	require.Contains(t, changed, held)
+	require.Contains(t, changed, edited)
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+func TestHandleServiceSetArguments(t *testing.T) {
+	ctx := context.Background()
+
+	svcHandler := &mockServiceHandler{
+		serviceStatus: map[string]services.ServiceStatus{"chronyd": services.Running, "sshd": services.Stopped},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{serviceHandler: svcHandler})
+
+	svcs := []commonv1.Service{
+		{Name: "chronyd", Enable: true, Ensure: "running", Arguments: "-r"},
+		{Name: "sshd", Enable: false, Ensure: "stopped", Arguments: "-p 2222"},
+	}
+
+	// New arguments restart the running service; the stopped one picks them
+	// up when it is started.
+	_, _, owned, err := r.handleServiceSet(ctx, "test-node", "default", svcs, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"chronyd": 1}, svcHandler.restartCalls)
+	require.Equal(t, []string{"chronyd", "sshd"}, owned)
+
+	_, _, owned, err = r.handleServiceSet(ctx, "test-node", "default", svcs, nil, nil, nil, nil, owned, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"chronyd": 1}, svcHandler.restartCalls)
+
+	svcs[0].Arguments = "-r -s"
+	_, _, owned, err = r.handleServiceSet(ctx, "test-node", "default", svcs, nil, nil, nil, nil, owned, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"chronyd": 2}, svcHandler.restartCalls)
+
+	// Removed arguments are planned, then cleared, which restarts the
+	// running service.  The arguments of a service dropped from the
+	// ConfigSet are cleared too.
+	svcs[0].Arguments = ""
+	p := &planner{}
+	_, _, planned, err := r.handleServiceSet(ctx, "test-node", "default", svcs[:1], nil, nil, nil, nil, owned, p)
+	require.NoError(t, err)
+	require.Equal(t, owned, planned)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "chronyd", Action: "clear arguments"},
+		{Name: "sshd", Action: "clear arguments"},
+	}, p.plan.Services)
+	require.Equal(t, map[string]string{"chronyd": "-r -s", "sshd": "-p 2222"}, svcHandler.arguments)
+
+	_, _, owned, err = r.handleServiceSet(ctx, "test-node", "default", svcs[:1], nil, nil, nil, nil, owned, nil)
+	require.NoError(t, err)
+	require.Empty(t, owned)
+	require.Empty(t, svcHandler.arguments)
+	require.Equal(t, map[string]int{"chronyd": 3}, svcHandler.restartCalls)
+
+	// Arguments set by hand are left alone.
+	svcHandler.arguments = map[string]string{"chronyd": "-x"}
+	_, _, _, err = r.handleServiceSet(ctx, "test-node", "default", svcs[:1], nil, nil, nil, nil, owned, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"chronyd": "-x"}, svcHandler.arguments)
+}
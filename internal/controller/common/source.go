@@ -0,0 +1,65 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+// resolveSource sets file.Content from file.Source.  Nothing is downloaded
+// when file.Path already has the expected digest; its current content is used
+// so that ownership and mode are still enforced.  In plan mode a file which
+// differs is recorded as a download instead, and planned is true.
+func (r *ConfigSetReconciler) resolveSource(ctx context.Context, file *commonv1.File, p *planner) (planned bool, err error) {
+	if file.Content != "" || file.Template != "" {
+		return false, fmt.Errorf("file %q: source cannot be combined with content or template", file.Path)
+	}
+	if file.SHA256 == "" {
+		return false, fmt.Errorf("file %q: source requires sha256", file.Path)
+	}
+
+	sum, err := files.NormalizeSHA256(file.SHA256)
+	if err != nil {
+		return false, fmt.Errorf("file %q: %w", file.Path, err)
+	}
+
+	current, readErr := os.ReadFile(file.Path)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return false, fmt.Errorf("failed to read file %q: %w", file.Path, readErr)
+	}
+	if readErr == nil {
+		h := sha256.Sum256(current)
+		if hex.EncodeToString(h[:]) == sum {
+			file.Content = string(current)
+			return false, nil
+		}
+	}
+
+	if file.CreateOnly && readErr == nil {
+		// Leave the existing file alone without downloading anything.
+		file.Content = string(current)
+		return false, nil
+	}
+
+	if p != nil {
+		p.addFile(file.Path, "download", fmt.Sprintf("%s (sha256:%s)", file.Source, sum))
+		return true, nil
+	}
+
+	if r.sources == nil {
+		r.sources = files.NewSourceFetcher(r.logger, nil, r.cfg.SourceCachePath)
+	}
+
+	data, err := r.sources.Fetch(ctx, file.Source, sum)
+	if err != nil {
+		return false, fmt.Errorf("file %q: %w", file.Path, err)
+	}
+
+	file.Content = string(data)
+	return false, nil
+}
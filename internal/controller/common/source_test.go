@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func TestHandleFileSetSource(t *testing.T) {
+	content := []byte("exporter binary")
+	h := sha256.Sum256(content)
+	sum := hex.EncodeToString(h[:])
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/empty" {
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current")
+	missing := filepath.Join(dir, "missing")
+	require.NoError(t, os.WriteFile(current, content, 0o755))
+
+	sys := &mockSystemHandler{}
+	r := newPlanTestReconciler(sys)
+	r.cfg.SourceCachePath = filepath.Join(dir, "cache")
+
+	fileSet := []commonv1.File{
+		{Path: current, Source: srv.URL + "/exporter", SHA256: sum},
+		{Path: missing, Source: srv.URL + "/exporter", SHA256: "sha256:" + sum},
+	}
+
+	p := &planner{}
+	changed, _, err := r.handleFileSet(context.Background(), "test-node", "cs", "default", fileSet, commonv1.ManagedNode{}, p)
+	require.NoError(t, err)
+	require.Equal(t, []string{missing}, changed)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: missing, Action: "download", Detail: srv.URL + "/exporter (sha256:" + sum + ")"},
+	}, p.plan.Files)
+	require.Zero(t, requests.Load())
+
+	changed, _, err = r.handleFileSet(context.Background(), "test-node", "cs", "default", fileSet, commonv1.ManagedNode{}, nil)
+	require.NoError(t, err)
+	require.Contains(t, changed, missing)
+	require.Equal(t, int32(1), requests.Load(), "only the file without the expected digest is downloaded")
+	require.Contains(t, sys.File().(*mockFileHandler).fileReplaceCalls, missing, "downloaded content replaces the file")
+
+	_, _, err = r.handleFileSet(context.Background(), "test-node", "cs", "default", []commonv1.File{
+		{Path: missing, Source: srv.URL + "/exporter", SHA256: sum, Content: "inline"},
+	}, commonv1.ManagedNode{}, nil)
+	require.ErrorContains(t, err, "source cannot be combined")
+
+	// An empty body is content like any other.
+	empty := sha256.Sum256(nil)
+	truncated := filepath.Join(dir, "truncated")
+	require.NoError(t, os.WriteFile(truncated, []byte("stale"), 0o644))
+
+	changed, _, err = r.handleFileSet(context.Background(), "test-node", "cs", "default", []commonv1.File{
+		{Path: truncated, Source: srv.URL + "/empty", SHA256: hex.EncodeToString(empty[:])},
+	}, commonv1.ManagedNode{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{truncated}, changed)
+	require.Contains(t, sys.File().(*mockFileHandler).fileWriteCalls, truncated)
+}
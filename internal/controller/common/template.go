@@ -0,0 +1,62 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/zachfi/nodemanager/pkg/templates"
+)
+
+// buildGoTemplate renders template in-process.  The data is passed through
+// JSON first so that templates see exactly the same structure as the gomplate
+// "data" datasource, which lets existing templates move between engines by
+// replacing (ds "data") with the root context.
+func buildGoTemplate(template string, data Data) ([]byte, error) {
+	root, err := templateRoot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates.Render("configset", template, root, goTemplateFuncs(root))
+}
+
+func templateRoot(data Data) (map[string]any, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// goTemplateFuncs returns the functions which depend on the template data.
+func goTemplateFuncs(root map[string]any) template.FuncMap {
+	return template.FuncMap{
+		// ds mirrors the gomplate datasource so that (ds "data") keeps working.
+		"ds": func(name string) (any, error) {
+			if name != "data" {
+				return nil, fmt.Errorf("unknown datasource %q", name)
+			}
+			return root, nil
+		},
+		// nodesWithLabel returns the entries of Nodes whose label key equals value.
+		"nodesWithLabel": func(key, value string) []any {
+			var out []any
+			nodes, _ := root["Nodes"].([]any)
+			for _, n := range nodes {
+				node, _ := n.(map[string]any)
+				labels, _ := node["Labels"].(map[string]any)
+				if v, ok := labels[key]; ok && v == value {
+					out = append(out, n)
+				}
+			}
+			return out
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func TestBuildGoTemplate(t *testing.T) {
+	data := Data{
+		Node: NodeData{
+			Labels:  map[string]string{"kubernetes.io/hostname": "a"},
+			Secrets: map[string][]byte{"password": []byte("hunter2")},
+			Facts:   map[string]string{"cpu.count": "8", "init": "openrc"},
+		},
+		Nodes: []NodeInfo{
+			{Name: "a", Labels: map[string]string{"role": "web"}},
+			{Name: "b", Labels: map[string]string{"role": "db"}},
+			{Name: "c", Labels: map[string]string{"role": "web"}, Status: commonv1.ManagedNodeStatus{Release: "14.2-RELEASE"}},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "root context",
+			template: `{{ index .Node.Labels "kubernetes.io/hostname" }}`,
+			expected: "a",
+		},
+		{
+			name:     "ds compatibility",
+			template: `{{ index (ds "data").Node.Labels "kubernetes.io/hostname" }}`,
+			expected: "a",
+		},
+		{
+			name:     "facts",
+			template: `{{ .Node.Facts.init }} {{ index .Node.Facts "cpu.count" }}`,
+			expected: "openrc 8",
+		},
+		{
+			name:     "secrets",
+			template: `{{ .Node.Secrets.password | b64dec }}`,
+			expected: "hunter2",
+		},
+		{
+			name:     "nodesWithLabel",
+			template: `{{ range nodesWithLabel "role" "web" }}{{ .Name }} {{ end }}`,
+			expected: "a c ",
+		},
+		{
+			name:     "status",
+			template: `{{ range .Nodes }}{{ if hasKey .Status "release" }}{{ .Status.release }}{{ end }}{{ end }}`,
+			expected: "14.2-RELEASE",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := buildGoTemplate(tc.template, data)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(out))
+		})
+	}
+
+	_, err := buildGoTemplate(`{{ ds "other" }}`, data)
+	require.Error(t, err)
+}
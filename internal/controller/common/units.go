@@ -0,0 +1,358 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+// unitScope is the systemd instance a unit belongs to: the system instance,
+// or the instance of a user.
+type unitScope struct {
+	ctx     context.Context
+	user    string
+	svc     handler.ServiceHandler
+	units   handler.UnitHandler
+	changed bool
+}
+
+// handleUnitSet writes and removes the unit files and drop-ins, reloads each
+// systemd instance once when any of its units changed, and then ensures the
+// mask, enablement and state of each unit.  A running unit whose files
+// changed is restarted, also when only its drop-ins are declared, e.g. a
+// drop-in for a unit installed by a package.
+func (r *ConfigSetReconciler) handleUnitSet(ctx context.Context, nodeName string, units []commonv1.Unit, p *planner) error {
+	if len(units) == 0 {
+		return nil
+	}
+
+	ctx, span := r.tracer.Start(ctx, "handleUnitSet")
+	defer span.End()
+
+	var (
+		errs    []error
+		scopes  = make(map[string]*unitScope)
+		order   []string
+		changed = make(map[string]bool)
+		// restarts holds the units whose files changed, in order, and managed
+		// the units whose state is ensured by ensureUnitState.
+		restarts []commonv1.Unit
+		managed  = make(map[string]bool)
+	)
+
+	scopeFor := func(user string) (*unitScope, error) {
+		if s, ok := scopes[user]; ok {
+			return s, nil
+		}
+		svcCtx := serviceContext(ctx, user)
+		svc := withUserContext(r.system.Service(), svcCtx)
+		unitHandler, ok := svc.(handler.UnitHandler)
+		if !ok {
+			return nil, fmt.Errorf("units are not supported by the service manager of this node")
+		}
+		s := &unitScope{ctx: svcCtx, user: user, svc: svc, units: unitHandler}
+		scopes[user] = s
+		order = append(order, user)
+		return s, nil
+	}
+
+	// Unit files restored by hand with `nodemanager filebucket restore` are
+	// not written until they are released.
+	held, err := files.FileBucketHolds(r.cfg.FileBucket.Path)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// The unit files are handled first, so that a single daemon-reload picks
+	// up every change.
+	for _, u := range units {
+		s, err := scopeFor(u.User)
+		if err != nil {
+			return err
+		}
+
+		path, err := s.units.UnitPath(s.ctx, u.Name, u.DropIn)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read unit %q: %w", unitID(u), err))
+			continue
+		}
+		if r.fileHeld(held, path) {
+			continue
+		}
+
+		fileChanged, err := r.ensureUnitFile(s, nodeName, u, p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if fileChanged {
+			s.changed = true
+			key := unitKey(u.User, u.Name)
+			// A removed unit has been stopped already.
+			if !changed[key] && (u.DropIn != "" || u.Ensure != stateAbsent) {
+				restarts = append(restarts, commonv1.Unit{Name: u.Name, User: u.User})
+			}
+			changed[key] = true
+		}
+	}
+
+	if p == nil {
+		for _, user := range order {
+			s := scopes[user]
+			if !s.changed {
+				continue
+			}
+			if err := unitOperation(nodeName, "daemon_reload", "", func() error { return s.units.DaemonReload(s.ctx) }); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, u := range units {
+		if u.DropIn != "" || u.Ensure == stateAbsent {
+			continue
+		}
+		s, err := scopeFor(u.User)
+		if err != nil {
+			return err
+		}
+		if u.State != "" {
+			managed[unitKey(u.User, u.Name)] = true
+		}
+		if err := r.ensureUnitState(s, nodeName, u, changed[unitKey(u.User, u.Name)], p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, u := range restarts {
+		if managed[unitKey(u.User, u.Name)] {
+			continue
+		}
+		s, err := scopeFor(u.User)
+		if err != nil {
+			return err
+		}
+		if err := r.restartChangedUnit(s, nodeName, u, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// restartChangedUnit restarts a unit whose files changed when it is running.
+// It is used for the units whose state is not managed by the ConfigSet.
+func (r *ConfigSetReconciler) restartChangedUnit(s *unitScope, nodeName string, u commonv1.Unit, p *planner) error {
+	if status, _ := s.svc.Status(s.ctx, u.Name); status != services.Running {
+		return nil
+	}
+
+	if p != nil {
+		p.addUnit(unitID(u), "restart", "unit file changed")
+		return nil
+	}
+
+	return unitOperation(nodeName, "restart", u.Name, func() error { return s.svc.Restart(s.ctx, u.Name) })
+}
+
+// ensureUnitFile writes or removes the unit file or drop-in of u, and reports
+// whether it changed.  A unit is stopped and disabled before its file is
+// removed.
+func (r *ConfigSetReconciler) ensureUnitFile(s *unitScope, nodeName string, u commonv1.Unit, p *planner) (bool, error) {
+	id := unitID(u)
+
+	current, err := s.units.UnitContent(s.ctx, u.Name, u.DropIn)
+	if err != nil {
+		return false, fmt.Errorf("failed to read unit %q: %w", id, err)
+	}
+
+	if u.Ensure == stateAbsent {
+		if current == nil {
+			return false, nil
+		}
+		if p != nil {
+			p.addUnit(id, "remove", "")
+			return true, nil
+		}
+
+		if u.DropIn == "" {
+			if status, _ := s.svc.Status(s.ctx, u.Name); status == services.Running {
+				if err := unitOperation(nodeName, "stop", u.Name, func() error { return s.svc.Stop(s.ctx, u.Name) }); err != nil {
+					return false, err
+				}
+			}
+			if state, _ := s.units.UnitFileState(s.ctx, u.Name); unitEnabled(state) {
+				if err := unitOperation(nodeName, "disable", u.Name, func() error { return s.svc.Disable(s.ctx, u.Name) }); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		removed, err := s.units.RemoveUnit(s.ctx, u.Name, u.DropIn)
+		if err != nil {
+			return false, fmt.Errorf("failed to remove unit %q: %w", id, err)
+		}
+		return removed, nil
+	}
+
+	// A unit without content is provided by a package or by hand.
+	if u.Content == "" || bytes.Equal(current, []byte(u.Content)) {
+		return false, nil
+	}
+
+	if p != nil {
+		p.addUnit(id, "write", unifiedDiff(id, string(current), u.Content))
+		return true, nil
+	}
+
+	written, err := s.units.WriteUnit(s.ctx, u.Name, u.DropIn, []byte(u.Content))
+	if err != nil {
+		return false, fmt.Errorf("failed to write unit %q: %w", id, err)
+	}
+	return written, nil
+}
+
+// ensureUnitState masks or unmasks the unit, enables or disables it, and
+// starts or stops it.  A masked unit is neither enabled nor started.
+func (r *ConfigSetReconciler) ensureUnitState(s *unitScope, nodeName string, u commonv1.Unit, filesChanged bool, p *planner) error {
+	id := unitID(u)
+
+	state, err := s.units.UnitFileState(s.ctx, u.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read the state of unit %q: %w", id, err)
+	}
+	masked := unitMasked(state)
+
+	if u.Mask != nil && *u.Mask != masked {
+		operation, f := "unmask", s.units.Unmask
+		if *u.Mask {
+			operation, f = "mask", s.units.Mask
+		}
+		if p != nil {
+			p.addUnit(id, operation, state)
+		} else {
+			if err := unitOperation(nodeName, operation, u.Name, func() error { return f(s.ctx, u.Name) }); err != nil {
+				return err
+			}
+			if state, err = s.units.UnitFileState(s.ctx, u.Name); err != nil {
+				return fmt.Errorf("failed to read the state of unit %q: %w", id, err)
+			}
+		}
+		masked = *u.Mask
+	}
+	if masked {
+		return nil
+	}
+
+	if u.Enable != nil {
+		// Static and indirect units cannot be enabled themselves.
+		var operation string
+		switch {
+		case *u.Enable && !unitEnabled(state) && state != "static" && state != "indirect":
+			operation = "enable"
+		case !*u.Enable && unitEnabled(state):
+			operation = "disable"
+		}
+
+		if operation != "" && p != nil {
+			p.addUnit(id, operation, state)
+		} else if operation != "" {
+			f := s.svc.Enable
+			if operation == "disable" {
+				f = s.svc.Disable
+			}
+			if err := unitOperation(nodeName, operation, u.Name, func() error { return f(s.ctx, u.Name) }); err != nil {
+				return err
+			}
+		}
+	}
+
+	if u.State == "" {
+		return nil
+	}
+
+	status, _ := s.svc.Status(s.ctx, u.Name)
+
+	var operation string
+	switch services.ServiceStatusFromString(u.State) {
+	case services.Running:
+		if status != services.Running {
+			operation = "start"
+		} else if filesChanged {
+			operation = "restart"
+		}
+	case services.Stopped:
+		if status == services.Running {
+			operation = "stop"
+		}
+	}
+	if operation == "" {
+		return nil
+	}
+
+	if p != nil {
+		detail := status.String()
+		if operation == "restart" {
+			detail = "unit file changed"
+		}
+		p.addUnit(id, operation, detail)
+		return nil
+	}
+
+	f := map[string]func(context.Context, string) error{
+		"start":   s.svc.Start,
+		"stop":    s.svc.Stop,
+		"restart": s.svc.Restart,
+	}[operation]
+	return unitOperation(nodeName, operation, u.Name, func() error { return f(s.ctx, u.Name) })
+}
+
+// unitOperation runs f and records it as a service operation.
+func unitOperation(nodeName, operation, name string, f func() error) error {
+	err := f()
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	serviceOperationsTotal.WithLabelValues(nodeName, operation, result).Inc()
+	if err != nil {
+		if name == "" {
+			return fmt.Errorf("failed to %s: %w", strings.ReplaceAll(operation, "_", "-"), err)
+		}
+		return fmt.Errorf("failed to %s unit %q: %w", operation, name, err)
+	}
+	return nil
+}
+
+func unitEnabled(state string) bool {
+	return slices.Contains([]string{"enabled", "enabled-runtime"}, state)
+}
+
+func unitMasked(state string) bool {
+	return slices.Contains([]string{"masked", "masked-runtime"}, state)
+}
+
+func unitKey(user, name string) string {
+	return user + "\x00" + name
+}
+
+// unitID identifies a unit file or drop-in in plans and conflicts, e.g.
+// "backup.service", "backup.service.d/limits.conf" or "~alice/sync.timer".
+func unitID(u commonv1.Unit) string {
+	id := u.Name
+	if u.DropIn != "" {
+		id += ".d/" + u.DropIn + ".conf"
+	}
+	if u.User != "" {
+		id = "~" + u.User + "/" + id
+	}
+	return id
+}
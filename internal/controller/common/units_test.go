@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+func TestHandleUnitSet(t *testing.T) {
+	ctx := context.Background()
+	enable := true
+	mask := true
+
+	svcHandler := &mockServiceHandler{
+		serviceStatus: map[string]services.ServiceStatus{"backup.service": services.Stopped, "old.service": services.Running},
+		unitFiles:     map[string][]byte{"old.service": []byte("[Unit]\n")},
+		unitStates:    map[string]string{"old.service": "enabled"},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{serviceHandler: svcHandler})
+
+	units := []commonv1.Unit{
+		{Name: "backup.service", Content: "[Service]\nExecStart=/usr/local/bin/backup\n"},
+		{Name: "backup.timer", Content: "[Timer]\nOnCalendar=daily\n", Enable: &enable, State: "running"},
+		{Name: "sshd.service", DropIn: "limits", Content: "[Service]\nLimitNOFILE=65536\n"},
+		{Name: "old.service", Ensure: "absent"},
+		{Name: "cups.service", Mask: &mask},
+	}
+
+	p := &planner{}
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, p))
+	require.Empty(t, svcHandler.unitCalls)
+	require.Equal(t, []string{"backup.service", "backup.timer", "sshd.service.d/limits.conf", "old.service", "backup.timer", "backup.timer", "cups.service"},
+		plannedNames(p.plan.Units))
+
+	// Every unit file is written before a single daemon-reload.
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, nil))
+	require.Equal(t, []string{
+		"write backup.service",
+		"write backup.timer",
+		"write sshd.service.d/limits.conf",
+		"remove old.service",
+		"daemon-reload",
+		"mask cups.service",
+	}, svcHandler.unitCalls)
+	require.Equal(t, 1, svcHandler.stopCalls["old.service"])
+	require.Equal(t, 1, svcHandler.disableCalls["old.service"])
+	require.Equal(t, 1, svcHandler.enableCalls["backup.timer"])
+	require.Equal(t, 1, svcHandler.startCalls["backup.timer"])
+
+	// Nothing changed, so nothing is reloaded.  A changed unit which is
+	// running is restarted.
+	svcHandler.unitCalls = nil
+	svcHandler.unitStates["backup.timer"] = "enabled"
+	svcHandler.serviceStatus["backup.timer"] = services.Running
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, nil))
+	require.Empty(t, svcHandler.unitCalls)
+	require.Empty(t, svcHandler.restartCalls)
+
+	units[1].Content = "[Timer]\nOnCalendar=hourly\n"
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, nil))
+	require.Equal(t, []string{"write backup.timer", "daemon-reload"}, svcHandler.unitCalls)
+	require.Equal(t, 1, svcHandler.restartCalls["backup.timer"])
+
+	// Unmasking.
+	svcHandler.unitCalls = nil
+	mask = false
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units[4:], nil))
+	require.Equal(t, []string{"unmask cups.service"}, svcHandler.unitCalls)
+
+	// A running unit is restarted when only its drop-in is declared.
+	svcHandler.unitCalls = nil
+	svcHandler.serviceStatus["sshd.service"] = services.Running
+	units[2].Content = "[Service]\nLimitNOFILE=131072\n"
+
+	p = &planner{}
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units[2:3], p))
+	require.Equal(t, []string{"sshd.service.d/limits.conf", "sshd.service"}, plannedNames(p.plan.Units))
+	require.Empty(t, svcHandler.restartCalls["sshd.service"])
+
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units[2:3], nil))
+	require.Equal(t, []string{"write sshd.service.d/limits.conf", "daemon-reload"}, svcHandler.unitCalls)
+	require.Equal(t, 1, svcHandler.restartCalls["sshd.service"])
+
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units[2:3], nil))
+	require.Equal(t, 1, svcHandler.restartCalls["sshd.service"])
+}
+
+func TestHandleUnitSetHeld(t *testing.T) {
+	ctx := context.Background()
+	bucket := filepath.Join(t.TempDir(), "bucket")
+	require.NoError(t, files.HoldFile(bucket, "sshd.service.d/limits.conf", "abcd"))
+
+	svcHandler := &mockServiceHandler{
+		serviceStatus: map[string]services.ServiceStatus{"sshd.service": services.Running},
+	}
+	r := newPlanTestReconciler(&mockSystemHandler{serviceHandler: svcHandler})
+	r.cfg.FileBucket = FileBucketConfig{Path: bucket}
+
+	units := []commonv1.Unit{
+		{Name: "sshd.service", DropIn: "limits", Content: "[Service]\nLimitNOFILE=65536\n"},
+		{Name: "backup.service", Content: "[Service]\nExecStart=/usr/local/bin/backup\n"},
+	}
+
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, nil))
+	require.Equal(t, []string{"write backup.service", "daemon-reload"}, svcHandler.unitCalls)
+	require.Empty(t, svcHandler.restartCalls)
+
+	_, err := files.ReleaseFile(bucket, "sshd.service.d/limits.conf")
+	require.NoError(t, err)
+
+	svcHandler.unitCalls = nil
+	require.NoError(t, r.handleUnitSet(ctx, "test-node", units, nil))
+	require.Equal(t, []string{"write sshd.service.d/limits.conf", "daemon-reload"}, svcHandler.unitCalls)
+	require.Equal(t, 1, svcHandler.restartCalls["sshd.service"])
+}
+
+func TestUnitID(t *testing.T) {
+	require.Equal(t, "backup.service", unitID(commonv1.Unit{Name: "backup.service"}))
+	require.Equal(t, "backup.service.d/limits.conf", unitID(commonv1.Unit{Name: "backup.service", DropIn: "limits"}))
+	require.Equal(t, "~alice/sync.timer", unitID(commonv1.Unit{Name: "sync.timer", User: "alice"}))
+}
+
+func plannedNames(changes []commonv1.PlannedChange) []string {
+	names := make([]string, 0, len(changes))
+	for _, c := range changes {
+		names = append(names, c.Name)
+	}
+	return names
+}
@@ -0,0 +1,375 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+// handleGroupSet ensures the local groups of a ConfigSet.  Groups are handled
+// before users so that a user may name a group from the same ConfigSet.
+func (r *ConfigSetReconciler) handleGroupSet(ctx context.Context, nodeName string, groupSet []commonv1.Group, p *planner) error {
+	if len(groupSet) == 0 {
+		return nil
+	}
+
+	ctx, span := r.tracer.Start(ctx, "handleGroupSet")
+	defer span.End()
+
+	handler := r.system.User()
+
+	var errs []error
+	for _, g := range groupSet {
+		current, err := handler.LookupGroup(ctx, g.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to lookup group %q: %w", g.Name, err))
+			continue
+		}
+
+		desired := toGroup(g)
+
+		var (
+			operation string
+			opErr     error
+		)
+
+		switch users.UserEnsureFromString(g.Ensure) {
+		case users.Present:
+			switch {
+			case current == nil && p != nil:
+				p.addGroup(g.Name, "add", desired.GID)
+			case current == nil:
+				operation = "add"
+				opErr = handler.AddGroup(ctx, desired)
+			case users.GroupDiffers(*current, desired) && p != nil:
+				p.addGroup(g.Name, "modify", fmt.Sprintf("gid %s -> %s", current.GID, desired.GID))
+			case users.GroupDiffers(*current, desired):
+				operation = "modify"
+				opErr = handler.ModifyGroup(ctx, desired)
+			}
+		case users.Absent:
+			switch {
+			case current != nil && p != nil:
+				p.addGroup(g.Name, "remove", "")
+			case current != nil:
+				operation = "remove"
+				opErr = handler.RemoveGroup(ctx, g.Name)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unhandled Ensure value %q for group %q", g.Ensure, g.Name))
+			continue
+		}
+
+		if operation == "" {
+			continue
+		}
+
+		result := "success"
+		if opErr != nil {
+			result = "error"
+			errs = append(errs, fmt.Errorf("failed to %s group %q: %w", operation, g.Name, opErr))
+		}
+		userOperationsTotal.WithLabelValues(nodeName, "group_"+operation, result).Inc()
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleUserSet ensures the local users of a ConfigSet, and their SSH
+// authorized keys.
+func (r *ConfigSetReconciler) handleUserSet(ctx context.Context, nodeName string, userSet []commonv1.User, p *planner) error {
+	if len(userSet) == 0 {
+		return nil
+	}
+
+	ctx, span := r.tracer.Start(ctx, "handleUserSet")
+	defer span.End()
+
+	handler := r.system.User()
+
+	var errs []error
+	for _, u := range userSet {
+		span.SetAttributes(attribute.String("name", u.Name))
+
+		current, err := handler.LookupUser(ctx, u.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to lookup user %q: %w", u.Name, err))
+			continue
+		}
+
+		desired := toUser(u)
+
+		var (
+			operation string
+			opErr     error
+		)
+
+		switch users.UserEnsureFromString(u.Ensure) {
+		case users.Present:
+			switch {
+			case current == nil && p != nil:
+				p.addUser(u.Name, "add", userDetail(desired))
+			case current == nil:
+				operation = "add"
+				opErr = handler.AddUser(ctx, desired)
+			case users.UserDiffers(*current, desired) && p != nil:
+				p.addUser(u.Name, "modify", userDetail(desired))
+			case users.UserDiffers(*current, desired):
+				operation = "modify"
+				opErr = handler.ModifyUser(ctx, desired)
+			}
+		case users.Absent:
+			switch {
+			case current != nil && p != nil:
+				p.addUser(u.Name, "remove", "")
+			case current != nil:
+				operation = "remove"
+				opErr = handler.RemoveUser(ctx, u.Name)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unhandled Ensure value %q for user %q", u.Ensure, u.Name))
+			continue
+		}
+
+		if operation != "" {
+			result := "success"
+			if opErr != nil {
+				result = "error"
+				errs = append(errs, fmt.Errorf("failed to %s user %q: %w", operation, u.Name, opErr))
+			}
+			userOperationsTotal.WithLabelValues(nodeName, "user_"+operation, result).Inc()
+			if opErr != nil {
+				continue
+			}
+		}
+
+		if len(u.AuthorizedKeys) == 0 || users.UserEnsureFromString(u.Ensure) != users.Present {
+			continue
+		}
+
+		// Read the account back after a change so that the home directory and
+		// primary group chosen by the platform are used.
+		if operation != "" {
+			if current, err = handler.LookupUser(ctx, u.Name); err != nil {
+				errs = append(errs, fmt.Errorf("failed to lookup user %q: %w", u.Name, err))
+				continue
+			}
+		}
+
+		if err := r.ensureAuthorizedKeys(ctx, u, current, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ensureAuthorizedKeys writes the authorized_keys file of user, creating the
+// .ssh directory if needed.  current is the account on the node, which is nil
+// only in plan mode for a user that would be added.
+func (r *ConfigSetReconciler) ensureAuthorizedKeys(ctx context.Context, user commonv1.User, current *users.User, p *planner) error {
+	home := user.Home
+	if current != nil {
+		home = current.Home
+	}
+	if home == "" {
+		if p != nil {
+			home = filepath.Join("/home", user.Name)
+		} else {
+			return fmt.Errorf("user %q has no home directory for authorized keys", user.Name)
+		}
+	}
+
+	sshDir := filepath.Join(home, ".ssh")
+	keysPath := filepath.Join(sshDir, "authorized_keys")
+	content := strings.Join(user.AuthorizedKeys, "\n") + "\n"
+
+	held, err := files.FileBucketHolds(r.cfg.FileBucket.Path)
+	if err != nil {
+		return err
+	}
+	if r.fileHeld(held, keysPath) {
+		return nil
+	}
+
+	if p != nil {
+		if existing, err := os.ReadFile(keysPath); err != nil || string(existing) != content {
+			p.addUser(user.Name, "authorized_keys", fmt.Sprintf("%s: %d keys", keysPath, len(user.AuthorizedKeys)))
+		}
+		return nil
+	}
+
+	uid, gid, err := r.accountIDs(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	if err := writeAuthorizedKeys(home, []byte(content), uid, gid); err != nil {
+		return fmt.Errorf("failed to write %q: %w", keysPath, err)
+	}
+
+	return nil
+}
+
+// accountIDs returns the numeric uid and primary gid of the account.  The gid
+// is -1, leaving the group alone, when the primary group cannot be found.
+func (r *ConfigSetReconciler) accountIDs(ctx context.Context, account *users.User) (int, int, error) {
+	uid, err := strconv.Atoi(account.UID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %q has no numeric uid: %w", account.Name, err)
+	}
+
+	gid := -1
+	if account.Group != "" {
+		group, err := r.system.User().LookupGroup(ctx, account.Group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to lookup group %q: %w", account.Group, err)
+		}
+		if group != nil {
+			if gid, err = strconv.Atoi(group.GID); err != nil {
+				return 0, 0, fmt.Errorf("group %q has no numeric gid: %w", account.Group, err)
+			}
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// writeAuthorizedKeys writes .ssh/authorized_keys below home, owned by uid
+// and gid.  The user controls everything below the home directory, so the
+// files are reached through an os.Root which cannot leave it, a symlinked
+// .ssh or authorized_keys is refused, and the content is written to a new
+// temporary file which is renamed into place.  sshd refuses keys which are
+// writable by anyone but the user.
+func writeAuthorizedKeys(home string, content []byte, uid, gid int) error {
+	root, err := os.OpenRoot(home)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	const (
+		sshDir   = ".ssh"
+		keysPath = ".ssh/authorized_keys"
+	)
+
+	info, err := root.Lstat(sshDir)
+	switch {
+	case os.IsNotExist(err):
+		if err := root.Mkdir(sshDir, 0o700); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("%s is not a directory", sshDir)
+	}
+
+	dir, err := root.Open(sshDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Chown(uid, gid); err != nil {
+		return err
+	}
+	if err := dir.Chmod(0o700); err != nil {
+		return err
+	}
+
+	info, err = root.Lstat(keysPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", keysPath)
+	}
+
+	tmpPath := fmt.Sprintf("%s/.authorized_keys.%d", sshDir, time.Now().UnixNano())
+	tmp, err := root.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer tmp.Close()
+		if err := tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+		if err := tmp.Chmod(0o600); err != nil {
+			return err
+		}
+		if _, err := tmp.Write(content); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if err == nil {
+		err = root.Rename(tmpPath, keysPath)
+	}
+	if err != nil {
+		_ = root.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+func toUser(u commonv1.User) users.User {
+	out := users.User{
+		Name:    u.Name,
+		Group:   u.Group,
+		Groups:  u.Groups,
+		Home:    u.Home,
+		Shell:   u.Shell,
+		Comment: u.Comment,
+		System:  u.System,
+	}
+	if u.UID != nil {
+		out.UID = strconv.FormatInt(*u.UID, 10)
+	}
+	return out
+}
+
+func toGroup(g commonv1.Group) users.Group {
+	out := users.Group{
+		Name:   g.Name,
+		System: g.System,
+	}
+	if g.GID != nil {
+		out.GID = strconv.FormatInt(*g.GID, 10)
+	}
+	return out
+}
+
+// userDetail summarises the fields of u set by the ConfigSet for the plan.
+func userDetail(u users.User) string {
+	var parts []string
+	for _, f := range []struct{ key, value string }{
+		{"uid", u.UID},
+		{"group", u.Group},
+		{"home", u.Home},
+		{"shell", u.Shell},
+		{"comment", u.Comment},
+	} {
+		if f.value != "" {
+			parts = append(parts, f.key+"="+f.value)
+		}
+	}
+	if u.Groups != nil {
+		parts = append(parts, "groups="+strings.Join(u.Groups, ","))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+func TestHandleUserSet(t *testing.T) {
+	home := t.TempDir()
+
+	uh := &mockUserHandler{
+		users: map[string]*users.User{
+			"alice": {Name: "alice", UID: strconv.Itoa(os.Getuid()), Group: "alice", Home: home, Shell: "/bin/sh"},
+			"bob":   {Name: "bob", UID: "1001", Group: "bob", Home: "/home/bob", Shell: "/bin/sh"},
+			"old":   {Name: "old", UID: "1002", Group: "old"},
+		},
+		groups: map[string]*users.Group{
+			"wheel": {Name: "wheel", GID: "10"},
+			"stale": {Name: "stale", GID: "500"},
+		},
+	}
+	sys := &mockSystemHandler{userHandler: uh}
+	r := newPlanTestReconciler(sys)
+
+	err := r.handleGroupSet(context.Background(), "test-node", []commonv1.Group{
+		{Name: "wheel", GID: ptr.To[int64](10)},
+		{Name: "ops", GID: ptr.To[int64](2000)},
+		{Name: "stale", Ensure: "absent"},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"ops": 1}, uh.addGroupCalls)
+	require.Empty(t, uh.modifyGroupCalls)
+	require.Equal(t, map[string]int{"stale": 1}, uh.removeGroupCalls)
+
+	err = r.handleUserSet(context.Background(), "test-node", []commonv1.User{
+		{Name: "alice", Shell: "/bin/sh", AuthorizedKeys: []string{"ssh-ed25519 AAAA alice@example"}},
+		{Name: "bob", Shell: "/bin/bash"},
+		{Name: "carol"},
+		{Name: "old", Ensure: "absent"},
+		{Name: "never", Ensure: "absent"},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"carol": 1}, uh.addUserCalls)
+	require.Equal(t, map[string]int{"bob": 1}, uh.modifyUserCalls)
+	require.Equal(t, map[string]int{"old": 1}, uh.removeUserCalls)
+
+	keysPath := filepath.Join(home, ".ssh", "authorized_keys")
+	require.DirExists(t, filepath.Join(home, ".ssh"))
+	content, err := os.ReadFile(keysPath)
+	require.NoError(t, err)
+	require.Equal(t, "ssh-ed25519 AAAA alice@example\n", string(content))
+
+	info, err := os.Stat(keysPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestWriteAuthorizedKeysSymlinks(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	target := filepath.Join(t.TempDir(), "shadow")
+	require.NoError(t, os.WriteFile(target, []byte("root:x:0:0\n"), 0o640))
+
+	// A symlinked .ssh directory is refused.
+	home := t.TempDir()
+	require.NoError(t, os.Symlink(filepath.Dir(target), filepath.Join(home, ".ssh")))
+	require.Error(t, writeAuthorizedKeys(home, []byte("key\n"), uid, gid))
+	require.NoFileExists(t, filepath.Join(filepath.Dir(target), "authorized_keys"))
+
+	// So is a symlinked authorized_keys.
+	home = t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(home, ".ssh"), 0o700))
+	require.NoError(t, os.Symlink(target, filepath.Join(home, ".ssh", "authorized_keys")))
+	require.Error(t, writeAuthorizedKeys(home, []byte("key\n"), uid, gid))
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "root:x:0:0\n", string(content))
+	info, err := os.Stat(target)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Join(home, ".ssh"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+
+	// A regular file is replaced.
+	require.NoError(t, os.Remove(filepath.Join(home, ".ssh", "authorized_keys")))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "authorized_keys"), []byte("old\n"), 0o644))
+	require.NoError(t, writeAuthorizedKeys(home, []byte("key\n"), uid, gid))
+
+	content, err = os.ReadFile(filepath.Join(home, ".ssh", "authorized_keys"))
+	require.NoError(t, err)
+	require.Equal(t, "key\n", string(content))
+	info, err = os.Stat(filepath.Join(home, ".ssh", "authorized_keys"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestPlanUserSet(t *testing.T) {
+	uh := &mockUserHandler{
+		users: map[string]*users.User{
+			"bob": {Name: "bob", UID: "1001", Group: "bob", Shell: "/bin/sh", Groups: []string{"wheel"}},
+		},
+		groups: map[string]*users.Group{
+			"wheel": {Name: "wheel", GID: "10"},
+		},
+	}
+	sys := &mockSystemHandler{userHandler: uh}
+	r := newPlanTestReconciler(sys)
+	p := &planner{}
+
+	require.NoError(t, r.handleGroupSet(context.Background(), "test-node", []commonv1.Group{
+		{Name: "wheel", GID: ptr.To[int64](11)},
+		{Name: "ops"},
+	}, p))
+	require.NoError(t, r.handleUserSet(context.Background(), "test-node", []commonv1.User{
+		{Name: "bob", Groups: []string{"wheel", "ops"}},
+		{Name: "carol", Home: "/nonexistent/carol", AuthorizedKeys: []string{"ssh-ed25519 AAAA carol@example"}},
+	}, p))
+
+	require.Empty(t, uh.addGroupCalls)
+	require.Empty(t, uh.modifyGroupCalls)
+	require.Empty(t, uh.addUserCalls)
+	require.Empty(t, uh.modifyUserCalls)
+
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "wheel", Action: "modify", Detail: "gid 10 -> 11"},
+		{Name: "ops", Action: "add"},
+	}, p.plan.Groups)
+	require.Equal(t, []commonv1.PlannedChange{
+		{Name: "bob", Action: "modify", Detail: "groups=wheel,ops"},
+		{Name: "carol", Action: "add", Detail: "home=/nonexistent/carol"},
+		{Name: "carol", Action: "authorized_keys", Detail: "/nonexistent/carol/.ssh/authorized_keys: 1 keys"},
+	}, p.plan.Users)
+
+	_, err := os.Stat("/nonexistent/carol")
+	require.True(t, os.IsNotExist(err))
+}
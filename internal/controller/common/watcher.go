@@ -0,0 +1,278 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+	"github.com/zachfi/nodemanager/pkg/files"
+)
+
+// watchGrace is how long after a ConfigSet reconcile events on its files are
+// still attributed to nodemanager itself, since they are delivered
+// asynchronously.
+const watchGrace = time.Second
+
+// watchedConfigSet is a ConfigSet whose files are watched.
+type watchedConfigSet struct {
+	namespace string
+	paths     []string
+	// busy is set while the ConfigSet is being reconciled and quietUntil just
+	// after, so that its own writes are not reported as drift.
+	busy       bool
+	quietUntil time.Time
+	pending    *time.Timer
+}
+
+// fileWatcher enqueues the ConfigSets which manage a file as soon as the file
+// is modified, removed or chmod'ed by something other than nodemanager.  A
+// file may be managed by several ConfigSets, e.g. when each writes a partial
+// of it.  Parent directories are watched rather than the files themselves, so
+// that a file replaced by a rename, as most editors do, stays watched.
+type fileWatcher struct {
+	logger   *slog.Logger
+	nodeName string
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+	events   chan event.GenericEvent
+	done     chan struct{}
+
+	mu         sync.Mutex
+	configSets map[string]*watchedConfigSet
+	owners     map[string]map[string]struct{} // path -> ConfigSet names
+	dirs       map[string]int                 // watched directory -> number of paths in it
+}
+
+func newFileWatcher(logger *slog.Logger, nodeName string, debounce time.Duration) (*fileWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileWatcher{
+		logger:     logger.With("component", "file-watcher"),
+		nodeName:   nodeName,
+		debounce:   debounce,
+		watcher:    w,
+		events:     make(chan event.GenericEvent, 16),
+		done:       make(chan struct{}),
+		configSets: make(map[string]*watchedConfigSet),
+		owners:     make(map[string]map[string]struct{}),
+		dirs:       make(map[string]int),
+	}, nil
+}
+
+// Start implements manager.Runnable.
+func (w *fileWatcher) Start(ctx context.Context) error {
+	defer func() {
+		close(w.done)
+		_ = w.watcher.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(ev)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Warn("file watch error", "err", err)
+		}
+	}
+}
+
+// begin marks the ConfigSet as being reconciled, so that its own writes are
+// not reported as drift.
+func (w *fileWatcher) begin(name, namespace string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cs := w.configSet(name, namespace)
+	cs.busy = true
+}
+
+// track replaces the watched paths of the ConfigSet once it has been
+// reconciled.
+func (w *fileWatcher) track(name, namespace string, paths []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cs := w.configSet(name, namespace)
+	cs.busy = false
+	cs.quietUntil = time.Now().Add(watchGrace)
+
+	for _, p := range cs.paths {
+		if !slices.Contains(paths, p) {
+			w.unwatch(name, p)
+		}
+	}
+
+	watched := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if slices.Contains(cs.paths, p) {
+			watched = append(watched, p)
+			continue
+		}
+		if err := w.watch(name, p); err != nil {
+			w.logger.Debug("not watching file", "path", p, "err", err)
+			continue
+		}
+		watched = append(watched, p)
+	}
+	cs.paths = watched
+}
+
+// forget stops watching the files of a ConfigSet which no longer applies to
+// the node.
+func (w *fileWatcher) forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cs, ok := w.configSets[name]
+	if !ok {
+		return
+	}
+	for _, p := range cs.paths {
+		w.unwatch(name, p)
+	}
+	if cs.pending != nil {
+		cs.pending.Stop()
+	}
+	delete(w.configSets, name)
+}
+
+// handle enqueues every ConfigSet which manages the changed file.  A change
+// made while any of them is reconciled is attributed to nodemanager, since
+// the file is written on behalf of all of them.
+func (w *fileWatcher) handle(ev fsnotify.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	owners, ok := w.owners[filepath.Clean(ev.Name)]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for name := range owners {
+		cs := w.configSets[name]
+		if cs.busy || now.Before(cs.quietUntil) {
+			return
+		}
+	}
+
+	for name := range owners {
+		w.logger.Info("managed file changed outside nodemanager", "path", ev.Name, "op", ev.Op.String(), "configset", name)
+		fileDriftEventsTotal.WithLabelValues(w.nodeName, name, ev.Name).Inc()
+
+		// Coalesce a burst of events, e.g. an editor writing a file in
+		// several steps, into a single reconcile.
+		cs := w.configSets[name]
+		if cs.pending != nil {
+			cs.pending.Reset(w.debounce)
+			continue
+		}
+		cs.pending = time.AfterFunc(w.debounce, func() { w.enqueue(name) })
+	}
+}
+
+func (w *fileWatcher) enqueue(name string) {
+	w.mu.Lock()
+	cs, ok := w.configSets[name]
+	if ok {
+		cs.pending = nil
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	obj := &commonv1.ConfigSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cs.namespace}}
+	select {
+	case w.events <- event.GenericEvent{Object: obj}:
+	case <-w.done:
+	}
+}
+
+// configSet returns the entry for a ConfigSet, creating it if needed.  The
+// caller must hold w.mu.
+func (w *fileWatcher) configSet(name, namespace string) *watchedConfigSet {
+	cs, ok := w.configSets[name]
+	if !ok {
+		cs = &watchedConfigSet{}
+		w.configSets[name] = cs
+	}
+	cs.namespace = namespace
+	return cs
+}
+
+// watch records the ConfigSet as an owner of path, adding the parent
+// directory of path to the watcher for its first owner.  The caller must hold
+// w.mu.
+func (w *fileWatcher) watch(name, path string) error {
+	if owners, ok := w.owners[path]; ok {
+		owners[name] = struct{}{}
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if w.dirs[dir] == 0 {
+		if err := w.watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+	w.dirs[dir]++
+	w.owners[path] = map[string]struct{}{name: {}}
+	return nil
+}
+
+// unwatch releases path for the ConfigSet.  Once path has no owner left, its
+// parent directory is removed from the watcher unless another watched path is
+// in it.  The caller must hold w.mu.
+func (w *fileWatcher) unwatch(name, path string) {
+	owners, ok := w.owners[path]
+	if !ok {
+		return
+	}
+	delete(owners, name)
+	if len(owners) > 0 {
+		return
+	}
+	delete(w.owners, path)
+
+	dir := filepath.Dir(path)
+	w.dirs[dir]--
+	if w.dirs[dir] > 0 {
+		return
+	}
+	delete(w.dirs, dir)
+	if err := w.watcher.Remove(dir); err != nil {
+		w.logger.Debug("failed to remove directory watch", "dir", dir, "err", err)
+	}
+}
+
+// watchedPaths returns the paths of fileSet which the watcher tracks.
+// Directories are left out, since the watcher only sees their entries.
+func watchedPaths(fileSet []commonv1.File) []string {
+	paths := make([]string, 0, len(fileSet))
+	for _, f := range fileSet {
+		if files.FileEnsureFromString(f.Ensure) == files.Directory {
+			continue
+		}
+		paths = append(paths, filepath.Clean(f.Path))
+	}
+	return paths
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	commonv1 "github.com/zachfi/nodemanager/api/common/v1"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+	require.NoError(t, os.WriteFile(path, []byte("managed\n"), 0o644))
+
+	w, err := newFileWatcher(slog.New(slog.NewTextHandler(os.Stdout, nil)), "test-node", 50*time.Millisecond)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Start(ctx) }()
+
+	// Writes made while the ConfigSet is reconciled are our own.
+	w.begin("watched", "nodemanager")
+	require.NoError(t, os.WriteFile(path, []byte("managed, rewritten\n"), 0o644))
+	w.track("watched", "nodemanager", []string{path, filepath.Join(dir, "missing", "x.conf")})
+	requireNoEnqueue(t, w.events, watchGrace+200*time.Millisecond)
+
+	// A burst of external changes enqueues the ConfigSet once.
+	require.NoError(t, os.WriteFile(path, []byte("edited\n"), 0o644))
+	require.NoError(t, os.Chmod(path, 0o600))
+	ev := requireEnqueue(t, w.events)
+	require.Equal(t, "watched", ev.Object.GetName())
+	require.Equal(t, "nodemanager", ev.Object.GetNamespace())
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+
+	var m dto.Metric
+	require.NoError(t, fileDriftEventsTotal.WithLabelValues("test-node", "watched", path).Write(&m))
+	require.GreaterOrEqual(t, m.GetCounter().GetValue(), 2.0)
+
+	// Replacing the file by a rename, as editors do, is seen too.
+	tmp := filepath.Join(dir, ".app.conf.swp")
+	require.NoError(t, os.WriteFile(tmp, []byte("replaced\n"), 0o644))
+	require.NoError(t, os.Rename(tmp, path))
+	requireEnqueue(t, w.events)
+
+	require.NoError(t, os.Remove(path))
+	requireEnqueue(t, w.events)
+
+	// Other files in the directory are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.conf"), []byte("x"), 0o644))
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+
+	w.forget("watched")
+	require.NoError(t, os.WriteFile(path, []byte("unmanaged\n"), 0o644))
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+	require.Empty(t, w.dirs)
+}
+
+func TestFileWatcherSharedPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "motd")
+	require.NoError(t, os.WriteFile(path, []byte("fragments\n"), 0o644))
+
+	w, err := newFileWatcher(slog.New(slog.NewTextHandler(os.Stdout, nil)), "test-node", 50*time.Millisecond)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Start(ctx) }()
+
+	// Two ConfigSets write partials of the same file.
+	w.begin("banner", "nodemanager")
+	w.track("banner", "nodemanager", []string{path})
+	w.begin("news", "nodemanager")
+	w.track("news", "nodemanager", []string{path})
+	time.Sleep(watchGrace + 100*time.Millisecond)
+
+	// Writes made while either of them is reconciled are our own.
+	w.begin("news", "nodemanager")
+	require.NoError(t, os.WriteFile(path, []byte("fragments, rewritten\n"), 0o644))
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+	w.track("news", "nodemanager", []string{path})
+	time.Sleep(watchGrace + 100*time.Millisecond)
+
+	// An external change enqueues both.
+	require.NoError(t, os.WriteFile(path, []byte("edited\n"), 0o644))
+	enqueued := []string{requireEnqueue(t, w.events).Object.GetName(), requireEnqueue(t, w.events).Object.GetName()}
+	require.ElementsMatch(t, []string{"banner", "news"}, enqueued)
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+
+	// Once one of them stops managing the file, the other is still enqueued.
+	w.forget("banner")
+	require.NoError(t, os.WriteFile(path, []byte("edited again\n"), 0o644))
+	require.Equal(t, "news", requireEnqueue(t, w.events).Object.GetName())
+	requireNoEnqueue(t, w.events, 200*time.Millisecond)
+
+	w.forget("news")
+	require.Empty(t, w.owners)
+	require.Empty(t, w.dirs)
+}
+
+func TestWatchedPaths(t *testing.T) {
+	fileSet := []commonv1.File{
+		{Path: "/etc/app.conf"},
+		{Path: "/etc/app.d/", Ensure: "directory"},
+		{Path: "/etc/app.d/../link", Ensure: "symlink"},
+	}
+	require.Equal(t, []string{"/etc/app.conf", "/etc/link"}, watchedPaths(fileSet))
+}
+
+func requireEnqueue(t *testing.T, events <-chan event.GenericEvent) event.GenericEvent {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConfigSet was not enqueued")
+		return event.GenericEvent{}
+	}
+}
+
+func requireNoEnqueue(t *testing.T, events <-chan event.GenericEvent, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected enqueue of %s", ev.Object.GetName())
+	case <-time.After(wait):
+	}
+}
@@ -349,7 +349,7 @@ func (r *JailReconciler) handleUpdate(ctx context.Context, j *freebsdv1.Jail, ja
 
 	if j.Spec.Update.Group != "" {
 		lockTTL := time.Until(schedExpr.Next(time.Now()))
-		if err := r.locker.LockFor(ctx, lockReq, lockTTL); err != nil {
+		if err := r.locker.LockFor(locker.WithPurpose(ctx, locker.PurposeJailUpdate), lockReq, lockTTL); err != nil {
 			if apierrors.IsConflict(err) {
 				r.logger.Info("jail update group lock held by another member, skipping this slot",
 					"group", j.Spec.Update.Group, "jail", j.Name)
@@ -6,3 +6,36 @@ package common
 //	kubectl annotate managednode <name> upgrade.nodemanager/hold=true
 //	kubectl annotate managednode <name> upgrade.nodemanager/hold-   # remove
 const AnnotationUpgradeHold = "upgrade.nodemanager/hold"
+
+// AnnotationConfigSetPlan puts a ConfigSet into plan mode when set to any
+// non-empty value other than a false boolean such as "false".  In plan mode the controller computes the packages, files,
+// services and executions it would change and publishes them to the
+// ManagedNode status without touching the node.
+//
+//	kubectl annotate configset <name> configset.nodemanager/plan=true
+//	kubectl annotate configset <name> configset.nodemanager/plan-   # apply
+const AnnotationConfigSetPlan = "configset.nodemanager/plan"
+
+// AnnotationNodeLabelRuleKeys records the comma separated keys of the labels
+// which NodeLabelRules have set on a ManagedNode.  It is maintained by the
+// controller, which removes those labels once no rule sets them.
+const AnnotationNodeLabelRuleKeys = "nodelabelrule.nodemanager/labels"
+
+// AnnotationLockSlots sets the number of nodes which may hold a lock group at
+// once.  It is read from the Lease named after the group; the other slots are
+// the Leases <group>-1 to <group>-<slots-1>.  Without it a group has a single
+// slot.
+//
+//	kubectl annotate lease <group> lock.nodemanager/slots=3
+const AnnotationLockSlots = "lock.nodemanager/slots"
+
+// The annotations a node records on the Lease of a lock while it holds it:
+// its identity, the purpose of the lock (upgrade, service-restart or
+// jail-update), and when the lock was acquired and expires, in RFC 3339.
+// They are removed when the lock is released.
+const (
+	AnnotationLockHolder     = "lock.nodemanager/holder"
+	AnnotationLockPurpose    = "lock.nodemanager/purpose"
+	AnnotationLockAcquiredAt = "lock.nodemanager/acquired-at"
+	AnnotationLockExpiresAt  = "lock.nodemanager/expires-at"
+)
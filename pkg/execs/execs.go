@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"os/user"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var _ handler.ExecHandler = (*ExecHandlerCommon)(nil)
@@ -64,3 +70,58 @@ func (h *ExecHandlerCommon) RunCommandWithInput(ctx context.Context, stdin strin
 
 	return out.String(), cmd.ProcessState.ExitCode(), nil
 }
+
+func (h *ExecHandlerCommon) RunCommandWithOptions(ctx context.Context, opts handler.ExecOptions, command string, arg ...string) (string, int, error) {
+	_, span := tracer.Start(ctx, "RunCommandWithOptions")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("command", command),
+		attribute.String("dir", opts.Dir),
+		attribute.String("user", opts.User),
+	)
+
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command, arg...)
+	cmd.Dir = opts.Dir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = os.Environ()
+	// A child which outlives the killed command keeps the output pipe open,
+	// so stop waiting for it shortly after the context is done.
+	cmd.WaitDelay = 10 * time.Second
+
+	if opts.User != "" {
+		u, err := user.Lookup(opts.User)
+		if err != nil {
+			return "", -1, fmt.Errorf("failed to lookup user %q: %w", opts.User, err)
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return "", -1, fmt.Errorf("failed to parse uid of %q: %w", opts.User, err)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return "", -1, fmt.Errorf("failed to parse gid of %q: %w", opts.User, err)
+		}
+
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+		}
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+
+	cmd.Env = append(cmd.Env, opts.Env...)
+
+	err := cmd.Run()
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return out.String(), code, fmt.Errorf("failed to execute %q %s: %w", command, arg, err)
+	}
+
+	return out.String(), code, nil
+}
@@ -0,0 +1,32 @@
+package execs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+func TestRunCommandWithOptions(t *testing.T) {
+	h := &ExecHandlerCommon{}
+	dir := t.TempDir()
+
+	out, code, err := h.RunCommandWithOptions(context.Background(), handler.ExecOptions{
+		Dir: dir,
+		Env: []string{"NM_TEST=value"},
+	}, "/bin/sh", "-c", `echo "$PWD $NM_TEST"; echo err >&2`)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+	require.Equal(t, dir+" value\nerr\n", out)
+
+	out, code, err = h.RunCommandWithOptions(context.Background(), handler.ExecOptions{}, "/bin/sh", "-c", "echo failed; exit 3")
+	require.Error(t, err)
+	require.Equal(t, 3, code)
+	require.Equal(t, "failed\n", out)
+
+	_, code, err = h.RunCommandWithOptions(context.Background(), handler.ExecOptions{}, "/nonexistent/command")
+	require.Error(t, err)
+	require.Equal(t, -1, code)
+}
@@ -0,0 +1,266 @@
+// Package facts collects descriptive facts about the node, such as its CPU,
+// memory, disks and hardware, for publishing to the ManagedNode status.
+package facts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+// DefaultExternalDir holds executables whose output is merged into the facts.
+const DefaultExternalDir = "/etc/nodemanager/facts.d"
+
+// externalTimeout bounds each external fact executable, and
+// externalTotalTimeout all of them together, so that a slow directory cannot
+// hold up the reconcile.
+const (
+	externalTimeout      = 30 * time.Second
+	externalTotalTimeout = time.Minute
+)
+
+var tracer = otel.Tracer("facts")
+
+// Collector gathers the facts of the local node.  Facts are a flat map with
+// dotted keys, e.g. "cpu.count" or "filesystem./.type".  Only facts which
+// rarely change are collected, so that publishing them does not cause a
+// status write on every reconcile.
+type Collector struct {
+	logger *slog.Logger
+	exec   handler.ExecHandler
+
+	goos        string
+	root        string
+	externalDir string
+	externalTTL time.Duration
+	now         func() time.Time
+
+	// mu guards the external facts, which are reused until they are older
+	// than externalTTL.
+	mu         sync.Mutex
+	external   map[string]string
+	externalAt time.Time
+}
+
+// New returns a Collector which runs the executables in externalDir for
+// custom facts, at most once per externalTTL.  An empty externalDir disables
+// external facts, and a zero externalTTL runs them on every Collect.
+func New(logger *slog.Logger, exec handler.ExecHandler, externalDir string, externalTTL time.Duration) *Collector {
+	return &Collector{
+		logger:      logger.With("component", "facts"),
+		exec:        exec,
+		goos:        runtime.GOOS,
+		root:        "/",
+		externalDir: externalDir,
+		externalTTL: externalTTL,
+		now:         time.Now,
+	}
+}
+
+// Collect returns the facts of the node.  Facts which cannot be determined are
+// left out.  External facts override built in facts of the same name.
+func (c *Collector) Collect(ctx context.Context) map[string]string {
+	ctx, span := tracer.Start(ctx, "Collect")
+	defer span.End()
+
+	facts := make(map[string]string)
+
+	switch c.goos {
+	case "freebsd":
+		c.collectFreeBSD(ctx, facts)
+	default:
+		c.collectLinux(ctx, facts)
+	}
+
+	maps.Copy(facts, c.externalFacts(ctx))
+
+	return facts
+}
+
+// externalFacts returns the external facts, running the executables again
+// when the facts collected last are older than the TTL.
+func (c *Collector) externalFacts(ctx context.Context) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.external != nil && c.now().Sub(c.externalAt) < c.externalTTL {
+		return c.external
+	}
+
+	c.external = c.collectExternal(ctx)
+	c.externalAt = c.now()
+	return c.external
+}
+
+// collectExternal runs each executable in the external facts directory and
+// parses its output as a JSON object or as key=value lines.  The executables
+// which have not run when externalTotalTimeout expires are skipped.
+func (c *Collector) collectExternal(ctx context.Context) map[string]string {
+	facts := make(map[string]string)
+	if c.externalDir == "" {
+		return facts
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, externalTotalTimeout)
+	defer cancel()
+
+	entries, err := os.ReadDir(c.externalDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Warn("failed to read external facts directory", "dir", c.externalDir, "err", err)
+		}
+		return facts
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(c.externalDir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			c.logger.Warn("external facts timed out, skipping the remaining executables", "dir", c.externalDir, "timeout", externalTotalTimeout, "skipped", path)
+			break
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, externalTimeout)
+		output, code, err := c.exec.RunCommand(runCtx, path)
+		cancel()
+		if err != nil || code != 0 {
+			c.logger.Warn("external fact failed", "path", path, "exit_code", code, "err", err)
+			continue
+		}
+
+		parsed, err := parseExternal(output)
+		if err != nil {
+			c.logger.Warn("failed to parse external fact output", "path", path, "err", err)
+			continue
+		}
+		maps.Copy(facts, parsed)
+	}
+
+	return facts
+}
+
+// parseExternal parses the output of an external fact executable.  A JSON
+// object is flattened with dotted keys; anything else is read as key=value
+// lines, ignoring blank lines and lines starting with #.
+func parseExternal(output string) (map[string]string, error) {
+	facts := make(map[string]string)
+
+	trimmed := strings.TrimSpace(output)
+	if strings.HasPrefix(trimmed, "{") {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(trimmed), &obj); err != nil {
+			return nil, err
+		}
+		flatten("", obj, facts)
+		return facts, nil
+	}
+
+	for _, line := range strings.Split(trimmed, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid line %q, expected key=value", line)
+		}
+		facts[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return facts, nil
+}
+
+func flatten(prefix string, value any, facts map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(key, child, facts)
+		}
+	case string:
+		facts[prefix] = v
+	case nil:
+		facts[prefix] = ""
+	case []any:
+		b, _ := json.Marshal(v)
+		facts[prefix] = string(b)
+	default:
+		facts[prefix] = fmt.Sprint(v)
+	}
+}
+
+// readFile returns the trimmed content of path beneath the collector root.
+func (c *Collector) readFile(path string) (string, bool) {
+	b, err := os.ReadFile(filepath.Join(c.root, path))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00")), true
+}
+
+func (c *Collector) exists(path string) bool {
+	_, err := os.Stat(filepath.Join(c.root, path))
+	return err == nil
+}
+
+// addFilesystem records the type and size of the filesystem mounted at
+// mountpoint.
+func (c *Collector) addFilesystem(facts map[string]string, mountpoint, fstype string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Join(c.root, mountpoint), &st); err != nil {
+		return
+	}
+
+	key := "filesystem." + mountpoint
+	facts[key+".type"] = fstype
+	facts[key+".size_bytes"] = strconv.FormatUint(uint64(st.Blocks)*uint64(st.Bsize), 10) //nolint:unconvert // the field types differ between platforms
+}
+
+// setKernel records the running and installed kernels, and whether a reboot
+// is needed to run the installed one.
+func setKernel(facts map[string]string, running, installed string) {
+	if running != "" {
+		facts["kernel.running"] = running
+	}
+	if installed != "" {
+		facts["kernel.installed"] = installed
+	}
+	if running != "" && installed != "" {
+		facts["kernel.reboot_required"] = strconv.FormatBool(running != installed)
+	}
+}
+
+// pseudoFilesystems are not reported as filesystems.
+var pseudoFilesystems = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs",
+	"devfs", "devpts", "devtmpfs", "efivarfs", "fdescfs", "fusectl", "hugetlbfs",
+	"linprocfs", "linsysfs", "mqueue", "nsfs", "overlay", "proc", "procfs",
+	"pstore", "ramfs", "rpc_pipefs", "securityfs", "squashfs", "sysfs",
+	"tmpfs", "tracefs",
+}
+
+func isPseudoFilesystem(fstype string) bool {
+	return slices.Contains(pseudoFilesystems, fstype)
+}
@@ -0,0 +1,218 @@
+package facts
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func writeFixture(t *testing.T, root, path, content string) {
+	t.Helper()
+
+	full := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+	require.NoError(t, os.WriteFile(full, []byte(content), 0o644))
+}
+
+func TestCollectLinux(t *testing.T) {
+	root := t.TempDir()
+
+	writeFixture(t, root, "proc/cpuinfo", `processor	: 0
+model name	: AMD Ryzen 7 5800X 8-Core Processor
+flags		: fpu vme hypervisor
+
+processor	: 1
+model name	: AMD Ryzen 7 5800X 8-Core Processor
+flags		: fpu vme hypervisor
+`)
+	writeFixture(t, root, "proc/meminfo", "MemTotal:       16318460 kB\nMemFree:         1000 kB\n")
+	writeFixture(t, root, "proc/stat", "cpu  1 2 3\nbtime 1712345678\n")
+	writeFixture(t, root, "proc/sys/kernel/osrelease", "6.9.1-arch1-1\n")
+	writeFixture(t, root, "sys/block/nvme0n1/size", "1953525168\n")
+	writeFixture(t, root, "sys/block/loop0/size", "1024\n")
+	writeFixture(t, root, "sys/class/dmi/id/sys_vendor", "QEMU\n")
+	writeFixture(t, root, "sys/class/dmi/id/product_name", "Standard PC (Q35 + ICH9, 2009)\n")
+	writeFixture(t, root, "proc/1/cgroup", "0::/init.scope\n")
+	writeFixture(t, root, "proc/mounts", "/dev/nvme0n1p2 / ext4 rw 0 0\nproc /proc proc rw 0 0\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "run/systemd/system"), 0o755))
+
+	oldKernel := filepath.Join(root, "lib/modules/6.9.1-arch1-1")
+	require.NoError(t, os.MkdirAll(oldKernel, 0o755))
+	require.NoError(t, os.Chtimes(oldKernel, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "lib/modules/6.9.3-arch1-1"), 0o755))
+
+	// systemd-detect-virt is not installed.
+	exec := &handler.MockExecHandler{Status: []int{-1}}
+	c := New(testLogger, exec, "", 0)
+	c.goos = "linux"
+	c.root = root
+
+	facts := c.Collect(context.Background())
+
+	require.Equal(t, "AMD Ryzen 7 5800X 8-Core Processor", facts["cpu.model"])
+	require.Equal(t, "2", facts["cpu.count"])
+	require.Equal(t, "16710103040", facts["memory.total_bytes"])
+	require.Equal(t, "2024-04-05T19:34:38Z", facts["boot_time"])
+	require.Equal(t, "1000204886016", facts["disk.nvme0n1.size_bytes"])
+	require.NotContains(t, facts, "disk.loop0.size_bytes")
+	require.Equal(t, "ext4", facts["filesystem./.type"])
+	require.NotEmpty(t, facts["filesystem./.size_bytes"])
+	require.NotContains(t, facts, "filesystem./proc.type")
+	require.Equal(t, "6.9.1-arch1-1", facts["kernel.running"])
+	require.Equal(t, "6.9.3-arch1-1", facts["kernel.installed"])
+	require.Equal(t, "true", facts["kernel.reboot_required"])
+	require.Equal(t, "systemd", facts["init"])
+	require.Equal(t, "unknown", facts["virtualization"])
+	require.Equal(t, "none", facts["container"])
+	require.Equal(t, "QEMU", facts["dmi.vendor"])
+	require.Equal(t, "Standard PC (Q35 + ICH9, 2009)", facts["dmi.product"])
+	require.NotContains(t, facts, "dmi.serial")
+}
+
+func TestCollectLinuxDeviceTree(t *testing.T) {
+	root := t.TempDir()
+
+	writeFixture(t, root, "proc/cpuinfo", "processor\t: 0\nprocessor\t: 1\nModel\t\t: Raspberry Pi 4 Model B Rev 1.4\n")
+	writeFixture(t, root, "sys/firmware/devicetree/base/model", "Raspberry Pi 4 Model B Rev 1.4\x00")
+	writeFixture(t, root, "sys/firmware/devicetree/base/serial-number", "10000000abcdef01\x00")
+	writeFixture(t, root, ".dockerenv", "")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "run/openrc"), 0o755))
+
+	exec := &handler.MockExecHandler{Output: []string{"kvm\n"}}
+	c := New(testLogger, exec, "", 0)
+	c.goos = "linux"
+	c.root = root
+
+	facts := c.Collect(context.Background())
+
+	require.Equal(t, "Raspberry Pi 4 Model B Rev 1.4", facts["cpu.model"])
+	require.Equal(t, "Raspberry Pi 4 Model B Rev 1.4", facts["dmi.product"])
+	require.Equal(t, "10000000abcdef01", facts["dmi.serial"])
+	require.Equal(t, "openrc", facts["init"])
+	require.Equal(t, "kvm", facts["virtualization"])
+	require.Equal(t, "docker", facts["container"])
+	require.Equal(t, [][]string{{"--vm"}}, exec.Recorder["systemd-detect-virt"])
+}
+
+func TestCollectFreeBSD(t *testing.T) {
+	exec := &handler.MockExecHandler{
+		Output: []string{
+			`hw.model: Intel(R) Xeon(R) CPU E3-1230 v5 @ 3.40GHz
+hw.ncpu: 8
+hw.physmem: 34250563584
+kern.boottime: { sec = 1712345678, usec = 123456 } Fri Apr  5 19:34:38 2024
+kern.vm_guest: none
+security.jail.jailed: 0
+kern.disks: ada1 ada0
+`,
+			"14.1-RELEASE-p2\n14.1-RELEASE-p1\n",
+			"smbios.system.maker=\"Supermicro\"\nsmbios.system.product=\"X11SSH-F\"\nsmbios.system.serial=\"0123456789\"\n",
+			"ada1\t512\t4000787030016\t7814037168\t4096\t0\t7752021\t16\t63\n",
+			"ada0\t512\t500107862016\t976773168\t4096\t0\t969021\t16\t63\n",
+			"/dev/ada0p2\t\t/\t\tufs\trw\t\t1 1\ndevfs\t\t\t/dev\t\tdevfs\trw\t\t0 0\n",
+		},
+	}
+	c := New(testLogger, exec, "", 0)
+	c.goos = "freebsd"
+
+	facts := c.Collect(context.Background())
+
+	require.Equal(t, "Intel(R) Xeon(R) CPU E3-1230 v5 @ 3.40GHz", facts["cpu.model"])
+	require.Equal(t, "8", facts["cpu.count"])
+	require.Equal(t, "34250563584", facts["memory.total_bytes"])
+	require.Equal(t, "2024-04-05T19:34:38Z", facts["boot_time"])
+	require.Equal(t, "none", facts["virtualization"])
+	require.Equal(t, "none", facts["container"])
+	require.Equal(t, "rc", facts["init"])
+	require.Equal(t, "14.1-RELEASE-p1", facts["kernel.running"])
+	require.Equal(t, "14.1-RELEASE-p2", facts["kernel.installed"])
+	require.Equal(t, "true", facts["kernel.reboot_required"])
+	require.Equal(t, "Supermicro", facts["dmi.vendor"])
+	require.Equal(t, "X11SSH-F", facts["dmi.product"])
+	require.Equal(t, "0123456789", facts["dmi.serial"])
+	require.Equal(t, "4000787030016", facts["disk.ada1.size_bytes"])
+	require.Equal(t, "500107862016", facts["disk.ada0.size_bytes"])
+	require.Equal(t, "ufs", facts["filesystem./.type"])
+	require.NotContains(t, facts, "filesystem./dev.type")
+
+	require.Equal(t, [][]string{{"-i", "hw.model", "hw.ncpu", "hw.physmem", "kern.boottime", "kern.vm_guest", "security.jail.jailed", "kern.disks"}}, exec.Recorder[sysctl])
+	require.Equal(t, [][]string{{"ada1"}, {"ada0"}}, exec.Recorder[diskinfo])
+}
+
+func TestCollectExternal(t *testing.T) {
+	dir := t.TempDir()
+
+	writeScript := func(name, script string, mode os.FileMode) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), mode))
+	}
+	writeScript("rack", "echo 'rack=r12'\necho '# comment'\necho\necho 'row = b'\n", 0o755)
+	writeScript("app.json", `echo '{"app": {"version": "1.2.3", "replicas": 3, "tags": ["a", "b"]}, "cpu.count": "override"}'`, 0o755)
+	writeScript("not-executable", "echo 'ignored=true'\n", 0o644)
+	writeScript("broken", "exit 1\n", 0o755)
+	writeScript("garbage", "echo 'no separator'\n", 0o755)
+
+	c := New(testLogger, &execs.ExecHandlerCommon{}, dir, 0)
+	c.goos = "linux"
+	c.root = t.TempDir()
+
+	facts := c.Collect(context.Background())
+
+	require.Equal(t, "r12", facts["rack"])
+	require.Equal(t, "b", facts["row"])
+	require.Equal(t, "1.2.3", facts["app.version"])
+	require.Equal(t, "3", facts["app.replicas"])
+	require.Equal(t, `["a","b"]`, facts["app.tags"])
+	require.Equal(t, "override", facts["cpu.count"], "external facts win")
+	require.NotContains(t, facts, "ignored")
+}
+
+func TestCollectExternalTTL(t *testing.T) {
+	dir := t.TempDir()
+	rack := filepath.Join(dir, "rack")
+	require.NoError(t, os.WriteFile(rack, []byte("#!/bin/sh\n"), 0o755))
+
+	exec := &handler.MockExecHandler{Output: []string{"rack=r12\n", "rack=r13\n"}}
+	c := New(testLogger, exec, dir, time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	require.Equal(t, "r12", c.externalFacts(context.Background())["rack"])
+
+	// The facts are reused until they are older than the TTL.
+	now = now.Add(30 * time.Second)
+	require.Equal(t, "r12", c.externalFacts(context.Background())["rack"])
+	require.Len(t, exec.Recorder[rack], 1)
+
+	now = now.Add(time.Minute)
+	require.Equal(t, "r13", c.externalFacts(context.Background())["rack"])
+	require.Len(t, exec.Recorder[rack], 2)
+
+	// Nothing runs once the time for all executables is used up.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Empty(t, c.collectExternal(ctx))
+	require.Len(t, exec.Recorder[rack], 2)
+}
+
+func TestParseExternal(t *testing.T) {
+	_, err := parseExternal(`{"broken"`)
+	require.Error(t, err)
+
+	facts, err := parseExternal("")
+	require.NoError(t, err)
+	require.Empty(t, facts)
+
+	facts, err = parseExternal("a=b=c\n")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "b=c"}, facts)
+}
@@ -0,0 +1,133 @@
+package facts
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sysctl         = "/sbin/sysctl"
+	kenv           = "/bin/kenv"
+	freebsdVersion = "/bin/freebsd-version"
+	diskinfo       = "/usr/sbin/diskinfo"
+	mount          = "/sbin/mount"
+)
+
+var freebsdSysctls = []string{
+	"hw.model",
+	"hw.ncpu",
+	"hw.physmem",
+	"kern.boottime",
+	"kern.vm_guest",
+	"security.jail.jailed",
+	"kern.disks",
+}
+
+// boottimeSec matches the seconds of kern.boottime, e.g.
+// "{ sec = 1712345678, usec = 123456 } Fri Apr  5 19:34:38 2024".
+var boottimeSec = regexp.MustCompile(`sec = (\d+)`)
+
+func (c *Collector) collectFreeBSD(ctx context.Context, facts map[string]string) {
+	ctx, span := tracer.Start(ctx, "collectFreeBSD")
+	defer span.End()
+
+	// -i ignores sysctls which do not exist, e.g. on older releases.
+	output, _, err := c.exec.RunCommand(ctx, sysctl, append([]string{"-i"}, freebsdSysctls...)...)
+	if err != nil {
+		c.logger.Warn("failed to read sysctls", "err", err)
+	}
+	values := parseSysctl(output)
+
+	if v := values["hw.model"]; v != "" {
+		facts["cpu.model"] = v
+	}
+	if v := values["hw.ncpu"]; v != "" {
+		facts["cpu.count"] = v
+	}
+	if v := values["hw.physmem"]; v != "" {
+		facts["memory.total_bytes"] = v
+	}
+	if m := boottimeSec.FindStringSubmatch(values["kern.boottime"]); m != nil {
+		if sec, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			facts["boot_time"] = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	if v := values["kern.vm_guest"]; v != "" {
+		facts["virtualization"] = v
+	}
+	facts["container"] = "none"
+	if values["security.jail.jailed"] == "1" {
+		facts["container"] = "jail"
+	}
+	facts["init"] = "rc"
+
+	// freebsd-version prints the installed kernel, then the running kernel.
+	if output, _, err := c.exec.RunCommand(ctx, freebsdVersion, "-k", "-r"); err == nil {
+		lines := strings.Fields(output)
+		if len(lines) == 2 {
+			setKernel(facts, lines[1], lines[0])
+		}
+	}
+
+	if output, _, err := c.exec.RunCommand(ctx, kenv); err == nil {
+		env := parseKenv(output)
+		for fact, key := range map[string]string{
+			"dmi.vendor":  "smbios.system.maker",
+			"dmi.product": "smbios.system.product",
+			"dmi.serial":  "smbios.system.serial",
+		} {
+			if v := env[key]; v != "" {
+				facts[fact] = v
+			}
+		}
+	}
+
+	for _, disk := range strings.Fields(values["kern.disks"]) {
+		// diskinfo prints the name, sector size and media size in bytes.
+		output, _, err := c.exec.RunCommand(ctx, diskinfo, disk)
+		if err != nil {
+			continue
+		}
+		if fields := strings.Fields(output); len(fields) >= 3 {
+			facts["disk."+disk+".size_bytes"] = fields[2]
+		}
+	}
+
+	// mount -p prints the mounted filesystems in fstab format.
+	if output, _, err := c.exec.RunCommand(ctx, mount, "-p"); err == nil {
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || isPseudoFilesystem(fields[2]) {
+				continue
+			}
+			c.addFilesystem(facts, fields[1], fields[2])
+		}
+	}
+}
+
+// parseSysctl parses "name: value" lines.
+func parseSysctl(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ": ")
+		if ok {
+			values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// parseKenv parses the key="value" lines printed by kenv.
+func parseKenv(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	return values
+}
@@ -0,0 +1,208 @@
+package facts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *Collector) collectLinux(ctx context.Context, facts map[string]string) {
+	ctx, span := tracer.Start(ctx, "collectLinux")
+	defer span.End()
+
+	if cpuinfo, ok := c.readFile("proc/cpuinfo"); ok {
+		count := 0
+		for _, line := range strings.Split(cpuinfo, "\n") {
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			switch key {
+			case "processor":
+				count++
+			case "model name", "Model":
+				// x86 has a model name per processor; ARM boards a single Model.
+				if _, ok := facts["cpu.model"]; !ok {
+					facts["cpu.model"] = value
+				}
+			}
+		}
+		if count > 0 {
+			facts["cpu.count"] = strconv.Itoa(count)
+		}
+	}
+
+	if meminfo, ok := c.readFile("proc/meminfo"); ok {
+		for _, line := range strings.Split(meminfo, "\n") {
+			if v, found := strings.CutPrefix(line, "MemTotal:"); found {
+				fields := strings.Fields(v)
+				if len(fields) == 0 {
+					continue
+				}
+				if kb, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+					facts["memory.total_bytes"] = strconv.FormatUint(kb*1024, 10)
+				}
+			}
+		}
+	}
+
+	if stat, ok := c.readFile("proc/stat"); ok {
+		for _, line := range strings.Split(stat, "\n") {
+			if v, found := strings.CutPrefix(line, "btime "); found {
+				if sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+					facts["boot_time"] = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+				}
+			}
+		}
+	}
+
+	c.linuxDisks(facts)
+	c.linuxFilesystems(facts)
+
+	running, _ := c.readFile("proc/sys/kernel/osrelease")
+	setKernel(facts, running, c.linuxInstalledKernel())
+
+	facts["init"] = c.linuxInit()
+	facts["virtualization"] = c.linuxVirtualization(ctx)
+	facts["container"] = c.linuxContainer()
+
+	for fact, file := range map[string]string{
+		"dmi.vendor":  "sys/class/dmi/id/sys_vendor",
+		"dmi.product": "sys/class/dmi/id/product_name",
+		"dmi.serial":  "sys/class/dmi/id/product_serial",
+	} {
+		if v, ok := c.readFile(file); ok && v != "" {
+			facts[fact] = v
+		}
+	}
+	// Boards without DMI, e.g. a Raspberry Pi, describe themselves in the
+	// device tree.
+	if _, ok := facts["dmi.product"]; !ok {
+		if v, ok := c.readFile("sys/firmware/devicetree/base/model"); ok && v != "" {
+			facts["dmi.product"] = v
+		}
+	}
+	if _, ok := facts["dmi.serial"]; !ok {
+		if v, ok := c.readFile("sys/firmware/devicetree/base/serial-number"); ok && v != "" {
+			facts["dmi.serial"] = v
+		}
+	}
+}
+
+// linuxDisks records the size of each block device which is not virtual.
+func (c *Collector) linuxDisks(facts map[string]string) {
+	entries, err := os.ReadDir(filepath.Join(c.root, "sys/block"))
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		size, ok := c.readFile(filepath.Join("sys/block", name, "size"))
+		if !ok {
+			continue
+		}
+		sectors, err := strconv.ParseUint(size, 10, 64)
+		if err != nil || sectors == 0 {
+			continue
+		}
+		// The size is always in 512 byte sectors, whatever the device uses.
+		facts["disk."+name+".size_bytes"] = strconv.FormatUint(sectors*512, 10)
+	}
+}
+
+func (c *Collector) linuxFilesystems(facts map[string]string) {
+	mounts, ok := c.readFile("proc/mounts")
+	if !ok {
+		return
+	}
+
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || isPseudoFilesystem(fields[2]) {
+			continue
+		}
+		// Spaces in mount points are escaped as \040.
+		c.addFilesystem(facts, strings.ReplaceAll(fields[1], `\040`, " "), fields[2])
+	}
+}
+
+// linuxInstalledKernel returns the most recently installed kernel, by the
+// modification time of its modules directory.
+func (c *Collector) linuxInstalledKernel() string {
+	entries, err := os.ReadDir(filepath.Join(c.root, "lib/modules"))
+	if err != nil {
+		return ""
+	}
+
+	var (
+		newest  string
+		newestT time.Time
+	)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if info.ModTime().After(newestT) {
+			newest, newestT = e.Name(), info.ModTime()
+		}
+	}
+	return newest
+}
+
+func (c *Collector) linuxInit() string {
+	switch {
+	case c.exists("run/systemd/system"):
+		return "systemd"
+	case c.exists("run/openrc"):
+		return "openrc"
+	case c.exists("run/runit") || c.exists("etc/runit/runsvdir"):
+		return "runit"
+	}
+	return "unknown"
+}
+
+// linuxVirtualization returns the hypervisor the node runs on, or "none".
+func (c *Collector) linuxVirtualization(ctx context.Context) string {
+	output, code, err := c.exec.RunCommand(ctx, "systemd-detect-virt", "--vm")
+	if err == nil && code == 0 && strings.TrimSpace(output) != "" {
+		return strings.TrimSpace(output)
+	}
+
+	// Without systemd, the hypervisor CPU flag at least tells a guest apart.
+	if cpuinfo, ok := c.readFile("proc/cpuinfo"); ok {
+		for _, line := range strings.Split(cpuinfo, "\n") {
+			if strings.HasPrefix(line, "flags") && strings.Contains(line, " hypervisor") {
+				return "unknown"
+			}
+		}
+	}
+	return "none"
+}
+
+// linuxContainer returns the container runtime the node runs in, or "none".
+func (c *Collector) linuxContainer() string {
+	switch {
+	case c.exists(".dockerenv"):
+		return "docker"
+	case c.exists("run/.containerenv"):
+		return "podman"
+	}
+
+	if cgroup, ok := c.readFile("proc/1/cgroup"); ok {
+		for _, runtime := range []string{"kubepods", "docker", "lxc"} {
+			if strings.Contains(cgroup, "/"+runtime) {
+				return runtime
+			}
+		}
+	}
+	return "none"
+}
@@ -0,0 +1,237 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Setting is a single key managed within an ini or keyvalue file.  Section is
+// only meaningful for ini files; an empty Section refers to the keys before
+// the first section header.
+type Setting struct {
+	Section string
+	Key     string
+	Value   string
+	Absent  bool
+}
+
+// EnsureLine returns content with line present, or with matching lines
+// removed when absent is set.  When expr is not empty, the last line matching
+// it is replaced by line, and every matching line is removed when absent.
+// Without expr only lines equal to line are considered.  Content that already
+// satisfies the request is returned unchanged.
+func EnsureLine(content []byte, line, expr string, absent bool) ([]byte, error) {
+	match := func(l string) bool { return l == line }
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", expr, err)
+		}
+		match = re.MatchString
+	}
+
+	lines := splitLines(content)
+
+	if absent {
+		kept := lines[:0:0]
+		for _, l := range lines {
+			if !match(l) {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == len(lines) {
+			return content, nil
+		}
+		return joinLines(kept), nil
+	}
+
+	if slices.Contains(lines, line) {
+		return content, nil
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		if match(lines[i]) {
+			lines[i] = line
+			return joinLines(lines), nil
+		}
+	}
+
+	return joinLines(append(lines, line)), nil
+}
+
+var keyValueLine = regexp.MustCompile(`^(\s*(?:export\s+)?)([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// EnsureKeyValues returns content, a shell style KEY=value file such as
+// rc.conf or /etc/default/*, with settings applied.  Values are written
+// double quoted, but an existing value is compared after unquoting so that
+// KEY=yes and KEY="yes" are considered equal and left untouched.  Comments and
+// unmanaged keys are preserved.
+func EnsureKeyValues(content []byte, settings []Setting) ([]byte, error) {
+	lines := splitLines(content)
+	changed := false
+
+	for _, s := range settings {
+		if !keyValueLine.MatchString(s.Key + "=") {
+			return nil, fmt.Errorf("invalid key %q", s.Key)
+		}
+
+		if s.Absent {
+			kept := lines[:0:0]
+			for _, l := range lines {
+				if m := keyValueLine.FindStringSubmatch(l); m != nil && m[2] == s.Key {
+					changed = true
+					continue
+				}
+				kept = append(kept, l)
+			}
+			lines = kept
+			continue
+		}
+
+		// The shell uses the last assignment, so that is the one we manage.
+		found := false
+		for i := len(lines) - 1; i >= 0; i-- {
+			m := keyValueLine.FindStringSubmatch(lines[i])
+			if m == nil || m[2] != s.Key {
+				continue
+			}
+			found = true
+			if unquoteShellValue(m[3]) != s.Value {
+				lines[i] = m[1] + s.Key + "=" + quoteShellValue(s.Value)
+				changed = true
+			}
+			break
+		}
+
+		if !found {
+			lines = append(lines, s.Key+"="+quoteShellValue(s.Value))
+			changed = true
+		}
+	}
+
+	if !changed {
+		return content, nil
+	}
+
+	return joinLines(lines), nil
+}
+
+// LookupKeyValue returns the unquoted value of the last assignment of key in
+// content, a shell style KEY=value file, and whether it is assigned.
+func LookupKeyValue(content []byte, key string) (string, bool) {
+	lines := splitLines(content)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := keyValueLine.FindStringSubmatch(lines[i]); m != nil && m[2] == key {
+			return unquoteShellValue(m[3]), true
+		}
+	}
+	return "", false
+}
+
+// EnsureINI returns content, an ini file, with settings applied.  The file is
+// only re-serialised when a setting actually differs, so formatting of a file
+// which is already correct is never disturbed.
+func EnsureINI(content []byte, settings []Setting) ([]byte, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{SpaceBeforeInlineComment: true}, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ini: %w", err)
+	}
+
+	changed := false
+	for _, s := range settings {
+		name := s.Section
+		if name == "" {
+			name = ini.DefaultSection
+		}
+
+		if s.Absent {
+			if sec, secErr := cfg.GetSection(name); secErr == nil && sec.HasKey(s.Key) {
+				sec.DeleteKey(s.Key)
+				changed = true
+			}
+			continue
+		}
+
+		sec := cfg.Section(name)
+		if sec.HasKey(s.Key) && sec.Key(s.Key).Value() == s.Value {
+			continue
+		}
+		sec.Key(s.Key).SetValue(s.Value)
+		changed = true
+	}
+
+	if !changed {
+		return content, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write ini: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func splitLines(content []byte) []string {
+	s := strings.TrimSuffix(string(content), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// quoteShellValue double quotes v, escaping the characters which remain
+// special inside double quotes.
+func quoteShellValue(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	return `"` + r.Replace(v) + `"`
+}
+
+// unquoteShellValue returns the value of the right hand side of a shell
+// assignment, ignoring any trailing comment.
+func unquoteShellValue(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	switch raw[0] {
+	case '\'':
+		if end := strings.IndexByte(raw[1:], '\''); end >= 0 {
+			return raw[1 : end+1]
+		}
+		return raw[1:]
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			if c == '\\' && i+1 < len(raw) && strings.IndexByte("\\\"$`", raw[i+1]) >= 0 {
+				b.WriteByte(raw[i+1])
+				i++
+				continue
+			}
+			if c == '"' {
+				break
+			}
+			b.WriteByte(c)
+		}
+		return b.String()
+	}
+
+	if i := strings.IndexAny(raw, " \t"); i >= 0 {
+		return raw[:i]
+	}
+	return raw
+}
@@ -0,0 +1,186 @@
+package files
+
+import (
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureLine(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		line     string
+		expr     string
+		absent   bool
+		expected string
+	}{
+		{
+			name:     "append to empty",
+			line:     "PermitRootLogin no",
+			expected: "PermitRootLogin no\n",
+		},
+		{
+			name:     "append missing trailing newline",
+			content:  "a",
+			line:     "b",
+			expected: "a\nb\n",
+		},
+		{
+			name:     "already present",
+			content:  "a\nPermitRootLogin no\nb",
+			line:     "PermitRootLogin no",
+			expr:     "^#?PermitRootLogin",
+			expected: "a\nPermitRootLogin no\nb",
+		},
+		{
+			name:     "replace last match",
+			content:  "#PermitRootLogin yes\nx\nPermitRootLogin yes\n",
+			line:     "PermitRootLogin no",
+			expr:     "^#?PermitRootLogin",
+			expected: "#PermitRootLogin yes\nx\nPermitRootLogin no\n",
+		},
+		{
+			name:     "remove by regexp",
+			content:  "a\nPermitRootLogin yes\n#PermitRootLogin no\nb\n",
+			expr:     "^#?PermitRootLogin",
+			absent:   true,
+			expected: "a\nb\n",
+		},
+		{
+			name:     "remove exact line",
+			content:  "a\nb\na\n",
+			line:     "a",
+			absent:   true,
+			expected: "b\n",
+		},
+		{
+			name:     "remove missing",
+			content:  "a\nb",
+			line:     "c",
+			absent:   true,
+			expected: "a\nb",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := EnsureLine([]byte(tc.content), tc.line, tc.expr, tc.absent)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(out))
+		})
+	}
+
+	_, err := EnsureLine(nil, "a", "(", false)
+	require.Error(t, err)
+}
+
+func TestEnsureKeyValues(t *testing.T) {
+	rcConf := `# rc.conf
+hostname="host1"
+sshd_enable=YES
+ntpd_enable="NO" # disabled for now
+export PATH='/bin'
+`
+
+	cases := []struct {
+		name     string
+		settings []Setting
+		expected string
+	}{
+		{
+			name: "unchanged after unquoting",
+			settings: []Setting{
+				{Key: "hostname", Value: "host1"},
+				{Key: "sshd_enable", Value: "YES"},
+				{Key: "ntpd_enable", Value: "NO"},
+				{Key: "PATH", Value: "/bin"},
+			},
+			expected: rcConf,
+		},
+		{
+			name:     "replace value",
+			settings: []Setting{{Key: "ntpd_enable", Value: "YES"}},
+			expected: "# rc.conf\nhostname=\"host1\"\nsshd_enable=YES\nntpd_enable=\"YES\"\nexport PATH='/bin'\n",
+		},
+		{
+			name:     "keep export prefix",
+			settings: []Setting{{Key: "PATH", Value: "/bin:$HOME/bin"}},
+			expected: "# rc.conf\nhostname=\"host1\"\nsshd_enable=YES\nntpd_enable=\"NO\" # disabled for now\nexport PATH=\"/bin:\\$HOME/bin\"\n",
+		},
+		{
+			name:     "append and remove",
+			settings: []Setting{{Key: "sshd_enable", Absent: true}, {Key: "zfs_enable", Value: "YES"}},
+			expected: "# rc.conf\nhostname=\"host1\"\nntpd_enable=\"NO\" # disabled for now\nexport PATH='/bin'\nzfs_enable=\"YES\"\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := EnsureKeyValues([]byte(rcConf), tc.settings)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(out))
+		})
+	}
+
+	_, err := EnsureKeyValues(nil, []Setting{{Key: "bad key", Value: "x"}})
+	require.Error(t, err)
+
+	// Round trip of values needing escapes.
+	out, err := EnsureKeyValues(nil, []Setting{{Key: "ARGS", Value: `-a "b" \c`}})
+	require.NoError(t, err)
+	out2, err := EnsureKeyValues(out, []Setting{{Key: "ARGS", Value: `-a "b" \c`}})
+	require.NoError(t, err)
+	require.Equal(t, string(out), string(out2))
+}
+
+func TestLookupKeyValue(t *testing.T) {
+	content := []byte("# options\nOPTS=-a\nexport OPTS=\"-p 2222\"\nOTHER='x'\n")
+
+	value, ok := LookupKeyValue(content, "OPTS")
+	require.True(t, ok)
+	require.Equal(t, "-p 2222", value)
+
+	value, ok = LookupKeyValue(content, "OTHER")
+	require.True(t, ok)
+	require.Equal(t, "x", value)
+
+	_, ok = LookupKeyValue(content, "MISSING")
+	require.False(t, ok)
+	_, ok = LookupKeyValue(nil, "OPTS")
+	require.False(t, ok)
+}
+
+func TestEnsureINI(t *testing.T) {
+	content := []byte(`top = 1
+
+[main]
+; comment
+gpgcheck = 1
+installonly_limit = 3
+`)
+
+	out, err := EnsureINI(content, []Setting{
+		{Key: "top", Value: "1"},
+		{Section: "main", Key: "gpgcheck", Value: "1"},
+		{Section: "other", Key: "missing", Absent: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, string(content), string(out), "content which already matches must not be rewritten")
+
+	out, err = EnsureINI(content, []Setting{
+		{Section: "main", Key: "gpgcheck", Value: "0"},
+		{Section: "main", Key: "installonly_limit", Absent: true},
+		{Section: "extra", Key: "enabled", Value: "true"},
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(out), "; comment")
+
+	cfg, err := ini.Load(out)
+	require.NoError(t, err)
+	require.Equal(t, "1", cfg.Section("").Key("top").String())
+	require.Equal(t, "0", cfg.Section("main").Key("gpgcheck").String())
+	require.False(t, cfg.Section("main").HasKey("installonly_limit"))
+	require.Equal(t, "true", cfg.Section("extra").Key("enabled").String())
+}
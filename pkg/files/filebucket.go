@@ -0,0 +1,250 @@
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fileBucketHoldsFile lists the paths whose enforcement is paused, at the
+// root of the filebucket.
+const fileBucketHoldsFile = "held.json"
+
+// FileBucketEntry is a blob in the filebucket with its sidecar metadata.
+type FileBucketEntry struct {
+	Hash string
+	Meta FileBucketMeta
+}
+
+// FileHold records that enforcement of a path is paused, because it was
+// restored from the filebucket by hand.
+type FileHold struct {
+	Hash  string `json:"hash"`
+	Since string `json:"since"`
+}
+
+// ListFileBucket returns the blobs in the filebucket which have metadata,
+// optionally only those backed up from path, sorted by path and then newest
+// first.
+func ListFileBucket(bucketPath, path string) ([]FileBucketEntry, error) {
+	var entries []FileBucketEntry
+
+	err := filepath.WalkDir(bucketPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == bucketPath {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() || filepath.Ext(p) != ".meta" {
+			return nil
+		}
+
+		hash, ok := blobHash(bucketPath, strings.TrimSuffix(p, ".meta"))
+		if !ok {
+			return nil
+		}
+
+		meta, err := readFileBucketMeta(p)
+		if err != nil || (path != "" && meta.Path != path) {
+			return nil
+		}
+
+		entries = append(entries, FileBucketEntry{Hash: hash, Meta: meta})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("filebucket: list %s: %w", bucketPath, err)
+	}
+
+	slices.SortFunc(entries, func(a, b FileBucketEntry) int {
+		if c := strings.Compare(a.Meta.Path, b.Meta.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Meta.BackedUpAt, a.Meta.BackedUpAt)
+	})
+
+	return entries, nil
+}
+
+// ResolveFileBucketHash returns the full hash of the blob whose hash starts
+// with prefix.  At least four characters are required.
+func ResolveFileBucketHash(bucketPath, prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 {
+		return "", fmt.Errorf("filebucket: hash prefix %q is too short", prefix)
+	}
+
+	dir := filepath.Join(bucketPath, prefix[0:2], prefix[2:4])
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("filebucket: %w", err)
+	}
+
+	var matches []string
+	for _, e := range dirEntries {
+		hash := prefix[0:4] + e.Name()
+		if e.IsDir() || filepath.Ext(e.Name()) == ".meta" || !strings.HasPrefix(hash, prefix) {
+			continue
+		}
+		matches = append(matches, hash)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("filebucket: no blob matches %q", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("filebucket: %q is ambiguous, matching %d blobs", prefix, len(matches))
+	}
+}
+
+// ReadFileBucket returns the content and metadata of the blob with hash.
+func ReadFileBucket(bucketPath, hash string) ([]byte, FileBucketMeta, error) {
+	blobPath := FileBucketBlobPath(bucketPath, hash)
+
+	data, err := os.ReadFile(blobPath)
+	if err != nil {
+		return nil, FileBucketMeta{}, fmt.Errorf("filebucket: read blob: %w", err)
+	}
+
+	meta, err := readFileBucketMeta(blobPath + ".meta")
+	if err != nil {
+		return nil, FileBucketMeta{}, fmt.Errorf("filebucket: read meta: %w", err)
+	}
+
+	return data, meta, nil
+}
+
+// RestoreFromFileBucket writes the blob with hash back to the path it was
+// backed up from, with its original mode and ownership.
+func RestoreFromFileBucket(bucketPath, hash string) (FileBucketMeta, error) {
+	data, meta, err := ReadFileBucket(bucketPath, hash)
+	if err != nil {
+		return meta, err
+	}
+
+	mode := os.FileMode(0o644)
+	if meta.Mode != "" {
+		if mode, err = GetFileModeFromString(context.Background(), meta.Mode); err != nil {
+			return meta, fmt.Errorf("filebucket: invalid mode %q: %w", meta.Mode, err)
+		}
+	}
+
+	if err := os.WriteFile(meta.Path, data, mode); err != nil {
+		return meta, fmt.Errorf("filebucket: restore %s: %w", meta.Path, err)
+	}
+	// WriteFile leaves the mode of an existing file alone.
+	if err := os.Chmod(meta.Path, mode); err != nil {
+		return meta, fmt.Errorf("filebucket: chmod %s: %w", meta.Path, err)
+	}
+	if err := os.Chown(meta.Path, int(meta.UID), int(meta.GID)); err != nil {
+		return meta, fmt.Errorf("filebucket: chown %s: %w", meta.Path, err)
+	}
+
+	return meta, nil
+}
+
+// FileBucketHolds returns the paths whose enforcement is paused.
+func FileBucketHolds(bucketPath string) (map[string]FileHold, error) {
+	holds := make(map[string]FileHold)
+	if bucketPath == "" {
+		return holds, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(bucketPath, fileBucketHoldsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return holds, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filebucket: read holds: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &holds); err != nil {
+		return nil, fmt.Errorf("filebucket: parse holds: %w", err)
+	}
+
+	return holds, nil
+}
+
+// HoldFile pauses enforcement of path, which was restored from the blob with
+// hash.
+func HoldFile(bucketPath, path, hash string) error {
+	holds, err := FileBucketHolds(bucketPath)
+	if err != nil {
+		return err
+	}
+
+	holds[path] = FileHold{Hash: hash, Since: time.Now().UTC().Format(time.RFC3339)}
+	return writeFileBucketHolds(bucketPath, holds)
+}
+
+// ReleaseFile resumes enforcement of path.  It reports whether path was held.
+func ReleaseFile(bucketPath, path string) (bool, error) {
+	holds, err := FileBucketHolds(bucketPath)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := holds[path]; !ok {
+		return false, nil
+	}
+
+	delete(holds, path)
+	return true, writeFileBucketHolds(bucketPath, holds)
+}
+
+func writeFileBucketHolds(bucketPath string, holds map[string]FileHold) error {
+	if err := os.MkdirAll(bucketPath, 0o700); err != nil {
+		return fmt.Errorf("filebucket: create dir %s: %w", bucketPath, err)
+	}
+
+	data, err := json.MarshalIndent(holds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so that the controller never reads a partial file.
+	tmp := filepath.Join(bucketPath, fileBucketHoldsFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("filebucket: write holds: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(bucketPath, fileBucketHoldsFile))
+}
+
+func readFileBucketMeta(path string) (FileBucketMeta, error) {
+	var meta FileBucketMeta
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// blobHash returns the hash of the blob at blobPath, which must be in the
+// <hash[0:2]>/<hash[2:4]>/<hash[4:]> layout beneath bucketPath.
+func blobHash(bucketPath, blobPath string) (string, bool) {
+	rel, err := filepath.Rel(bucketPath, blobPath)
+	if err != nil {
+		return "", false
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 3 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return "", false
+	}
+
+	hash := strings.Join(parts, "")
+	return hash, sha256Hex.MatchString(hash)
+}
@@ -0,0 +1,133 @@
+package files
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFileBucket(t *testing.T) {
+	bucket := t.TempDir()
+	srcDir := t.TempDir()
+	nginx := filepath.Join(srcDir, "nginx.conf")
+	sshd := filepath.Join(srcDir, "sshd_config")
+
+	save := func(path, content, backedUpAt string) string {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o640))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		hash, err := SaveToFileBucket(bucket, path, []byte(content), info)
+		require.NoError(t, err)
+
+		// Pin the backup time so that the order is deterministic.
+		metaPath := FileBucketBlobPath(bucket, hash) + ".meta"
+		meta, err := readFileBucketMeta(metaPath)
+		require.NoError(t, err)
+		meta.BackedUpAt = backedUpAt
+		data, err := json.Marshal(meta)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(metaPath, data, 0o600))
+		return hash
+	}
+
+	first := save(nginx, "worker_processes 1;\n", "2026-01-01T00:00:00Z")
+	second := save(nginx, "worker_processes 2;\n", "2026-02-01T00:00:00Z")
+	other := save(sshd, "Port 22\n", "2026-01-15T00:00:00Z")
+
+	// Neither the holds list nor stray files are blobs.
+	require.NoError(t, HoldFile(bucket, nginx, first))
+	require.NoError(t, os.WriteFile(filepath.Join(bucket, "stray.meta"), []byte("{}"), 0o600))
+
+	entries, err := ListFileBucket(bucket, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, []string{second, first, other}, []string{entries[0].Hash, entries[1].Hash, entries[2].Hash})
+	require.Equal(t, "0640", entries[0].Meta.Mode)
+
+	entries, err = ListFileBucket(bucket, sshd)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, other, entries[0].Hash)
+
+	_, err = ListFileBucket(filepath.Join(bucket, "missing"), "")
+	require.Error(t, err)
+
+	hash, err := ResolveFileBucketHash(bucket, other[:8])
+	require.NoError(t, err)
+	require.Equal(t, other, hash)
+
+	_, err = ResolveFileBucketHash(bucket, other[:3])
+	require.ErrorContains(t, err, "too short")
+
+	_, err = ResolveFileBucketHash(bucket, "0000000000")
+	require.ErrorContains(t, err, "no blob matches")
+}
+
+func TestRestoreFromFileBucket(t *testing.T) {
+	bucket := t.TempDir()
+	path := filepath.Join(t.TempDir(), "app.conf")
+
+	previous := []byte("previous\n")
+	require.NoError(t, os.WriteFile(path, previous, 0o600))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	hash, err := SaveToFileBucket(bucket, path, previous, info)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("current\n"), 0o600))
+	require.NoError(t, os.Chmod(path, 0o644))
+
+	meta, err := RestoreFromFileBucket(bucket, hash)
+	require.NoError(t, err)
+	require.Equal(t, path, meta.Path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, previous, data)
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileBucketHolds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	bucket := t.TempDir()
+
+	holds, err := FileBucketHolds(bucket)
+	require.NoError(t, err)
+	require.Empty(t, holds)
+
+	require.NoError(t, HoldFile(bucket, "/etc/app.conf", "abcd"))
+	holds, err = FileBucketHolds(bucket)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", holds["/etc/app.conf"].Hash)
+	require.NotEmpty(t, holds["/etc/app.conf"].Since)
+
+	// GC leaves the holds list alone however old it is.
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(bucket, fileBucketHoldsFile), past, past))
+	require.NoError(t, GCFileBucket(bucket, time.Hour, logger))
+
+	released, err := ReleaseFile(bucket, "/etc/app.conf")
+	require.NoError(t, err)
+	require.True(t, released)
+
+	released, err = ReleaseFile(bucket, "/etc/app.conf")
+	require.NoError(t, err)
+	require.False(t, released)
+
+	holds, err = FileBucketHolds(bucket)
+	require.NoError(t, err)
+	require.Empty(t, holds)
+
+	holds, err = FileBucketHolds("")
+	require.NoError(t, err)
+	require.Empty(t, holds)
+}
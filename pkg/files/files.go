@@ -24,12 +24,15 @@ import (
 type FileEnsure int64
 
 var EnsureByName map[string]FileEnsure = map[string]FileEnsure{
-	"unhandled": UnhandledFileEnsure,
-	"file":      File,
-	"directory": Directory,
-	"symlink":   Symlink,
-	"absent":    Absent,
-	"":          File, // Default to File if empty string
+	"unhandled":  UnhandledFileEnsure,
+	"file":       File,
+	"directory":  Directory,
+	"symlink":    Symlink,
+	"absent":     Absent,
+	"lineinfile": LineInFile,
+	"ini":        INI,
+	"keyvalue":   KeyValue,
+	"":           File, // Default to File if empty string
 }
 
 const (
@@ -38,6 +41,9 @@ const (
 	Directory
 	Symlink
 	Absent
+	LineInFile
+	INI
+	KeyValue
 )
 
 func (f FileEnsure) String() string {
@@ -52,10 +58,22 @@ func (f FileEnsure) String() string {
 		return "symlink"
 	case Absent:
 		return "absent"
+	case LineInFile:
+		return "lineinfile"
+	case INI:
+		return "ini"
+	case KeyValue:
+		return "keyvalue"
 	}
 	return "unhandled"
 }
 
+// Partial reports whether the ensure mode manages only part of a file, so
+// that several ConfigSets may manage different keys of the same path.
+func (f FileEnsure) Partial() bool {
+	return f == LineInFile || f == INI || f == KeyValue
+}
+
 func FileEnsureFromString(ensure string) FileEnsure {
 	if f, ok := EnsureByName[ensure]; ok {
 		return f
@@ -242,6 +260,86 @@ func (h *FileHandlerCommon) WriteContentFile(ctx context.Context, path string, d
 	return true, nil
 }
 
+func (h *FileHandlerCommon) ReplaceContentFile(ctx context.Context, path string, data []byte) (bool, error) {
+	var err error
+	_, span := tracer.Start(ctx, "ReplaceContentFile")
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	span.SetAttributes(attribute.String("path", path))
+
+	fileBytes, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	dataHash := h.hash(ctx, data)
+	if err == nil && h.hash(ctx, fileBytes) == dataHash {
+		return false, nil
+	}
+
+	h.logger.Info("replacing file", "path", path, "hash", dataHash)
+	// A new file gets the mode os.Create would give it.
+	if err = ReplaceFile(path, data, 0o644); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReplaceFile writes data to a temporary file in the directory of path,
+// which is synced and renamed over path.  The mode and owner of the file it
+// replaces are kept; a new file gets perm.  Readers see either the previous
+// or the new content, never a partial file.
+func ReplaceFile(path string, data []byte, perm os.FileMode) error {
+	mode, uid, gid := perm, -1, -1
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".nodemanager-*")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer func() { _ = tmp.Close() }()
+		if err := tmp.Chmod(mode); err != nil {
+			return err
+		}
+		if err := tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	// Sync the directory so that the rename survives a crash.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
+
 func (h *FileHandlerCommon) Remove(ctx context.Context, path string) (bool, error) {
 	var err error
 	_, span := tracer.Start(ctx, "Remove")
@@ -262,6 +360,59 @@ func (h *FileHandlerCommon) Remove(ctx context.Context, path string) (bool, erro
 	return true, err
 }
 
+// ModeDiffers reports whether the permission bits of path differ from mode.
+// It makes no changes and is used to compute drift without correcting it.
+func ModeDiffers(ctx context.Context, path, mode string) (bool, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to stat file")
+	}
+
+	desiredFileMode, err := GetFileModeFromString(ctx, mode)
+	if err != nil {
+		return false, err
+	}
+
+	return fileInfo.Mode()&os.ModePerm != desiredFileMode&os.ModePerm, nil
+}
+
+// OwnerDiffers reports whether path is owned by a different user or group
+// than requested.  An empty owner or group is not compared.  Like ModeDiffers
+// it makes no changes.
+func OwnerDiffers(path, owner, group string) (bool, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to stat file")
+	}
+
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+
+	if owner != "" {
+		uid, err := lookupUID(owner)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to lookup user")
+		}
+		if uid != strconv.Itoa(int(stat.Uid)) {
+			return true, nil
+		}
+	}
+
+	if group != "" {
+		gid, err := lookupGID(group)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to lookup group")
+		}
+		if gid != strconv.Itoa(int(stat.Gid)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (h *FileHandlerCommon) hash(ctx context.Context, data []byte) string {
 	_, span := tracer.Start(ctx, "hash")
 	defer span.End()
@@ -307,13 +458,12 @@ func SaveToFileBucket(bucketPath, filePath string, data []byte, info os.FileInfo
 	h := sha256.Sum256(data)
 	hash := hex.EncodeToString(h[:])
 
-	dir := filepath.Join(bucketPath, hash[0:2], hash[2:4])
+	blobPath := FileBucketBlobPath(bucketPath, hash)
+	dir := filepath.Dir(blobPath)
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return "", fmt.Errorf("filebucket: create dir %s: %w", dir, err)
 	}
 
-	blobPath := filepath.Join(dir, hash[4:])
-
 	// Idempotent: skip write if blob already exists.
 	if _, err := os.Stat(blobPath); err == nil {
 		return hash, nil
@@ -345,6 +495,12 @@ func SaveToFileBucket(bucketPath, filePath string, data []byte, info os.FileInfo
 	return hash, nil
 }
 
+// FileBucketBlobPath returns the path of the blob with the hex-encoded SHA256
+// hash in the content-addressed store rooted at bucketPath.
+func FileBucketBlobPath(bucketPath, hash string) string {
+	return filepath.Join(bucketPath, hash[0:2], hash[2:4], hash[4:])
+}
+
 // GCFileBucket removes blobs (and their .meta sidecars) from bucketPath whose
 // modification time is older than maxAge. Skips entries that are not regular
 // files and skips .meta files (they are removed together with their blob).
@@ -361,6 +517,10 @@ func GCFileBucket(bucketPath string, maxAge time.Duration, logger *slog.Logger)
 		if filepath.Ext(path) == ".meta" {
 			return nil
 		}
+		// only blobs are collected, not the holds list
+		if _, ok := blobHash(bucketPath, path); !ok {
+			return nil
+		}
 
 		info, err := d.Info()
 		if err != nil {
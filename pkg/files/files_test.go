@@ -3,6 +3,7 @@ package files
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -78,6 +79,50 @@ func TestFiles(t *testing.T) {
 	}
 }
 
+func TestReplaceContentFile(t *testing.T) {
+	ctx := context.Background()
+	h := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), "", "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exporter")
+
+	changed, err := h.ReplaceContentFile(ctx, path, []byte("v1"))
+	require.NoError(t, err)
+	require.True(t, changed)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	changed, err = h.ReplaceContentFile(ctx, path, []byte("v1"))
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// The open file, like a running binary, is replaced rather than
+	// written into, and its mode is kept.
+	require.NoError(t, os.Chmod(path, 0o750))
+	running, err := os.Open(path)
+	require.NoError(t, err)
+	defer running.Close()
+
+	changed, err = h.ReplaceContentFile(ctx, path, []byte("v2"))
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	old, err := io.ReadAll(running)
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(old))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(content))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+}
+
 func TestSaveToFileBucket(t *testing.T) {
 	bucket := t.TempDir()
 	srcDir := t.TempDir()
@@ -170,3 +215,25 @@ func TestGCFileBucket(t *testing.T) {
 	_, err = os.Stat(newBlob)
 	require.NoError(t, err, "recent blob should not be removed")
 }
+
+func TestModeDiffers(t *testing.T) {
+	ctx := context.Background()
+	p := filepath.Join(t.TempDir(), "f")
+	require.NoError(t, os.WriteFile(p, []byte("f"), 0o600))
+	require.NoError(t, os.Chmod(p, 0o600))
+
+	differs, err := ModeDiffers(ctx, p, "0600")
+	require.NoError(t, err)
+	require.False(t, differs)
+
+	differs, err = ModeDiffers(ctx, p, "0644")
+	require.NoError(t, err)
+	require.True(t, differs)
+
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o600), info.Mode(), "ModeDiffers must not change the file")
+
+	_, err = ModeDiffers(ctx, filepath.Join(t.TempDir(), "missing"), "0600")
+	require.Error(t, err)
+}
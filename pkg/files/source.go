@@ -0,0 +1,385 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	ociScheme = "oci://"
+
+	// maxSourceSize caps the size of a downloaded response, since the
+	// content is held in memory until its digest is verified.
+	maxSourceSize = 256 << 20
+)
+
+var sha256Hex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// ociManifestMediaTypes are accepted when resolving an OCI reference.
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// SourceFetcher downloads remote file content and caches it by digest, using
+// the same content-addressed layout as the filebucket.  Content is only
+// downloaded when it is not already cached, and is never returned unless its
+// SHA256 matches.
+type SourceFetcher struct {
+	logger    *slog.Logger
+	client    *http.Client
+	cachePath string
+	maxSize   int64
+}
+
+// NewSourceFetcher returns a SourceFetcher caching into cachePath.  A nil
+// client uses a client with a 10 minute timeout.  Responses larger than 256
+// MiB are refused.
+func NewSourceFetcher(logger *slog.Logger, client *http.Client, cachePath string) *SourceFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &SourceFetcher{logger: logger, client: client, cachePath: cachePath, maxSize: maxSourceSize}
+}
+
+// NormalizeSHA256 returns sum in lower case without a "sha256:" prefix, or an
+// error when it is not a SHA256 hex digest.
+func NormalizeSHA256(sum string) (string, error) {
+	sum = strings.ToLower(strings.TrimPrefix(sum, "sha256:"))
+	if !sha256Hex.MatchString(sum) {
+		return "", fmt.Errorf("invalid sha256 %q", sum)
+	}
+	return sum, nil
+}
+
+// Fetch returns the content of source, which must have the given SHA256.
+// source is an http(s) URL, or an OCI artifact reference of the form
+// oci://registry/repository:tag or oci://registry/repository@digest, whose
+// manifest must contain a layer with the given digest.
+func (f *SourceFetcher) Fetch(ctx context.Context, source, sum string) ([]byte, error) {
+	var err error
+	ctx, span := tracer.Start(ctx, "Fetch")
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	span.SetAttributes(attribute.String("source", source))
+
+	sum, err = NormalizeSHA256(sum)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, ok := f.cached(sum); ok {
+		span.AddEvent("cache hit")
+		return data, nil
+	}
+
+	var data []byte
+	if strings.HasPrefix(source, ociScheme) {
+		data, err = f.fetchOCI(ctx, strings.TrimPrefix(source, ociScheme), sum)
+	} else {
+		data, err = f.fetchHTTP(ctx, source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %q: %w", source, err)
+	}
+
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != sum {
+		err = fmt.Errorf("sha256 mismatch for %q: expected %s, got %s", source, sum, hex.EncodeToString(got[:]))
+		return nil, err
+	}
+
+	f.logger.Info("downloaded file source", "source", source, "sha256", sum, "bytes", len(data))
+
+	if cacheErr := f.store(source, sum, data); cacheErr != nil {
+		f.logger.Warn("failed to cache file source", "source", source, "err", cacheErr)
+	}
+
+	return data, nil
+}
+
+// cached returns the cached content for sum, discarding a blob which no
+// longer matches its digest.
+func (f *SourceFetcher) cached(sum string) ([]byte, bool) {
+	if f.cachePath == "" {
+		return nil, false
+	}
+
+	blobPath := FileBucketBlobPath(f.cachePath, sum)
+	data, err := os.ReadFile(blobPath)
+	if err != nil {
+		return nil, false
+	}
+
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != sum {
+		_ = os.Remove(blobPath)
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (f *SourceFetcher) store(source, sum string, data []byte) error {
+	if f.cachePath == "" {
+		return nil
+	}
+
+	blobPath := FileBucketBlobPath(f.cachePath, sum)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(blobPath, data, 0o600); err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(FileBucketMeta{
+		Path:       source,
+		BackedUpAt: time.Now().UTC().Format(time.RFC3339),
+		Mode:       "0600",
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(blobPath+".meta", meta, 0o600)
+}
+
+func (f *SourceFetcher) fetchHTTP(ctx context.Context, source string) ([]byte, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported source scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.get(req)
+}
+
+// fetchOCI resolves ref to a manifest and downloads the layer with the
+// digest sum.  Registries requiring a token get an anonymous one.
+func (f *SourceFetcher) fetchOCI(ctx context.Context, ref, sum string) ([]byte, error) {
+	registry, repository, reference, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	base := "https://" + registry + "/v2/" + repository
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/manifests/"+reference, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(ociManifestMediaTypes, ", "))
+
+	body, token, err := f.getWithToken(ctx, req, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	digest := "sha256:" + sum
+	found := false
+	for _, l := range manifest.Layers {
+		if l.Digest == digest {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("manifest %s has no layer %s", reference, digest)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, base+"/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err = f.getWithToken(ctx, req, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	return body, nil
+}
+
+// getWithToken performs req, retrying once with an anonymous bearer token if
+// the registry responds 401.  The token used is returned for reuse.
+func (f *SourceFetcher) getWithToken(ctx context.Context, req *http.Request, token string) ([]byte, string, error) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, token, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusUnauthorized && token == "" {
+		token, err = f.anonymousToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, "", err
+		}
+		retry := req.Clone(ctx)
+		retry.Header.Set("Authorization", "Bearer "+token)
+		body, err := f.get(retry)
+		return body, token, err
+	}
+
+	body, err := readBody(resp, f.maxSize)
+	return body, token, err
+}
+
+// anonymousToken requests a token from the realm in a Bearer
+// WWW-Authenticate challenge.
+func (f *SourceFetcher) anonymousToken(ctx context.Context, challenge string) (string, error) {
+	params := parseBearerChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := f.get(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+
+	var resp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse token: %w", err)
+	}
+	if resp.Token != "" {
+		return resp.Token, nil
+	}
+	return resp.AccessToken, nil
+}
+
+func (f *SourceFetcher) get(req *http.Request) ([]byte, error) {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return readBody(resp, f.maxSize)
+}
+
+// readBody reads at most limit bytes of the body of resp, and fails when the
+// body is larger.
+func readBody(resp *http.Response, limit int64) ([]byte, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(resp.Body, limit+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > limit {
+		return nil, fmt.Errorf("response from %s exceeds %d bytes", resp.Request.URL.Redacted(), limit)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseOCIReference splits registry/repository:tag or
+// registry/repository@digest.  The tag defaults to latest.
+func parseOCIReference(ref string) (registry, repository, reference string, err error) {
+	registry, rest, ok := strings.Cut(ref, "/")
+	if !ok || registry == "" || rest == "" {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q: expected registry/repository[:tag|@digest]", ref)
+	}
+
+	if repo, digest, ok := strings.Cut(rest, "@"); ok {
+		return registry, repo, digest, nil
+	}
+
+	// A colon after the last slash separates the tag.
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		return registry, rest[:i], rest[i+1:], nil
+	}
+
+	return registry, rest, "latest", nil
+}
+
+// parseBearerChallenge returns the parameters of a WWW-Authenticate header
+// such as: Bearer realm="https://auth.example/token",service="example".
+func parseBearerChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+
+	scheme, rest, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return params
+	}
+
+	for rest != "" {
+		var key, value string
+		key, rest, ok = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+
+	return params
+}
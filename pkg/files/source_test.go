@@ -0,0 +1,161 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func TestSourceFetcherHTTP(t *testing.T) {
+	content := []byte("#!/bin/sh\necho exporter\n")
+	var requests atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/exporter" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	cache := t.TempDir()
+	f := NewSourceFetcher(slog.Default(), srv.Client(), cache)
+	ctx := context.Background()
+
+	data, err := f.Fetch(ctx, srv.URL+"/exporter", "sha256:"+strings.ToUpper(sum(content)))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.FileExists(t, FileBucketBlobPath(cache, sum(content)))
+
+	// Served from the cache.
+	data, err = f.Fetch(ctx, srv.URL+"/exporter", sum(content))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, int32(1), requests.Load())
+
+	// A corrupted cache entry is discarded and downloaded again.
+	require.NoError(t, os.WriteFile(FileBucketBlobPath(cache, sum(content)), []byte("corrupt"), 0o600))
+	data, err = f.Fetch(ctx, srv.URL+"/exporter", sum(content))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, int32(2), requests.Load())
+
+	_, err = f.Fetch(ctx, srv.URL+"/exporter", sum([]byte("other")))
+	require.ErrorContains(t, err, "sha256 mismatch")
+
+	_, err = f.Fetch(ctx, srv.URL+"/missing", sum([]byte("missing")))
+	require.ErrorContains(t, err, "404")
+
+	_, err = f.Fetch(ctx, srv.URL+"/exporter", "abc")
+	require.ErrorContains(t, err, "invalid sha256")
+
+	_, err = f.Fetch(ctx, "ftp://example.com/exporter", sum([]byte("ftp")))
+	require.ErrorContains(t, err, "unsupported source scheme")
+
+	// A response larger than the limit is refused.
+	f = NewSourceFetcher(slog.Default(), srv.Client(), "")
+	f.maxSize = int64(len(content)) - 1
+	_, err = f.Fetch(ctx, srv.URL+"/exporter", sum(content))
+	require.ErrorContains(t, err, "exceeds")
+
+	f.maxSize = int64(len(content))
+	data, err = f.Fetch(ctx, srv.URL+"/exporter", sum(content))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+}
+
+func TestSourceFetcherStore(t *testing.T) {
+	cache := t.TempDir()
+	f := NewSourceFetcher(slog.Default(), nil, cache)
+	content := []byte("exporter")
+
+	require.NoError(t, f.store("https://example.com/exporter", sum(content), content))
+	require.FileExists(t, FileBucketBlobPath(cache, sum(content))+".meta")
+
+	// A failed write of the metadata is returned.
+	other := []byte("other")
+	require.NoError(t, os.MkdirAll(FileBucketBlobPath(cache, sum(other))+".meta", 0o700))
+	require.Error(t, f.store("https://example.com/other", sum(other), other))
+}
+
+func TestSourceFetcherOCI(t *testing.T) {
+	content := []byte("artifact content")
+	digest := "sha256:" + sum(content)
+
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			require.Equal(t, "repository:tools/exporter:pull", r.URL.Query().Get("scope"))
+			_, _ = w.Write([]byte(`{"token":"anon"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer anon" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:tools/exporter:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/tools/exporter/manifests/v1.2.3":
+			require.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json")
+			_, _ = fmt.Fprintf(w, `{"schemaVersion":2,"layers":[{"digest":"sha256:%s"},{"digest":%q}]}`, strings.Repeat("0", 64), digest)
+		case "/v2/tools/exporter/blobs/" + digest:
+			_, _ = w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	registry := strings.TrimPrefix(srv.URL, "https://")
+	f := NewSourceFetcher(slog.Default(), srv.Client(), "")
+
+	data, err := f.Fetch(context.Background(), "oci://"+registry+"/tools/exporter:v1.2.3", sum(content))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	_, err = f.Fetch(context.Background(), "oci://"+registry+"/tools/exporter:v1.2.3", sum([]byte("not a layer")))
+	require.ErrorContains(t, err, "has no layer")
+}
+
+func TestParseOCIReference(t *testing.T) {
+	cases := []struct {
+		ref        string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{"ghcr.io/org/tool:v1", "ghcr.io", "org/tool", "v1"},
+		{"localhost:5000/tool", "localhost:5000", "tool", "latest"},
+		{"ghcr.io/org/tool@sha256:abc", "ghcr.io", "org/tool", "sha256:abc"},
+	}
+
+	for _, tc := range cases {
+		registry, repository, reference, err := parseOCIReference(tc.ref)
+		require.NoError(t, err, tc.ref)
+		require.Equal(t, tc.registry, registry, tc.ref)
+		require.Equal(t, tc.repository, repository, tc.ref)
+		require.Equal(t, tc.reference, reference, tc.ref)
+	}
+
+	_, _, _, err := parseOCIReference("tool")
+	require.Error(t, err)
+}
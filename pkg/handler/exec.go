@@ -10,6 +10,20 @@ type ExecHandler interface {
 	// stdin (e.g. pfctl -f -), which matters on write-limited media such as
 	// SD cards.
 	RunCommandWithInput(ctx context.Context, stdin string, command string, arg ...string) (string, int, error)
+	// RunCommandWithOptions runs command with the given working directory,
+	// environment and user.  The combined stdout and stderr is returned.
+	RunCommandWithOptions(ctx context.Context, opts ExecOptions, command string, arg ...string) (string, int, error)
+}
+
+// ExecOptions customise how RunCommandWithOptions runs a command.
+type ExecOptions struct {
+	// Dir is the working directory.  Empty uses the working directory of
+	// nodemanager.
+	Dir string
+	// Env is appended to the environment of nodemanager, as KEY=value.
+	Env []string
+	// User runs the command as this user and their primary group.
+	User string
 }
 
 var _ ExecHandler = (*MockExecHandler)(nil)
@@ -21,6 +35,9 @@ type MockExecHandler struct {
 	// Output holds per-call stdout values consumed in order, like Status.
 	// When exhausted, RunCommand returns "".
 	Output []string
+	// OptionsRecorder stores the options passed to each RunCommandWithOptions
+	// call, keyed by command name.
+	OptionsRecorder map[string][]ExecOptions
 	// InputRecorder stores the stdin string passed to each RunCommandWithInput
 	// call, keyed by command name. Multiple calls append in order.
 	InputRecorder map[string][]string
@@ -58,3 +75,11 @@ func (h *MockExecHandler) RunCommandWithInput(ctx context.Context, stdin string,
 	h.InputRecorder[command] = append(h.InputRecorder[command], stdin)
 	return h.RunCommand(ctx, command, args...)
 }
+
+func (h *MockExecHandler) RunCommandWithOptions(ctx context.Context, opts ExecOptions, command string, args ...string) (string, int, error) {
+	if h.OptionsRecorder == nil {
+		h.OptionsRecorder = make(map[string][]ExecOptions)
+	}
+	h.OptionsRecorder[command] = append(h.OptionsRecorder[command], opts)
+	return h.RunCommand(ctx, command, args...)
+}
@@ -18,6 +18,12 @@ type FileHandler interface {
 	// returned.
 	WriteContentFile(ctx context.Context, path string, content []byte) (bool, error)
 
+	// ReplaceContentFile is WriteContentFile for content which replaces the
+	// file as a whole, such as a binary which may be running.  The content is
+	// written to a temporary file in the same directory, which is synced and
+	// renamed over path, keeping the mode and owner of the file it replaces.
+	ReplaceContentFile(ctx context.Context, path string, content []byte) (bool, error)
+
 	// Remove receives a path which should be removed.  A boolean indicating if
 	// the file was removed and an error, if any, are returned.
 	Remove(ctx context.Context, path string) (bool, error)
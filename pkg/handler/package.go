@@ -1,13 +1,56 @@
 package handler
 
-import "context"
+import (
+	"context"
+
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+// VersionMatcher is implemented by the package handlers whose versions have
+// parts which a requested version may leave out, such as the release of an
+// RPM.  Versions are compared as strings for the other handlers.
+type VersionMatcher interface {
+	// VersionMatches reports whether the installed version is the requested
+	// one.
+	VersionMatches(installed, requested string) bool
+}
 
 type PackageHandler interface {
 	// Install installs the named package. If version is non-empty, the exact
 	// version is requested; otherwise the latest available version is used.
 	Install(ctx context.Context, name, version string) error
 	Remove(context.Context, string) error
+	// InstallPackages installs the packages in a single transaction.  A
+	// package with a Version is installed at that version, the others at the
+	// latest available version, upgrading them if already installed.
+	InstallPackages(context.Context, []packages.Package) error
+	// RemovePackages removes the named packages in a single transaction.
+	RemovePackages(context.Context, []string) error
+	// Available returns the versions of the named packages offered by the
+	// repositories, as of the last refresh of the package metadata.
+	// Packages which no repository offers are left out.
+	Available(context.Context, []string) (map[string]string, error)
 	// List returns a map of installed package names to their installed versions.
 	List(context.Context) (map[string]string, error)
 	UpgradeAll(context.Context) error
+	// Hold prevents the named package from being upgraded, using the native
+	// mechanism of the package manager.
+	Hold(context.Context, string) error
+	// Unhold releases a hold placed on the named package.
+	Unhold(context.Context, string) error
+	// Held returns the names of the held packages.
+	Held(context.Context) ([]string, error)
+	RepositoryHandler
+}
+
+// RepositoryHandler configures the repositories packages are installed from.
+type RepositoryHandler interface {
+	// RepositoryConfigured reports whether the repository is configured as
+	// described, without changing anything.
+	RepositoryConfigured(context.Context, packages.Repository) (bool, error)
+	// EnsureRepository configures the repository and its signing key, and
+	// reports whether anything changed.
+	EnsureRepository(context.Context, packages.Repository) (bool, error)
+	// Refresh updates the package metadata from the repositories.
+	Refresh(context.Context) error
 }
@@ -9,9 +9,42 @@ import (
 type ServiceHandler interface {
 	Enable(context.Context, string) error
 	Disable(context.Context, string) error
+	// Enabled reports whether the named service is started at boot.
+	Enabled(context.Context, string) (bool, error)
 	Start(context.Context, string) error
 	Stop(context.Context, string) error
 	Restart(context.Context, string) error
 	Status(context.Context, string) (services.ServiceStatus, error)
-	SetArguments(context.Context, string, string) error
+	// SetArguments sets the arguments the named service is started with,
+	// and reports whether they changed.  Empty arguments clear the setting.
+	// The service must be restarted for changed arguments to apply.
+	SetArguments(context.Context, string, string) (bool, error)
+	// Arguments returns the arguments set for the named service, or "" when
+	// none are set.
+	Arguments(context.Context, string) (string, error)
+}
+
+// UnitHandler manages the unit files of a service manager which has them.
+// Only systemd implements it; a ServiceHandler is checked for it with a type
+// assertion.
+type UnitHandler interface {
+	// UnitContent returns the content of the unit file, or of its drop-in
+	// when dropIn is set.  It returns nil when the file does not exist.
+	UnitContent(ctx context.Context, name, dropIn string) ([]byte, error)
+	// UnitPath returns the path of the unit file, or of its drop-in when
+	// dropIn is set.
+	UnitPath(ctx context.Context, name, dropIn string) (string, error)
+	// WriteUnit writes the unit file, or its drop-in when dropIn is set, and
+	// reports whether it changed.
+	WriteUnit(ctx context.Context, name, dropIn string, content []byte) (bool, error)
+	// RemoveUnit removes the unit file, or its drop-in when dropIn is set,
+	// and reports whether it existed.
+	RemoveUnit(ctx context.Context, name, dropIn string) (bool, error)
+	// UnitFileState returns the enablement state of the unit, e.g. enabled,
+	// disabled, static or masked.
+	UnitFileState(ctx context.Context, name string) (string, error)
+	Mask(ctx context.Context, name string) error
+	Unmask(ctx context.Context, name string) error
+	// DaemonReload reloads the unit files, so that changes to them apply.
+	DaemonReload(ctx context.Context) error
 }
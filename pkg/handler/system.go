@@ -6,4 +6,5 @@ type System interface {
 	Service() ServiceHandler
 	File() FileHandler
 	Node() NodeHandler
+	User() UserHandler
 }
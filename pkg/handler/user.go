@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+type UserHandler interface {
+	// LookupUser returns the named user, or nil if it does not exist.
+	LookupUser(ctx context.Context, name string) (*users.User, error)
+	AddUser(ctx context.Context, user users.User) error
+	// ModifyUser updates the fields set on user to match.
+	ModifyUser(ctx context.Context, user users.User) error
+	RemoveUser(ctx context.Context, name string) error
+
+	// LookupGroup returns the named group, or nil if it does not exist.
+	LookupGroup(ctx context.Context, name string) (*users.Group, error)
+	AddGroup(ctx context.Context, group users.Group) error
+	ModifyGroup(ctx context.Context, group users.Group) error
+	RemoveGroup(ctx context.Context, name string) error
+}
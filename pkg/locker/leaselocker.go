@@ -12,6 +12,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/zachfi/nodemanager/pkg/common"
 )
 
 var _ Locker = &leaseLocker{}
@@ -40,6 +42,15 @@ func (l *leaseLocker) Lock(ctx context.Context, req types.NamespacedName) error
 }
 
 func (l *leaseLocker) LockFor(ctx context.Context, req types.NamespacedName, duration time.Duration) error {
+	start := time.Now()
+	err := l.acquire(ctx, req, duration)
+	observeLock(l.id, req.Name, purposeFrom(ctx), start, err)
+	return err
+}
+
+// acquire creates the lease, takes it over once it expired, or renews it
+// when this instance already holds it.
+func (l *leaseLocker) acquire(ctx context.Context, req types.NamespacedName, duration time.Duration) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -47,7 +58,9 @@ func (l *leaseLocker) LockFor(ctx context.Context, req types.NamespacedName, dur
 	var (
 		b                    = backoff.New(ctx, l.cfg.Backoff)
 		leaseInterface       = l.clientset.CoordinationV1().Leases(req.Namespace)
-		currentMicroTime     = metav1.NewMicroTime(time.Now())
+		purpose              = purposeFrom(ctx)
+		now                  = time.Now()
+		currentMicroTime     = metav1.NewMicroTime(now)
 		leaseDurationSeconds = int32(duration.Seconds())
 		lockData             = coordinationv1.LeaseSpec{
 			HolderIdentity:       &l.id,
@@ -61,6 +74,8 @@ func (l *leaseLocker) LockFor(ctx context.Context, req types.NamespacedName, dur
 		}
 	)
 
+	l.annotate(newLease, purpose, now, duration)
+
 	// Attempt to create the lease
 	_, err := leaseInterface.Create(ctx, newLease, metav1.CreateOptions{})
 	if err == nil {
@@ -80,24 +95,33 @@ func (l *leaseLocker) LockFor(ctx context.Context, req types.NamespacedName, dur
 		}
 
 		var (
-			isExpired  = existingLease.Spec.RenewTime.Add(time.Duration(*existingLease.Spec.LeaseDurationSeconds) * time.Second).Before(time.Now())
+			isExpired  = leaseExpired(existingLease)
 			isHeldByMe = existingLease.Spec.HolderIdentity != nil && *existingLease.Spec.HolderIdentity == l.id
 		)
 
-		if isHeldByMe {
-			l.logger.Info("lock already held by this instance (treating as acquired)", "lease", req.String())
-			return nil // Already holding the lock
-		}
-
-		if !isExpired {
+		if !isHeldByMe && !isExpired {
 			// Lock is held and not expired
 			return apierrors.NewConflict(coordinationv1.Resource("leases"), req.Name, fmt.Errorf("lock held by another instance and not expired"))
 		}
 
-		// Replace the spec with our lock
-		existingLease.Spec = lockData
+		if isHeldByMe {
+			// Renew the lock we already hold, so that a caller holding it
+			// across reconciles keeps it until it unlocks.
+			existingLease.Spec.RenewTime = lockData.RenewTime
+			existingLease.Spec.LeaseDurationSeconds = lockData.LeaseDurationSeconds
+		} else {
+			// Replace the spec with our lock, and the acquire time of the
+			// previous holder.
+			existingLease.Spec = lockData
+			delete(existingLease.Annotations, common.AnnotationLockAcquiredAt)
+		}
+		l.annotate(existingLease, purpose, now, duration)
 
 		_, updateErr := leaseInterface.Update(ctx, existingLease, metav1.UpdateOptions{})
+		if updateErr == nil && isHeldByMe {
+			l.logger.Info("lock renewed", "lease", req.String())
+			return nil
+		}
 		if updateErr == nil {
 			l.logger.Info("lock acquired by updating expired Lease", "lease", req.String())
 			return nil // Success!
@@ -115,7 +139,53 @@ func (l *leaseLocker) LockFor(ctx context.Context, req types.NamespacedName, dur
 	return apierrors.NewConflict(coordinationv1.Resource("leases"), req.Name, fmt.Errorf("failed to acquire lock after multiple retries due to contention"))
 }
 
+// leaseExpired reports whether the lease has lapsed.  A lease which was never
+// renewed, such as one created by hand to carry annotations, has lapsed.
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(time.Now())
+}
+
+// annotate records the holder of the lease, the purpose of the lock, when it
+// was acquired and when it expires.  The acquire time of a renewed lease is
+// kept.
+func (l *leaseLocker) annotate(lease *coordinationv1.Lease, purpose string, now time.Time, duration time.Duration) {
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	if _, ok := lease.Annotations[common.AnnotationLockAcquiredAt]; !ok {
+		lease.Annotations[common.AnnotationLockAcquiredAt] = now.UTC().Format(time.RFC3339)
+	}
+	lease.Annotations[common.AnnotationLockHolder] = l.id
+	lease.Annotations[common.AnnotationLockPurpose] = purpose
+	lease.Annotations[common.AnnotationLockExpiresAt] = now.Add(duration).UTC().Format(time.RFC3339)
+}
+
+// clearLease releases the lease in place: it has no holder, has expired, and
+// the annotations recording the holder are removed.  Other annotations, such
+// as the slots of a group, are kept.
+func clearLease(lease *coordinationv1.Lease) {
+	pastMicroTime := metav1.NewMicroTime(time.Now().Add(-2 * time.Hour))
+
+	lease.Spec.HolderIdentity = nil // Key to release the lock
+	lease.Spec.RenewTime = &pastMicroTime
+	for _, key := range holderAnnotations {
+		delete(lease.Annotations, key)
+	}
+}
+
 func (l *leaseLocker) Unlock(ctx context.Context, req types.NamespacedName) error {
+	err := l.release(ctx, req)
+	if err == nil {
+		observeUnlock(l.id, req.Name)
+	}
+	return err
+}
+
+// release gives up the lease when this instance holds it.
+func (l *leaseLocker) release(ctx context.Context, req types.NamespacedName) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -144,10 +214,7 @@ func (l *leaseLocker) Unlock(ctx context.Context, req types.NamespacedName) erro
 		}
 
 		// C. Prepare to release ownership and explicitly expire the Lease
-		pastMicroTime := metav1.NewMicroTime(time.Now().Add(-2 * time.Hour))
-
-		existingLease.Spec.HolderIdentity = nil // Key to release the lock
-		existingLease.Spec.RenewTime = &pastMicroTime
+		clearLease(existingLease)
 		// Retain ResourceVersion for Optimistic Locking
 
 		// D. Attempt the Update
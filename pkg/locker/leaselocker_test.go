@@ -78,7 +78,7 @@ func TestLeaseLocker_Lock(t *testing.T) {
 		},
 		{
 			name:        "Lock_Already_Held_By_Me_Success",
-			description: "Should treat a lock already held by the same instance as successfully acquired (no-op).",
+			description: "Should treat a lock already held by the same instance as successfully acquired, renewing it.",
 			existingObjs: []runtime.Object{
 				// Lease is held by testID and is not expired.
 				createLease(testID, now, int32(leaseDuration.Seconds())),
@@ -239,6 +239,28 @@ func TestLeaseLocker_LockFor(t *testing.T) {
 	require.Equal(t, testID, *lease.Spec.HolderIdentity)
 }
 
+func TestLeaseLocker_LockRenews(t *testing.T) {
+	// Locking a lease this instance holds renews it, keeping the acquire time.
+	acquired := time.Now().Add(-time.Hour)
+	existing := createLease(testID, acquired, int32(leaseDuration.Seconds()))
+	acquireTime := metav1.NewMicroTime(acquired)
+	existing.Spec.AcquireTime = &acquireTime
+
+	fakeClient := fake.NewSimpleClientset(existing)
+	cfg := Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	lkr := NewLeaseLocker(ctx, logger, cfg, fakeClient, testReq.Namespace, testID)
+	require.NoError(t, lkr.Lock(ctx, testReq))
+
+	lease, err := fakeClient.CoordinationV1().Leases(testReq.Namespace).Get(ctx, testReq.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, testID, *lease.Spec.HolderIdentity)
+	require.Equal(t, int32(cfg.LeaseDuration.Seconds()), *lease.Spec.LeaseDurationSeconds)
+	require.True(t, lease.Spec.RenewTime.After(acquired.Add(time.Minute)))
+	require.True(t, lease.Spec.AcquireTime.Time.Equal(acquireTime.Time))
+}
+
 // TestLockConflict specifically tests the update retry logic against an injected conflict.
 // func TestLock_Conflict(t *testing.T) {
 // 	// 1. Set up a Lease that is expired (so we attempt to claim it)
@@ -0,0 +1,52 @@
+package locker
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// lockHeld is 1 for each lock this node holds, labelled by its purpose.
+	lockHeld = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nodemanager_lock_held",
+		Help: "Whether this node holds the lock. Value is 1 while held.",
+	}, []string{"node", "lock", "purpose"})
+
+	// lockWaitSeconds records how long acquiring a lock took, labelled by
+	// result ("success", "conflict" when another node holds it, or "error").
+	lockWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nodemanager_lock_wait_seconds",
+		Help:    "Time spent acquiring a lock in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"node", "lock", "purpose", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(lockHeld, lockWaitSeconds)
+}
+
+// observeLock records an attempt to acquire a lock which started at start.
+func observeLock(node, lock, purpose string, start time.Time, err error) {
+	result := "success"
+	switch {
+	case apierrors.IsConflict(err):
+		result = "conflict"
+	case err != nil:
+		result = "error"
+	}
+	lockWaitSeconds.WithLabelValues(node, lock, purpose, result).Observe(time.Since(start).Seconds())
+
+	if err == nil {
+		// A lock renewed for another purpose replaces the previous series.
+		lockHeld.DeletePartialMatch(prometheus.Labels{"node": node, "lock": lock})
+		lockHeld.WithLabelValues(node, lock, purpose).Set(1)
+	}
+}
+
+// observeUnlock records that a lock was released.
+func observeUnlock(node, lock string) {
+	lockHeld.DeletePartialMatch(prometheus.Labels{"node": node, "lock": lock})
+}
@@ -0,0 +1,25 @@
+package locker
+
+import "context"
+
+// The purposes recorded on the Lease of a lock.
+const (
+	PurposeUpgrade        = "upgrade"
+	PurposeServiceRestart = "service-restart"
+	PurposeJailUpdate     = "jail-update"
+)
+
+type purposeKey struct{}
+
+// WithPurpose returns a context which records purpose on the locks acquired
+// with it, so that the holder of a lock shows why it holds it.
+func WithPurpose(ctx context.Context, purpose string) context.Context {
+	return context.WithValue(ctx, purposeKey{}, purpose)
+}
+
+func purposeFrom(ctx context.Context) string {
+	if purpose, ok := ctx.Value(purposeKey{}).(string); ok && purpose != "" {
+		return purpose
+	}
+	return "unknown"
+}
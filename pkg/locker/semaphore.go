@@ -0,0 +1,163 @@
+package locker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/zachfi/nodemanager/pkg/common"
+)
+
+var _ Locker = &semaphoreLocker{}
+
+// semaphoreLocker is a Locker which lets up to N holders hold a group at
+// once.  Each slot is a Lease: the first is named after the group, and the
+// others after the group and their index, e.g. web, web-1 and web-2.  The
+// number of slots is read from the lock.nodemanager/slots annotation on the
+// first Lease, and defaults to one, which behaves as the single Lease lock.
+type semaphoreLocker struct {
+	logger    *slog.Logger
+	clientset kubernetes.Interface
+	cfg       Config
+
+	// lease acquires and releases the individual slots.
+	lease *leaseLocker
+}
+
+func NewSemaphoreLocker(ctx context.Context, logger *slog.Logger, cfg Config, clientset kubernetes.Interface, namespace, id string) Locker {
+	return &semaphoreLocker{
+		logger:    logger,
+		clientset: clientset,
+		cfg:       cfg,
+		lease: &leaseLocker{
+			logger:    logger,
+			cfg:       cfg,
+			clientset: clientset,
+			namespace: namespace,
+			id:        id,
+		},
+	}
+}
+
+func (l *semaphoreLocker) Lock(ctx context.Context, req types.NamespacedName) error {
+	return l.LockFor(ctx, req, l.cfg.LeaseDuration)
+}
+
+// LockFor renews the slot this instance holds, or acquires the first free or
+// expired one.  A conflict is returned when every slot is held.
+func (l *semaphoreLocker) LockFor(ctx context.Context, req types.NamespacedName, duration time.Duration) error {
+	start := time.Now()
+	err := l.acquire(ctx, req, duration)
+	observeLock(l.lease.id, req.Name, purposeFrom(ctx), start, err)
+	return err
+}
+
+func (l *semaphoreLocker) acquire(ctx context.Context, req types.NamespacedName, duration time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	slots, err := l.slots(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if held, ok := l.heldSlot(ctx, slots); ok {
+		return l.lease.acquire(ctx, held, duration)
+	}
+
+	for _, slot := range slots {
+		err := l.lease.acquire(ctx, slot, duration)
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return apierrors.NewConflict(coordinationv1.Resource("leases"), req.Name, fmt.Errorf("all %d slots held by other instances", len(slots)))
+}
+
+func (l *semaphoreLocker) Unlock(ctx context.Context, req types.NamespacedName) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	slots, err := l.slots(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	held, ok := l.heldSlot(ctx, slots)
+	if !ok {
+		l.logger.Info("Unlock called but no slot is held by this identity", "lease", req.String())
+		return nil
+	}
+
+	if err := l.lease.release(ctx, held); err != nil {
+		return err
+	}
+	observeUnlock(l.lease.id, req.Name)
+	return nil
+}
+
+func (l *semaphoreLocker) Locked(ctx context.Context, req types.NamespacedName) bool {
+	slots, err := l.slots(ctx, req)
+	if err != nil {
+		return false
+	}
+
+	_, ok := l.heldSlot(ctx, slots)
+	return ok
+}
+
+// slots returns the Leases of the slots of the group.
+func (l *semaphoreLocker) slots(ctx context.Context, req types.NamespacedName) ([]types.NamespacedName, error) {
+	count := 1
+
+	lease, err := l.clientset.CoordinationV1().Leases(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		if value, ok := lease.Annotations[common.AnnotationLockSlots]; ok {
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid %s annotation %q on lease %s", common.AnnotationLockSlots, value, req.String())
+			}
+		}
+	}
+
+	slots := make([]types.NamespacedName, count)
+	for i := range slots {
+		slots[i] = slotName(req, i)
+	}
+	return slots, nil
+}
+
+// heldSlot returns the slot this instance holds.
+func (l *semaphoreLocker) heldSlot(ctx context.Context, slots []types.NamespacedName) (types.NamespacedName, bool) {
+	for _, slot := range slots {
+		if l.lease.Locked(ctx, slot) {
+			return slot, true
+		}
+	}
+	return types.NamespacedName{}, false
+}
+
+func slotName(req types.NamespacedName, i int) types.NamespacedName {
+	if i == 0 {
+		return req
+	}
+	return types.NamespacedName{Namespace: req.Namespace, Name: fmt.Sprintf("%s-%d", req.Name, i)}
+}
@@ -0,0 +1,142 @@
+package locker
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/zachfi/nodemanager/pkg/common"
+)
+
+// createGroupLease creates the first slot of a group with the given number of
+// slots and no holder.
+func createGroupLease(slots string) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testReq.Name,
+			Namespace:   testReq.Namespace,
+			Annotations: map[string]string{common.AnnotationLockSlots: slots},
+		},
+	}
+}
+
+func TestSemaphoreLocker_Lock(t *testing.T) {
+	cfg := Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	fakeClient := fake.NewSimpleClientset(createGroupLease("3"))
+	lockers := make([]Locker, 4)
+	for i, id := range []string{"node-a", "node-b", "node-c", "node-d"} {
+		lockers[i] = NewSemaphoreLocker(ctx, logger, cfg, fakeClient, testReq.Namespace, id)
+	}
+
+	// Three nodes hold the group at once; the fourth waits for a slot.
+	for _, lkr := range lockers[:3] {
+		require.NoError(t, lkr.Lock(ctx, testReq))
+		require.True(t, lkr.Locked(ctx, testReq))
+	}
+	err := lockers[3].Lock(ctx, testReq)
+	require.True(t, apierrors.IsConflict(err), "expected a conflict, got %v", err)
+	require.False(t, lockers[3].Locked(ctx, testReq))
+
+	holders := make(map[string]string)
+	for _, name := range []string{testName, testName + "-1", testName + "-2"} {
+		lease, err := fakeClient.CoordinationV1().Leases(testNamespace).Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		holders[name] = *lease.Spec.HolderIdentity
+	}
+	require.Equal(t, map[string]string{testName: "node-a", testName + "-1": "node-b", testName + "-2": "node-c"}, holders)
+
+	// Locking again renews the slot already held rather than taking another.
+	require.NoError(t, lockers[1].Lock(ctx, testReq))
+	leases, err := fakeClient.CoordinationV1().Leases(testNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, leases.Items, 3)
+
+	// A released slot is taken by the next node, and the annotation on the
+	// first slot survives its release.
+	require.NoError(t, lockers[0].Unlock(ctx, testReq))
+	require.False(t, lockers[0].Locked(ctx, testReq))
+	require.NoError(t, lockers[3].Lock(ctx, testReq))
+
+	lease, err := fakeClient.CoordinationV1().Leases(testNamespace).Get(ctx, testName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "node-d", *lease.Spec.HolderIdentity)
+	require.Equal(t, "3", lease.Annotations[common.AnnotationLockSlots])
+}
+
+func TestSemaphoreLocker_LockFor(t *testing.T) {
+	now := time.Now()
+	customTTL := 25 * time.Hour
+
+	cfg := Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	tests := []struct {
+		name         string
+		existingObjs []runtime.Object
+		expectSlot   string
+		expectError  bool
+	}{
+		{
+			name:       "Single_Slot_Without_Lease",
+			expectSlot: testName,
+		},
+		{
+			name:         "Single_Slot_Held_By_Others",
+			existingObjs: []runtime.Object{createLease(otherID, now, int32(leaseDuration.Seconds()))},
+			expectError:  true,
+		},
+		{
+			name: "Expired_Slot_Taken",
+			existingObjs: func() []runtime.Object {
+				lease := createLease(otherID, now.Add(-leaseDuration-time.Minute), int32(leaseDuration.Seconds()))
+				lease.Annotations = map[string]string{common.AnnotationLockSlots: "2"}
+				return []runtime.Object{lease}
+			}(),
+			expectSlot: testName,
+		},
+		{
+			name: "Second_Slot_Taken",
+			existingObjs: func() []runtime.Object {
+				lease := createLease(otherID, now, int32(leaseDuration.Seconds()))
+				lease.Annotations = map[string]string{common.AnnotationLockSlots: "2"}
+				return []runtime.Object{lease}
+			}(),
+			expectSlot: testName + "-1",
+		},
+		{
+			name:         "Invalid_Slots",
+			existingObjs: []runtime.Object{createGroupLease("none")},
+			expectError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleClientset(tt.existingObjs...)
+			lkr := NewSemaphoreLocker(ctx, logger, cfg, fakeClient, testReq.Namespace, testID)
+
+			err := lkr.LockFor(ctx, testReq, customTTL)
+			if tt.expectError {
+				require.Error(t, err)
+				require.False(t, lkr.Locked(ctx, testReq))
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, lkr.Locked(ctx, testReq))
+
+			lease, err := fakeClient.CoordinationV1().Leases(testNamespace).Get(ctx, tt.expectSlot, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, testID, *lease.Spec.HolderIdentity)
+			require.Equal(t, int32(customTTL.Seconds()), *lease.Spec.LeaseDurationSeconds)
+		})
+	}
+}
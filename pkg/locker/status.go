@@ -0,0 +1,107 @@
+package locker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/zachfi/nodemanager/pkg/common"
+)
+
+// holderAnnotations are recorded on a Lease while it is held.
+var holderAnnotations = []string{
+	common.AnnotationLockHolder,
+	common.AnnotationLockPurpose,
+	common.AnnotationLockAcquiredAt,
+	common.AnnotationLockExpiresAt,
+}
+
+// LockStatus is the state of the Lease of a lock.
+type LockStatus struct {
+	Name    string
+	Holder  string
+	Purpose string
+	// AcquiredAt and ExpiresAt are zero when the lease is not held.
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+	// Slots is set on the first Lease of a group with several slots.
+	Slots   string
+	Expired bool
+}
+
+// Held reports whether the lock is held and has not expired.
+func (s LockStatus) Held() bool {
+	return s.Holder != "" && !s.Expired
+}
+
+// ListLocks returns the status of the Leases in namespace which nodemanager
+// uses as locks, sorted by name.  Leases without any lock annotation, such as
+// those of leader election, are left out.
+func ListLocks(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]LockStatus, error) {
+	leases, err := clientset.CoordinationV1().Leases(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []LockStatus
+	for _, lease := range leases.Items {
+		if !hasLockAnnotation(lease.Annotations) {
+			continue
+		}
+
+		status := LockStatus{
+			Name:    lease.Name,
+			Purpose: lease.Annotations[common.AnnotationLockPurpose],
+			Slots:   lease.Annotations[common.AnnotationLockSlots],
+			Expired: leaseExpired(&lease),
+		}
+		if lease.Spec.HolderIdentity != nil {
+			status.Holder = *lease.Spec.HolderIdentity
+		}
+		status.AcquiredAt, _ = time.Parse(time.RFC3339, lease.Annotations[common.AnnotationLockAcquiredAt])
+		status.ExpiresAt, _ = time.Parse(time.RFC3339, lease.Annotations[common.AnnotationLockExpiresAt])
+		locks = append(locks, status)
+	}
+
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, nil
+}
+
+// ForceRelease releases a lease on behalf of its holder, e.g. when the node
+// holding it is gone.  holder must match the current holder, so that a lease
+// taken over since it was inspected is not released.
+func ForceRelease(ctx context.Context, clientset kubernetes.Interface, req types.NamespacedName, holder string) error {
+	leaseInterface := clientset.CoordinationV1().Leases(req.Namespace)
+
+	lease, err := leaseInterface.Get(ctx, req.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		current := "nobody"
+		if lease.Spec.HolderIdentity != nil {
+			current = *lease.Spec.HolderIdentity
+		}
+		return fmt.Errorf("lease %s is held by %s, not %s", req.String(), current, holder)
+	}
+
+	clearLease(lease)
+	_, err = leaseInterface.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+func hasLockAnnotation(annotations map[string]string) bool {
+	for key := range annotations {
+		if strings.HasPrefix(key, "lock.nodemanager/") {
+			return true
+		}
+	}
+	return false
+}
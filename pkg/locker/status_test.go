@@ -0,0 +1,75 @@
+package locker
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/zachfi/nodemanager/pkg/common"
+)
+
+func TestListLocks(t *testing.T) {
+	cfg := Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	// The leader election lease carries no lock annotations.
+	leaderElection := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "leader", Namespace: testNamespace}}
+	fakeClient := fake.NewSimpleClientset(leaderElection, createGroupLease("2"))
+
+	lkr := NewSemaphoreLocker(ctx, logger, cfg, fakeClient, testNamespace, testID)
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, lkr.Lock(WithPurpose(ctx, PurposeUpgrade), testReq))
+
+	locks, err := ListLocks(ctx, fakeClient, testNamespace)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+
+	lock := locks[0]
+	require.Equal(t, testName, lock.Name)
+	require.Equal(t, testID, lock.Holder)
+	require.Equal(t, PurposeUpgrade, lock.Purpose)
+	require.Equal(t, "2", lock.Slots)
+	require.True(t, lock.Held())
+	require.True(t, lock.AcquiredAt.After(before))
+	require.WithinDuration(t, time.Now().Add(cfg.LeaseDuration), lock.ExpiresAt, time.Minute)
+
+	// Releasing the lock removes its holder annotations and keeps the slots.
+	require.NoError(t, lkr.Unlock(ctx, testReq))
+	lease, err := fakeClient.CoordinationV1().Leases(testNamespace).Get(ctx, testName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{common.AnnotationLockSlots: "2"}, lease.Annotations)
+
+	locks, err = ListLocks(ctx, fakeClient, testNamespace)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	require.False(t, locks[0].Held())
+}
+
+func TestForceRelease(t *testing.T) {
+	cfg := Config{}
+	cfg.RegisterFlagsAndApplyDefaults("", &flag.FlagSet{})
+
+	fakeClient := fake.NewSimpleClientset()
+	lkr := NewLeaseLocker(ctx, logger, cfg, fakeClient, testNamespace, otherID)
+	require.NoError(t, lkr.Lock(WithPurpose(ctx, PurposeServiceRestart), testReq))
+
+	// The lease is only released for the holder it was inspected with.
+	require.Error(t, ForceRelease(ctx, fakeClient, testReq, testID))
+	require.True(t, lkr.Locked(ctx, testReq))
+
+	require.NoError(t, ForceRelease(ctx, fakeClient, testReq, otherID))
+	require.False(t, lkr.Locked(ctx, testReq))
+
+	lease, err := fakeClient.CoordinationV1().Leases(testNamespace).Get(ctx, testName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, lease.Annotations, common.AnnotationLockHolder)
+
+	// Anyone may take the released lease.
+	other := NewLeaseLocker(ctx, logger, cfg, fakeClient, testNamespace, testID)
+	require.NoError(t, other.Lock(ctx, testReq))
+}
@@ -0,0 +1,57 @@
+// Package runit implements the handler.NodeHandler interface for systems using runit.
+package runit
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/zachfi/nodemanager/pkg/common/info"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"go.opentelemetry.io/otel"
+)
+
+const reboot = "/usr/bin/reboot"
+
+var _ handler.NodeHandler = (*Runit)(nil)
+
+var tracer = otel.Tracer("nodes/runit")
+
+type Runit struct {
+	logger *slog.Logger
+
+	info handler.InfoResolver
+	exec handler.ExecHandler
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.NodeHandler {
+	return &Runit{
+		logger: logger.With("node", "runit"),
+
+		info: info.NewInfoResolver(),
+		exec: exec,
+	}
+}
+
+func (h *Runit) Reboot(ctx context.Context) {
+	_, span := tracer.Start(ctx, "Reboot")
+	defer span.End()
+
+	err := h.exec.SimpleRunCommand(ctx, reboot)
+	if err != nil {
+		h.logger.Error("failed to call reboot", "err", err)
+	}
+}
+
+func (h *Runit) Upgrade(ctx context.Context) error {
+	// Runit does not have an Upgrade implementation.  Upgrades are handled through the package manager.
+	return nil
+}
+
+func (h *Runit) Hostname() (string, error) {
+	return os.Hostname()
+}
+
+func (h *Runit) Info(ctx context.Context) *handler.SysInfo {
+	return h.info.Info(ctx)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/zachfi/nodemanager/pkg/nodes/alpine"
 	"github.com/zachfi/nodemanager/pkg/packages/apk"
 	"github.com/zachfi/nodemanager/pkg/services/openrc"
+	"github.com/zachfi/nodemanager/pkg/users/busybox"
 )
 
 var _ handler.System = (*AlpineLinux)(nil)
@@ -21,6 +22,7 @@ type AlpineLinux struct {
 	node handler.NodeHandler
 	pkg  handler.PackageHandler
 	svc  handler.ServiceHandler
+	user handler.UserHandler
 }
 
 func New(logger *slog.Logger) handler.System {
@@ -32,6 +34,7 @@ func New(logger *slog.Logger) handler.System {
 	s.pkg = apk.New(logger, s.exec)
 	s.svc = openrc.New(logger, s.exec)
 	s.node = alpine.New(logger, s.exec)
+	s.user = busybox.New(logger, s.exec)
 
 	return s
 }
@@ -55,3 +58,7 @@ func (a *AlpineLinux) Package() handler.PackageHandler {
 func (a *AlpineLinux) Service() handler.ServiceHandler {
 	return a.svc
 }
+
+func (a *AlpineLinux) User() handler.UserHandler {
+	return a.user
+}
@@ -9,6 +9,7 @@ import (
 	systemd_node "github.com/zachfi/nodemanager/pkg/nodes/systemd"
 	"github.com/zachfi/nodemanager/pkg/packages/pacman"
 	systemd_svc "github.com/zachfi/nodemanager/pkg/services/systemd"
+	"github.com/zachfi/nodemanager/pkg/users/shadow"
 )
 
 var _ handler.System = (*ArchLinux)(nil)
@@ -21,6 +22,7 @@ type ArchLinux struct {
 	node handler.NodeHandler
 	pkg  handler.PackageHandler
 	svc  handler.ServiceHandler
+	user handler.UserHandler
 }
 
 func New(logger *slog.Logger) handler.System {
@@ -32,6 +34,7 @@ func New(logger *slog.Logger) handler.System {
 	s.pkg = pacman.New(logger, s.exec)
 	s.svc = systemd_svc.New(logger, s.exec)
 	s.node = systemd_node.New(logger, s.exec)
+	s.user = shadow.New(logger, s.exec)
 
 	return s
 }
@@ -55,3 +58,7 @@ func (a *ArchLinux) Package() handler.PackageHandler {
 func (a *ArchLinux) Service() handler.ServiceHandler {
 	return a.svc
 }
+
+func (a *ArchLinux) User() handler.UserHandler {
+	return a.user
+}
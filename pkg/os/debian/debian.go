@@ -0,0 +1,64 @@
+package debian
+
+import (
+	"log/slog"
+
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	systemd_node "github.com/zachfi/nodemanager/pkg/nodes/systemd"
+	"github.com/zachfi/nodemanager/pkg/packages/apt"
+	systemd_svc "github.com/zachfi/nodemanager/pkg/services/systemd"
+	"github.com/zachfi/nodemanager/pkg/users/shadow"
+)
+
+var _ handler.System = (*Debian)(nil)
+
+type Debian struct {
+	logger *slog.Logger
+
+	exec handler.ExecHandler
+	f    handler.FileHandler
+	node handler.NodeHandler
+	pkg  handler.PackageHandler
+	svc  handler.ServiceHandler
+	user handler.UserHandler
+}
+
+func New(logger *slog.Logger) handler.System {
+	s := &Debian{
+		logger: logger,
+		exec:   &execs.ExecHandlerCommon{},
+		f:      files.New(logger, "root", "root"),
+	}
+	s.pkg = apt.New(logger, s.exec)
+	s.svc = systemd_svc.New(logger, s.exec)
+	s.node = systemd_node.New(logger, s.exec)
+	s.user = shadow.New(logger, s.exec)
+
+	return s
+}
+
+func (a *Debian) Exec() handler.ExecHandler {
+	return a.exec
+}
+
+func (a *Debian) File() handler.FileHandler {
+	return a.f
+}
+
+func (a *Debian) Node() handler.NodeHandler {
+	return a.node
+}
+
+func (a *Debian) Package() handler.PackageHandler {
+	return a.pkg
+}
+
+func (a *Debian) Service() handler.ServiceHandler {
+	return a.svc
+}
+
+func (a *Debian) User() handler.UserHandler {
+	return a.user
+}
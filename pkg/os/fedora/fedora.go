@@ -0,0 +1,64 @@
+package fedora
+
+import (
+	"log/slog"
+
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	systemd_node "github.com/zachfi/nodemanager/pkg/nodes/systemd"
+	"github.com/zachfi/nodemanager/pkg/packages/dnf"
+	systemd_svc "github.com/zachfi/nodemanager/pkg/services/systemd"
+	"github.com/zachfi/nodemanager/pkg/users/shadow"
+)
+
+var _ handler.System = (*Fedora)(nil)
+
+type Fedora struct {
+	logger *slog.Logger
+
+	exec handler.ExecHandler
+	f    handler.FileHandler
+	node handler.NodeHandler
+	pkg  handler.PackageHandler
+	svc  handler.ServiceHandler
+	user handler.UserHandler
+}
+
+func New(logger *slog.Logger) handler.System {
+	s := &Fedora{
+		logger: logger,
+		exec:   &execs.ExecHandlerCommon{},
+		f:      files.New(logger, "root", "root"),
+	}
+	s.pkg = dnf.New(logger, s.exec)
+	s.svc = systemd_svc.New(logger, s.exec)
+	s.node = systemd_node.New(logger, s.exec)
+	s.user = shadow.New(logger, s.exec)
+
+	return s
+}
+
+func (a *Fedora) Exec() handler.ExecHandler {
+	return a.exec
+}
+
+func (a *Fedora) File() handler.FileHandler {
+	return a.f
+}
+
+func (a *Fedora) Node() handler.NodeHandler {
+	return a.node
+}
+
+func (a *Fedora) Package() handler.PackageHandler {
+	return a.pkg
+}
+
+func (a *Fedora) Service() handler.ServiceHandler {
+	return a.svc
+}
+
+func (a *Fedora) User() handler.UserHandler {
+	return a.user
+}
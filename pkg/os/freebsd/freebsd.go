@@ -9,6 +9,7 @@ import (
 	freebsd_node "github.com/zachfi/nodemanager/pkg/nodes/freebsd"
 	"github.com/zachfi/nodemanager/pkg/packages/pkgng"
 	freebsd_svc "github.com/zachfi/nodemanager/pkg/services/freebsd"
+	"github.com/zachfi/nodemanager/pkg/users/pw"
 )
 
 var _ handler.System = (*FreeBSD)(nil)
@@ -21,6 +22,7 @@ type FreeBSD struct {
 	node handler.NodeHandler
 	pkg  handler.PackageHandler
 	svc  handler.ServiceHandler
+	user handler.UserHandler
 }
 
 func New(logger *slog.Logger) handler.System {
@@ -32,6 +34,7 @@ func New(logger *slog.Logger) handler.System {
 	s.pkg = pkgng.New(logger, s.exec)
 	s.svc = freebsd_svc.New(logger, s.exec)
 	s.node = freebsd_node.New(logger, s.exec)
+	s.user = pw.New(logger, s.exec)
 
 	return s
 }
@@ -55,3 +58,7 @@ func (a *FreeBSD) Package() handler.PackageHandler {
 func (a *FreeBSD) Service() handler.ServiceHandler {
 	return a.svc
 }
+
+func (a *FreeBSD) User() handler.UserHandler {
+	return a.user
+}
@@ -0,0 +1,64 @@
+package void
+
+import (
+	"log/slog"
+
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	runit_node "github.com/zachfi/nodemanager/pkg/nodes/runit"
+	"github.com/zachfi/nodemanager/pkg/packages/xbps"
+	runit_svc "github.com/zachfi/nodemanager/pkg/services/runit"
+	"github.com/zachfi/nodemanager/pkg/users/shadow"
+)
+
+var _ handler.System = (*VoidLinux)(nil)
+
+type VoidLinux struct {
+	logger *slog.Logger
+
+	exec handler.ExecHandler
+	f    handler.FileHandler
+	node handler.NodeHandler
+	pkg  handler.PackageHandler
+	svc  handler.ServiceHandler
+	user handler.UserHandler
+}
+
+func New(logger *slog.Logger) handler.System {
+	s := &VoidLinux{
+		logger: logger,
+		exec:   &execs.ExecHandlerCommon{},
+		f:      files.New(logger, "root", "root"),
+	}
+	s.pkg = xbps.New(logger, s.exec)
+	s.svc = runit_svc.New(logger, s.exec)
+	s.node = runit_node.New(logger, s.exec)
+	s.user = shadow.New(logger, s.exec)
+
+	return s
+}
+
+func (v *VoidLinux) Exec() handler.ExecHandler {
+	return v.exec
+}
+
+func (v *VoidLinux) File() handler.FileHandler {
+	return v.f
+}
+
+func (v *VoidLinux) Node() handler.NodeHandler {
+	return v.node
+}
+
+func (v *VoidLinux) Package() handler.PackageHandler {
+	return v.pkg
+}
+
+func (v *VoidLinux) Service() handler.ServiceHandler {
+	return v.svc
+}
+
+func (v *VoidLinux) User() handler.UserHandler {
+	return v.user
+}
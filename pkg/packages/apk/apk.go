@@ -2,29 +2,49 @@ package apk
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
 	"go.opentelemetry.io/otel"
 )
 
-const apk = "/sbin/apk"
+const (
+	apk          = "/sbin/apk"
+	world        = "/etc/apk/world"
+	repositories = "/etc/apk/repositories"
+	keysDir      = "/etc/apk/keys"
+
+	// repositoryMarker precedes the line of a repository managed by
+	// nodemanager in the repositories file.
+	repositoryMarker = "# nodemanager: "
+)
 
 var _ handler.PackageHandler = (*Apk)(nil)
 
 var tracer = otel.Tracer("packages/apk")
 
 type Apk struct {
-	exec   handler.ExecHandler
-	logger *slog.Logger
+	exec         handler.ExecHandler
+	logger       *slog.Logger
+	world        string
+	repositories string
+	keysDir      string
 }
 
 func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
 	return &Apk{
-		logger: logger,
-		exec:   exec,
+		logger:       logger,
+		exec:         exec,
+		world:        world,
+		repositories: repositories,
+		keysDir:      keysDir,
 	}
 }
 
@@ -58,6 +78,57 @@ func (h *Apk) List(ctx context.Context) (map[string]string, error) {
 	return h.matchPackageOutput(output), nil
 }
 
+// InstallPackages adds the packages in one transaction.  Packages already
+// installed are upgraded to the latest available version.
+func (h *Apk) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"add", "-u"}
+	for _, p := range pkgs {
+		target := p.Name
+		if p.Version != "" {
+			target = p.Name + "=" + p.Version
+		}
+		args = append(args, target)
+	}
+
+	h.logger.Info("installing packages", "count", len(pkgs))
+	return h.exec.SimpleRunCommand(ctx, apk, args...)
+}
+
+func (h *Apk) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	h.logger.Info("removing packages", "count", len(names))
+	return h.exec.SimpleRunCommand(ctx, apk, append([]string{"del"}, names...)...)
+}
+
+func (h *Apk) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	output, _, err := h.exec.RunCommand(ctx, apk, append([]string{"list"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.matchAvailableOutput(output, names), nil
+}
+
 func (h *Apk) UpgradeAll(ctx context.Context) error {
 	_, span := tracer.Start(ctx, "UpgradeAll")
 	defer span.End()
@@ -70,6 +141,164 @@ func (h *Apk) UpgradeAll(ctx context.Context) error {
 	return h.exec.SimpleRunCommand(ctx, apk, "upgrade")
 }
 
+// Hold pins the package to its installed version in the world file.
+func (h *Apk) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+
+	pkgs, err := h.List(ctx)
+	if err != nil {
+		return err
+	}
+	version, ok := pkgs[name]
+	if !ok {
+		return fmt.Errorf("cannot hold package %q: not installed", name)
+	}
+
+	h.logger.Info("pinning package", "name", name, "version", version)
+	return h.exec.SimpleRunCommand(ctx, apk, "add", name+"="+version)
+}
+
+// Unhold replaces the pinned world entry of the package with an unversioned
+// one.
+func (h *Apk) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+	h.logger.Info("unpinning package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, apk, "add", name)
+}
+
+// Held returns the packages pinned to a version in the world file.
+func (h *Apk) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+
+	content, err := os.ReadFile(h.world)
+	if err != nil {
+		return nil, err
+	}
+
+	var held []string
+	for _, entry := range strings.Fields(string(content)) {
+		// Only an exact version is a pin, not a constraint like "name>=1.2".
+		if strings.ContainsAny(entry, "<>~") {
+			continue
+		}
+		if name, _, ok := strings.Cut(entry, "="); ok {
+			held = append(held, name)
+		}
+	}
+
+	return held, nil
+}
+
+func (h *Apk) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	files, err := h.repositoryFiles(repo)
+	if err != nil {
+		return false, err
+	}
+	changed, err := packages.ConfigFilesChanged(files)
+	return !changed, err
+}
+
+// EnsureRepository adds the repository to the repositories file, below a
+// marker comment which names it, and writes its key to the keys directory.
+// apk finds a key by the name it was signed with, so the repository should
+// be named after its key.  apk has no repository priority.
+func (h *Apk) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	files, err := h.repositoryFiles(repo)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := packages.WriteConfigFiles(files)
+	if changed {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed, err
+}
+
+func (h *Apk) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, apk, "update", "-q")
+}
+
+func (h *Apk) repositoryFiles(repo packages.Repository) ([]packages.ConfigFile, error) {
+	key := packages.ConfigFile{Path: filepath.Join(h.keysDir, repo.Name+".rsa.pub")}
+	if repo.Key != "" {
+		key.Content = []byte(strings.TrimSpace(repo.Key) + "\n")
+	}
+
+	content, err := os.ReadFile(h.repositories)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return []packages.ConfigFile{
+		key,
+		{Path: h.repositories, Content: setRepositoryLine(content, repo)},
+	}, nil
+}
+
+// setRepositoryLine returns the repositories file with the line of the
+// repository replaced, or appended when it is not yet present.  A disabled
+// repository is commented out.
+func setRepositoryLine(content []byte, repo packages.Repository) []byte {
+	marker := repositoryMarker + repo.Name
+	line := repo.URL
+	if !repo.Enabled {
+		line = "#" + line
+	}
+
+	var lines []string
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	found := false
+	for i := 0; i < len(lines); i++ {
+		if lines[i] != marker {
+			continue
+		}
+		found = true
+		if i+1 < len(lines) {
+			lines[i+1] = line
+		} else {
+			lines = append(lines, line)
+		}
+		break
+	}
+	if !found {
+		lines = append(lines, marker, line)
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// matchAvailableOutput parses "apk list" output, in which the packages which
+// are not installed carry no status in brackets.  Packages matching a name by
+// pattern only are left out.
+func (h *Apk) matchAvailableOutput(output string, names []string) map[string]string {
+	re := regexp.MustCompile(`^(.+)-([^-]+)-r([^-\s]+) \S+ \{\S+\} \(.+?\)`)
+
+	available := make(map[string]string)
+	for _, l := range strings.Split(output, "\n") {
+		m := re.FindStringSubmatch(l)
+		if m == nil || !slices.Contains(names, m[1]) {
+			continue
+		}
+		if _, ok := available[m[1]]; !ok {
+			available[m[1]] = m[2] + "-r" + m[3]
+		}
+	}
+
+	return available
+}
+
 func (h *Apk) matchPackageOutput(output string) map[string]string {
 	re := regexp.MustCompile(`^(.+)-([^-]+)-r([^-]+) (\S+) \{(\S+)\} \((.+?)\) \[(\w+)\]$`)
 	lines := strings.Split(output, "\n")
@@ -1,12 +1,17 @@
 package apk
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
 )
 
 func Test_Apk_matchPackageOutput(t *testing.T) {
@@ -54,3 +59,107 @@ func Test_Apk_matchPackageOutput(t *testing.T) {
 
 	assert.EqualValues(t, expected, results)
 }
+
+func Test_Apk_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	content, err := os.ReadFile("tests/apk_list.txt")
+	require.NoError(t, err)
+
+	world := filepath.Join(t.TempDir(), "world")
+	require.NoError(t, os.WriteFile(world, []byte("alpine-base\nnginx=1.24.0-r15\nopenrc>=0.52\nmusl~1.2\n"), 0o644))
+
+	mock := &handler.MockExecHandler{Output: []string{string(content), string(content)}}
+	h := &Apk{logger: logger, exec: mock, world: world}
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx"}, held)
+
+	require.NoError(t, h.Hold(ctx, "zlib"))
+	require.Error(t, h.Hold(ctx, "missing"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	require.Equal(t, [][]string{
+		{"list", "-I"},
+		{"add", "zlib=1.3.1-r0"},
+		{"list", "-I"},
+		{"add", "nginx"},
+	}, mock.Recorder[apk])
+}
+
+func Test_Apk_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	original := "https://dl-cdn.alpinelinux.org/alpine/v3.20/main\nhttps://dl-cdn.alpinelinux.org/alpine/v3.20/community\n"
+	repositories := filepath.Join(dir, "repositories")
+	require.NoError(t, os.WriteFile(repositories, []byte(original), 0o644))
+
+	exec := &handler.MockExecHandler{}
+	h := &Apk{logger: logger, exec: exec, repositories: repositories, keysDir: filepath.Join(dir, "keys")}
+
+	repo := packages.Repository{Name: "example", URL: "https://apk.example.com/main", Key: "-----BEGIN PUBLIC KEY-----", Enabled: true}
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(repositories)
+	require.NoError(t, err)
+	require.Equal(t, original+"# nodemanager: example\nhttps://apk.example.com/main\n", string(content))
+	require.FileExists(t, filepath.Join(dir, "keys", "example.rsa.pub"))
+
+	ok, err = h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Disabling comments the line out in place.
+	repo.Enabled = false
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(repositories)
+	require.NoError(t, err)
+	require.Equal(t, original+"# nodemanager: example\n#https://apk.example.com/main\n", string(content))
+
+	require.NoError(t, h.Refresh(ctx))
+	require.Equal(t, [][]string{{"update", "-q"}}, exec.Recorder[apk])
+}
+
+func Test_Apk_Batch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{
+		"nginx-1.26.1-r0 x86_64 {nginx} (BSD-2-Clause) [upgradable from: nginx-1.24.0-r15]\n" +
+			"nginx-openrc-1.26.1-r0 x86_64 {nginx} (BSD-2-Clause)\n" +
+			"curl-8.9.1-r0 x86_64 {curl} (curl) [installed]\n",
+	}}
+	h := &Apk{logger: logger, exec: mock}
+
+	available, err := h.Available(ctx, []string{"nginx", "curl", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"nginx": "1.26.1-r0", "curl": "8.9.1-r0"}, available)
+
+	require.NoError(t, h.InstallPackages(ctx, []packages.Package{{Name: "nginx"}, {Name: "curl", Version: "8.9.1-r0"}}))
+	require.NoError(t, h.RemovePackages(ctx, []string{"telnet", "nano"}))
+	require.NoError(t, h.InstallPackages(ctx, nil))
+
+	require.Equal(t, [][]string{
+		{"list", "nginx", "curl", "missing"},
+		{"add", "-u", "nginx", "curl=8.9.1-r0"},
+		{"del", "telnet", "nano"},
+	}, mock.Recorder[apk])
+}
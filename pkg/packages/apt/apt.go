@@ -0,0 +1,288 @@
+package apt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	env       = "/usr/bin/env"
+	aptGet    = "/usr/bin/apt-get"
+	aptCache  = "/usr/bin/apt-cache"
+	aptMark   = "/usr/bin/apt-mark"
+	dpkgQuery = "/usr/bin/dpkg-query"
+
+	sourcesDir     = "/etc/apt/sources.list.d"
+	keyringsDir    = "/etc/apt/keyrings"
+	preferencesDir = "/etc/apt/preferences.d"
+)
+
+var _ handler.PackageHandler = (*Apt)(nil)
+
+var tracer = otel.Tracer("packages/apt")
+
+type Apt struct {
+	exec           handler.ExecHandler
+	logger         *slog.Logger
+	sourcesDir     string
+	keyringsDir    string
+	preferencesDir string
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
+	return &Apt{
+		logger:         logger,
+		exec:           exec,
+		sourcesDir:     sourcesDir,
+		keyringsDir:    keyringsDir,
+		preferencesDir: preferencesDir,
+	}
+}
+
+func (h *Apt) Install(ctx context.Context, name, version string) error {
+	_, span := tracer.Start(ctx, "Install")
+	defer span.End()
+
+	pkg := name
+	if version != "" {
+		pkg = name + "=" + version
+	}
+
+	h.logger.Info("installing package", "name", name, "version", version)
+	return h.aptGet(ctx, "install", "-y", "-q", pkg)
+}
+
+func (h *Apt) Remove(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Remove")
+	defer span.End()
+	return h.aptGet(ctx, "remove", "-y", "-q", name)
+}
+
+func (h *Apt) List(ctx context.Context) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "List")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, dpkgQuery, "-W", "-f", "${db:Status-Abbrev} ${Package} ${Version}\n")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.matchPackageOutput(output), nil
+}
+
+func (h *Apt) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"install", "-y", "-q"}
+	for _, p := range pkgs {
+		target := p.Name
+		if p.Version != "" {
+			target = p.Name + "=" + p.Version
+		}
+		args = append(args, target)
+	}
+
+	h.logger.Info("installing packages", "count", len(pkgs))
+	return h.aptGet(ctx, args...)
+}
+
+func (h *Apt) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	h.logger.Info("removing packages", "count", len(names))
+	return h.aptGet(ctx, append([]string{"remove", "-y", "-q"}, names...)...)
+}
+
+// Available returns the candidate versions reported by apt-cache policy.
+func (h *Apt) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	output, _, err := h.exec.RunCommand(ctx, aptCache, append([]string{"policy"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return matchPolicyOutput(output), nil
+}
+
+func (h *Apt) UpgradeAll(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "UpgradeAll")
+	defer span.End()
+
+	err := h.aptGet(ctx, "update", "-q")
+	if err != nil {
+		return err
+	}
+
+	return h.aptGet(ctx, "upgrade", "-y", "-q")
+}
+
+func (h *Apt) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+	h.logger.Info("holding package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, aptMark, "hold", name)
+}
+
+func (h *Apt) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+	h.logger.Info("releasing package hold", "name", name)
+	return h.exec.SimpleRunCommand(ctx, aptMark, "unhold", name)
+}
+
+func (h *Apt) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, aptMark, "showhold")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(output), nil
+}
+
+func (h *Apt) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	files, err := h.repositoryFiles(repo)
+	if err != nil {
+		return false, err
+	}
+	changed, err := packages.ConfigFilesChanged(files)
+	return !changed, err
+}
+
+// EnsureRepository writes the repository to its own sources list, signed by
+// its own keyring.  A priority is applied with a pin on the origin of the
+// repository.
+func (h *Apt) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	files, err := h.repositoryFiles(repo)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := packages.WriteConfigFiles(files)
+	if changed {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed, err
+}
+
+func (h *Apt) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.aptGet(ctx, "update", "-q")
+}
+
+// repositoryFiles returns the files for the repository.  The URL is the rest
+// of a one-line "deb" entry, e.g. "https://deb.example.com/debian stable main".
+func (h *Apt) repositoryFiles(repo packages.Repository) ([]packages.ConfigFile, error) {
+	fields := strings.Fields(repo.URL)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("repository %q: url must include the suite, e.g. %q", repo.Name, "https://deb.example.com/debian stable main")
+	}
+
+	key := packages.ConfigFile{Path: filepath.Join(h.keyringsDir, repo.Name+".asc")}
+	pref := packages.ConfigFile{Path: filepath.Join(h.preferencesDir, repo.Name+".pref")}
+
+	line := "deb " + strings.Join(fields, " ")
+	if repo.Key != "" {
+		key.Content = []byte(strings.TrimSpace(repo.Key) + "\n")
+		line = fmt.Sprintf("deb [signed-by=%s] %s", key.Path, strings.Join(fields, " "))
+	}
+	if !repo.Enabled {
+		line = "# " + line
+	}
+
+	if repo.Priority != 0 {
+		u, err := url.Parse(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("repository %q: %w", repo.Name, err)
+		}
+		pref.Content = []byte(fmt.Sprintf("Package: *\nPin: origin %q\nPin-Priority: %d\n", u.Hostname(), repo.Priority))
+	}
+
+	return []packages.ConfigFile{
+		key,
+		pref,
+		{Path: filepath.Join(h.sourcesDir, repo.Name+".list"), Content: []byte(line + "\n")},
+	}, nil
+}
+
+// aptGet runs apt-get with debconf set to non-interactive so that package
+// maintainer scripts never block waiting on a prompt.
+func (h *Apt) aptGet(ctx context.Context, args ...string) error {
+	finalArgs := []string{"DEBIAN_FRONTEND=noninteractive", aptGet}
+	finalArgs = append(finalArgs, args...)
+	return h.exec.SimpleRunCommand(ctx, env, finalArgs...)
+}
+
+// matchPolicyOutput parses apt-cache policy output, in which each package
+// starts with an unindented "name:" line followed by its "Candidate:".
+func matchPolicyOutput(output string) map[string]string {
+	available := make(map[string]string)
+
+	var name string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" && !strings.HasPrefix(line, " ") {
+			name = strings.TrimSuffix(strings.TrimSpace(line), ":")
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || key != "Candidate" || name == "" {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "(none)" {
+			available[name] = value
+		}
+	}
+
+	return available
+}
+
+// matchPackageOutput parses dpkg-query output of the form
+// "<status> <name> <version>", keeping only packages whose desired and
+// current state are both installed ("ii").  Packages that were removed but
+// still have configuration files on disk ("rc") are not reported.
+func (h *Apt) matchPackageOutput(output string) map[string]string {
+	packages := make(map[string]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[0] != "ii" {
+			continue
+		}
+
+		packages[parts[1]] = parts[2]
+	}
+
+	return packages
+}
@@ -0,0 +1,166 @@
+package apt
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+func Test_Apt_matchPackageOutput(t *testing.T) {
+	content, err := os.ReadFile("tests/dpkg_query.txt")
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+
+	h := &Apt{
+		logger: logger,
+	}
+	results := h.matchPackageOutput(string(content))
+
+	expected := map[string]string{
+		"adduser":         "3.134",
+		"apt":             "2.6.1",
+		"base-files":      "12.4+deb12u5",
+		"bash":            "5.2.15-2+b2",
+		"ca-certificates": "20230311",
+		"coreutils":       "9.1-1",
+		"libc6":           "2.36-9+deb12u4",
+		"nginx":           "1.22.1-9",
+		"openssh-server":  "1:9.2p1-2+deb12u2",
+		"systemd":         "252.22-1~deb12u1",
+		"tzdata":          "2024a-0+deb12u1",
+	}
+
+	assert.EqualValues(t, expected, results)
+}
+
+func Test_Apt_Commands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	content, err := os.ReadFile("tests/dpkg_query.txt")
+	require.NoError(t, err)
+
+	mock := &handler.MockExecHandler{Output: []string{string(content)}}
+	h := New(logger, mock)
+
+	pkgs, err := h.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "1.22.1-9", pkgs["nginx"])
+	require.Equal(t, []string{"-W", "-f", "${db:Status-Abbrev} ${Package} ${Version}\n"}, mock.Recorder[dpkgQuery][0])
+
+	require.NoError(t, h.Install(ctx, "nginx", ""))
+	require.NoError(t, h.Install(ctx, "nginx", "1.22.1-9"))
+	require.NoError(t, h.Remove(ctx, "nginx"))
+	require.NoError(t, h.UpgradeAll(ctx))
+
+	expected := [][]string{
+		{"DEBIAN_FRONTEND=noninteractive", aptGet, "install", "-y", "-q", "nginx"},
+		{"DEBIAN_FRONTEND=noninteractive", aptGet, "install", "-y", "-q", "nginx=1.22.1-9"},
+		{"DEBIAN_FRONTEND=noninteractive", aptGet, "remove", "-y", "-q", "nginx"},
+		{"DEBIAN_FRONTEND=noninteractive", aptGet, "update", "-q"},
+		{"DEBIAN_FRONTEND=noninteractive", aptGet, "upgrade", "-y", "-q"},
+	}
+	require.Equal(t, expected, mock.Recorder[env])
+}
+
+func Test_Apt_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{"linux-image-amd64\nnginx\n"}}
+	h := New(logger, mock)
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux-image-amd64", "nginx"}, held)
+
+	require.NoError(t, h.Hold(ctx, "nginx"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	require.Equal(t, [][]string{
+		{"showhold"},
+		{"hold", "nginx"},
+		{"unhold", "nginx"},
+	}, mock.Recorder[aptMark])
+}
+
+func Test_Apt_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	h := &Apt{
+		logger:         logger,
+		exec:           &handler.MockExecHandler{},
+		sourcesDir:     filepath.Join(dir, "sources.list.d"),
+		keyringsDir:    filepath.Join(dir, "keyrings"),
+		preferencesDir: filepath.Join(dir, "preferences.d"),
+	}
+
+	repo := packages.Repository{
+		Name:     "example",
+		URL:      "https://deb.example.com/debian stable main",
+		Key:      "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+		Priority: 600,
+		Enabled:  true,
+	}
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(filepath.Join(dir, "sources.list.d", "example.list"))
+	require.NoError(t, err)
+	require.Equal(t, "deb [signed-by="+filepath.Join(dir, "keyrings", "example.asc")+"] https://deb.example.com/debian stable main\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dir, "preferences.d", "example.pref"))
+	require.NoError(t, err)
+	require.Equal(t, "Package: *\nPin: origin \"deb.example.com\"\nPin-Priority: 600\n", string(content))
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Dropping the priority removes the pin.
+	repo.Priority = 0
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoFileExists(t, filepath.Join(dir, "preferences.d", "example.pref"))
+
+	_, err = h.EnsureRepository(ctx, packages.Repository{Name: "bad", URL: "https://deb.example.com/debian"})
+	require.Error(t, err)
+}
+
+func Test_Apt_matchPolicyOutput(t *testing.T) {
+	output := `nginx:
+  Installed: 1.22.1-9
+  Candidate: 1.22.1-9+deb12u1
+  Version table:
+     1.22.1-9+deb12u1 500
+        500 http://deb.debian.org/debian-security bookworm-security/main amd64 Packages
+ *** 1.22.1-9 500
+        100 /var/lib/dpkg/status
+curl:
+  Installed: (none)
+  Candidate: 7.88.1-10+deb12u6
+  Version table:
+     7.88.1-10+deb12u6 500
+obsolete:
+  Installed: 1.0-1
+  Candidate: (none)
+`
+
+	require.Equal(t, map[string]string{
+		"nginx": "1.22.1-9+deb12u1",
+		"curl":  "7.88.1-10+deb12u6",
+	}, matchPolicyOutput(output))
+}
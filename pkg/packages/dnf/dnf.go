@@ -0,0 +1,262 @@
+package dnf
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	dnf      = "/usr/bin/dnf"
+	rpm      = "/usr/bin/rpm"
+	reposDir = "/etc/yum.repos.d"
+	keysDir  = "/etc/pki/rpm-gpg"
+)
+
+var (
+	_ handler.PackageHandler = (*Dnf)(nil)
+	_ handler.VersionMatcher = (*Dnf)(nil)
+)
+
+var tracer = otel.Tracer("packages/dnf")
+
+type Dnf struct {
+	exec     handler.ExecHandler
+	logger   *slog.Logger
+	reposDir string
+	keysDir  string
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
+	return &Dnf{
+		logger:   logger,
+		exec:     exec,
+		reposDir: reposDir,
+		keysDir:  keysDir,
+	}
+}
+
+func (h *Dnf) Install(ctx context.Context, name, version string) error {
+	_, span := tracer.Start(ctx, "Install")
+	defer span.End()
+
+	// dnf identifies exact versions as "name-version[-release]"
+	pkg := name
+	if version != "" {
+		pkg = name + "-" + version
+	}
+
+	h.logger.Info("installing package", "name", name, "version", version)
+	return h.exec.SimpleRunCommand(ctx, dnf, "install", "-y", "-q", pkg)
+}
+
+func (h *Dnf) Remove(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Remove")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, dnf, "remove", "-y", "-q", name)
+}
+
+func (h *Dnf) List(ctx context.Context) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "List")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, rpm, "-qa", "--queryformat", "%{NAME} %{VERSION}-%{RELEASE}\n")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.matchPackageOutput(output), nil
+}
+
+// VersionMatches compares the VERSION-RELEASE reported by List with a
+// requested version, which matches any release when it has none, as dnf
+// installs "name-version" at the latest release of that version.
+func (h *Dnf) VersionMatches(installed, requested string) bool {
+	if strings.Contains(requested, "-") {
+		return installed == requested
+	}
+	version, _, _ := strings.Cut(installed, "-")
+	return version == requested
+}
+
+func (h *Dnf) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"install", "-y", "-q"}
+	for _, p := range pkgs {
+		target := p.Name
+		if p.Version != "" {
+			target = p.Name + "-" + p.Version
+		}
+		args = append(args, target)
+	}
+
+	h.logger.Info("installing packages", "count", len(pkgs))
+	return h.exec.SimpleRunCommand(ctx, dnf, args...)
+}
+
+func (h *Dnf) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	return h.exec.SimpleRunCommand(ctx, dnf, append([]string{"remove", "-y", "-q"}, names...)...)
+}
+
+func (h *Dnf) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	args := append([]string{"repoquery", "-q", "--latest-limit", "1", "--queryformat", "%{NAME} %{VERSION}-%{RELEASE}\n"}, names...)
+	output, _, err := h.exec.RunCommand(ctx, dnf, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.matchPackageOutput(output), nil
+}
+
+func (h *Dnf) UpgradeAll(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "UpgradeAll")
+	defer span.End()
+
+	return h.exec.SimpleRunCommand(ctx, dnf, "upgrade", "-y", "-q", "--refresh")
+}
+
+// Hold adds a versionlock for the installed version of the package.  This
+// requires the dnf versionlock plugin.
+func (h *Dnf) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+	h.logger.Info("locking package version", "name", name)
+	return h.exec.SimpleRunCommand(ctx, dnf, "versionlock", "add", "-q", name)
+}
+
+func (h *Dnf) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+	h.logger.Info("removing package versionlock", "name", name)
+	return h.exec.SimpleRunCommand(ctx, dnf, "versionlock", "delete", "-q", name)
+}
+
+func (h *Dnf) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, dnf, "versionlock", "list", "-q")
+	if err != nil {
+		return nil, err
+	}
+
+	return matchVersionlockOutput(output), nil
+}
+
+func (h *Dnf) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	changed, err := packages.ConfigFilesChanged(h.repositoryFiles(repo))
+	return !changed, err
+}
+
+// EnsureRepository writes the repository to its own .repo file, and its
+// ASCII armored GPG key to the rpm-gpg directory.
+func (h *Dnf) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	changed, err := packages.WriteConfigFiles(h.repositoryFiles(repo))
+	if changed {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed, err
+}
+
+func (h *Dnf) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, dnf, "makecache", "-q")
+}
+
+func (h *Dnf) repositoryFiles(repo packages.Repository) []packages.ConfigFile {
+	key := packages.ConfigFile{Path: filepath.Join(h.keysDir, "RPM-GPG-KEY-"+repo.Name)}
+
+	enabled := 0
+	if repo.Enabled {
+		enabled = 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", repo.Name)
+	fmt.Fprintf(&b, "name=%s\n", repo.Name)
+	fmt.Fprintf(&b, "baseurl=%s\n", repo.URL)
+	fmt.Fprintf(&b, "enabled=%d\n", enabled)
+	if repo.Priority != 0 {
+		fmt.Fprintf(&b, "priority=%d\n", repo.Priority)
+	}
+	if repo.Key != "" {
+		key.Content = []byte(strings.TrimSpace(repo.Key) + "\n")
+		b.WriteString("gpgcheck=1\n")
+		fmt.Fprintf(&b, "gpgkey=file://%s\n", key.Path)
+	} else {
+		b.WriteString("gpgcheck=0\n")
+	}
+
+	return []packages.ConfigFile{
+		key,
+		{Path: filepath.Join(h.reposDir, repo.Name+".repo"), Content: []byte(b.String())},
+	}
+}
+
+// matchVersionlockOutput returns the package names of versionlock entries of
+// the form "<name>-<epoch>:<version>-<release>.*".
+func matchVersionlockOutput(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.ContainsAny(line, " \t") {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(line, ".*"), "-")
+		if len(parts) < 3 {
+			continue
+		}
+		names = append(names, strings.Join(parts[:len(parts)-2], "-"))
+	}
+	return names
+}
+
+// matchPackageOutput parses rpm output of the form "<name> <version>-<release>".
+// The gpg-pubkey pseudo-packages that rpm reports for imported signing keys
+// are not installable and are skipped.
+func (h *Dnf) matchPackageOutput(output string) map[string]string {
+	packages := make(map[string]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if parts[0] == "gpg-pubkey" {
+			continue
+		}
+
+		packages[parts[0]] = parts[1]
+	}
+
+	return packages
+}
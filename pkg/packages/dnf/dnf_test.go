@@ -0,0 +1,137 @@
+package dnf
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+func Test_Dnf_matchPackageOutput(t *testing.T) {
+	content, err := os.ReadFile("tests/rpm_qa.txt")
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+
+	h := &Dnf{
+		logger: logger,
+	}
+	results := h.matchPackageOutput(string(content))
+
+	expected := map[string]string{
+		"bash":           "5.1.8-9.el9",
+		"coreutils":      "8.32-35.el9",
+		"dnf":            "4.14.0-9.el9",
+		"glibc":          "2.34-100.el9_4.2",
+		"kernel-core":    "5.14.0-427.16.1.el9_4",
+		"nginx":          "1.20.1-14.el9_2.1",
+		"openssh-server": "8.7p1-38.el9",
+		"rocky-release":  "9.4-1.7.el9",
+		"systemd":        "252-32.el9_4",
+	}
+
+	assert.EqualValues(t, expected, results)
+}
+
+func Test_Dnf_Commands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	content, err := os.ReadFile("tests/rpm_qa.txt")
+	require.NoError(t, err)
+
+	mock := &handler.MockExecHandler{Output: []string{string(content)}}
+	h := New(logger, mock)
+
+	pkgs, err := h.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "1.20.1-14.el9_2.1", pkgs["nginx"])
+	require.Equal(t, []string{"-qa", "--queryformat", "%{NAME} %{VERSION}-%{RELEASE}\n"}, mock.Recorder[rpm][0])
+
+	require.NoError(t, h.Install(ctx, "nginx", ""))
+	require.NoError(t, h.Install(ctx, "nginx", "1.20.1-14.el9_2.1"))
+	require.NoError(t, h.Remove(ctx, "nginx"))
+	require.NoError(t, h.UpgradeAll(ctx))
+
+	expected := [][]string{
+		{"install", "-y", "-q", "nginx"},
+		{"install", "-y", "-q", "nginx-1.20.1-14.el9_2.1"},
+		{"remove", "-y", "-q", "nginx"},
+		{"upgrade", "-y", "-q", "--refresh"},
+	}
+	require.Equal(t, expected, mock.Recorder[dnf])
+}
+
+func Test_Dnf_VersionMatches(t *testing.T) {
+	h := &Dnf{}
+
+	require.True(t, h.VersionMatches("1.20.1-14.el9_2.1", "1.20.1-14.el9_2.1"))
+	require.True(t, h.VersionMatches("1.20.1-14.el9_2.1", "1.20.1"), "a version without a release matches any release")
+	require.False(t, h.VersionMatches("1.20.1-14.el9_2.1", "1.20.1-13.el9"))
+	require.False(t, h.VersionMatches("1.20.1-14.el9_2.1", "1.20"))
+	require.False(t, h.VersionMatches("1.22.1-1.el9", "1.20.1"))
+}
+
+func Test_Dnf_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{"# Added lock on Mon Apr  1 2024\nnginx-1:1.20.1-14.el9_2.1.*\nkernel-core-0:5.14.0-427.16.1.el9_4.*\n"}}
+	h := New(logger, mock)
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx", "kernel-core"}, held)
+
+	require.NoError(t, h.Hold(ctx, "nginx"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	require.Equal(t, [][]string{
+		{"versionlock", "list", "-q"},
+		{"versionlock", "add", "-q", "nginx"},
+		{"versionlock", "delete", "-q", "nginx"},
+	}, mock.Recorder[dnf])
+}
+
+func Test_Dnf_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	mock := &handler.MockExecHandler{}
+	h := &Dnf{logger: logger, exec: mock, reposDir: filepath.Join(dir, "yum.repos.d"), keysDir: filepath.Join(dir, "rpm-gpg")}
+
+	repo := packages.Repository{
+		Name:     "example",
+		URL:      "https://rpm.example.com/el9/$basearch",
+		Key:      "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+		Priority: 10,
+		Enabled:  true,
+	}
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(filepath.Join(dir, "yum.repos.d", "example.repo"))
+	require.NoError(t, err)
+	require.Equal(t, "[example]\nname=example\nbaseurl=https://rpm.example.com/el9/$basearch\nenabled=1\npriority=10\ngpgcheck=1\ngpgkey=file://"+
+		filepath.Join(dir, "rpm-gpg", "RPM-GPG-KEY-example")+"\n", string(content))
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	require.NoError(t, h.Refresh(ctx))
+	require.Equal(t, [][]string{{"makecache", "-q"}}, mock.Recorder[dnf])
+}
@@ -6,12 +6,22 @@ const (
 	UnhandledPackageEnsure PackageEnsure = iota
 	Installed
 	Absent
+	// Latest installs the package and upgrades it whenever the repositories
+	// offer a different version.
+	Latest
 )
 
 var EnsureByName map[string]PackageEnsure = map[string]PackageEnsure{
 	"unhandled": UnhandledPackageEnsure,
 	"installed": Installed,
 	"absent":    Absent,
+	"latest":    Latest,
+}
+
+// Package is a package to install, at Version when it is set.
+type Package struct {
+	Name    string
+	Version string
 }
 
 func (p PackageEnsure) String() string {
@@ -22,6 +32,8 @@ func (p PackageEnsure) String() string {
 		return "installed"
 	case Absent:
 		return "absent"
+	case Latest:
+		return "latest"
 	}
 	return "unhandled"
 }
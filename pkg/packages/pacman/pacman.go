@@ -6,13 +6,20 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
 	"go.opentelemetry.io/otel"
 )
 
-const pacman = "/usr/bin/pacman"
+const (
+	pacman     = "/usr/bin/pacman"
+	pacmanKey  = "/usr/bin/pacman-key"
+	pacmanConf = "/etc/pacman.conf"
+)
 
 var _ handler.PackageHandler = (*Pacman)(nil)
 
@@ -21,12 +28,14 @@ var tracer = otel.Tracer("packages/pacman")
 type Pacman struct {
 	exec   handler.ExecHandler
 	logger *slog.Logger
+	conf   string
 }
 
 func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
 	return &Pacman{
 		logger: logger,
 		exec:   exec,
+		conf:   pacmanConf,
 	}
 }
 
@@ -73,9 +82,349 @@ func (h *Pacman) List(ctx context.Context) (map[string]string, error) {
 	return packages, nil
 }
 
+// InstallPackages syncs the package metadata once and installs the packages
+// in one transaction.
+func (h *Pacman) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"-Sy", "--needed", "--noconfirm"}
+	for _, p := range pkgs {
+		target := p.Name
+		if p.Version != "" {
+			target = p.Name + "=" + p.Version
+		}
+		args = append(args, target)
+	}
+
+	h.logger.Info("installing packages", "count", len(pkgs))
+	return h.exec.SimpleRunCommand(ctx, pacman, args...)
+}
+
+func (h *Pacman) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	h.logger.Info("removing packages", "count", len(names))
+	return h.exec.SimpleRunCommand(ctx, pacman, append([]string{"-Rcs", "--noconfirm"}, names...)...)
+}
+
+// Available reads the versions from the sync databases, listed as
+// "repo name version [installed]".  The first repository offering a package
+// wins, as it does for pacman.
+func (h *Pacman) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	output, _, err := h.exec.RunCommand(ctx, pacman, "-Sl")
+	if err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 3 || !slices.Contains(names, parts[1]) {
+			continue
+		}
+		if _, ok := available[parts[1]]; !ok {
+			available[parts[1]] = parts[2]
+		}
+	}
+
+	return available, nil
+}
+
 func (h *Pacman) UpgradeAll(ctx context.Context) error {
 	_, span := tracer.Start(ctx, "UpgradeAll")
 	defer span.End()
 
 	return h.exec.SimpleRunCommand(ctx, pacman, "-Syu", "--noconfirm")
 }
+
+// Hold adds the package to IgnorePkg in the [options] section of pacman.conf.
+func (h *Pacman) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+
+	lines, err := h.readConf()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(ignoredPackages(lines), name) {
+		return nil
+	}
+
+	options := -1
+	for i, line := range lines {
+		if section, ok := confSection(line); ok && section == "options" {
+			options = i
+			break
+		}
+	}
+	if options < 0 {
+		return fmt.Errorf("no [options] section in %s", h.conf)
+	}
+
+	h.logger.Info("ignoring package upgrades", "name", name)
+
+	// Extend the first IgnorePkg line, or add one at the top of [options].
+	for i := options + 1; i < len(lines); i++ {
+		if _, ok := confSection(lines[i]); ok {
+			break
+		}
+		if _, ok := ignorePkgValue(lines[i]); ok {
+			lines[i] = strings.TrimRight(lines[i], " \t") + " " + name
+			return h.writeConf(lines)
+		}
+	}
+
+	lines = slices.Insert(lines, options+1, "IgnorePkg = "+name)
+	return h.writeConf(lines)
+}
+
+// Unhold removes the package from every IgnorePkg line of pacman.conf.
+func (h *Pacman) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+
+	lines, err := h.readConf()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(ignoredPackages(lines), name) {
+		return nil
+	}
+
+	h.logger.Info("allowing package upgrades", "name", name)
+
+	var (
+		result  []string
+		options bool
+	)
+	for _, line := range lines {
+		if section, ok := confSection(line); ok {
+			options = section == "options"
+		}
+		value, ok := ignorePkgValue(line)
+		if !options || !ok {
+			result = append(result, line)
+			continue
+		}
+
+		pkgs := slices.DeleteFunc(strings.Fields(value), func(p string) bool { return p == name })
+		if len(pkgs) > 0 {
+			result = append(result, "IgnorePkg = "+strings.Join(pkgs, " "))
+		}
+	}
+
+	return h.writeConf(result)
+}
+
+// Held returns the packages listed by IgnorePkg in pacman.conf.
+func (h *Pacman) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+
+	lines, err := h.readConf()
+	if err != nil {
+		return nil, err
+	}
+
+	return ignoredPackages(lines), nil
+}
+
+func (h *Pacman) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	file, err := h.repositoryFile(repo)
+	if err != nil {
+		return false, err
+	}
+	changed, err := packages.ConfigFilesChanged([]packages.ConfigFile{file})
+	if err != nil || changed {
+		return false, err
+	}
+
+	return h.keyTrusted(ctx, repo)
+}
+
+// EnsureRepository writes a section for the repository to pacman.conf, or
+// removes it when the repository is disabled.  The key is the fingerprint of
+// the signing key, which is received from the keyserver and locally signed.
+// pacman has no repository priority; repositories are added after the
+// existing ones.
+func (h *Pacman) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	var changed bool
+
+	trusted, err := h.keyTrusted(ctx, repo)
+	if err != nil {
+		return false, err
+	}
+	if !trusted {
+		h.logger.Info("importing repository key", "name", repo.Name, "key", repo.Key)
+		if err := h.exec.SimpleRunCommand(ctx, pacmanKey, "--recv-keys", repo.Key); err != nil {
+			return false, err
+		}
+		if err := h.exec.SimpleRunCommand(ctx, pacmanKey, "--lsign-key", repo.Key); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	file, err := h.repositoryFile(repo)
+	if err != nil {
+		return changed, err
+	}
+	written, err := packages.WriteConfigFiles([]packages.ConfigFile{file})
+	if written {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed || written, err
+}
+
+func (h *Pacman) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, pacman, "-Sy", "--noconfirm")
+}
+
+// keyTrusted reports whether the key of an enabled repository is in the
+// pacman keyring.
+func (h *Pacman) keyTrusted(ctx context.Context, repo packages.Repository) (bool, error) {
+	if repo.Key == "" || !repo.Enabled {
+		return true, nil
+	}
+	// pacman-key exits non-zero for a key which is not in the keyring.
+	_, exit, err := h.exec.RunCommand(ctx, pacmanKey, "--list-keys", repo.Key)
+	if err != nil && exit <= 0 {
+		return false, err
+	}
+	return exit == 0, nil
+}
+
+func (h *Pacman) repositoryFile(repo packages.Repository) (packages.ConfigFile, error) {
+	info, err := os.Stat(h.conf)
+	if err != nil {
+		return packages.ConfigFile{}, err
+	}
+	lines, err := h.readConf()
+	if err != nil {
+		return packages.ConfigFile{}, err
+	}
+
+	lines = setRepositorySection(lines, repo)
+	return packages.ConfigFile{
+		Path:    h.conf,
+		Content: []byte(strings.Join(lines, "\n") + "\n"),
+		Mode:    info.Mode().Perm(),
+	}, nil
+}
+
+// setRepositorySection replaces the section of the repository in place,
+// appends it when it is not yet present, and removes it when the repository
+// is disabled.
+func setRepositorySection(lines []string, repo packages.Repository) []string {
+	var section []string
+	if repo.Enabled {
+		section = []string{"[" + repo.Name + "]", "Server = " + repo.URL}
+	}
+
+	start := -1
+	for i, line := range lines {
+		if name, ok := confSection(line); ok && name == repo.Name {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 {
+		if section == nil {
+			return lines
+		}
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, section...)
+	}
+
+	end := start + 1
+	for end < len(lines) {
+		if _, ok := confSection(lines[end]); ok {
+			break
+		}
+		end++
+	}
+	// Leave the blank lines between this section and the next one.
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	// Take the blank line which separated a removed section.
+	if section == nil && start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+		start--
+	}
+
+	return slices.Concat(lines[:start], section, lines[end:])
+}
+
+func (h *Pacman) readConf() ([]string, error) {
+	content, err := os.ReadFile(h.conf)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"), nil
+}
+
+func (h *Pacman) writeConf(lines []string) error {
+	info, err := os.Stat(h.conf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.conf, []byte(strings.Join(lines, "\n")+"\n"), info.Mode().Perm())
+}
+
+// ignoredPackages returns the packages of the IgnorePkg lines in the
+// [options] section.
+func ignoredPackages(lines []string) []string {
+	var (
+		pkgs    []string
+		options bool
+	)
+	for _, line := range lines {
+		if section, ok := confSection(line); ok {
+			options = section == "options"
+			continue
+		}
+		if value, ok := ignorePkgValue(line); ok && options {
+			pkgs = append(pkgs, strings.Fields(value)...)
+		}
+	}
+	return pkgs
+}
+
+// confSection returns the name of the section a "[name]" line starts.
+func confSection(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return line[1 : len(line)-1], true
+	}
+	return "", false
+}
+
+// ignorePkgValue returns the value of an uncommented "IgnorePkg = ..." line.
+func ignorePkgValue(line string) (string, bool) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok || strings.TrimSpace(key) != "IgnorePkg" {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
@@ -0,0 +1,159 @@
+package pacman
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+func Test_Pacman_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	original, err := os.ReadFile("tests/pacman.conf")
+	require.NoError(t, err)
+
+	conf := filepath.Join(t.TempDir(), "pacman.conf")
+	require.NoError(t, os.WriteFile(conf, original, 0o644))
+
+	h := &Pacman{logger: logger, exec: &handler.MockExecHandler{}, conf: conf}
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Empty(t, held)
+
+	require.NoError(t, h.Hold(ctx, "linux"))
+	require.NoError(t, h.Hold(ctx, "nginx"))
+	require.NoError(t, h.Hold(ctx, "nginx"))
+
+	held, err = h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux", "nginx"}, held)
+
+	content, err := os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "[options]\nIgnorePkg = linux nginx\n")
+	require.Contains(t, string(content), "#IgnorePkg   =\n")
+
+	require.NoError(t, h.Unhold(ctx, "linux"))
+	held, err = h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx"}, held)
+
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	content, err = os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, string(original), string(content))
+}
+
+func Test_Pacman_HoldNoOptions(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "pacman.conf")
+	require.NoError(t, os.WriteFile(conf, []byte("[core]\nInclude = /etc/pacman.d/mirrorlist\n"), 0o644))
+
+	h := &Pacman{logger: slog.New(slog.NewTextHandler(os.Stdout, nil)), conf: conf}
+	require.Error(t, h.Hold(context.Background(), "linux"))
+}
+
+func Test_Pacman_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	original, err := os.ReadFile("tests/pacman.conf")
+	require.NoError(t, err)
+
+	conf := filepath.Join(t.TempDir(), "pacman.conf")
+	require.NoError(t, os.WriteFile(conf, original, 0o644))
+
+	// The key is not in the keyring until it is received.
+	exec := &handler.MockExecHandler{Status: []int{1}}
+	h := &Pacman{logger: logger, exec: exec, conf: conf}
+
+	repo := packages.Repository{Name: "example", URL: "https://arch.example.com/$arch", Key: "0123456789ABCDEF", Enabled: true}
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, [][]string{
+		{"--list-keys", "0123456789ABCDEF"},
+		{"--recv-keys", "0123456789ABCDEF"},
+		{"--lsign-key", "0123456789ABCDEF"},
+	}, exec.Recorder[pacmanKey])
+
+	content, err := os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, string(original)+"\n[example]\nServer = https://arch.example.com/$arch\n", string(content))
+
+	ok, err = h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// A changed URL replaces the section in place.
+	repo.URL = "https://mirror.example.com/$arch"
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, string(original)+"\n[example]\nServer = https://mirror.example.com/$arch\n", string(content))
+
+	// Disabling removes the section.
+	repo.Enabled = false
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, string(original), string(content))
+}
+
+func Test_setRepositorySection(t *testing.T) {
+	lines := []string{"[options]", "", "[example]", "Server = old", "", "[extra]", "Include = mirrorlist"}
+
+	result := setRepositorySection(slices.Clone(lines), packages.Repository{Name: "example", URL: "new", Enabled: true})
+	require.Equal(t, []string{"[options]", "", "[example]", "Server = new", "", "[extra]", "Include = mirrorlist"}, result)
+
+	result = setRepositorySection(slices.Clone(lines), packages.Repository{Name: "example"})
+	require.Equal(t, []string{"[options]", "", "[extra]", "Include = mirrorlist"}, result)
+}
+
+func Test_Pacman_Batch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{
+		"core linux 6.10.3.arch1-1 [installed: 6.9.1.arch1-1]\n" +
+			"extra nginx 1.26.1-1\n" +
+			"custom nginx 1.27.0-1\n",
+	}}
+	h := &Pacman{logger: logger, exec: mock}
+
+	available, err := h.Available(ctx, []string{"linux", "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"linux": "6.10.3.arch1-1", "nginx": "1.26.1-1"}, available)
+
+	require.NoError(t, h.InstallPackages(ctx, []packages.Package{{Name: "nginx"}, {Name: "git", Version: "2.46.0-1"}}))
+	require.NoError(t, h.RemovePackages(ctx, []string{"telnet", "nano"}))
+	require.NoError(t, h.RemovePackages(ctx, nil))
+
+	require.Equal(t, [][]string{
+		{"-Sl"},
+		{"-Sy", "--needed", "--noconfirm", "nginx", "git=2.46.0-1"},
+		{"-Rcs", "--noconfirm", "telnet", "nano"},
+	}, mock.Recorder[pacman])
+}
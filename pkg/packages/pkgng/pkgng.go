@@ -2,30 +2,41 @@ package pkgng
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 )
 
-const pkg = "/usr/sbin/pkg"
+const (
+	pkg      = "/usr/sbin/pkg"
+	reposDir = "/usr/local/etc/pkg/repos"
+	keysDir  = "/usr/local/etc/pkg/keys"
+)
 
 var _ handler.PackageHandler = (*Pkgng)(nil)
 
 var tracer = otel.Tracer("packages/pkgng")
 
 type Pkgng struct {
-	logger *slog.Logger
-	exec   handler.ExecHandler
+	logger   *slog.Logger
+	exec     handler.ExecHandler
+	reposDir string
+	keysDir  string
 }
 
 func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
 	return &Pkgng{
-		logger: logger,
-		exec:   exec,
+		logger:   logger,
+		exec:     exec,
+		reposDir: reposDir,
+		keysDir:  keysDir,
 	}
 }
 
@@ -85,6 +96,70 @@ func (h *Pkgng) List(ctx context.Context) (map[string]string, error) {
 	return packages, nil
 }
 
+// InstallPackages installs the packages in one transaction.  Packages already
+// installed are upgraded when the repositories offer a newer version.
+func (h *Pkgng) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"install", "-qy"}
+	for _, p := range pkgs {
+		target := p.Name
+		if p.Version != "" {
+			target = p.Name + "-" + p.Version
+		}
+		args = append(args, target)
+	}
+
+	span.SetAttributes(attribute.Int("count", len(pkgs)))
+	h.logger.Info("installing packages", "count", len(pkgs))
+
+	return h.exec.SimpleRunCommand(ctx, pkg, args...)
+}
+
+func (h *Pkgng) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	span.SetAttributes(attribute.Int("count", len(names)))
+	h.logger.Info("removing packages", "count", len(names))
+
+	return h.exec.SimpleRunCommand(ctx, pkg, append([]string{"remove", "-qy"}, names...)...)
+}
+
+// Available queries the repository catalogue without updating it.
+func (h *Pkgng) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	output, _, err := h.exec.RunCommand(ctx, pkg, append([]string{"rquery", "-U", "%n %v"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 {
+			available[parts[0]] = parts[1]
+		}
+	}
+
+	return available, nil
+}
+
 func (h *Pkgng) UpgradeAll(ctx context.Context) error {
 	_, span := tracer.Start(ctx, "UpgradeAll")
 	defer span.End()
@@ -93,3 +168,81 @@ func (h *Pkgng) UpgradeAll(ctx context.Context) error {
 
 	return h.exec.SimpleRunCommand(ctx, pkg, "upgrade", "-y")
 }
+
+// Hold locks the package, which prevents pkg from modifying or removing it.
+func (h *Pkgng) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+	h.logger.Info("locking package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, pkg, "lock", "-qy", name)
+}
+
+func (h *Pkgng) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+	h.logger.Info("unlocking package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, pkg, "unlock", "-qy", name)
+}
+
+func (h *Pkgng) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, pkg, "query", "-e", "%k == 1", "%n")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(output), nil
+}
+
+func (h *Pkgng) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	changed, err := packages.ConfigFilesChanged(h.repositoryFiles(repo))
+	return !changed, err
+}
+
+// EnsureRepository writes the repository to its own file in the repos
+// directory, and its public key next to the other keys.
+func (h *Pkgng) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	changed, err := packages.WriteConfigFiles(h.repositoryFiles(repo))
+	if changed {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed, err
+}
+
+func (h *Pkgng) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, pkg, "update", "-q")
+}
+
+func (h *Pkgng) repositoryFiles(repo packages.Repository) []packages.ConfigFile {
+	key := packages.ConfigFile{Path: filepath.Join(h.keysDir, repo.Name+".pub")}
+
+	enabled := "no"
+	if repo.Enabled {
+		enabled = "yes"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: {\n", repo.Name)
+	fmt.Fprintf(&b, "  url: %q,\n", repo.URL)
+	fmt.Fprintf(&b, "  enabled: %s,\n", enabled)
+	if repo.Priority != 0 {
+		fmt.Fprintf(&b, "  priority: %d,\n", repo.Priority)
+	}
+	if repo.Key != "" {
+		key.Content = []byte(strings.TrimSpace(repo.Key) + "\n")
+		b.WriteString("  signature_type: \"pubkey\",\n")
+		fmt.Fprintf(&b, "  pubkey: %q,\n", key.Path)
+	}
+	b.WriteString("}\n")
+
+	return []packages.ConfigFile{
+		key,
+		{Path: filepath.Join(h.reposDir, repo.Name+".conf"), Content: []byte(b.String())},
+	}
+}
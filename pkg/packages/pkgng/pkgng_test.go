@@ -0,0 +1,132 @@
+package pkgng
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+func Test_Pkgng_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{"nginx\nopenssh-portable\n"}}
+	h := &Pkgng{logger: logger, exec: mock}
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx", "openssh-portable"}, held)
+
+	require.NoError(t, h.Hold(ctx, "zsh"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	require.Equal(t, [][]string{
+		{"query", "-e", "%k == 1", "%n"},
+		{"lock", "-qy", "zsh"},
+		{"unlock", "-qy", "nginx"},
+	}, mock.Recorder[pkg])
+}
+
+func Test_Pkgng_Batch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	// rquery prints nothing for a package the catalogue lacks.
+	mock := &handler.MockExecHandler{Output: []string{"nginx 1.26.1_1,3\ncurl 8.9.1\n\n"}}
+	h := &Pkgng{logger: logger, exec: mock}
+
+	available, err := h.Available(ctx, []string{"nginx", "curl", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"nginx": "1.26.1_1,3", "curl": "8.9.1"}, available)
+
+	require.NoError(t, h.InstallPackages(ctx, []packages.Package{{Name: "nginx"}, {Name: "curl", Version: "8.9.1"}}))
+	require.NoError(t, h.RemovePackages(ctx, []string{"telnet", "nano"}))
+	require.NoError(t, h.InstallPackages(ctx, nil))
+	require.NoError(t, h.RemovePackages(ctx, nil))
+
+	available, err = h.Available(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, available)
+
+	require.Equal(t, [][]string{
+		{"rquery", "-U", "%n %v", "nginx", "curl", "missing"},
+		{"install", "-qy", "nginx", "curl-8.9.1"},
+		{"remove", "-qy", "telnet", "nano"},
+	}, mock.Recorder[pkg])
+}
+
+func Test_Pkgng_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	exec := &handler.MockExecHandler{}
+	h := &Pkgng{logger: logger, exec: exec, reposDir: filepath.Join(dir, "repos"), keysDir: filepath.Join(dir, "keys")}
+
+	repo := packages.Repository{
+		Name:     "example",
+		URL:      "pkg+https://pkg.example.com/${ABI}/latest",
+		Key:      "-----BEGIN PUBLIC KEY-----\n",
+		Priority: 10,
+		Enabled:  true,
+	}
+	conf := filepath.Join(dir, "repos", "example.conf")
+	key := filepath.Join(dir, "keys", "example.pub")
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, "example: {\n"+
+		"  url: \"pkg+https://pkg.example.com/${ABI}/latest\",\n"+
+		"  enabled: yes,\n"+
+		"  priority: 10,\n"+
+		"  signature_type: \"pubkey\",\n"+
+		"  pubkey: \""+key+"\",\n"+
+		"}\n", string(content))
+
+	content, err = os.ReadFile(key)
+	require.NoError(t, err)
+	require.Equal(t, "-----BEGIN PUBLIC KEY-----\n", string(content))
+
+	ok, err = h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Disabling keeps the file with enabled: no, and a repository without a
+	// key drops the signature and its key file.
+	repo.Enabled = false
+	repo.Key = ""
+	repo.Priority = 0
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, "example: {\n"+
+		"  url: \"pkg+https://pkg.example.com/${ABI}/latest\",\n"+
+		"  enabled: no,\n"+
+		"}\n", string(content))
+	require.NoFileExists(t, key)
+
+	require.NoError(t, h.Refresh(ctx))
+	require.Equal(t, [][]string{{"update", "-q"}}, exec.Recorder[pkg])
+}
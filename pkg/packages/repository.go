@@ -0,0 +1,94 @@
+package packages
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// Repository is a package repository to configure on the node.
+type Repository struct {
+	// Name identifies the repository, and names the files which configure
+	// it.
+	Name string
+	URL  string
+	// Key is the public key which signs the repository.  Its format depends
+	// on the package manager.
+	Key string
+	// Priority orders the repository against the others, where the package
+	// manager supports it.  Zero leaves the default.
+	Priority int
+	Enabled  bool
+}
+
+// ConfigFile is the desired content of a file which configures a package
+// manager.  A nil Content removes the file.
+type ConfigFile struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// ConfigFilesChanged reports whether any of the files differs from disk.
+func ConfigFilesChanged(files []ConfigFile) (bool, error) {
+	for _, f := range files {
+		changed, err := configFileChanged(f)
+		if err != nil || changed {
+			return changed, err
+		}
+	}
+	return false, nil
+}
+
+// WriteConfigFiles writes the files which differ from disk, and reports
+// whether any of them changed.  Files are replaced by a rename, so that the
+// package manager never reads a partial file.
+func WriteConfigFiles(files []ConfigFile) (bool, error) {
+	var changed bool
+	for _, f := range files {
+		c, err := configFileChanged(f)
+		if err != nil {
+			return changed, err
+		}
+		if !c {
+			continue
+		}
+
+		if f.Content == nil {
+			if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				return changed, err
+			}
+			changed = true
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+			return changed, err
+		}
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0o644
+		}
+		tmp := f.Path + ".nodemanager.tmp"
+		if err := os.WriteFile(tmp, f.Content, mode); err != nil {
+			return changed, err
+		}
+		if err := os.Rename(tmp, f.Path); err != nil {
+			_ = os.Remove(tmp)
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func configFileChanged(f ConfigFile) (bool, error) {
+	current, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return f.Content != nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return f.Content == nil || !bytes.Equal(current, f.Content), nil
+}
@@ -0,0 +1,51 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "repos", "example.conf")
+	key := filepath.Join(dir, "keys", "example.pub")
+
+	files := []ConfigFile{
+		{Path: key, Content: []byte("key\n")},
+		{Path: conf, Content: []byte("conf\n")},
+	}
+
+	changed, err := ConfigFilesChanged(files)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	changed, err = WriteConfigFiles(files)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(conf)
+	require.NoError(t, err)
+	require.Equal(t, "conf\n", string(content))
+
+	changed, err = ConfigFilesChanged(files)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	changed, err = WriteConfigFiles(files)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// A nil content removes the file.
+	files[0].Content = nil
+	changed, err = WriteConfigFiles(files)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoFileExists(t, key)
+
+	changed, err = ConfigFilesChanged(files)
+	require.NoError(t, err)
+	require.False(t, changed)
+}
@@ -0,0 +1,253 @@
+package xbps
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	xbpsInstall = "/usr/bin/xbps-install"
+	xbpsRemove  = "/usr/bin/xbps-remove"
+	xbpsQuery   = "/usr/bin/xbps-query"
+	xbpsPkgdb   = "/usr/bin/xbps-pkgdb"
+	confDir     = "/etc/xbps.d"
+	keysDir     = "/var/db/xbps/keys"
+)
+
+var _ handler.PackageHandler = (*Xbps)(nil)
+
+var tracer = otel.Tracer("packages/xbps")
+
+type Xbps struct {
+	exec    handler.ExecHandler
+	logger  *slog.Logger
+	confDir string
+	keysDir string
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.PackageHandler {
+	return &Xbps{
+		logger:  logger,
+		exec:    exec,
+		confDir: confDir,
+		keysDir: keysDir,
+	}
+}
+
+func (h *Xbps) Install(ctx context.Context, name, version string) error {
+	_, span := tracer.Start(ctx, "Install")
+	defer span.End()
+
+	h.logger.Info("installing package", "name", name, "version", version)
+	return h.exec.SimpleRunCommand(ctx, xbpsInstall, "-y", target(name, version))
+}
+
+func (h *Xbps) Remove(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Remove")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, xbpsRemove, "-y", name)
+}
+
+func (h *Xbps) List(ctx context.Context) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "List")
+	defer span.End()
+	output, _, err := h.exec.RunCommand(ctx, xbpsQuery, "-l")
+	if err != nil {
+		return nil, err
+	}
+
+	return matchPackageOutput(output), nil
+}
+
+// InstallPackages installs the packages in one transaction.  Packages already
+// installed are updated to the latest available version.
+func (h *Xbps) InstallPackages(ctx context.Context, pkgs []packages.Package) error {
+	_, span := tracer.Start(ctx, "InstallPackages")
+	defer span.End()
+
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	args := []string{"-y", "-u"}
+	for _, p := range pkgs {
+		args = append(args, target(p.Name, p.Version))
+	}
+
+	h.logger.Info("installing packages", "count", len(pkgs))
+	return h.exec.SimpleRunCommand(ctx, xbpsInstall, args...)
+}
+
+func (h *Xbps) RemovePackages(ctx context.Context, names []string) error {
+	_, span := tracer.Start(ctx, "RemovePackages")
+	defer span.End()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	h.logger.Info("removing packages", "count", len(names))
+	return h.exec.SimpleRunCommand(ctx, xbpsRemove, append([]string{"-y"}, names...)...)
+}
+
+// Available queries the repositories for each package in turn, since
+// xbps-query reads the properties of one package at a time.
+func (h *Xbps) Available(ctx context.Context, names []string) (map[string]string, error) {
+	_, span := tracer.Start(ctx, "Available")
+	defer span.End()
+
+	available := make(map[string]string)
+	for _, name := range names {
+		output, exit, err := h.exec.RunCommand(ctx, xbpsQuery, "-R", "--property", "pkgver", name)
+		// xbps-query exits non-zero for a package which no repository offers.
+		if err != nil && exit <= 0 {
+			return nil, err
+		}
+		if exit != 0 {
+			continue
+		}
+
+		if pkgName, version, ok := splitPkgver(strings.TrimSpace(output)); ok && pkgName == name {
+			available[name] = version
+		}
+	}
+
+	return available, nil
+}
+
+// UpgradeAll updates xbps itself first, since xbps refuses to update the
+// other packages while a newer xbps is available.
+func (h *Xbps) UpgradeAll(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "UpgradeAll")
+	defer span.End()
+
+	err := h.exec.SimpleRunCommand(ctx, xbpsInstall, "-S", "-y", "-u", "xbps")
+	if err != nil {
+		return err
+	}
+
+	return h.exec.SimpleRunCommand(ctx, xbpsInstall, "-y", "-u")
+}
+
+// Hold sets the hold mode of the package in the package database, which
+// keeps it from being updated.
+func (h *Xbps) Hold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Hold")
+	defer span.End()
+	h.logger.Info("holding package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, xbpsPkgdb, "-m", "hold", name)
+}
+
+func (h *Xbps) Unhold(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unhold")
+	defer span.End()
+	h.logger.Info("unholding package", "name", name)
+	return h.exec.SimpleRunCommand(ctx, xbpsPkgdb, "-m", "unhold", name)
+}
+
+// Held returns the packages in hold mode, which xbps-query lists by pkgver.
+func (h *Xbps) Held(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "Held")
+	defer span.End()
+
+	output, _, err := h.exec.RunCommand(ctx, xbpsQuery, "-H")
+	if err != nil {
+		return nil, err
+	}
+
+	var held []string
+	for _, pkgver := range strings.Fields(output) {
+		if name, _, ok := splitPkgver(pkgver); ok {
+			held = append(held, name)
+		}
+	}
+
+	return held, nil
+}
+
+func (h *Xbps) RepositoryConfigured(ctx context.Context, repo packages.Repository) (bool, error) {
+	changed, err := packages.ConfigFilesChanged(h.repositoryFiles(repo))
+	return !changed, err
+}
+
+// EnsureRepository writes a file for the repository to xbps.d, or removes
+// it when the repository is disabled, and writes its key to the keys
+// directory.  xbps finds a key by the fingerprint of the repository which
+// was signed with it, so the repository should be named after that
+// fingerprint.  xbps has no repository priority; the files of xbps.d are
+// read in the order of their names.
+func (h *Xbps) EnsureRepository(ctx context.Context, repo packages.Repository) (bool, error) {
+	_, span := tracer.Start(ctx, "EnsureRepository")
+	defer span.End()
+
+	changed, err := packages.WriteConfigFiles(h.repositoryFiles(repo))
+	if changed {
+		h.logger.Info("configured repository", "name", repo.Name)
+	}
+	return changed, err
+}
+
+func (h *Xbps) Refresh(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "Refresh")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, xbpsInstall, "-S", "-y")
+}
+
+func (h *Xbps) repositoryFiles(repo packages.Repository) []packages.ConfigFile {
+	conf := packages.ConfigFile{Path: filepath.Join(h.confDir, repo.Name+".conf")}
+	if repo.Enabled {
+		conf.Content = []byte("repository=" + repo.URL + "\n")
+	}
+
+	key := packages.ConfigFile{Path: filepath.Join(h.keysDir, repo.Name+".plist")}
+	if repo.Key != "" {
+		key.Content = []byte(strings.TrimSpace(repo.Key) + "\n")
+	}
+
+	return []packages.ConfigFile{key, conf}
+}
+
+// target returns the argument which selects the package, at the exact
+// version when one is set.  A version is the pkgver suffix, e.g. "2.4.59_1".
+func target(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "-" + version
+}
+
+// splitPkgver splits a pkgver such as "nginx-1.26.1_1" at the last hyphen,
+// which xbps keeps out of versions.
+func splitPkgver(pkgver string) (string, string, bool) {
+	i := strings.LastIndex(pkgver, "-")
+	if i <= 0 || i == len(pkgver)-1 {
+		return "", "", false
+	}
+	return pkgver[:i], pkgver[i+1:], true
+}
+
+// matchPackageOutput parses "xbps-query -l" output, in which each line holds
+// the state, the pkgver and the description of an installed package.  Only
+// fully installed packages, with state "ii", are returned.
+func matchPackageOutput(output string) map[string]string {
+	pkgs := make(map[string]string)
+
+	for _, l := range strings.Split(output, "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 2 || fields[0] != "ii" {
+			continue
+		}
+
+		if name, version, ok := splitPkgver(fields[1]); ok {
+			pkgs[name] = version
+		}
+	}
+
+	return pkgs
+}
@@ -0,0 +1,128 @@
+package xbps
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/packages"
+)
+
+func Test_Xbps_matchPackageOutput(t *testing.T) {
+	content, err := os.ReadFile("tests/xbps_query_l.txt")
+	require.NoError(t, err)
+
+	results := matchPackageOutput(string(content))
+
+	expected := map[string]string{
+		"base-files":      "0.144_1",
+		"base-system":     "0.114_2",
+		"bash":            "5.2.21_1",
+		"ca-certificates": "20230311+3.97_1",
+		"chrony":          "4.5_1",
+		"glibc":           "2.38_3",
+		"libgcc":          "13.2.0_2",
+		"nginx":           "1.24.0_3",
+		"openssh":         "9.6p1_1",
+		"runit-void":      "20231124_1",
+		"xbps":            "0.59.2_3",
+	}
+
+	assert.EqualValues(t, expected, results)
+}
+
+func Test_Xbps_Hold(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{"nginx-1.24.0_3\nrunit-void-20231124_1\n"}}
+	h := &Xbps{logger: logger, exec: mock}
+
+	held, err := h.Held(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx", "runit-void"}, held)
+
+	require.NoError(t, h.Hold(ctx, "zlib"))
+	require.NoError(t, h.Unhold(ctx, "nginx"))
+
+	require.Equal(t, [][]string{{"-H"}}, mock.Recorder[xbpsQuery])
+	require.Equal(t, [][]string{
+		{"-m", "hold", "zlib"},
+		{"-m", "unhold", "nginx"},
+	}, mock.Recorder[xbpsPkgdb])
+}
+
+func Test_Xbps_Repository(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	exec := &handler.MockExecHandler{}
+	h := &Xbps{logger: logger, exec: exec, confDir: filepath.Join(dir, "xbps.d"), keysDir: filepath.Join(dir, "keys")}
+
+	repo := packages.Repository{Name: "example", URL: "https://xbps.example.com/current", Key: "<?xml version=\"1.0\"?>", Enabled: true}
+
+	ok, err := h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	changed, err := h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(filepath.Join(dir, "xbps.d", "example.conf"))
+	require.NoError(t, err)
+	require.Equal(t, "repository=https://xbps.example.com/current\n", string(content))
+	require.FileExists(t, filepath.Join(dir, "keys", "example.plist"))
+
+	ok, err = h.RepositoryConfigured(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Disabling removes the file of the repository.
+	repo.Enabled = false
+	changed, err = h.EnsureRepository(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoFileExists(t, filepath.Join(dir, "xbps.d", "example.conf"))
+
+	require.NoError(t, h.Refresh(ctx))
+	require.Equal(t, [][]string{{"-S", "-y"}}, exec.Recorder[xbpsInstall])
+}
+
+func Test_Xbps_Batch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{
+		Output: []string{"nginx-1.26.1_1\n", "curl-8.9.1_1\n", ""},
+		Status: []int{0, 0, 2},
+	}
+	h := &Xbps{logger: logger, exec: mock}
+
+	available, err := h.Available(ctx, []string{"nginx", "curl", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"nginx": "1.26.1_1", "curl": "8.9.1_1"}, available)
+
+	require.NoError(t, h.InstallPackages(ctx, []packages.Package{{Name: "nginx"}, {Name: "curl", Version: "8.9.1_1"}}))
+	require.NoError(t, h.RemovePackages(ctx, []string{"inetutils-telnet", "nano"}))
+	require.NoError(t, h.InstallPackages(ctx, nil))
+
+	require.Equal(t, [][]string{
+		{"-R", "--property", "pkgver", "nginx"},
+		{"-R", "--property", "pkgver", "curl"},
+		{"-R", "--property", "pkgver", "missing"},
+	}, mock.Recorder[xbpsQuery])
+	require.Equal(t, [][]string{{"-y", "-u", "nginx", "curl-8.9.1_1"}}, mock.Recorder[xbpsInstall])
+	require.Equal(t, [][]string{{"-y", "inetutils-telnet", "nano"}}, mock.Recorder[xbpsRemove])
+}
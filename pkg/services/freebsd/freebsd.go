@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"github.com/zachfi/nodemanager/pkg/services"
@@ -42,11 +43,52 @@ func (h *FreeBSD) Disable(ctx context.Context, name string) error {
 	return h.exec.SimpleRunCommand(ctx, "sysrc", "-f", rcFile, name+"_enable=NO")
 }
 
-func (h *FreeBSD) SetArguments(ctx context.Context, name string, args string) error {
-	_, span := tracer.Start(ctx, "SetArguments")
+// Enabled reports whether `service <name> enabled` succeeds, which reads
+// <name>_enable from every rc.conf file.
+func (h *FreeBSD) Enabled(ctx context.Context, name string) (bool, error) {
+	_, span := tracer.Start(ctx, "Enabled")
+	defer span.End()
+
+	_, code, err := h.exec.RunCommand(ctx, "service", name, "enabled")
+	if err != nil && code == 0 {
+		return false, err
+	}
+	return code == 0, nil
+}
+
+// SetArguments sets <name>_args in /etc/rc.conf.d/<name>, or removes it when
+// args is empty.
+func (h *FreeBSD) SetArguments(ctx context.Context, name string, args string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "SetArguments")
+	defer span.End()
+	rcFile := fmt.Sprintf("/etc/rc.conf.d/%s", name)
+
+	current, err := h.Arguments(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if current == args {
+		return false, nil
+	}
+
+	if args == "" {
+		return true, h.exec.SimpleRunCommand(ctx, "sysrc", "-f", rcFile, "-x", name+"_args")
+	}
+	return true, h.exec.SimpleRunCommand(ctx, "sysrc", "-f", rcFile, fmt.Sprintf("%s_args=%s", name, args))
+}
+
+// Arguments returns <name>_args from /etc/rc.conf.d/<name>.
+func (h *FreeBSD) Arguments(ctx context.Context, name string) (string, error) {
+	_, span := tracer.Start(ctx, "Arguments")
 	defer span.End()
 	rcFile := fmt.Sprintf("/etc/rc.conf.d/%s", name)
-	return h.exec.SimpleRunCommand(ctx, "sysrc", "-f", rcFile, fmt.Sprintf("%s_args=%s", name, args))
+
+	// -i reports an unset variable as empty rather than failing.
+	current, _, err := h.exec.RunCommand(ctx, "sysrc", "-f", rcFile, "-in", name+"_args")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(current), nil
 }
 
 func (h *FreeBSD) Start(ctx context.Context, name string) error {
@@ -1,28 +1,43 @@
 package openrc
 
 import (
+	"bytes"
 	"context"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"regexp"
 
+	"github.com/zachfi/nodemanager/pkg/files"
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"github.com/zachfi/nodemanager/pkg/services"
 	"go.opentelemetry.io/otel"
 )
 
+const (
+	confDir = "/etc/conf.d"
+	// runlevelDir holds a directory per runlevel with a link to each
+	// service added to it.
+	runlevelDir = "/etc/runlevels"
+)
+
 var _ handler.ServiceHandler = &OpenRC{}
 
 var tracer = otel.Tracer("services/openrc")
 
 type OpenRC struct {
-	exec   handler.ExecHandler
-	logger *slog.Logger
+	exec        handler.ExecHandler
+	logger      *slog.Logger
+	confDir     string
+	runlevelDir string
 }
 
 func New(logger *slog.Logger, exec handler.ExecHandler) handler.ServiceHandler {
 	return &OpenRC{
-		logger: logger,
-		exec:   exec,
+		logger:      logger,
+		exec:        exec,
+		confDir:     confDir,
+		runlevelDir: runlevelDir,
 	}
 }
 
@@ -38,10 +53,64 @@ func (h *OpenRC) Disable(ctx context.Context, name string) error {
 	return h.exec.SimpleRunCommand(ctx, "/sbin/rc-update", "del", name)
 }
 
-func (h *OpenRC) SetArguments(ctx context.Context, _, _ string) error {
+// Enabled reports whether the service was added to any runlevel.
+func (h *OpenRC) Enabled(ctx context.Context, name string) (bool, error) {
+	_, span := tracer.Start(ctx, "Enabled")
+	defer span.End()
+
+	matches, err := filepath.Glob(filepath.Join(h.runlevelDir, "*", name))
+	if err != nil {
+		return false, err
+	}
+	return len(matches) > 0, nil
+}
+
+// SetArguments sets command_args in /etc/conf.d/<name>, which the
+// openrc-run scripts pass to the command of the service, or removes it when
+// args is empty.  The other settings of the file are left alone.
+func (h *OpenRC) SetArguments(ctx context.Context, name, args string) (bool, error) {
 	_, span := tracer.Start(ctx, "SetArguments")
 	defer span.End()
-	return nil
+
+	path := filepath.Join(h.confDir, name)
+
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	content, err := files.EnsureKeyValues(current, []files.Setting{{Key: "command_args", Value: args, Absent: args == ""}})
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(content, current) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(h.confDir, 0o755); err != nil {
+		return false, err
+	}
+	// The file may hold credentials, so its mode and owner are kept.
+	if err := files.ReplaceFile(path, content, 0o644); err != nil {
+		return false, err
+	}
+
+	h.logger.Info("set service arguments", "name", name, "path", path)
+	return true, nil
+}
+
+// Arguments returns command_args from /etc/conf.d/<name>.
+func (h *OpenRC) Arguments(ctx context.Context, name string) (string, error) {
+	_, span := tracer.Start(ctx, "Arguments")
+	defer span.End()
+
+	content, err := os.ReadFile(filepath.Join(h.confDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	args, _ := files.LookupKeyValue(content, "command_args")
+	return args, nil
 }
 
 func (h *OpenRC) Start(ctx context.Context, name string) error {
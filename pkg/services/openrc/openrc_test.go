@@ -0,0 +1,84 @@
+package openrc
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+func TestOpenRCSetArguments(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sshd")
+
+	require.NoError(t, os.WriteFile(path, []byte("# sshd options\nrc_need=\"net\"\n"), 0o600))
+
+	h := &OpenRC{logger: logger, exec: &handler.MockExecHandler{}, confDir: dir}
+
+	changed, err := h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# sshd options\nrc_need=\"net\"\ncommand_args=\"-p 2222\"\n", string(content))
+
+	// The mode of an existing file is kept.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	changed, err = h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// A service without a conf.d file gets one.
+	changed, err = h.SetArguments(ctx, "chronyd", "-r")
+	require.NoError(t, err)
+	require.True(t, changed)
+	info, err = os.Stat(filepath.Join(dir, "chronyd"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	args, err := h.Arguments(ctx, "sshd")
+	require.NoError(t, err)
+	require.Equal(t, "-p 2222", args)
+
+	// Empty arguments remove command_args.
+	changed, err = h.SetArguments(ctx, "sshd", "")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# sshd options\nrc_need=\"net\"\n", string(content))
+
+	args, err = h.Arguments(ctx, "sshd")
+	require.NoError(t, err)
+	require.Empty(t, args)
+}
+
+func TestOpenRCEnabled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "default"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "boot"), 0o755))
+	require.NoError(t, os.Symlink("/etc/init.d/sshd", filepath.Join(dir, "default", "sshd")))
+	require.NoError(t, os.Symlink("/etc/init.d/hwclock", filepath.Join(dir, "boot", "hwclock")))
+
+	h := &OpenRC{logger: logger, exec: &handler.MockExecHandler{}, runlevelDir: dir}
+
+	for name, want := range map[string]bool{"sshd": true, "hwclock": true, "cupsd": false} {
+		enabled, err := h.Enabled(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, want, enabled, name)
+	}
+}
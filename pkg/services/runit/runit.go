@@ -0,0 +1,225 @@
+package runit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/files"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/services"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	sv = "/usr/bin/sv"
+	// svDir holds the service directories which are available.
+	svDir = "/etc/sv"
+	// serviceDir is scanned by runsvdir, which supervises the services
+	// linked into it.
+	serviceDir = "/var/service"
+)
+
+var _ handler.ServiceHandler = &Runit{}
+
+var tracer = otel.Tracer("services/runit")
+
+type Runit struct {
+	exec       handler.ExecHandler
+	logger     *slog.Logger
+	sv         string
+	svDir      string
+	serviceDir string
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.ServiceHandler {
+	return &Runit{
+		logger:     logger,
+		exec:       exec,
+		sv:         sv,
+		svDir:      svDir,
+		serviceDir: serviceDir,
+	}
+}
+
+// Enable links the service directory into /var/service, where runsvdir
+// picks it up and starts it within a few seconds.  A link to another service
+// directory is replaced; anything else in the way of the link is an error.
+func (h *Runit) Enable(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Enable")
+	defer span.End()
+
+	target := filepath.Join(h.svDir, name)
+	if _, err := os.Stat(target); err != nil {
+		return fmt.Errorf("service %q not found: %w", name, err)
+	}
+
+	link := filepath.Join(h.serviceDir, name)
+	info, err := os.Lstat(link)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink == 0:
+		return fmt.Errorf("%s exists and is not a link to %s", link, target)
+	default:
+		current, err := os.Readlink(link)
+		if err != nil {
+			return err
+		}
+		if current == target {
+			return nil
+		}
+
+		h.logger.Info("removing stale service link", "name", name, "target", current)
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+
+	h.logger.Info("enabling service", "name", name)
+	return os.Symlink(target, link)
+}
+
+// Disable removes the link of the service from /var/service.  runsvdir stops
+// the service when its link is gone.
+func (h *Runit) Disable(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Disable")
+	defer span.End()
+
+	err := os.Remove(filepath.Join(h.serviceDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		h.logger.Info("disabled service", "name", name)
+	}
+	return err
+}
+
+// Enabled reports whether the service is linked into the directory which
+// runsvdir scans.
+func (h *Runit) Enabled(ctx context.Context, name string) (bool, error) {
+	_, span := tracer.Start(ctx, "Enabled")
+	defer span.End()
+
+	_, err := os.Lstat(filepath.Join(h.serviceDir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetArguments sets OPTS in /etc/sv/<name>/conf, which the run scripts of
+// Void Linux source and pass to the command of the service, or removes it
+// when args is empty.  The other settings of the file are left alone.
+func (h *Runit) SetArguments(ctx context.Context, name, args string) (bool, error) {
+	_, span := tracer.Start(ctx, "SetArguments")
+	defer span.End()
+
+	dir := filepath.Join(h.svDir, name)
+	path := filepath.Join(dir, "conf")
+
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	content, err := files.EnsureKeyValues(current, []files.Setting{{Key: "OPTS", Value: args, Absent: args == ""}})
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(content, current) {
+		return false, nil
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return false, fmt.Errorf("service %q not found: %w", name, err)
+	}
+	// The file may hold credentials, so its mode and owner are kept.
+	if err := files.ReplaceFile(path, content, 0o644); err != nil {
+		return false, err
+	}
+
+	h.logger.Info("set service arguments", "name", name, "path", path)
+	return true, nil
+}
+
+// Arguments returns OPTS from /etc/sv/<name>/conf.
+func (h *Runit) Arguments(ctx context.Context, name string) (string, error) {
+	_, span := tracer.Start(ctx, "Arguments")
+	defer span.End()
+
+	content, err := os.ReadFile(filepath.Join(h.svDir, name, "conf"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	args, _ := files.LookupKeyValue(content, "OPTS")
+	return args, nil
+}
+
+func (h *Runit) Start(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Start")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, h.sv, "up", name)
+}
+
+func (h *Runit) Stop(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Stop")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, h.sv, "down", name)
+}
+
+func (h *Runit) Restart(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Restart")
+	defer span.End()
+	return h.exec.SimpleRunCommand(ctx, h.sv, "restart", name)
+}
+
+// Status reads the state of the service from "sv status".  sv exits non-zero
+// for a service which is not supervised, e.g. because it is not enabled, and
+// reports it as failed on stdout; such a service is stopped.  The command is
+// run with its output streams merged, since RunCommand only returns stderr
+// on a non-zero exit.
+func (h *Runit) Status(ctx context.Context, name string) (services.ServiceStatus, error) {
+	_, span := tracer.Start(ctx, "Status")
+	defer span.End()
+
+	output, exit, err := h.exec.RunCommandWithOptions(ctx, handler.ExecOptions{}, h.sv, "status", name)
+	if err != nil && exit <= 0 {
+		return services.UnknownServiceStatus, err
+	}
+
+	return parseStatus(output)
+}
+
+// parseStatus parses the first line of "sv status" output, such as
+//
+//	run: sshd: (pid 1234) 3600s; run: log: (pid 1233) 3600s
+//	down: sshd: 12s, normally up
+//
+// The state of the log service, after the semicolon, is ignored.
+func parseStatus(output string) (services.ServiceStatus, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	state, _, ok := strings.Cut(line, ":")
+	if !ok {
+		return services.UnknownServiceStatus, fmt.Errorf("unexpected sv status output: %q", line)
+	}
+
+	switch state {
+	case "run":
+		return services.Running, nil
+	// finish is the state while the finish script runs after the service
+	// exited; fail and warning are reported for a service which runsv does
+	// not supervise.
+	case "down", "finish", "fail", "warning":
+		return services.Stopped, nil
+	}
+
+	return services.UnknownServiceStatus, fmt.Errorf("unexpected sv status output: %q", line)
+}
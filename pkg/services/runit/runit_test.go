@@ -0,0 +1,214 @@
+package runit
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/execs"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/services"
+)
+
+func TestRunitStatus(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		output   string
+		exit     int
+		expected services.ServiceStatus
+		err      bool
+	}{
+		{
+			name:     "running",
+			output:   "run: sshd: (pid 1234) 3600s\n",
+			expected: services.Running,
+		},
+		{
+			name:     "running with log",
+			output:   "run: nginx: (pid 2210) 86s; run: log: (pid 2209) 86s\n",
+			expected: services.Running,
+		},
+		{
+			name:     "running normally down",
+			output:   "run: chronyd: (pid 901) 12s, normally down\n",
+			expected: services.Running,
+		},
+		{
+			name:     "down",
+			output:   "down: sshd: 12s, normally up\n",
+			expected: services.Stopped,
+		},
+		{
+			name:     "down with log",
+			output:   "down: nginx: 3s, normally up, want up; run: log: (pid 2209) 400s\n",
+			expected: services.Stopped,
+		},
+		{
+			name:     "finishing",
+			output:   "finish: nginx: (pid 2301) 1s, normally up\n",
+			expected: services.Stopped,
+		},
+		{
+			name:     "not enabled",
+			output:   "fail: dhcpcd: unable to change to service directory: file does not exist\n",
+			exit:     1,
+			expected: services.Stopped,
+		},
+		{
+			name:     "not supervised",
+			output:   "warning: dhcpcd: unable to open supervise/ok: file does not exist\n",
+			exit:     1,
+			expected: services.Stopped,
+		},
+		{
+			name:     "unexpected",
+			output:   "",
+			exit:     1,
+			expected: services.UnknownServiceStatus,
+			err:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &handler.MockExecHandler{Output: []string{tc.output}, Status: []int{tc.exit}}
+			h := &Runit{logger: logger, exec: mock, sv: sv}
+
+			status, err := h.Status(ctx, "sshd")
+			if tc.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expected, status)
+			require.Equal(t, [][]string{{"status", "sshd"}}, mock.Recorder[sv])
+			require.Len(t, mock.OptionsRecorder[sv], 1)
+		})
+	}
+}
+
+// TestRunitStatusExit runs a stand-in for sv which, like sv, reports a
+// service which is not supervised on stdout and exits non-zero.
+func TestRunitStatusExit(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+
+	script := filepath.Join(t.TempDir(), "sv")
+	content := "#!/bin/sh\necho \"fail: $2: unable to change to service directory: file does not exist\"\nexit 1\n"
+	require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+
+	h := &Runit{logger: logger, exec: &execs.ExecHandlerCommon{}, sv: script}
+
+	status, err := h.Status(ctx, "dhcpcd")
+	require.NoError(t, err)
+	require.Equal(t, services.Stopped, status)
+}
+
+func TestRunitEnable(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+	svDir := filepath.Join(dir, "sv")
+	serviceDir := filepath.Join(dir, "service")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(svDir, "sshd"), 0o755))
+	require.NoError(t, os.MkdirAll(serviceDir, 0o755))
+
+	mock := &handler.MockExecHandler{}
+	h := &Runit{logger: logger, exec: mock, sv: sv, svDir: svDir, serviceDir: serviceDir}
+
+	enabled, err := h.Enabled(ctx, "sshd")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	require.NoError(t, h.Enable(ctx, "sshd"))
+	enabled, err = h.Enabled(ctx, "sshd")
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	target, err := os.Readlink(filepath.Join(serviceDir, "sshd"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(svDir, "sshd"), target)
+
+	// Enabling again leaves the link alone.
+	require.NoError(t, h.Enable(ctx, "sshd"))
+	require.Error(t, h.Enable(ctx, "missing"))
+
+	// A link to another service directory is replaced.
+	require.NoError(t, os.MkdirAll(filepath.Join(svDir, "nginx"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "old", "nginx"), filepath.Join(serviceDir, "nginx")))
+	require.NoError(t, h.Enable(ctx, "nginx"))
+	target, err = os.Readlink(filepath.Join(serviceDir, "nginx"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(svDir, "nginx"), target)
+
+	// A directory in place of the link is left alone.
+	require.NoError(t, os.MkdirAll(filepath.Join(svDir, "dhcpcd"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(serviceDir, "dhcpcd"), 0o755))
+	require.Error(t, h.Enable(ctx, "dhcpcd"))
+	require.DirExists(t, filepath.Join(serviceDir, "dhcpcd"))
+
+	require.NoError(t, h.Disable(ctx, "sshd"))
+	require.NoFileExists(t, filepath.Join(serviceDir, "sshd"))
+	require.NoError(t, h.Disable(ctx, "sshd"))
+	enabled, err = h.Enabled(ctx, "sshd")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	require.NoError(t, h.Start(ctx, "sshd"))
+	require.NoError(t, h.Stop(ctx, "sshd"))
+	require.NoError(t, h.Restart(ctx, "sshd"))
+	require.Equal(t, [][]string{{"up", "sshd"}, {"down", "sshd"}, {"restart", "sshd"}}, mock.Recorder[sv])
+}
+
+func TestRunitSetArguments(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sshd", "conf")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sshd"), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("# sshd options\nSSHD_KEYGEN=yes\n"), 0o600))
+
+	h := &Runit{logger: logger, exec: &handler.MockExecHandler{}, svDir: dir}
+
+	changed, err := h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# sshd options\nSSHD_KEYGEN=yes\nOPTS=\"-p 2222\"\n", string(content))
+
+	// The mode of an existing file is kept.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	changed, err = h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	args, err := h.Arguments(ctx, "sshd")
+	require.NoError(t, err)
+	require.Equal(t, "-p 2222", args)
+
+	// Empty arguments remove OPTS.
+	changed, err = h.SetArguments(ctx, "sshd", "")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# sshd options\nSSHD_KEYGEN=yes\n", string(content))
+
+	// A service without a directory has no run script to read the conf.
+	_, err = h.SetArguments(ctx, "missing", "-r")
+	require.Error(t, err)
+}
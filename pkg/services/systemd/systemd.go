@@ -2,7 +2,10 @@ package systemd
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os/user"
+	"strings"
 
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"github.com/zachfi/nodemanager/pkg/services"
@@ -19,6 +22,11 @@ type Systemd struct {
 	exec   handler.ExecHandler
 	logger *slog.Logger
 	user   string
+
+	// unitDir and lookupUser locate the unit files.  They default to
+	// /etc/systemd/system and user.Lookup.
+	unitDir    string
+	lookupUser func(string) (*user.User, error)
 }
 
 func New(logger *slog.Logger, exec handler.ExecHandler) handler.ServiceHandler {
@@ -40,10 +48,93 @@ func (h *Systemd) Disable(ctx context.Context, name string) error {
 	return h.systemctlSimple(ctx, "disable", name)
 }
 
-func (h *Systemd) SetArguments(ctx context.Context, _, _ string) error {
-	_, span := tracer.Start(ctx, "SetArguments")
+// Enabled reports whether systemctl is-enabled succeeds, which it does for
+// the units which are started at boot, including static and indirect ones.
+func (h *Systemd) Enabled(ctx context.Context, name string) (bool, error) {
+	_, span := tracer.Start(ctx, "Enabled")
+	defer span.End()
+
+	output, code, err := h.systemctl(ctx, "is-enabled", name)
+	if code == 0 && err == nil {
+		return true, nil
+	}
+	// is-enabled exits non-zero for the disabled states, so only a missing
+	// state is an error.
+	if strings.TrimSpace(output) == "" {
+		if err == nil {
+			err = fmt.Errorf("no state reported for unit %q", name)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// SetArguments appends the arguments to the ExecStart of the service with a
+// drop-in, and reloads systemd when the drop-in changed.  The command the
+// arguments are appended to is read from the unit files other than the
+// drop-in, so that changing the arguments replaces them instead of appending
+// to the previous ones.  Empty arguments remove the drop-in.
+func (h *Systemd) SetArguments(ctx context.Context, name, args string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "SetArguments")
 	defer span.End()
-	return nil
+
+	unit := unitName(name)
+
+	var changed bool
+	if args == "" {
+		removed, err := h.RemoveUnit(ctx, unit, argumentsDropIn)
+		if err != nil {
+			return false, err
+		}
+		changed = removed
+	} else {
+		command, err := h.baseExecStart(ctx, unit)
+		if err != nil {
+			return false, err
+		}
+
+		changed, err = h.WriteUnit(ctx, unit, argumentsDropIn, argumentsDropInContent(command, args))
+		if err != nil {
+			return false, err
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	return true, h.DaemonReload(ctx)
+}
+
+// Arguments returns the arguments the drop-in appends to the ExecStart of
+// the service.  When the command of the unit has changed since, the whole
+// command line of the drop-in is returned, so that it differs from any
+// arguments and is written again.
+func (h *Systemd) Arguments(ctx context.Context, name string) (string, error) {
+	ctx, span := tracer.Start(ctx, "Arguments")
+	defer span.End()
+
+	unit := unitName(name)
+
+	content, err := h.UnitContent(ctx, unit, argumentsDropIn)
+	if err != nil || content == nil {
+		return "", err
+	}
+
+	var current string
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(line, "ExecStart="); ok && value != "" {
+			current = value
+		}
+	}
+
+	command, err := h.baseExecStart(ctx, unit)
+	if err != nil {
+		return "", err
+	}
+	if args, ok := strings.CutPrefix(current, command+" "); ok {
+		return args, nil
+	}
+	return current, nil
 }
 
 func (h *Systemd) Start(ctx context.Context, name string) error {
@@ -85,9 +176,11 @@ func (h *Systemd) Status(ctx context.Context, name string) (services.ServiceStat
 func (h *Systemd) WithContext(ctx context.Context) handler.ServiceHandler {
 	if user, ok := ctx.Value(UserContextKey).(string); ok && user != "" && user != h.user {
 		return &Systemd{
-			exec:   h.exec,
-			logger: h.logger.With("user", user),
-			user:   user,
+			exec:       h.exec,
+			logger:     h.logger.With("user", user),
+			user:       user,
+			unitDir:    h.unitDir,
+			lookupUser: h.lookupUser,
 		}
 	}
 
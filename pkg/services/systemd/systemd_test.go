@@ -104,3 +104,24 @@ func TestSystemd(t *testing.T) {
 		require.Equal(t, services.Stopped, status)
 	}
 }
+
+func TestSystemdEnabled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{
+		Output: []string{"enabled\n", "static\n", "disabled\n", ""},
+		Status: []int{0, 0, 1, 1},
+	}
+	h := New(logger, mock)
+
+	for _, want := range []bool{true, true, false} {
+		enabled, err := h.Enabled(ctx, testSvc)
+		require.NoError(t, err)
+		require.Equal(t, want, enabled)
+	}
+
+	_, err := h.Enabled(ctx, testSvc)
+	require.Error(t, err, "a missing state is an error")
+	require.Equal(t, []string{"is-enabled", testSvc}, mock.Recorder[systemctl][0])
+}
@@ -0,0 +1,408 @@
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+const (
+	unitDir     = "/etc/systemd/system"
+	userUnitDir = ".config/systemd/user"
+)
+
+var _ handler.UnitHandler = &Systemd{}
+
+func (h *Systemd) UnitContent(ctx context.Context, name, dropIn string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "UnitContent")
+	defer span.End()
+
+	path, owner, err := h.unitPath(name, dropIn)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+	if owner != nil {
+		content, err = owner.readFile(path)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// UnitPath returns the path of the unit file below /etc/systemd/system, or
+// below ~/.config/systemd/user for a user.
+func (h *Systemd) UnitPath(ctx context.Context, name, dropIn string) (string, error) {
+	path, _, err := h.unitPath(name, dropIn)
+	return path, err
+}
+
+// WriteUnit writes the unit file below /etc/systemd/system, or below
+// ~/.config/systemd/user for a user, in which case the file and the
+// directories created for it are owned by the user.
+func (h *Systemd) WriteUnit(ctx context.Context, name, dropIn string, content []byte) (bool, error) {
+	_, span := tracer.Start(ctx, "WriteUnit")
+	defer span.End()
+
+	path, owner, err := h.unitPath(name, dropIn)
+	if err != nil {
+		return false, err
+	}
+
+	// The files of a user are reached below their home directory, those of
+	// the system below the unit directory.
+	var (
+		root *os.Root
+		rel  string
+	)
+	if owner != nil {
+		if root, rel, err = owner.openRoot(path); err != nil {
+			return false, err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return false, err
+		}
+		if root, err = os.OpenRoot(filepath.Dir(path)); err != nil {
+			return false, err
+		}
+		rel = filepath.Base(path)
+	}
+	defer root.Close()
+
+	var current []byte
+	if owner != nil {
+		current, err = root.ReadFile(rel)
+	} else {
+		current, err = os.ReadFile(path)
+	}
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if owner != nil {
+		if err := owner.mkdirAll(root, filepath.Dir(rel)); err != nil {
+			return false, err
+		}
+	}
+
+	if err := writeUnitFile(root, rel, content, owner); err != nil {
+		return false, err
+	}
+
+	h.logger.Info("wrote unit file", "path", path)
+	return true, nil
+}
+
+func (h *Systemd) RemoveUnit(ctx context.Context, name, dropIn string) (bool, error) {
+	_, span := tracer.Start(ctx, "RemoveUnit")
+	defer span.End()
+
+	path, owner, err := h.unitPath(name, dropIn)
+	if err != nil {
+		return false, err
+	}
+
+	if owner != nil {
+		err = owner.remove(path)
+	} else {
+		err = os.Remove(path)
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	h.logger.Info("removed unit file", "path", path)
+	return true, nil
+}
+
+func (h *Systemd) UnitFileState(ctx context.Context, name string) (string, error) {
+	_, span := tracer.Start(ctx, "UnitFileState")
+	defer span.End()
+
+	// is-enabled exits non-zero for every state but enabled, so only a
+	// missing state is an error.
+	output, _, err := h.systemctl(ctx, "is-enabled", name)
+	state := strings.TrimSpace(output)
+	if state == "" {
+		if err == nil {
+			err = fmt.Errorf("no state reported for unit %q", name)
+		}
+		return "", err
+	}
+
+	return strings.Fields(state)[0], nil
+}
+
+func (h *Systemd) Mask(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Mask")
+	defer span.End()
+	return h.systemctlSimple(ctx, "mask", name)
+}
+
+func (h *Systemd) Unmask(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "Unmask")
+	defer span.End()
+	return h.systemctlSimple(ctx, "unmask", name)
+}
+
+func (h *Systemd) DaemonReload(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "DaemonReload")
+	defer span.End()
+	return h.systemctlSimple(ctx, "daemon-reload")
+}
+
+// unitOwner is the owner of the unit files of a user.
+type unitOwner struct {
+	home     string
+	uid, gid int
+}
+
+// unitPath returns the path of the unit file, or of its drop-in, and the
+// owner of the files of a user.
+func (h *Systemd) unitPath(name, dropIn string) (string, *unitOwner, error) {
+	if name == "" || strings.ContainsRune(name, '/') || strings.ContainsRune(dropIn, '/') {
+		return "", nil, fmt.Errorf("invalid unit name %q", name)
+	}
+
+	dir := h.unitDir
+	if dir == "" {
+		dir = unitDir
+	}
+
+	var owner *unitOwner
+	if h.user != "" {
+		lookup := h.lookupUser
+		if lookup == nil {
+			lookup = user.Lookup
+		}
+		u, err := lookup(h.user)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to look up user %q: %w", h.user, err)
+		}
+		uid, err := strconv.Atoi(u.Uid)
+		if err != nil {
+			return "", nil, err
+		}
+		gid, err := strconv.Atoi(u.Gid)
+		if err != nil {
+			return "", nil, err
+		}
+		owner = &unitOwner{home: u.HomeDir, uid: uid, gid: gid}
+		dir = filepath.Join(u.HomeDir, userUnitDir)
+	}
+
+	if dropIn != "" {
+		return filepath.Join(dir, name+".d", dropIn+".conf"), owner, nil
+	}
+	return filepath.Join(dir, name), owner, nil
+}
+
+// openRoot opens the home directory of the owner and returns path relative
+// to it.  The user controls everything below their home directory, so their
+// unit files are only reached through an os.Root, which cannot be led out of
+// it by a symlink.
+func (o *unitOwner) openRoot(path string) (*os.Root, string, error) {
+	rel, err := filepath.Rel(o.home, path)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.OpenRoot(o.home)
+	if err != nil {
+		return nil, "", err
+	}
+	return root, rel, nil
+}
+
+func (o *unitOwner) readFile(path string) ([]byte, error) {
+	root, rel, err := o.openRoot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.ReadFile(rel)
+}
+
+func (o *unitOwner) remove(path string) error {
+	root, rel, err := o.openRoot(path)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Remove(rel)
+}
+
+// mkdirAll creates dir below root and gives the directories it created to
+// the owner.  A symlink in place of a directory is refused.
+func (o *unitOwner) mkdirAll(root *os.Root, dir string) error {
+	var d string
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		d = filepath.Join(d, part)
+
+		info, err := root.Lstat(d)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", filepath.Join(o.home, d))
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := root.Mkdir(d, 0o755); err != nil {
+			return err
+		}
+		f, err := root.Open(d)
+		if err != nil {
+			return err
+		}
+		err = f.Chown(o.uid, o.gid)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUnitFile writes content to a new temporary file next to rel, which is
+// then renamed over it.  The temporary file is created exclusively, so a
+// file planted in its place is never written through.
+func writeUnitFile(root *os.Root, rel string, content []byte, owner *unitOwner) error {
+	tmpPath := filepath.Join(filepath.Dir(rel), fmt.Sprintf(".%s.nodemanager.%d", filepath.Base(rel), time.Now().UnixNano()))
+	tmp, err := root.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer tmp.Close()
+		if owner != nil {
+			if err := tmp.Chown(owner.uid, owner.gid); err != nil {
+				return err
+			}
+		}
+		if err := tmp.Chmod(0o644); err != nil {
+			return err
+		}
+		if _, err := tmp.Write(content); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if err == nil {
+		err = root.Rename(tmpPath, rel)
+	}
+	if err != nil {
+		_ = root.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// argumentsDropIn is the drop-in which carries the arguments of a service.
+const argumentsDropIn = "nodemanager-arguments"
+
+// unitName returns the unit of a service name, which may omit the .service
+// suffix.
+func unitName(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	return name + ".service"
+}
+
+// baseExecStart returns the ExecStart of the unit without the arguments set
+// by nodemanager.  It is read from the unit files on each call, so that a
+// package update which changes the ExecStart of the vendor unit is followed.
+func (h *Systemd) baseExecStart(ctx context.Context, unit string) (string, error) {
+	output, _, err := h.systemctl(ctx, "cat", unit)
+	if err != nil {
+		return "", err
+	}
+
+	return parseExecStart(unit, output)
+}
+
+// parseExecStart returns the ExecStart of the [Service] section in the
+// output of systemctl cat, which prints each unit file and drop-in after a
+// "# /path" comment.  The arguments drop-in is skipped.  The command line is
+// returned as written, with its quoting and its prefixes such as "-" and "@".
+func parseExecStart(unit, output string) (string, error) {
+	var (
+		commands []string
+		section  string
+		skip     bool
+	)
+
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSpace(strings.TrimSuffix(line, "\\")) + " " + strings.TrimSpace(lines[i])
+		}
+
+		if path, ok := strings.CutPrefix(line, "# /"); ok {
+			skip = filepath.Base(path) == argumentsDropIn+".conf"
+			section = ""
+			continue
+		}
+		if skip || line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section = line
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if section != "[Service]" || !ok || strings.TrimSpace(key) != "ExecStart" {
+			continue
+		}
+		// An empty assignment resets the commands of the files before it.
+		if value = strings.TrimSpace(value); value == "" {
+			commands = nil
+			continue
+		}
+		commands = append(commands, value)
+	}
+
+	switch len(commands) {
+	case 0:
+		return "", fmt.Errorf("unit %q has no ExecStart to pass arguments to", unit)
+	case 1:
+		return commands[0], nil
+	default:
+		return "", fmt.Errorf("unit %q has %d ExecStart commands, the arguments cannot be appended to one", unit, len(commands))
+	}
+}
+
+func argumentsDropInContent(command, args string) []byte {
+	var b strings.Builder
+	b.WriteString("# Managed by nodemanager: the arguments of the service.\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("ExecStart=\n")
+	b.WriteString("ExecStart=" + command + " " + args + "\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,237 @@
+package systemd
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+)
+
+func TestSystemdUnits(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	mock := &handler.MockExecHandler{Output: []string{"masked\n"}}
+	h := &Systemd{logger: logger, exec: mock, unitDir: filepath.Join(dir, "system")}
+
+	content, err := h.UnitContent(ctx, "backup.service", "")
+	require.NoError(t, err)
+	require.Nil(t, content)
+
+	changed, err := h.WriteUnit(ctx, "backup.service", "", []byte("[Service]\n"))
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	changed, err = h.WriteUnit(ctx, "backup.service", "", []byte("[Service]\n"))
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	changed, err = h.WriteUnit(ctx, "sshd.service", "limits", []byte("[Service]\nLimitNOFILE=65536\n"))
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.FileExists(t, filepath.Join(dir, "system", "sshd.service.d", "limits.conf"))
+
+	removed, err := h.RemoveUnit(ctx, "backup.service", "")
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	removed, err = h.RemoveUnit(ctx, "backup.service", "")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	_, err = h.WriteUnit(ctx, "../escape.service", "", nil)
+	require.Error(t, err)
+
+	state, err := h.UnitFileState(ctx, "cups.service")
+	require.NoError(t, err)
+	require.Equal(t, "masked", state)
+
+	require.NoError(t, h.Mask(ctx, "cups.service"))
+	require.NoError(t, h.Unmask(ctx, "cups.service"))
+	require.NoError(t, h.DaemonReload(ctx))
+
+	require.Equal(t, [][]string{
+		{"is-enabled", "cups.service"},
+		{"mask", "cups.service"},
+		{"unmask", "cups.service"},
+		{"daemon-reload"},
+	}, mock.Recorder[systemctl])
+}
+
+func TestSystemdUserUnits(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	home := t.TempDir()
+
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	lookup := func(name string) (*user.User, error) {
+		return &user.User{Username: name, Uid: uid, Gid: gid, HomeDir: home}, nil
+	}
+
+	mock := &handler.MockExecHandler{}
+	h := &Systemd{logger: logger, exec: mock, lookupUser: lookup}
+	ctx := context.WithValue(context.Background(), UserContextKey, testUser)
+	hh := h.WithContext(ctx).(*Systemd)
+
+	changed, err := hh.WriteUnit(ctx, "sync.timer", "", []byte("[Timer]\n"))
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.FileExists(t, filepath.Join(home, ".config", "systemd", "user", "sync.timer"))
+
+	require.NoError(t, hh.DaemonReload(ctx))
+	require.Equal(t, [][]string{{"--user", "-M", testUser + "@", "daemon-reload"}}, mock.Recorder[systemctl])
+}
+
+func TestSystemdUserUnitsSymlinks(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.WithValue(context.Background(), UserContextKey, testUser)
+
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	newHandler := func(home string) *Systemd {
+		lookup := func(name string) (*user.User, error) {
+			return &user.User{Username: name, Uid: uid, Gid: gid, HomeDir: home}, nil
+		}
+		h := &Systemd{logger: logger, exec: &handler.MockExecHandler{}, lookupUser: lookup}
+		return h.WithContext(ctx).(*Systemd)
+	}
+
+	outside := t.TempDir()
+	target := filepath.Join(outside, "shadow")
+	require.NoError(t, os.WriteFile(target, []byte("root:x:0:0\n"), 0o640))
+
+	// A symlinked directory below the home directory is refused.
+	home := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(home, ".config")))
+	_, err := newHandler(home).WriteUnit(ctx, "sync.timer", "", []byte("[Timer]\n"))
+	require.Error(t, err)
+	require.NoFileExists(t, filepath.Join(outside, "systemd", "user", "sync.timer"))
+
+	// So is a unit file which is a symlink out of the home directory.
+	home = t.TempDir()
+	dir := filepath.Join(home, ".config", "systemd", "user")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.Symlink(target, filepath.Join(dir, "sync.timer")))
+	_, err = newHandler(home).WriteUnit(ctx, "sync.timer", "", []byte("[Timer]\n"))
+	require.Error(t, err)
+
+	// A file planted at the previous temporary name is not written through.
+	require.NoError(t, os.Remove(filepath.Join(dir, "sync.timer")))
+	require.NoError(t, os.Symlink(target, filepath.Join(dir, "sync.timer.nodemanager.tmp")))
+
+	changed, err := newHandler(home).WriteUnit(ctx, "sync.timer", "", []byte("[Timer]\n"))
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	info, err := os.Lstat(filepath.Join(dir, "sync.timer"))
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "root:x:0:0\n", string(content))
+}
+
+func TestSystemdSetArguments(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	vendor := "# /usr/lib/systemd/system/sshd.service\n[Unit]\nDescription=OpenSSH\n\n[Service]\nExecStart=/usr/bin/sshd -D\n"
+	withDropIn := func(args string) string {
+		return vendor + "\n# /etc/systemd/system/sshd.service.d/nodemanager-arguments.conf\n" +
+			"[Service]\nExecStart=\nExecStart=/usr/bin/sshd -D " + args + "\n"
+	}
+
+	mock := &handler.MockExecHandler{
+		Output: []string{vendor, "", withDropIn("-p 2222"), withDropIn("-p 2222"), "", "# /usr/lib/systemd/system/sshd.service\n[Service]\nExecStart=/usr/sbin/sshd -D\n" + withDropIn("-p 2200")[len(vendor):], "", "# /usr/lib/systemd/system/sshd.service\n[Service]\nExecStart=/usr/sbin/sshd -D\n"},
+	}
+	h := &Systemd{logger: logger, exec: mock, unitDir: dir}
+
+	changed, err := h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err := os.ReadFile(filepath.Join(dir, "sshd.service.d", "nodemanager-arguments.conf"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "ExecStart=\nExecStart=/usr/bin/sshd -D -p 2222\n")
+
+	// The command is read without the drop-in, so the arguments replace the
+	// previous ones.
+	changed, err = h.SetArguments(ctx, "sshd", "-p 2222")
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	changed, err = h.SetArguments(ctx, "sshd", "-p 2200")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(filepath.Join(dir, "sshd.service.d", "nodemanager-arguments.conf"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "ExecStart=/usr/bin/sshd -D -p 2200\n")
+
+	// A package update which changes the vendor ExecStart is followed.
+	changed, err = h.SetArguments(ctx, "sshd", "-p 2200")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	content, err = os.ReadFile(filepath.Join(dir, "sshd.service.d", "nodemanager-arguments.conf"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "ExecStart=/usr/sbin/sshd -D -p 2200\n")
+
+	args, err := h.Arguments(ctx, "sshd")
+	require.NoError(t, err)
+	require.Equal(t, "-p 2200", args)
+
+	// Empty arguments remove the drop-in.
+	changed, err = h.SetArguments(ctx, "sshd", "")
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoFileExists(t, filepath.Join(dir, "sshd.service.d", "nodemanager-arguments.conf"))
+
+	args, err = h.Arguments(ctx, "sshd")
+	require.NoError(t, err)
+	require.Empty(t, args)
+
+	require.Equal(t, [][]string{
+		{"cat", "sshd.service"},
+		{"daemon-reload"},
+		{"cat", "sshd.service"},
+		{"cat", "sshd.service"},
+		{"daemon-reload"},
+		{"cat", "sshd.service"},
+		{"daemon-reload"},
+		{"cat", "sshd.service"},
+		{"daemon-reload"},
+	}, mock.Recorder[systemctl])
+}
+
+func TestParseExecStart(t *testing.T) {
+	_, err := parseExecStart("oneshot.service", "# /usr/lib/systemd/system/oneshot.service\n[Service]\nType=oneshot\n")
+	require.Error(t, err)
+
+	_, err = parseExecStart("multi.service", "# /etc/systemd/system/multi.service\n[Service]\nExecStart=/bin/a\nExecStart=/bin/b -x\n")
+	require.Error(t, err)
+
+	// Quoting and prefixes are kept, continuation lines are joined, and an
+	// empty assignment in a drop-in resets the vendor command.
+	command, err := parseExecStart("nginx.service", `# /usr/lib/systemd/system/nginx.service
+[Unit]
+ExecStart=/not/a/service
+[Service]
+ExecStart=/usr/bin/nginx -g 'daemon on;'
+
+# /etc/systemd/system/nginx.service.d/override.conf
+[Service]
+ExecStart=
+ExecStart=-/usr/bin/nginx \
+    -g "daemon off; worker_processes 2;"
+`)
+	require.NoError(t, err)
+	require.Equal(t, `-/usr/bin/nginx -g "daemon off; worker_processes 2;"`, command)
+}
@@ -9,7 +9,10 @@ import (
 	"github.com/zachfi/nodemanager/pkg/handler"
 	"github.com/zachfi/nodemanager/pkg/os/alpine"
 	"github.com/zachfi/nodemanager/pkg/os/arch"
+	"github.com/zachfi/nodemanager/pkg/os/debian"
+	"github.com/zachfi/nodemanager/pkg/os/fedora"
 	"github.com/zachfi/nodemanager/pkg/os/freebsd"
+	"github.com/zachfi/nodemanager/pkg/os/void"
 )
 
 var ErrSystemNotFound = errors.New("not found for system")
@@ -24,6 +27,12 @@ func New(ctx context.Context, logger *slog.Logger) (handler.System, OSID, error)
 		return alpine.New(logger), Alpine, nil
 	case FreeBSD:
 		return freebsd.New(logger), FreeBSD, nil
+	case Debian:
+		return debian.New(logger), Debian, nil
+	case Fedora:
+		return fedora.New(logger), Fedora, nil
+	case Void:
+		return void.New(logger), Void, nil
 	}
 
 	return nil, UnhandledOsID, ErrSystemNotFound
@@ -36,6 +45,9 @@ const (
 	Arch
 	Alpine
 	FreeBSD
+	Debian
+	Fedora
+	Void
 )
 
 // String returns the string representation of the OSID
@@ -47,6 +59,12 @@ func (o OSID) String() string {
 		return "alpine"
 	case FreeBSD:
 		return "freebsd"
+	case Debian:
+		return "debian"
+	case Fedora:
+		return "fedora"
+	case Void:
+		return "void"
 	}
 	return "unhandled"
 }
@@ -60,6 +78,12 @@ func OSIDFromString(osid string) OSID {
 		return Alpine
 	case "freebsd":
 		return FreeBSD
+	case "debian", "ubuntu", "raspbian":
+		return Debian
+	case "fedora", "rhel", "rocky", "almalinux", "centos":
+		return Fedora
+	case "void":
+		return Void
 	default:
 		return UnhandledOsID
 	}
@@ -0,0 +1,183 @@
+// Package templates implements the in-process template engine used to render
+// managed file content.  Templates use text/template syntax with a curated
+// function library, so no external binary is required on the node.
+package templates
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Engine selects the renderer used for a File template.
+type Engine int64
+
+const (
+	UnhandledEngine Engine = iota
+	Gomplate
+	Go
+)
+
+var EngineByName map[string]Engine = map[string]Engine{
+	"unhandled": UnhandledEngine,
+	"gomplate":  Gomplate,
+	"go":        Go,
+	"":          Gomplate, // Default to gomplate for existing templates
+}
+
+func (e Engine) String() string {
+	switch e {
+	case UnhandledEngine:
+		return "unhandled"
+	case Gomplate:
+		return "gomplate"
+	case Go:
+		return "go"
+	}
+	return "unhandled"
+}
+
+func EngineFromString(engine string) Engine {
+	if e, ok := EngineByName[engine]; ok {
+		return e
+	}
+	return UnhandledEngine
+}
+
+// FuncMap returns the function library available to templates.  It contains
+// the hermetic subset of the sprig functions (strings, lists, dicts, base64,
+// sha256sum, toJson, ...), so a template renders identically on every
+// reconcile, plus toYaml and CIDR helpers.  Functions which read the process
+// environment, the clock or random state are deliberately excluded because
+// they would cause managed files to be rewritten on every reconcile.
+func FuncMap() template.FuncMap {
+	funcs := sprig.HermeticTxtFuncMap()
+
+	funcs["toYaml"] = toYaml
+	funcs["cidrHost"] = cidrHost
+	funcs["cidrNetmask"] = cidrNetmask
+	funcs["cidrSubnet"] = cidrSubnet
+	funcs["cidrContains"] = cidrContains
+
+	return funcs
+}
+
+// Render executes text against data.  extra is merged over FuncMap so callers
+// can provide functions that close over their own state.  Referencing a map
+// key which does not exist is an error rather than rendering "<no value>".
+func Render(name, text string, data any, extra template.FuncMap) ([]byte, error) {
+	funcs := FuncMap()
+	for k, v := range extra {
+		funcs[k] = v
+	}
+
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func toYaml(v any) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(b, []byte("\n"))), nil
+}
+
+// cidrHost returns the address of host number hostnum within prefix.  A
+// negative hostnum counts back from the end of the range, e.g. -1 is the last
+// address.
+func cidrHost(prefix string, hostnum int) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	p = p.Masked()
+
+	hostBits := p.Addr().BitLen() - p.Bits()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+
+	n := big.NewInt(int64(hostnum))
+	if hostnum < 0 {
+		n.Add(size, n)
+	}
+	if n.Sign() < 0 || n.Cmp(size) >= 0 {
+		return "", fmt.Errorf("host number %d does not fit in %s", hostnum, prefix)
+	}
+
+	return addrAdd(p.Addr(), n).String(), nil
+}
+
+// cidrNetmask returns the dotted netmask of an IPv4 prefix.
+func cidrNetmask(prefix string) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("netmask is only defined for IPv4 prefixes: %s", prefix)
+	}
+
+	var mask [4]byte
+	binary.BigEndian.PutUint32(mask[:], ^uint32(0)<<(32-p.Bits()))
+	return netip.AddrFrom4(mask).String(), nil
+}
+
+// cidrSubnet extends prefix by newbits and returns subnet number netnum, e.g.
+// cidrSubnet "10.0.0.0/16" 8 2 returns "10.0.2.0/24".
+func cidrSubnet(prefix string, newbits, netnum int) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	p = p.Masked()
+
+	bits := p.Bits() + newbits
+	if newbits < 0 || bits > p.Addr().BitLen() {
+		return "", fmt.Errorf("cannot extend %s by %d bits", prefix, newbits)
+	}
+	if netnum < 0 || big.NewInt(int64(netnum)).BitLen() > newbits {
+		return "", fmt.Errorf("subnet number %d does not fit in %d bits", netnum, newbits)
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(netnum)), uint(p.Addr().BitLen()-bits))
+	return netip.PrefixFrom(addrAdd(p.Addr(), offset), bits).String(), nil
+}
+
+// cidrContains reports whether ip is within prefix.
+func cidrContains(prefix, ip string) (bool, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return false, err
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, err
+	}
+	return p.Contains(a), nil
+}
+
+func addrAdd(addr netip.Addr, n *big.Int) netip.Addr {
+	sum := new(big.Int).SetBytes(addr.AsSlice())
+	sum.Add(sum, n)
+
+	b := make([]byte, addr.BitLen()/8)
+	sum.FillBytes(b)
+
+	out, _ := netip.AddrFromSlice(b)
+	return out
+}
@@ -0,0 +1,62 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]any{
+		"Node": map[string]any{
+			"Labels": map[string]any{"role": "web"},
+		},
+		"Items": []any{"b", "a"},
+	}
+
+	cases := []struct {
+		name     string
+		text     string
+		expected string
+		err      bool
+	}{
+		{name: "field access", text: `role={{ .Node.Labels.role }}`, expected: "role=web"},
+		{name: "strings", text: `{{ .Node.Labels.role | upper | quote }}`, expected: `"WEB"`},
+		{name: "lists", text: `{{ .Items | sortAlpha | join "," }}`, expected: "a,b"},
+		{name: "dict", text: `{{ $d := dict "a" 1 }}{{ $d.a }}`, expected: "1"},
+		{name: "base64", text: `{{ "hello" | b64enc }}`, expected: "aGVsbG8="},
+		{name: "sha256", text: `{{ "hello" | sha256sum }}`, expected: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{name: "toJson", text: `{{ .Node.Labels | toJson }}`, expected: `{"role":"web"}`},
+		{name: "toYaml", text: `{{ .Node | toYaml }}`, expected: "Labels:\n  role: web"},
+		{name: "cidrHost", text: `{{ cidrHost "10.1.0.0/24" 5 }}`, expected: "10.1.0.5"},
+		{name: "cidrHost negative", text: `{{ cidrHost "10.1.0.0/24" -2 }}`, expected: "10.1.0.254"},
+		{name: "cidrHost v6", text: `{{ cidrHost "2001:db8::/64" 1 }}`, expected: "2001:db8::1"},
+		{name: "cidrHost out of range", text: `{{ cidrHost "10.1.0.0/30" 4 }}`, err: true},
+		{name: "cidrNetmask", text: `{{ cidrNetmask "10.1.0.0/20" }}`, expected: "255.255.240.0"},
+		{name: "cidrSubnet", text: `{{ cidrSubnet "10.0.0.0/16" 8 2 }}`, expected: "10.0.2.0/24"},
+		{name: "cidrSubnet too large", text: `{{ cidrSubnet "10.0.0.0/16" 2 4 }}`, err: true},
+		{name: "cidrContains", text: `{{ cidrContains "10.0.0.0/8" "10.2.3.4" }}`, expected: "true"},
+		{name: "missing key", text: `{{ .Node.Labels.missing }}`, err: true},
+		{name: "env excluded", text: `{{ env "HOME" }}`, err: true},
+		{name: "now excluded", text: `{{ now }}`, err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Render(tc.name, tc.text, data, nil)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(out))
+		})
+	}
+}
+
+func TestEngineFromString(t *testing.T) {
+	require.Equal(t, Gomplate, EngineFromString(""))
+	require.Equal(t, Gomplate, EngineFromString("gomplate"))
+	require.Equal(t, Go, EngineFromString("go"))
+	require.Equal(t, UnhandledEngine, EngineFromString("jinja"))
+}
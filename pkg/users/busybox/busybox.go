@@ -0,0 +1,198 @@
+// Package busybox implements the handler.UserHandler interface using the
+// busybox adduser family of applets, as found on Alpine Linux.
+package busybox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+	"github.com/zachfi/nodemanager/pkg/users/nss"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	adduser  = "/usr/sbin/adduser"
+	deluser  = "/usr/sbin/deluser"
+	addgroup = "/usr/sbin/addgroup"
+	delgroup = "/usr/sbin/delgroup"
+)
+
+var _ handler.UserHandler = (*Busybox)(nil)
+
+var tracer = otel.Tracer("users/busybox")
+
+type Busybox struct {
+	logger *slog.Logger
+	exec   handler.ExecHandler
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.UserHandler {
+	return &Busybox{
+		logger: logger,
+		exec:   exec,
+	}
+}
+
+func (h *Busybox) LookupUser(ctx context.Context, name string) (*users.User, error) {
+	return nss.LookupUser(ctx, h.exec, name)
+}
+
+func (h *Busybox) AddUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "AddUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	// -D: do not assign a password
+	args := []string{"-D"}
+	if user.System {
+		args = append(args, "-S")
+	}
+	if user.UID != "" {
+		args = append(args, "-u", user.UID)
+	}
+	if user.Group != "" {
+		args = append(args, "-G", user.Group)
+	}
+	if user.Home != "" {
+		args = append(args, "-h", user.Home)
+	}
+	if user.Shell != "" {
+		args = append(args, "-s", user.Shell)
+	}
+	if user.Comment != "" {
+		args = append(args, "-g", user.Comment)
+	}
+	args = append(args, user.Name)
+
+	h.logger.Info("adding user", "name", user.Name)
+	if err := h.exec.SimpleRunCommand(ctx, adduser, args...); err != nil {
+		return err
+	}
+
+	// adduser only sets the primary group.
+	for _, g := range user.Groups {
+		if err := h.exec.SimpleRunCommand(ctx, addgroup, user.Name, g); err != nil {
+			return errors.Wrap(err, "failed to add user to group")
+		}
+	}
+
+	return nil
+}
+
+// ModifyUser can only change supplementary group membership, since busybox
+// has no usermod.  Requesting any other change is an error.
+func (h *Busybox) ModifyUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "ModifyUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	current, err := h.LookupUser(ctx, user.Name)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("user %q does not exist", user.Name)
+	}
+
+	var unsupported []string
+	if user.UID != "" && user.UID != current.UID {
+		unsupported = append(unsupported, "uid")
+	}
+	if user.Group != "" && user.Group != current.Group {
+		unsupported = append(unsupported, "group")
+	}
+	if user.Home != "" && user.Home != current.Home {
+		unsupported = append(unsupported, "home")
+	}
+	if user.Shell != "" && user.Shell != current.Shell {
+		unsupported = append(unsupported, "shell")
+	}
+	if user.Comment != "" && user.Comment != current.Comment {
+		unsupported = append(unsupported, "comment")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("busybox cannot modify %s of existing user %q", strings.Join(unsupported, ", "), user.Name)
+	}
+
+	if user.Groups == nil {
+		return nil
+	}
+
+	h.logger.Info("modifying user", "name", user.Name)
+
+	for _, g := range user.Groups {
+		if slices.Contains(current.Groups, g) {
+			continue
+		}
+		if err := h.exec.SimpleRunCommand(ctx, addgroup, user.Name, g); err != nil {
+			return errors.Wrap(err, "failed to add user to group")
+		}
+	}
+
+	for _, g := range current.Groups {
+		if slices.Contains(user.Groups, g) {
+			continue
+		}
+		if err := h.exec.SimpleRunCommand(ctx, delgroup, user.Name, g); err != nil {
+			return errors.Wrap(err, "failed to remove user from group")
+		}
+	}
+
+	return nil
+}
+
+func (h *Busybox) RemoveUser(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	// Without --remove-home the home directory is kept.
+	h.logger.Info("removing user", "name", name)
+	return h.exec.SimpleRunCommand(ctx, deluser, name)
+}
+
+func (h *Busybox) LookupGroup(ctx context.Context, name string) (*users.Group, error) {
+	return nss.LookupGroup(ctx, h.exec, name)
+}
+
+func (h *Busybox) AddGroup(ctx context.Context, group users.Group) error {
+	_, span := tracer.Start(ctx, "AddGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", group.Name))
+
+	var args []string
+	if group.System {
+		args = append(args, "-S")
+	}
+	if group.GID != "" {
+		args = append(args, "-g", group.GID)
+	}
+	args = append(args, group.Name)
+
+	h.logger.Info("adding group", "name", group.Name)
+	return h.exec.SimpleRunCommand(ctx, addgroup, args...)
+}
+
+func (h *Busybox) ModifyGroup(ctx context.Context, group users.Group) error {
+	if group.GID == "" {
+		return nil
+	}
+
+	return fmt.Errorf("busybox cannot modify gid of existing group %q", group.Name)
+}
+
+func (h *Busybox) RemoveGroup(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	h.logger.Info("removing group", "name", name)
+	return h.exec.SimpleRunCommand(ctx, delgroup, name)
+}
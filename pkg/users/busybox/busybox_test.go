@@ -0,0 +1,61 @@
+package busybox
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+func Test_Busybox_Commands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{}
+	h := New(logger, mock)
+
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "deploy", UID: "1001", Group: "deploy", Groups: []string{"wheel"}, Shell: "/bin/ash"}))
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "svc", System: true}))
+	require.NoError(t, h.RemoveUser(ctx, "svc"))
+	require.NoError(t, h.AddGroup(ctx, users.Group{Name: "svc", GID: "900", System: true}))
+	require.NoError(t, h.RemoveGroup(ctx, "svc"))
+	require.Error(t, h.ModifyGroup(ctx, users.Group{Name: "svc", GID: "901"}))
+
+	require.Equal(t, [][]string{
+		{"-D", "-u", "1001", "-G", "deploy", "-s", "/bin/ash", "deploy"},
+		{"-D", "-S", "svc"},
+	}, mock.Recorder[adduser])
+	require.Equal(t, [][]string{
+		{"deploy", "wheel"},
+		{"-S", "-g", "900", "svc"},
+	}, mock.Recorder[addgroup])
+	require.Equal(t, [][]string{{"svc"}}, mock.Recorder[deluser])
+	require.Equal(t, [][]string{{"svc"}}, mock.Recorder[delgroup])
+}
+
+func Test_Busybox_ModifyUser(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	lookup := []string{
+		"deploy:x:1001:1001::/home/deploy:/bin/ash\n",
+		"deploy:x:1001:\n",
+		"deploy wheel audio\n",
+	}
+
+	mock := &handler.MockExecHandler{Output: lookup}
+	h := New(logger, mock)
+	require.NoError(t, h.ModifyUser(ctx, users.User{Name: "deploy", Groups: []string{"wheel", "video"}}))
+	require.Equal(t, [][]string{{"deploy", "video"}}, mock.Recorder[addgroup])
+	require.Equal(t, [][]string{{"deploy", "audio"}}, mock.Recorder[delgroup])
+
+	mock = &handler.MockExecHandler{Output: lookup}
+	h = New(logger, mock)
+	err := h.ModifyUser(ctx, users.User{Name: "deploy", Shell: "/bin/bash"})
+	require.ErrorContains(t, err, "shell")
+	require.Empty(t, mock.Recorder[addgroup])
+}
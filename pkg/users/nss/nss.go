@@ -0,0 +1,112 @@
+// Package nss looks up users and groups through the name service switch,
+// which is common to every platform with a UserHandler.
+package nss
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+const (
+	getent = "getent"
+	id     = "id"
+)
+
+// LookupUser returns the named user as resolved through NSS, or nil if the
+// user does not exist.  Groups holds the supplementary group names.
+func LookupUser(ctx context.Context, exec handler.ExecHandler, name string) (*users.User, error) {
+	output, status, err := exec.RunCommand(ctx, getent, "passwd", name)
+	if status == 2 {
+		return nil, nil // key not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup user %q: %w", name, err)
+	}
+
+	u, err := parsePasswd(output)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, nil
+	}
+
+	// Resolve the primary group name so it can be compared with the desired
+	// group by name.
+	group, _, err := exec.RunCommand(ctx, getent, "group", u.Group)
+	if err == nil {
+		if g := parseGroup(group); g != nil {
+			u.Group = g.Name
+		}
+	}
+
+	groups, _, err := exec.RunCommand(ctx, id, "-Gn", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup groups for user %q: %w", name, err)
+	}
+	u.Groups = []string{}
+	for _, g := range strings.Fields(groups) {
+		if g != u.Group && !slices.Contains(u.Groups, g) {
+			u.Groups = append(u.Groups, g)
+		}
+	}
+
+	return u, nil
+}
+
+// LookupGroup returns the named group as resolved through NSS, or nil if the
+// group does not exist.
+func LookupGroup(ctx context.Context, exec handler.ExecHandler, name string) (*users.Group, error) {
+	output, status, err := exec.RunCommand(ctx, getent, "group", name)
+	if status == 2 {
+		return nil, nil // key not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup group %q: %w", name, err)
+	}
+
+	return parseGroup(output), nil
+}
+
+// parsePasswd parses a single passwd(5) entry.  The primary group is
+// returned as the numeric GID.
+func parsePasswd(line string) (*users.User, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+
+	// name:password:uid:gid:gecos:home:shell
+	fields := strings.Split(line, ":")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("unexpected passwd entry %q", line)
+	}
+
+	return &users.User{
+		Name:    fields[0],
+		UID:     fields[2],
+		Group:   fields[3],
+		Comment: fields[4],
+		Home:    fields[5],
+		Shell:   fields[6],
+	}, nil
+}
+
+// parseGroup parses a single group(5) entry.
+func parseGroup(line string) *users.Group {
+	// name:password:gid:members
+	fields := strings.Split(strings.TrimSpace(line), ":")
+	if len(fields) < 3 {
+		return nil
+	}
+
+	return &users.Group{
+		Name: fields[0],
+		GID:  fields[2],
+	}
+}
@@ -0,0 +1,55 @@
+package nss
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+func TestLookupUser(t *testing.T) {
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{
+		Output: []string{
+			"deploy:x:1001:1001:Deploy user:/home/deploy:/bin/bash\n",
+			"deploy:x:1001:\n",
+			"deploy wheel docker\n",
+		},
+	}
+
+	u, err := LookupUser(ctx, mock, "deploy")
+	require.NoError(t, err)
+	require.Equal(t, &users.User{
+		Name:    "deploy",
+		UID:     "1001",
+		Group:   "deploy",
+		Groups:  []string{"wheel", "docker"},
+		Home:    "/home/deploy",
+		Shell:   "/bin/bash",
+		Comment: "Deploy user",
+	}, u)
+	require.Equal(t, [][]string{{"passwd", "deploy"}, {"group", "1001"}}, mock.Recorder[getent])
+	require.Equal(t, [][]string{{"-Gn", "deploy"}}, mock.Recorder[id])
+
+	missing := &handler.MockExecHandler{Status: []int{2}}
+	u, err = LookupUser(ctx, missing, "nobody-here")
+	require.NoError(t, err)
+	require.Nil(t, u)
+}
+
+func TestLookupGroup(t *testing.T) {
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{Output: []string{"docker:x:998:deploy\n"}}
+	g, err := LookupGroup(ctx, mock, "docker")
+	require.NoError(t, err)
+	require.Equal(t, &users.Group{Name: "docker", GID: "998"}, g)
+
+	missing := &handler.MockExecHandler{Status: []int{2}}
+	g, err = LookupGroup(ctx, missing, "missing")
+	require.NoError(t, err)
+	require.Nil(t, g)
+}
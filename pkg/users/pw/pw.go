@@ -0,0 +1,136 @@
+// Package pw implements the handler.UserHandler interface using the FreeBSD
+// pw(8) utility.
+package pw
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+	"github.com/zachfi/nodemanager/pkg/users/nss"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const pw = "/usr/sbin/pw"
+
+var _ handler.UserHandler = (*Pw)(nil)
+
+var tracer = otel.Tracer("users/pw")
+
+type Pw struct {
+	logger *slog.Logger
+	exec   handler.ExecHandler
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.UserHandler {
+	return &Pw{
+		logger: logger,
+		exec:   exec,
+	}
+}
+
+func (h *Pw) LookupUser(ctx context.Context, name string) (*users.User, error) {
+	return nss.LookupUser(ctx, h.exec, name)
+}
+
+func (h *Pw) AddUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "AddUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	args := append([]string{"useradd", "-n", user.Name}, userArgs(user)...)
+	if !user.System {
+		args = append(args, "-m")
+	}
+
+	h.logger.Info("adding user", "name", user.Name)
+	return h.exec.SimpleRunCommand(ctx, pw, args...)
+}
+
+func (h *Pw) ModifyUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "ModifyUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	args := append([]string{"usermod", "-n", user.Name}, userArgs(user)...)
+
+	h.logger.Info("modifying user", "name", user.Name)
+	return h.exec.SimpleRunCommand(ctx, pw, args...)
+}
+
+func (h *Pw) RemoveUser(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	// Without -r the home directory is kept.
+	h.logger.Info("removing user", "name", name)
+	return h.exec.SimpleRunCommand(ctx, pw, "userdel", "-n", name)
+}
+
+func (h *Pw) LookupGroup(ctx context.Context, name string) (*users.Group, error) {
+	return nss.LookupGroup(ctx, h.exec, name)
+}
+
+func (h *Pw) AddGroup(ctx context.Context, group users.Group) error {
+	_, span := tracer.Start(ctx, "AddGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", group.Name))
+
+	args := []string{"groupadd", "-n", group.Name}
+	if group.GID != "" {
+		args = append(args, "-g", group.GID)
+	}
+
+	h.logger.Info("adding group", "name", group.Name)
+	return h.exec.SimpleRunCommand(ctx, pw, args...)
+}
+
+func (h *Pw) ModifyGroup(ctx context.Context, group users.Group) error {
+	_, span := tracer.Start(ctx, "ModifyGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", group.Name))
+
+	if group.GID == "" {
+		return nil
+	}
+
+	h.logger.Info("modifying group", "name", group.Name)
+	return h.exec.SimpleRunCommand(ctx, pw, "groupmod", "-n", group.Name, "-g", group.GID)
+}
+
+func (h *Pw) RemoveGroup(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	h.logger.Info("removing group", "name", name)
+	return h.exec.SimpleRunCommand(ctx, pw, "groupdel", "-n", name)
+}
+
+// userArgs returns the useradd/usermod flags for the fields set on user.
+func userArgs(user users.User) []string {
+	var args []string
+	if user.UID != "" {
+		args = append(args, "-u", user.UID)
+	}
+	if user.Group != "" {
+		args = append(args, "-g", user.Group)
+	}
+	if user.Groups != nil {
+		args = append(args, "-G", strings.Join(user.Groups, ","))
+	}
+	if user.Home != "" {
+		args = append(args, "-d", user.Home)
+	}
+	if user.Shell != "" {
+		args = append(args, "-s", user.Shell)
+	}
+	if user.Comment != "" {
+		args = append(args, "-c", user.Comment)
+	}
+	return args
+}
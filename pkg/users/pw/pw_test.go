@@ -0,0 +1,38 @@
+package pw
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+func Test_Pw_Commands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{}
+	h := New(logger, mock)
+
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "deploy", UID: "1001", Group: "deploy", Groups: []string{"wheel"}, Comment: "Deploy user"}))
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "svc", System: true, Shell: "/usr/sbin/nologin"}))
+	require.NoError(t, h.ModifyUser(ctx, users.User{Name: "deploy", Shell: "/bin/sh"}))
+	require.NoError(t, h.RemoveUser(ctx, "deploy"))
+	require.NoError(t, h.AddGroup(ctx, users.Group{Name: "svc", GID: "900"}))
+	require.NoError(t, h.ModifyGroup(ctx, users.Group{Name: "svc", GID: "901"}))
+	require.NoError(t, h.RemoveGroup(ctx, "svc"))
+
+	require.Equal(t, [][]string{
+		{"useradd", "-n", "deploy", "-u", "1001", "-g", "deploy", "-G", "wheel", "-c", "Deploy user", "-m"},
+		{"useradd", "-n", "svc", "-s", "/usr/sbin/nologin"},
+		{"usermod", "-n", "deploy", "-s", "/bin/sh"},
+		{"userdel", "-n", "deploy"},
+		{"groupadd", "-n", "svc", "-g", "900"},
+		{"groupmod", "-n", "svc", "-g", "901"},
+		{"groupdel", "-n", "svc"},
+	}, mock.Recorder[pw])
+}
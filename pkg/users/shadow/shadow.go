@@ -0,0 +1,154 @@
+// Package shadow implements the handler.UserHandler interface using the
+// shadow-utils commands found on most Linux distributions.
+package shadow
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+	"github.com/zachfi/nodemanager/pkg/users/nss"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	useradd  = "/usr/sbin/useradd"
+	usermod  = "/usr/sbin/usermod"
+	userdel  = "/usr/sbin/userdel"
+	groupadd = "/usr/sbin/groupadd"
+	groupmod = "/usr/sbin/groupmod"
+	groupdel = "/usr/sbin/groupdel"
+)
+
+var _ handler.UserHandler = (*Shadow)(nil)
+
+var tracer = otel.Tracer("users/shadow")
+
+type Shadow struct {
+	logger *slog.Logger
+	exec   handler.ExecHandler
+}
+
+func New(logger *slog.Logger, exec handler.ExecHandler) handler.UserHandler {
+	return &Shadow{
+		logger: logger,
+		exec:   exec,
+	}
+}
+
+func (h *Shadow) LookupUser(ctx context.Context, name string) (*users.User, error) {
+	return nss.LookupUser(ctx, h.exec, name)
+}
+
+func (h *Shadow) AddUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "AddUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	args := userArgs(user)
+	if user.System {
+		args = append(args, "-r")
+	} else {
+		args = append(args, "-m")
+	}
+	args = append(args, user.Name)
+
+	h.logger.Info("adding user", "name", user.Name)
+	return h.exec.SimpleRunCommand(ctx, useradd, args...)
+}
+
+func (h *Shadow) ModifyUser(ctx context.Context, user users.User) error {
+	_, span := tracer.Start(ctx, "ModifyUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", user.Name))
+
+	args := userArgs(user)
+	if user.Home != "" {
+		args = append(args, "-m") // move the existing home directory
+	}
+	args = append(args, user.Name)
+
+	h.logger.Info("modifying user", "name", user.Name)
+	return h.exec.SimpleRunCommand(ctx, usermod, args...)
+}
+
+func (h *Shadow) RemoveUser(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveUser")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	// The home directory is deliberately kept.
+	h.logger.Info("removing user", "name", name)
+	return h.exec.SimpleRunCommand(ctx, userdel, name)
+}
+
+func (h *Shadow) LookupGroup(ctx context.Context, name string) (*users.Group, error) {
+	return nss.LookupGroup(ctx, h.exec, name)
+}
+
+func (h *Shadow) AddGroup(ctx context.Context, group users.Group) error {
+	_, span := tracer.Start(ctx, "AddGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", group.Name))
+
+	var args []string
+	if group.GID != "" {
+		args = append(args, "-g", group.GID)
+	}
+	if group.System {
+		args = append(args, "-r")
+	}
+	args = append(args, group.Name)
+
+	h.logger.Info("adding group", "name", group.Name)
+	return h.exec.SimpleRunCommand(ctx, groupadd, args...)
+}
+
+func (h *Shadow) ModifyGroup(ctx context.Context, group users.Group) error {
+	_, span := tracer.Start(ctx, "ModifyGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", group.Name))
+
+	if group.GID == "" {
+		return nil
+	}
+
+	h.logger.Info("modifying group", "name", group.Name)
+	return h.exec.SimpleRunCommand(ctx, groupmod, "-g", group.GID, group.Name)
+}
+
+func (h *Shadow) RemoveGroup(ctx context.Context, name string) error {
+	_, span := tracer.Start(ctx, "RemoveGroup")
+	defer span.End()
+	span.SetAttributes(attribute.String("name", name))
+
+	h.logger.Info("removing group", "name", name)
+	return h.exec.SimpleRunCommand(ctx, groupdel, name)
+}
+
+// userArgs returns the useradd/usermod flags for the fields set on user.
+func userArgs(user users.User) []string {
+	var args []string
+	if user.UID != "" {
+		args = append(args, "-u", user.UID)
+	}
+	if user.Group != "" {
+		args = append(args, "-g", user.Group)
+	}
+	if user.Groups != nil {
+		args = append(args, "-G", strings.Join(user.Groups, ","))
+	}
+	if user.Home != "" {
+		args = append(args, "-d", user.Home)
+	}
+	if user.Shell != "" {
+		args = append(args, "-s", user.Shell)
+	}
+	if user.Comment != "" {
+		args = append(args, "-c", user.Comment)
+	}
+	return args
+}
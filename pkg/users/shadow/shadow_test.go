@@ -0,0 +1,40 @@
+package shadow
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zachfi/nodemanager/pkg/handler"
+	"github.com/zachfi/nodemanager/pkg/users"
+)
+
+func Test_Shadow_Commands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	ctx := context.Background()
+
+	mock := &handler.MockExecHandler{}
+	h := New(logger, mock)
+
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "deploy", UID: "1001", Groups: []string{"wheel", "docker"}, Shell: "/bin/bash"}))
+	require.NoError(t, h.AddUser(ctx, users.User{Name: "svc", System: true, Home: "/var/lib/svc"}))
+	require.NoError(t, h.ModifyUser(ctx, users.User{Name: "deploy", Groups: []string{}, Home: "/srv/deploy"}))
+	require.NoError(t, h.RemoveUser(ctx, "deploy"))
+
+	require.NoError(t, h.AddGroup(ctx, users.Group{Name: "svc", GID: "900", System: true}))
+	require.NoError(t, h.ModifyGroup(ctx, users.Group{Name: "svc", GID: "901"}))
+	require.NoError(t, h.ModifyGroup(ctx, users.Group{Name: "svc"}))
+	require.NoError(t, h.RemoveGroup(ctx, "svc"))
+
+	require.Equal(t, [][]string{
+		{"-u", "1001", "-G", "wheel,docker", "-s", "/bin/bash", "-m", "deploy"},
+		{"-d", "/var/lib/svc", "-r", "svc"},
+	}, mock.Recorder[useradd])
+	require.Equal(t, [][]string{{"-G", "", "-d", "/srv/deploy", "-m", "deploy"}}, mock.Recorder[usermod])
+	require.Equal(t, [][]string{{"deploy"}}, mock.Recorder[userdel])
+	require.Equal(t, [][]string{{"-g", "900", "-r", "svc"}}, mock.Recorder[groupadd])
+	require.Equal(t, [][]string{{"-g", "901", "svc"}}, mock.Recorder[groupmod])
+	require.Equal(t, [][]string{{"svc"}}, mock.Recorder[groupdel])
+}
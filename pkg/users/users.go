@@ -0,0 +1,98 @@
+// Package users contains the types shared by the user and group handlers.
+package users
+
+import (
+	"slices"
+)
+
+type UserEnsure int64
+
+const (
+	UnhandledUserEnsure UserEnsure = iota
+	Present
+	Absent
+)
+
+var EnsureByName map[string]UserEnsure = map[string]UserEnsure{
+	"unhandled": UnhandledUserEnsure,
+	"present":   Present,
+	"absent":    Absent,
+	"":          Present, // Default to Present if empty string
+}
+
+func (u UserEnsure) String() string {
+	switch u {
+	case UnhandledUserEnsure:
+		return "unhandled"
+	case Present:
+		return "present"
+	case Absent:
+		return "absent"
+	}
+	return "unhandled"
+}
+
+func UserEnsureFromString(ensure string) UserEnsure {
+	if u, ok := EnsureByName[ensure]; ok {
+		return u
+	}
+	return UnhandledUserEnsure
+}
+
+// User is a local account.  Empty fields are left to the platform default
+// when adding, and left unchanged when modifying.
+type User struct {
+	Name string
+	UID  string
+	// Group is the primary group name.
+	Group string
+	// Groups are the supplementary group names.  Nil leaves membership
+	// unchanged; an empty, non-nil slice removes every supplementary group.
+	Groups  []string
+	Home    string
+	Shell   string
+	Comment string
+	System  bool
+}
+
+// Group is a local group.
+type Group struct {
+	Name   string
+	GID    string
+	System bool
+}
+
+// UserDiffers reports whether current must be modified to match desired.
+// Only the fields set on desired are compared.
+func UserDiffers(current, desired User) bool {
+	if desired.UID != "" && desired.UID != current.UID {
+		return true
+	}
+	if desired.Group != "" && desired.Group != current.Group {
+		return true
+	}
+	if desired.Home != "" && desired.Home != current.Home {
+		return true
+	}
+	if desired.Shell != "" && desired.Shell != current.Shell {
+		return true
+	}
+	if desired.Comment != "" && desired.Comment != current.Comment {
+		return true
+	}
+	if desired.Groups != nil {
+		want := slices.Clone(desired.Groups)
+		have := slices.Clone(current.Groups)
+		slices.Sort(want)
+		slices.Sort(have)
+		if !slices.Equal(slices.Compact(want), slices.Compact(have)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupDiffers reports whether current must be modified to match desired.
+func GroupDiffers(current, desired Group) bool {
+	return desired.GID != "" && desired.GID != current.GID
+}
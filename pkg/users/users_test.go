@@ -0,0 +1,36 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserDiffers(t *testing.T) {
+	current := User{
+		Name:   "deploy",
+		UID:    "1001",
+		Group:  "deploy",
+		Groups: []string{"wheel", "docker"},
+		Home:   "/home/deploy",
+		Shell:  "/bin/bash",
+	}
+
+	require.False(t, UserDiffers(current, User{Name: "deploy"}), "unset fields are not compared")
+	require.False(t, UserDiffers(current, User{Name: "deploy", UID: "1001", Groups: []string{"docker", "wheel"}}))
+	require.True(t, UserDiffers(current, User{Name: "deploy", Shell: "/bin/zsh"}))
+	require.True(t, UserDiffers(current, User{Name: "deploy", Groups: []string{"wheel"}}))
+	require.True(t, UserDiffers(current, User{Name: "deploy", Groups: []string{}}), "an empty list removes every group")
+}
+
+func TestGroupDiffers(t *testing.T) {
+	require.False(t, GroupDiffers(Group{Name: "g", GID: "10"}, Group{Name: "g"}))
+	require.False(t, GroupDiffers(Group{Name: "g", GID: "10"}, Group{Name: "g", GID: "10"}))
+	require.True(t, GroupDiffers(Group{Name: "g", GID: "10"}, Group{Name: "g", GID: "11"}))
+}
+
+func TestUserEnsureFromString(t *testing.T) {
+	require.Equal(t, Present, UserEnsureFromString(""))
+	require.Equal(t, Absent, UserEnsureFromString("absent"))
+	require.Equal(t, UnhandledUserEnsure, UserEnsureFromString("installed"))
+}